@@ -17,7 +17,8 @@ limitations under the License.
 package blte
 
 import (
-	"compress/zlib"
+	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/binary"
 	"fmt"
@@ -36,15 +37,20 @@ type chunkInfo struct {
 	checksum         [16]byte
 }
 
+// A hashingReader hashes everything read through it, unless its Hash is nil.
 type hashingReader struct {
 	r io.Reader
 
 	Hash hash.Hash
+
+	buf [1]byte
 }
 
 func (r *hashingReader) Read(b []byte) (int, error) {
 	n, err := r.r.Read(b)
-	r.Hash.Write(b[:n]) // error never returned
+	if r.Hash != nil {
+		r.Hash.Write(b[:n]) // error never returned
+	}
 	return n, err
 }
 
@@ -54,109 +60,252 @@ func (r *hashingReader) Read(b []byte) (int, error) {
 func (r *hashingReader) ReadByte() (byte, error) {
 	if br, ok := r.r.(io.ByteReader); ok {
 		b, err := br.ReadByte()
-		r.Hash.Write([]byte{b}) // error never returned
+		if err == nil && r.Hash != nil {
+			r.buf[0] = b
+			r.Hash.Write(r.buf[:]) // error never returned
+		}
 		return b, err
 	}
 
-	buf := make([]byte, 1)
-	var n int
-	var err error
 	for {
-		n, err = r.r.Read(buf)
+		n, err := r.r.Read(r.buf[:])
 		if n == 1 {
-			r.Hash.Write(buf) // error never returned
-			return buf[0], nil
+			if r.Hash != nil {
+				r.Hash.Write(r.buf[:]) // error never returned
+			}
+			return r.buf[0], nil
 		}
 		if err != nil {
 			return 0, err
 		}
 	}
-	panic("should never get here")
+}
+
+// A KeyProvider supplies the keys used to decrypt encrypted chunks, by the name recorded in each chunk.
+type KeyProvider interface {
+	BLTEKey(name uint64) (key [16]byte, ok bool)
 }
 
 type Reader struct {
-	r io.Reader
+	r   io.Reader
+	ctx context.Context
+
+	// Keys, if set, supplies the keys for any encrypted chunks.
+	Keys KeyProvider
+
+	// Verify says whether chunk checksums are checked; by default a mismatch fails the read.
+	Verify VerifyMode
+
+	// OnMismatch, if set, is called with each checksum mismatch when Verify is VerifyReport.
+	OnMismatch func(*ChecksumError)
+
+	// Limits, if set, replaces DefaultLimits.
+	Limits *Limits
+
+	// Recover, if set, makes bad chunks be skipped rather than failing the read; Damage lists them.
+	// Each chunk is then decoded in full before any of it is returned. Files without a chunk table can't be recovered, as their one chunk can't be skipped.
+	Recover RecoverMode
+
+	damage    []Damage
+	recovered int64 // decoded bytes produced so far in recovery mode
 
 	seenHeader bool
+	headerErr  error
 
 	flags      uint8
 	chunkCount uint32
 	chunks     []chunkInfo
 
-	currentChunk       uint32
-	remainingChunkData []byte
+	currentChunk uint32
+	chunk        io.Reader      // the decoded contents of the current chunk
+	chunkHash    *hashingReader // the encoded contents of the current chunk, if it has a checksum
+
+	// These are reused for every chunk, rather than allocated afresh.
+	limited io.LimitedReader
+	hashing hashingReader
+	md5     hash.Hash
+	mode    [1]byte
 }
 
 func NewReader(r io.Reader) *Reader {
 	return &Reader{r: r}
 }
 
+// NewReaderContext is like NewReader, but the Reader stops with ctx.Err() once ctx is done. It is checked before each chunk is started.
+func NewReaderContext(ctx context.Context, r io.Reader) *Reader {
+	return &Reader{r: r, ctx: ctx}
+}
+
+// nextChunk starts the next chunk, unless the Reader's context is done.
+func (r *Reader) nextChunk() error {
+	if r.ctx != nil {
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	r.currentChunk++
+	return r.readChunk()
+}
+
+// Read decodes data as it is read, so memory use doesn't depend on the size of the chunks.
+//
+// Chunk checksums can only be checked once a whole chunk has been read, so some data from a corrupt chunk may be returned before the error.
 func (r *Reader) Read(b []byte) (int, error) {
 	if err := r.readHeader(); err != nil {
 		return 0, err
 	}
 
-	// if we have remaining decompressed chunk data, just read that
-	if r.remainingChunkData != nil {
-		n := copy(b, r.remainingChunkData)
-		r.remainingChunkData = r.remainingChunkData[n:]
-		if len(r.remainingChunkData) == 0 {
-			r.remainingChunkData = nil
+	for {
+		if r.chunk == nil {
+			// read the chunk compression byte, and start decompressing the data
+			if err := r.nextChunk(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.chunk.Read(b)
+		if err != io.EOF {
+			return n, err
+		}
+		if err := r.finishChunk(); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
 		}
-		return n, nil
 	}
+}
 
-	// read the chunk compression byte, and checksum and decompress the data
-	r.currentChunk++
-	if err := r.readChunk(); err != nil {
+// WriteTo writes the decoded file to w, handing each chunk's reader straight to io.Copy, so that w's ReadFrom, or the chunk's own WriteTo, can avoid an intermediate buffer.
+func (r *Reader) WriteTo(w io.Writer) (int64, error) {
+	if err := r.readHeader(); err != nil {
+		if err == io.EOF {
+			return 0, nil
+		}
 		return 0, err
 	}
 
-	n := copy(b, r.remainingChunkData)
-	r.remainingChunkData = r.remainingChunkData[n:]
-	if len(r.remainingChunkData) == 0 {
-		r.remainingChunkData = nil
+	var written int64
+	for {
+		if r.chunk == nil {
+			if err := r.nextChunk(); err == io.EOF {
+				return written, nil
+			} else if err != nil {
+				return written, err
+			}
+		}
+
+		n, err := io.Copy(w, r.chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if err := r.finishChunk(); err != nil {
+			return written, err
+		}
+	}
+}
+
+// maxPresize limits how much DecodeAll allocates up front on the word of a chunk table, which may be corrupt.
+const maxPresize = 256 << 20
+
+// DecodeAll reads and decodes the whole of the BLTE-encoded file in r.
+//
+// The output buffer is sized from the chunk table, if the file has one, so it is only allocated once.
+func DecodeAll(r io.Reader) ([]byte, error) {
+	br := NewReader(r)
+	var buf bytes.Buffer
+	if size, ok := br.Size(); ok && size <= maxPresize {
+		buf.Grow(int(size))
+	}
+	if _, err := br.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Size returns the decoded size of the file, as listed in its chunk table, reading the header if necessary.
+//
+// It returns false for files without a chunk table, whose size isn't known until they are decoded, and for files whose header can't be read, in which case Read returns the error.
+// The size includes any chunks skipped with RecoverTruncate.
+func (r *Reader) Size() (int64, bool) {
+	if err := r.readHeader(); err != nil && err != io.EOF {
+		return 0, false
+	}
+	if r.chunks == nil {
+		return 0, false
 	}
-	return n, nil
+	var size int64
+	for _, c := range r.chunks {
+		size += int64(c.decompressedSize)
+	}
+	return size, true
 }
 
+// readHeader reads the header and starts the first chunk, the first time it is called. Later calls return the same error.
 func (r *Reader) readHeader() error {
-	if r.seenHeader {
-		return nil
+	if !r.seenHeader {
+		r.seenHeader = true
+		r.headerErr = r.startHeader()
 	}
-	r.seenHeader = true
+	return r.headerErr
+}
 
-	buf, err := readBytes(r.r, 8)
+func (r *Reader) startHeader() error {
+	chunks, flags, err := readHeader(r.r, r.Limits.orDefault())
 	if err != nil {
 		return err
 	}
+	if chunks == nil {
+		// no chunk info, just data!
+		return r.readChunk()
+	}
+	r.flags = flags
+	r.chunkCount = uint32(len(chunks))
+	r.chunks = chunks
+
+	return r.readChunk()
+}
+
+// readHeader reads a BLTE header, returning its chunk table, or nil if the file is a single chunk without one.
+//
+// The header's sizes are checked against l before anything is allocated for them.
+func readHeader(rd io.Reader, l *Limits) ([]chunkInfo, uint8, error) {
+	buf, err := readBytes(rd, 8)
+	if err != nil {
+		return nil, 0, err
+	}
 	if buf[0] != 'B' || buf[1] != 'L' || buf[2] != 'T' || buf[3] != 'E' {
-		return ErrBadMagic
+		return nil, 0, ErrBadMagic
 	}
-	hdrLen := binary.BigEndian.Uint32(buf[4:])
+	hdrLen := int64(binary.BigEndian.Uint32(buf[4:]))
 	if hdrLen == 0 {
-		// no chunk info, just data!
-		return nil
+		return nil, 0, nil
+	}
+	if err := check("header size", hdrLen, l.MaxHeaderSize); err != nil {
+		return nil, 0, err
 	}
 
-	hdrLen -= 8 // already seen bits of the header
-
-	buf, err = readBytes(r.r, 4) // ChunkInfo
+	buf, err = readBytes(rd, 4) // ChunkInfo
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
-	hdrLen -= 4
-	r.flags = buf[0]
+	flags := buf[0]
 	buf[0] = 0x00 // wowdev.wiki says this is a uint24, so treat as uint32
-	r.chunkCount = binary.BigEndian.Uint32(buf[:4])
+	chunkCount := binary.BigEndian.Uint32(buf[:4])
+	if err := check("chunk count", int64(chunkCount), int64(l.MaxChunks)); err != nil {
+		return nil, 0, err
+	}
+	if want := 8 + 4 + 24*int64(chunkCount); hdrLen != want {
+		return nil, 0, fmt.Errorf("blte: header is not same as expected length: %d chunks need %d bytes, but header is %d", chunkCount, want, hdrLen)
+	}
 
-	chunks := make([]chunkInfo, r.chunkCount)
-	for n := uint32(0); n < r.chunkCount; n++ {
-		buf, err = readBytes(r.r, 24) // ChunkInfoEntry
+	chunks := make([]chunkInfo, chunkCount)
+	for n := uint32(0); n < chunkCount; n++ {
+		buf, err = readBytes(rd, 24) // ChunkInfoEntry
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
-		hdrLen -= 24
 
 		chunks[n] = chunkInfo{
 			compressedSize:   binary.BigEndian.Uint32(buf[0:4]),
@@ -165,76 +314,117 @@ func (r *Reader) readHeader() error {
 		for x := 0; x < 16; x++ {
 			chunks[n].checksum[x] = buf[8+x]
 		}
+		if err := check(fmt.Sprintf("chunk %d encoded size", n), int64(chunks[n].compressedSize), l.MaxChunkSize); err != nil {
+			return nil, 0, err
+		}
+		if err := check(fmt.Sprintf("chunk %d decoded size", n), int64(chunks[n].decompressedSize), l.MaxChunkSize); err != nil {
+			return nil, 0, err
+		}
 	}
-	r.chunks = chunks
-
-	if hdrLen != 0 {
-		return fmt.Errorf("blte: header is not same as expected length: read %d bytes too many", -hdrLen)
-	}
-
-	return r.readChunk()
+	return chunks, flags, nil
 }
 
 func (r *Reader) readChunk() error {
 	var hr io.Reader = r.r
-	var hhr *hashingReader
+	r.chunkHash = nil
 	if r.chunks != nil {
 		// if this isn't a single chunk file, we'll want to check the hash
 		if r.currentChunk >= uint32(len(r.chunks)) {
 			return io.EOF
 		}
-		hhr = &hashingReader{
-			r: &io.LimitedReader{
-				R: r.r,
-				N: int64(r.chunks[r.currentChunk].compressedSize),
-			},
-			Hash: md5.New(),
+		if r.Recover != RecoverNone {
+			return r.recoverChunk()
 		}
-		hr = hhr
+		// the chunk is still delimited by its size even if it isn't hashed
+		r.limited = io.LimitedReader{R: r.r, N: int64(r.chunks[r.currentChunk].compressedSize)}
+		r.hashing = hashingReader{r: &r.limited}
+		if r.Verify != VerifyNone {
+			if r.md5 == nil {
+				r.md5 = md5.New()
+			}
+			r.md5.Reset()
+			r.hashing.Hash = r.md5
+		}
+		r.chunkHash = &r.hashing
+		hr = r.chunkHash
 	}
 
 	// read the chunk byte
-	cms, err := readBytes(hr, 1)
-	if err != nil {
+	if _, err := io.ReadFull(hr, r.mode[:]); err != nil {
 		return err
 	}
-	cm := cms[0]
 
-	// construct the reader
-	var rr io.Reader
+	var err error
+	r.chunk, err = chunkReader(r.mode[0], hr, int(r.currentChunk), r.Keys)
+	return err
+}
+
+// finishChunk checks the checksum of the chunk which has just been read.
+func (r *Reader) finishChunk() error {
+	putZlib(r.chunk)
+	r.chunk = nil
+	if r.chunkHash == nil {
+		return nil
+	}
+	// A compressed stream can end before its chunk does; skip what's left, so that the next chunk is read from the right place.
+	if _, err := io.Copy(ioutil.Discard, r.chunkHash); err != nil {
+		return err
+	}
+	if r.chunkHash.Hash == nil {
+		return nil
+	}
+
+	var sum [16]byte
+	copy(sum[:], r.chunkHash.Hash.Sum(nil))
+	return checkChunk(r.Verify, r.OnMismatch, int(r.currentChunk), sum, r.chunks[r.currentChunk].checksum)
+}
+
+// chunkReader returns a reader which decodes the rest of the index'th chunk, which has mode byte cm, from hr.
+func chunkReader(cm byte, hr io.Reader, index int, keys KeyProvider) (io.Reader, error) {
 	switch cm {
 	case 'N':
-		rr = hr
+		return hr, nil
 	case 'Z':
-		rr, err = zlib.NewReader(hr)
+		return getZlib(hr)
+	case 'E':
+		dr, err := decryptingReader(hr, index, keys)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		// Encrypted chunks hold another chunk, usually compressed.
+		inner, err := readBytes(dr, 1)
+		if err != nil {
+			return nil, fmt.Errorf("blte: encrypted chunk %d is empty", index)
 		}
-	default:
-		return fmt.Errorf("blte: unsupported compression method %v", cm)
+		return chunkReader(inner[0], dr, index, keys)
+	}
+	if c, ok := codecs[cm]; ok {
+		return c.NewReader(hr)
 	}
+	return nil, fmt.Errorf("blte: unsupported compression method %v", cm)
+}
 
-	// read the whole thing
-	r.remainingChunkData, err = ioutil.ReadAll(rr)
+// decodeChunk decodes the rest of the index'th chunk, which has mode byte cm, from hr. The result is appended to dst[:0], which may be nil.
+//
+// Decoding fails with a LimitError once the result exceeds max bytes, unless max is zero.
+func decodeChunk(dst []byte, cm byte, hr io.Reader, index int, keys KeyProvider, max int64) ([]byte, error) {
+	cr, err := chunkReader(cm, hr, index, keys)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	// if we have a hashingReader, check the hash
-	if hhr != nil {
-		hash := hhr.Hash.Sum(nil)
-		match := true
-		for n := 0; n < len(hash); n++ {
-			if hash[n] != r.chunks[r.currentChunk].checksum[n] {
-				match = false
-			}
-		}
-		if !match {
-			return fmt.Errorf("blte: checksum mismatch in chunk %d: calculated %x, header said %x", r.currentChunk, hash, r.chunks[r.currentChunk].checksum)
-		}
+	lr := cr
+	if max > 0 {
+		lr = io.LimitReader(cr, max+1)
 	}
-
-	return nil
+	buf := bytes.NewBuffer(dst[:0])
+	if _, err := buf.ReadFrom(lr); err != nil {
+		return nil, err
+	}
+	if max > 0 && int64(buf.Len()) > max {
+		return nil, &LimitError{What: fmt.Sprintf("chunk %d decoded size", index), Value: -1, Limit: max}
+	}
+	putZlib(cr)
+	return buf.Bytes(), nil
 }
 
 func readBytes(r io.Reader, n int) ([]byte, error) {
@@ -17,10 +17,18 @@ limitations under the License.
 package blte
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/lukegb/snowstorm/internal/salsa20"
 )
 
 func TestReader(t *testing.T) {
@@ -128,3 +136,379 @@ func TestReaderErrors(t *testing.T) {
 		})
 	}
 }
+
+type testKeys map[uint64][16]byte
+
+func (k testKeys) BLTEKey(name uint64) ([16]byte, bool) {
+	key, ok := k[name]
+	return key, ok
+}
+
+// encryptChunk encrypts chunk, the index'th chunk of its file, with Salsa20 as an 'E' chunk.
+func encryptChunk(t *testing.T, chunk []byte, index int, name uint64, key [16]byte) []byte {
+	iv := [8]byte{0xaa, 0xbb, 0xcc, 0xdd}
+	out := []byte{'E', 8}
+	out = append(out, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(out[2:], name)
+	out = append(out, 4)
+	out = append(out, iv[:4]...)
+	out = append(out, 'S')
+
+	iv[0] ^= byte(index)
+	enc := make([]byte, len(chunk))
+	if err := salsa20.XORKeyStream(enc, chunk, iv[:], key[:]); err != nil {
+		t.Fatal(err)
+	}
+	return append(out, enc...)
+}
+
+func TestReaderEncrypted(t *testing.T) {
+	const name = 0xFA505078126ACB3E
+	key := [16]byte{0xBD, 0xC5, 0x18, 0x62, 0xAB, 0xED, 0x79, 0xB2, 0xDE, 0x48, 0xC8, 0xE7, 0xE6, 0x6C, 0x62, 0x00}
+
+	// The second chunk is encrypted, so its IV is varied by its index.
+	plain, err := encodeChunk([]byte("hello "), ESpec{Mode: 'n'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := encodeChunk([]byte("encrypted world"), ESpec{Mode: 'z'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := [][]byte{plain, encryptChunk(t, compressed, 1, name, key)}
+	sizes := []int{6, 15}
+
+	var buf bytes.Buffer
+	buf.WriteString("BLTE")
+	binary.Write(&buf, binary.BigEndian, uint32(8+4+24*len(chunks)))
+	binary.Write(&buf, binary.BigEndian, uint32(0x0f<<24|len(chunks)))
+	for n, c := range chunks {
+		binary.Write(&buf, binary.BigEndian, uint32(len(c)))
+		binary.Write(&buf, binary.BigEndian, uint32(sizes[n]))
+		sum := md5.Sum(c)
+		buf.Write(sum[:])
+	}
+	for _, c := range chunks {
+		buf.Write(c)
+	}
+	encoded := buf.Bytes()
+
+	r := NewReader(bytes.NewReader(encoded))
+	r.Keys = testKeys{name: key}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll with key: %v", err)
+	}
+	if want := "hello encrypted world"; string(got) != want {
+		t.Errorf("ReadAll with key = %q; want %q", got, want)
+	}
+
+	for _, keys := range []KeyProvider{nil, testKeys{}} {
+		r := NewReader(bytes.NewReader(encoded))
+		r.Keys = keys
+		_, err := ioutil.ReadAll(r)
+		var mke *MissingKeyError
+		if !errors.As(err, &mke) || mke.Name != name || mke.Chunk != 1 {
+			t.Errorf("ReadAll with keys %v = %v; want MissingKeyError for key %016X in chunk 1", keys, err, uint64(name))
+		}
+	}
+}
+
+// failingReader returns the data it holds, and then an error.
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *failingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if err == io.EOF {
+		err = r.err
+	}
+	return n, err
+}
+
+func TestReaderStreams(t *testing.T) {
+	data := bytes.Repeat([]byte("snowstorm "), 100000)
+	for _, spec := range []ESpec{{Mode: 'n'}, {Mode: 'z'}, ChunkedESpec(int64(len(data)), 'z')} {
+		encoded, err := Encode(data, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Only the start of the file arrives, but it can be read without waiting for the rest of the chunk.
+		broken := errors.New("connection reset")
+		r := NewReader(&failingReader{bytes.NewReader(encoded[:len(encoded)/2]), broken})
+		b := make([]byte, 1000)
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Errorf("reading the start of %q: %v", spec, err)
+		} else if !bytes.Equal(b, data[:len(b)]) {
+			t.Errorf("reading the start of %q returned the wrong data", spec)
+		}
+		if _, err := ioutil.ReadAll(r); err != broken && err != io.ErrUnexpectedEOF {
+			t.Errorf("reading the rest of %q = %v; want %v", spec, err, broken)
+		}
+	}
+}
+
+func TestReaderVerify(t *testing.T) {
+	encoded, err := ioutil.ReadFile(filepath.Join("testdata", "badchecksum.blte"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		mode       VerifyMode
+		wantErr    bool
+		wantReport bool
+	}{
+		{VerifyStrict, true, false},
+		{VerifyNone, false, false},
+		{VerifyReport, false, true},
+	} {
+		t.Run(test.mode.String(), func(t *testing.T) {
+			var reported []*ChecksumError
+			r := NewReader(bytes.NewReader(encoded))
+			r.Verify = test.mode
+			r.OnMismatch = func(err *ChecksumError) { reported = append(reported, err) }
+
+			_, err := ioutil.ReadAll(r)
+			if _, ok := err.(*ChecksumError); ok != test.wantErr {
+				t.Errorf("ioutil.ReadAll: %v; want checksum error %v", err, test.wantErr)
+			} else if !test.wantErr && err != nil {
+				t.Errorf("ioutil.ReadAll: %v", err)
+			}
+			if got := len(reported) > 0; got != test.wantReport {
+				t.Errorf("OnMismatch called %d times; want calls %v", len(reported), test.wantReport)
+			}
+		})
+	}
+}
+
+func TestReaderChunkPadding(t *testing.T) {
+	// The first chunk has bytes after the end of its zlib stream, which must be skipped in every mode.
+	first, err := encodeChunk([]byte("hello "), ESpec{Mode: 'z'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := encodeChunk([]byte("world"), ESpec{Mode: 'z'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := [][]byte{append(first, 0, 0, 0), second}
+	sizes := []int{6, 5}
+
+	var buf bytes.Buffer
+	buf.WriteString("BLTE")
+	binary.Write(&buf, binary.BigEndian, uint32(8+4+24*len(chunks)))
+	binary.Write(&buf, binary.BigEndian, uint32(0x0f<<24|len(chunks)))
+	for n, c := range chunks {
+		binary.Write(&buf, binary.BigEndian, uint32(len(c)))
+		binary.Write(&buf, binary.BigEndian, uint32(sizes[n]))
+		sum := md5.Sum(c)
+		buf.Write(sum[:])
+	}
+	for _, c := range chunks {
+		buf.Write(c)
+	}
+
+	for _, mode := range []VerifyMode{VerifyStrict, VerifyNone, VerifyReport} {
+		t.Run(mode.String(), func(t *testing.T) {
+			r := NewReader(bytes.NewReader(buf.Bytes()))
+			r.Verify = mode
+			r.OnMismatch = func(err *ChecksumError) { t.Errorf("OnMismatch(%v)", err) }
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if want := "hello world"; string(got) != want {
+				t.Errorf("ReadAll = %q; want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestVerifyModeSet(t *testing.T) {
+	for _, m := range []VerifyMode{VerifyStrict, VerifyNone, VerifyReport} {
+		var got VerifyMode
+		if err := got.Set(m.String()); err != nil || got != m {
+			t.Errorf("Set(%q) = %v, %v; want %v", m.String(), got, err, m)
+		}
+	}
+	var m VerifyMode
+	if err := m.Set("sometimes"); err == nil {
+		t.Errorf("Set(%q) succeeded; want error", "sometimes")
+	}
+}
+
+func TestReaderLZ4(t *testing.T) {
+	data := bytes.Repeat([]byte("snowstorm "), 20000)
+	for _, spec := range []ESpec{{Mode: '4'}, ChunkedESpec(70000, '4')} {
+		encoded, err := Encode(data, spec)
+		if err != nil {
+			t.Fatalf("Encode(%q): %v", spec, err)
+		}
+		if len(encoded) > len(data)/10 {
+			t.Errorf("Encode(%q) is %d bytes; want it compressed", spec, len(encoded))
+		}
+		got, err := ioutil.ReadAll(NewReader(bytes.NewReader(encoded)))
+		if err != nil {
+			t.Errorf("reading %q: %v", spec, err)
+		} else if !bytes.Equal(got, data) {
+			t.Errorf("reading %q returned the wrong data", spec)
+		}
+	}
+}
+
+// reverseCodec "compresses" chunks by reversing them.
+type reverseCodec struct{}
+
+func reverse(b []byte) []byte {
+	out := make([]byte, len(b))
+	for n, c := range b {
+		out[len(b)-1-n] = c
+	}
+	return out
+}
+
+func (reverseCodec) NewReader(r io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(reverse(b)), nil
+}
+
+func (reverseCodec) Compress(b []byte) ([]byte, error) {
+	return reverse(b), nil
+}
+
+func TestRegisterCodec(t *testing.T) {
+	RegisterCodec('r', reverseCodec{})
+	defer delete(codecs, 'r')
+
+	encoded, err := Encode([]byte("snowstorm"), ESpec{Mode: 'r'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "BLTE\x00\x00\x00\x00rmrotswons"; string(encoded) != want {
+		t.Errorf("Encode = %q; want %q", encoded, want)
+	}
+	got, err := ioutil.ReadAll(NewReader(bytes.NewReader(encoded)))
+	if err != nil || string(got) != "snowstorm" {
+		t.Errorf("reading = %q, %v; want %q", got, err, "snowstorm")
+	}
+}
+
+func TestDecodeAll(t *testing.T) {
+	data := bytes.Repeat([]byte("snowstorm "), 100000)
+	for _, spec := range []ESpec{{Mode: 'n'}, {Mode: 'z'}, ChunkedESpec(300000, 'z')} {
+		encoded, err := Encode(data, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := DecodeAll(bytes.NewReader(encoded))
+		if err != nil {
+			t.Errorf("DecodeAll(%q): %v", spec, err)
+		} else if !bytes.Equal(got, data) {
+			t.Errorf("DecodeAll(%q) returned the wrong data", spec)
+		}
+
+		var buf bytes.Buffer
+		if n, err := NewReader(bytes.NewReader(encoded)).WriteTo(&buf); err != nil || n != int64(len(data)) {
+			t.Errorf("WriteTo(%q) = %d, %v; want %d, nil", spec, n, err, len(data))
+		} else if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("WriteTo(%q) wrote the wrong data", spec)
+		}
+	}
+
+	f, err := os.Open(filepath.Join("testdata", "badchecksum.blte"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := DecodeAll(f); err == nil {
+		t.Errorf("DecodeAll(badchecksum.blte) succeeded; want error")
+	}
+}
+
+func TestReaderContext(t *testing.T) {
+	data := bytes.Repeat([]byte("snowstorm "), 1000)
+	encoded, err := Encode(data, ChunkedESpec(1000, 'z'))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewReaderContext(ctx, bytes.NewReader(encoded))
+	b := make([]byte, 1500)
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatalf("reading before cancelling: %v", err)
+	}
+	cancel()
+
+	// The rest of the current chunk is still returned, but no more.
+	rest, err := ioutil.ReadAll(r)
+	if err != context.Canceled {
+		t.Errorf("reading after cancelling: %v; want %v", err, context.Canceled)
+	}
+	if len(rest) != 500 {
+		t.Errorf("read %d bytes after cancelling; want the 500 left in the chunk", len(rest))
+	}
+
+	if _, err := NewReaderContext(ctx, bytes.NewReader(encoded)).WriteTo(ioutil.Discard); err != context.Canceled {
+		t.Errorf("WriteTo with a cancelled context: %v; want %v", err, context.Canceled)
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	data := bytes.Repeat([]byte("snowstorm "), 100000)
+	encoded, err := Encode(data, ChunkedESpec(64<<10, 'z'))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := NewReader(bytes.NewReader(encoded)).WriteTo(ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReaderSize(t *testing.T) {
+	data := bytes.Repeat([]byte("snowstorm "), 1000)
+	for _, test := range []struct {
+		spec   ESpec
+		wantOK bool
+	}{
+		{ESpec{Mode: 'z'}, false},
+		{ChunkedESpec(3000, 'z'), true},
+	} {
+		encoded, err := Encode(data, test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewReader(bytes.NewReader(encoded))
+		size, ok := r.Size()
+		if ok != test.wantOK || (ok && size != int64(len(data))) {
+			t.Errorf("Size() of %q = %d, %v; want %d, %v", test.spec, size, ok, len(data), test.wantOK)
+		}
+
+		// Reading the size first doesn't lose any data.
+		if got, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("reading %q after Size: %v", test.spec, err)
+		}
+	}
+
+	// Header errors are still returned by Read.
+	r := NewReader(bytes.NewReader([]byte("BLTX\x00\x00\x00\x00")))
+	if _, ok := r.Size(); ok {
+		t.Errorf("Size() of a bad file succeeded")
+	}
+	if _, err := ioutil.ReadAll(r); err != ErrBadMagic {
+		t.Errorf("reading a bad file after Size: %v; want %v", err, ErrBadMagic)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/lukegb/snowstorm/internal/lz4"
+)
+
+// A Codec implements a chunk compression mode other than the built-in 'N' (none) and 'Z' (zlib).
+type Codec interface {
+	// NewReader returns a reader which decompresses the rest of a chunk from r, after its mode byte.
+	NewReader(r io.Reader) (io.Reader, error)
+
+	// Compress compresses b as a chunk, without its mode byte.
+	Compress(b []byte) ([]byte, error)
+}
+
+var codecs = map[byte]Codec{
+	'4': LZ4Codec{},
+}
+
+// RegisterCodec makes chunks with the given mode byte readable, and writable with an ESpec of that Mode, replacing any codec already registered for it.
+//
+// LZ4 ('4') is registered by default. Codecs should be registered before any data is read or written, as the registry isn't locked.
+func RegisterCodec(mode byte, c Codec) {
+	codecs[mode] = c
+}
+
+// LZ4Codec reads and writes LZ4 ('4') chunks.
+//
+// These hold a version byte, the big-endian uint64 decoded size, and the log2 of the block size, followed by the data split into independently compressed LZ4 blocks.
+type LZ4Codec struct{}
+
+const (
+	lz4Version    = 1
+	lz4BlockShift = 16 // 64KiB blocks
+	lz4MaxShift   = 24
+)
+
+// NewReader decompresses an LZ4 chunk a block at a time.
+func (LZ4Codec) NewReader(r io.Reader) (io.Reader, error) {
+	hdr, err := readBytes(r, 10)
+	if err != nil {
+		return nil, err
+	}
+	if hdr[0] != lz4Version {
+		return nil, fmt.Errorf("blte: unsupported LZ4 chunk version %d", hdr[0])
+	}
+	if hdr[9] > lz4MaxShift {
+		return nil, fmt.Errorf("blte: LZ4 block size 1<<%d is too large", hdr[9])
+	}
+
+	br, ok := r.(lz4.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &lz4Reader{
+		r:         br,
+		remaining: binary.BigEndian.Uint64(hdr[1:9]),
+		blockSize: 1 << hdr[9],
+	}, nil
+}
+
+// Compress compresses b in 64KiB blocks.
+func (LZ4Codec) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(lz4Version)
+	binary.Write(&buf, binary.BigEndian, uint64(len(b)))
+	buf.WriteByte(lz4BlockShift)
+	for len(b) > 0 {
+		n := len(b)
+		if n > 1<<lz4BlockShift {
+			n = 1 << lz4BlockShift
+		}
+		buf.Write(lz4.Encode(b[:n]))
+		b = b[n:]
+	}
+	return buf.Bytes(), nil
+}
+
+type lz4Reader struct {
+	r         lz4.Reader
+	remaining uint64
+	blockSize int
+
+	block []byte
+	out   []byte // the undelivered part of block
+}
+
+func (r *lz4Reader) Read(b []byte) (int, error) {
+	if len(r.out) == 0 {
+		if r.remaining == 0 {
+			// Anything after the last block would otherwise go unnoticed, and break the chunk's checksum.
+			_, err := r.r.ReadByte()
+			if err == nil {
+				return 0, fmt.Errorf("blte: trailing data after LZ4 blocks")
+			}
+			return 0, err
+		}
+
+		n := r.blockSize
+		if uint64(n) > r.remaining {
+			n = int(r.remaining)
+		}
+		if r.block == nil {
+			r.block = make([]byte, n)
+		}
+		if err := lz4.Decode(r.block[:n], r.r); err != nil {
+			return 0, fmt.Errorf("blte: decoding LZ4 block: %v", err)
+		}
+		r.remaining -= uint64(n)
+		r.out = r.block[:n]
+	}
+
+	n := copy(b, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
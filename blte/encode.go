@@ -0,0 +1,214 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// encodeChunk encodes b as a single chunk, with its mode byte.
+func encodeChunk(b []byte, spec ESpec) ([]byte, error) {
+	switch spec.Mode {
+	case 'n':
+		return append([]byte{'N'}, b...), nil
+	case 'z':
+		level := spec.Level
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		var buf bytes.Buffer
+		buf.WriteByte('Z')
+		zw, err := zlib.NewWriterLevel(&buf, level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(b); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	if c, ok := codecs[spec.Mode]; ok {
+		b, err := c.Compress(b)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{spec.Mode}, b...), nil
+	}
+	return nil, fmt.Errorf("blte: can't encode chunks with espec %q", spec)
+}
+
+// A Writer BLTE-encodes the data written to it, as described by an ESpec, such as "z" or "b:{256K*=z}".
+//
+// Each chunk is encoded as soon as it is full, but nothing is written to the underlying writer until Close, since the header must list every chunk first.
+// Files which aren't split into blocks are written without a chunk table. Zlib-compressed output depends on the compressor, so it won't always be byte-for-byte identical to Blizzard's.
+type Writer struct {
+	w    io.Writer
+	spec ESpec
+
+	buf     []byte   // data not yet encoded
+	encoded [][]byte // encoded chunks, with their mode bytes
+	sizes   []int    // decoded size of each chunk
+
+	block   int // index into spec.Blocks of the block run being filled
+	inBlock int // number of chunks already encoded from that run
+
+	hash   [md5.Size]byte
+	err    error
+	closed bool
+}
+
+// NewWriter creates a Writer which writes the encoded file to w.
+func NewWriter(w io.Writer, spec ESpec) *Writer {
+	return &Writer{w: w, spec: spec}
+}
+
+// ChunkedESpec returns an ESpec which splits a file into chunks of size bytes, each encoded with mode 'n', 'z', or that of a registered Codec.
+func ChunkedESpec(size int64, mode byte) ESpec {
+	return ESpec{Mode: 'b', Blocks: []BlockSpec{{Size: size, Spec: ESpec{Mode: mode}}}}
+}
+
+// Write buffers b, encoding any chunks it completes.
+func (w *Writer) Write(b []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("blte: write to closed Writer")
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, b...)
+	if w.spec.Mode != 'b' {
+		return len(b), nil
+	}
+
+	for w.block < len(w.spec.Blocks) {
+		bs := w.spec.Blocks[w.block]
+		if bs.Size == 0 || int64(len(w.buf)) <= bs.Size {
+			// Wait for more data: a chunk which ends with the file is encoded by Close.
+			break
+		}
+		if w.err = w.encodeChunk(w.buf[:bs.Size], bs.Spec); w.err != nil {
+			return 0, w.err
+		}
+		w.buf = w.buf[bs.Size:]
+	}
+	return len(b), nil
+}
+
+// encodeChunk encodes b as the next chunk, moving on to the next run of blocks if necessary.
+func (w *Writer) encodeChunk(b []byte, spec ESpec) error {
+	c, err := encodeChunk(b, spec)
+	if err != nil {
+		return err
+	}
+	w.encoded = append(w.encoded, c)
+	w.sizes = append(w.sizes, len(b))
+	w.inBlock++
+	if bs := w.spec.Blocks[w.block]; bs.Count != 0 && w.inBlock >= bs.Count {
+		w.block++
+		w.inBlock = 0
+	}
+	return nil
+}
+
+// Close encodes whatever remains and writes the encoded file. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.finish()
+	return w.err
+}
+
+func (w *Writer) finish() error {
+	var buf bytes.Buffer
+	if w.spec.Mode != 'b' {
+		chunk, err := encodeChunk(w.buf, w.spec)
+		if err != nil {
+			return err
+		}
+		buf.Write([]byte{'B', 'L', 'T', 'E', 0, 0, 0, 0})
+		buf.Write(chunk)
+		w.hash = md5.Sum(buf.Bytes())
+		_, err = w.w.Write(buf.Bytes())
+		return err
+	}
+
+	if len(w.buf) > 0 || len(w.encoded) == 0 {
+		if w.block >= len(w.spec.Blocks) {
+			if len(w.buf) > 0 {
+				return fmt.Errorf("blte: espec leaves the last %d bytes unencoded", len(w.buf))
+			}
+		} else if err := w.encodeChunk(w.buf, w.spec.Blocks[w.block].Spec); err != nil {
+			// An empty file is still one (empty) chunk.
+			return err
+		}
+		w.buf = nil
+	}
+
+	hdrLen := 8 + 4 + 24*len(w.encoded)
+	buf.WriteString("BLTE")
+	binary.Write(&buf, binary.BigEndian, uint32(hdrLen))
+	// The chunk info starts with a flags byte, then a 24-bit chunk count.
+	binary.Write(&buf, binary.BigEndian, uint32(0x0f<<24|len(w.encoded)))
+	for n, c := range w.encoded {
+		binary.Write(&buf, binary.BigEndian, uint32(len(c)))
+		binary.Write(&buf, binary.BigEndian, uint32(w.sizes[n]))
+		sum := md5.Sum(c)
+		buf.Write(sum[:])
+	}
+	w.hash = md5.Sum(buf.Bytes())
+	if _, err := w.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	for _, c := range w.encoded {
+		if _, err := w.w.Write(c); err != nil {
+			return err
+		}
+	}
+	w.encoded = nil
+	return nil
+}
+
+// HeaderHash returns the MD5 of the encoded file's header, which is what it is named after on the CDN, once the Writer has been closed.
+func (w *Writer) HeaderHash() [md5.Size]byte {
+	return w.hash
+}
+
+// Encode BLTE-encodes b as described by spec. It is equivalent to writing b to a Writer and closing it.
+func Encode(b []byte, spec ESpec) ([]byte, error) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, spec)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
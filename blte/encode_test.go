@@ -0,0 +1,127 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	data := bytes.Repeat([]byte("snowstorm "), 1000)
+	for _, espec := range []string{"n", "z", "z:1", "b:{1K*=z}", "b:{22=n,54=z,*=n}", "b:{4K*2=n,*=z}"} {
+		spec, err := ParseESpec(espec)
+		if err != nil {
+			t.Fatalf("ParseESpec(%q): %v", espec, err)
+		}
+		encoded, err := Encode(data, spec)
+		if err != nil {
+			t.Errorf("Encode(%q): %v", espec, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(NewReader(bytes.NewReader(encoded)))
+		if err != nil {
+			t.Errorf("decoding Encode(%q): %v", espec, err)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("decoding Encode(%q) returned %d bytes; want the original %d", espec, len(got), len(data))
+		}
+	}
+
+	// Unchunked files are named after their entire contents.
+	encoded, err := Encode([]byte("hello"), ESpec{Mode: 'n'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("BLTE\x00\x00\x00\x00Nhello"); !bytes.Equal(encoded, want) {
+		t.Errorf("Encode(hello, n) = %q; want %q", encoded, want)
+	}
+	if h, err := HeaderHash(encoded); err != nil || h != md5.Sum(encoded) {
+		t.Errorf("HeaderHash = %x, %v; want %x", h, err, md5.Sum(encoded))
+	}
+
+	// Chunked files are named after their header.
+	encoded, err = Encode([]byte("hello"), ESpec{Mode: 'b', Blocks: []BlockSpec{{Size: 2, Spec: ESpec{Mode: 'n'}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, err := HeaderHash(encoded); err != nil || h != md5.Sum(encoded[:8+4+24*3]) {
+		t.Errorf("HeaderHash = %x, %v; want the MD5 of a three-chunk header", h, err)
+	}
+
+	// The espec must cover the whole file.
+	if _, err := Encode(data, ESpec{Mode: 'b', Blocks: []BlockSpec{{Size: 10, Count: 1, Spec: ESpec{Mode: 'n'}}}}); err == nil {
+		t.Errorf("Encode with a short espec succeeded; want error")
+	}
+	if _, err := Encode(data, ESpec{Mode: 'e'}); err == nil {
+		t.Errorf("Encode with an encrypted espec succeeded; want error")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("snowstorm "), 1000)
+	for _, spec := range []ESpec{
+		{Mode: 'z'},
+		ChunkedESpec(1000, 'n'),
+		ChunkedESpec(1024, 'z'),
+		{Mode: 'b', Blocks: []BlockSpec{{Size: 22, Count: 1, Spec: ESpec{Mode: 'n'}}, {Size: 4096, Count: 2, Spec: ESpec{Mode: 'z'}}, {Count: 1, Spec: ESpec{Mode: 'n'}}}},
+	} {
+		want, err := Encode(data, spec)
+		if err != nil {
+			t.Fatalf("Encode(%q): %v", spec, err)
+		}
+
+		// Writes which don't line up with chunk boundaries.
+		var buf bytes.Buffer
+		w := NewWriter(&buf, spec)
+		for b := data; len(b) > 0; {
+			n := 333
+			if n > len(b) {
+				n = len(b)
+			}
+			if _, err := w.Write(b[:n]); err != nil {
+				t.Fatalf("Write(%q): %v", spec, err)
+			}
+			b = b[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close(%q): %v", spec, err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("Writer(%q) wrote %d bytes which differ from Encode's %d", spec, buf.Len(), len(want))
+		}
+		if h, _ := HeaderHash(want); w.HeaderHash() != h {
+			t.Errorf("Writer(%q).HeaderHash() = %x; want %x", spec, w.HeaderHash(), h)
+		}
+		if _, err := w.Write([]byte("x")); err == nil {
+			t.Errorf("Write(%q) after Close succeeded; want error", spec)
+		}
+	}
+
+	// A chunk which exactly fills the file isn't followed by an empty one.
+	encoded, err := Encode(data, ChunkedESpec(int64(len(data))/2, 'n'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.BigEndian.Uint32(encoded[8:12]) & 0xffffff; got != 2 {
+		t.Errorf("encoded %d chunks; want 2", got)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"crypto/cipher"
+	"crypto/rc4"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/lukegb/snowstorm/internal/salsa20"
+)
+
+// A MissingKeyError is returned when a chunk is encrypted with a key which the Reader's KeyProvider doesn't have, or which isn't set at all.
+type MissingKeyError struct {
+	// Name is the name of the key, conventionally written as 16 hex digits.
+	Name uint64
+
+	// Chunk is the index of the encrypted chunk.
+	Chunk int
+}
+
+func (e *MissingKeyError) Error() string {
+	return fmt.Sprintf("blte: chunk %d is encrypted with key %016X, which isn't available", e.Chunk, e.Name)
+}
+
+// decryptingReader decrypts the body of an 'E' chunk, which is the index'th chunk of its file, from hr. It returns a reader of the inner chunk, starting with its own mode byte.
+//
+// The body starts with the key name, the IV, and then a byte giving the cipher: 'S' for Salsa20 or 'A' for ARC4.
+func decryptingReader(hr io.Reader, index int, keys KeyProvider) (io.Reader, error) {
+	truncated := func(err error) error {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("blte: encrypted chunk %d is truncated", index)
+		}
+		return err
+	}
+
+	b, err := readBytes(hr, 1)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	if b[0] != 8 {
+		return nil, fmt.Errorf("blte: encrypted chunk %d has a bad key name length %d", index, b[0])
+	}
+	if b, err = readBytes(hr, 8); err != nil {
+		return nil, truncated(err)
+	}
+	name := binary.LittleEndian.Uint64(b)
+
+	if b, err = readBytes(hr, 1); err != nil {
+		return nil, truncated(err)
+	}
+	ivLen := int(b[0])
+	if ivLen > salsa20.NonceSize {
+		return nil, fmt.Errorf("blte: encrypted chunk %d has a bad IV length %d", index, ivLen)
+	}
+	if b, err = readBytes(hr, ivLen+1); err != nil {
+		return nil, truncated(err)
+	}
+	// The IV is padded to the size of a Salsa20 nonce, and varied by the chunk's index so that each chunk gets its own keystream.
+	var iv [salsa20.NonceSize]byte
+	copy(iv[:], b[:ivLen])
+	for n := 0; n < 4; n++ {
+		iv[n] ^= byte(index >> (8 * uint(n)))
+	}
+	cipherType := b[ivLen]
+
+	if keys == nil {
+		return nil, &MissingKeyError{Name: name, Chunk: index}
+	}
+	key, ok := keys.BLTEKey(name)
+	if !ok {
+		return nil, &MissingKeyError{Name: name, Chunk: index}
+	}
+
+	var stream cipher.Stream
+	switch cipherType {
+	case 'S':
+		if stream, err = salsa20.NewCipher(key[:], iv[:]); err != nil {
+			return nil, err
+		}
+	case 'A':
+		// ARC4 keys are the key followed by the IV, padded to 32 bytes.
+		var rc4Key [32]byte
+		copy(rc4Key[:], key[:])
+		copy(rc4Key[len(key):], iv[:ivLen])
+		if stream, err = rc4.NewCipher(rc4Key[:]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("blte: encrypted chunk %d uses unsupported cipher %q", index, cipherType)
+	}
+	return cipher.StreamReader{S: stream, R: hr}, nil
+}
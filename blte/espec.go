@@ -0,0 +1,262 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An ESpec is an encoding specification, which describes how a file is BLTE-encoded: for example "z", or "b:{256K*=z}".
+//
+// ESpecs are listed in the encoding table, so that a file can be encoded again exactly as Blizzard did.
+type ESpec struct {
+	// Mode is 'n' for no compression, 'z' for zlib, or 'b' for a file split into blocks. Encrypted ('e') and other modes can be parsed, but not encoded.
+	// The mode byte of a registered Codec, such as '4' for LZ4, can also be encoded, though it has no ESpec syntax.
+	Mode byte
+
+	// Level is the zlib compression level for 'z', or zero for the default.
+	Level int
+
+	// Args holds the arguments of modes which aren't understood, verbatim.
+	Args string
+
+	// Blocks lists how each run of blocks is encoded, for 'b'.
+	Blocks []BlockSpec
+}
+
+// A BlockSpec describes a run of Count blocks of Size bytes within a 'b' ESpec.
+type BlockSpec struct {
+	// Size is the size of each block. Zero means the block holds the rest of the file.
+	Size int64
+
+	// Count is the number of blocks. Zero means blocks repeat until the end of the file.
+	Count int
+
+	Spec ESpec
+}
+
+// ParseESpec parses an ESpec.
+func ParseESpec(s string) (ESpec, error) {
+	p := &especParser{s: s}
+	spec, err := p.spec()
+	if err == nil && p.pos != len(s) {
+		err = p.errorf("unexpected %q", s[p.pos:])
+	}
+	if err != nil {
+		return ESpec{}, err
+	}
+	return spec, nil
+}
+
+type especParser struct {
+	s   string
+	pos int
+}
+
+func (p *especParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("blte: bad espec %q at offset %d: %s", p.s, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *especParser) peek() byte {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *especParser) consume(c byte) bool {
+	if p.peek() != c {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+// until returns everything up to the next of the given bytes at the current nesting level.
+func (p *especParser) until(stop string) string {
+	start, depth := p.pos, 0
+	for ; p.pos < len(p.s); p.pos++ {
+		c := p.s[p.pos]
+		switch {
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case depth == 0 && strings.IndexByte(stop, c) >= 0:
+			return p.s[start:p.pos]
+		}
+	}
+	return p.s[start:]
+}
+
+func (p *especParser) spec() (ESpec, error) {
+	spec := ESpec{Mode: p.peek()}
+	p.pos++
+	switch spec.Mode {
+	case 'n':
+	case 'z':
+		if !p.consume(':') {
+			break
+		}
+		// The level may be bare, or in braces alongside a window size or "mpq".
+		args := p.until(",}")
+		if strings.HasPrefix(args, "{") {
+			args = strings.SplitN(strings.Trim(args, "{}"), ",", 2)[0]
+		}
+		level, err := strconv.Atoi(args)
+		if err != nil || level < 1 || level > 9 {
+			return ESpec{}, p.errorf("bad zlib level %q", args)
+		}
+		spec.Level = level
+	case 'b':
+		if !p.consume(':') {
+			return ESpec{}, p.errorf("missing block list")
+		}
+		braced := p.consume('{')
+		for {
+			b, err := p.block()
+			if err != nil {
+				return ESpec{}, err
+			}
+			spec.Blocks = append(spec.Blocks, b)
+			if !braced || !p.consume(',') {
+				break
+			}
+		}
+		if braced && !p.consume('}') {
+			return ESpec{}, p.errorf("missing }")
+		}
+	case 'e', 'c', 'g':
+		if p.consume(':') {
+			spec.Args = p.until(",}")
+		}
+	case 0:
+		return ESpec{}, p.errorf("empty espec")
+	default:
+		return ESpec{}, p.errorf("unknown mode %q", spec.Mode)
+	}
+	return spec, nil
+}
+
+// block parses [size[*[count]]=]spec.
+func (p *especParser) block() (BlockSpec, error) {
+	var b BlockSpec
+	if eq := strings.IndexByte(p.s[p.pos:], '='); eq >= 0 && !strings.ContainsAny(p.s[p.pos:p.pos+eq], "{,}:") {
+		sizeSpec := p.s[p.pos : p.pos+eq]
+		p.pos += eq + 1
+
+		size, count := sizeSpec, ""
+		repeat := false
+		if star := strings.IndexByte(sizeSpec, '*'); star >= 0 {
+			size, count, repeat = sizeSpec[:star], sizeSpec[star+1:], true
+		}
+		if size != "" {
+			n, err := parseSize(size)
+			if err != nil {
+				return BlockSpec{}, p.errorf("%v", err)
+			}
+			b.Size = n
+		}
+		switch {
+		case !repeat:
+			b.Count = 1
+		case count != "":
+			n, err := strconv.Atoi(count)
+			if err != nil || n < 1 {
+				return BlockSpec{}, p.errorf("bad block count %q", count)
+			}
+			b.Count = n
+		}
+		if b.Size == 0 {
+			// "*=" covers the rest of the file in one block.
+			b.Count = 1
+		}
+	} else {
+		// A bare spec covers the rest of the file.
+		b.Count = 1
+	}
+
+	spec, err := p.spec()
+	if err != nil {
+		return BlockSpec{}, err
+	}
+	b.Spec = spec
+	return b, nil
+}
+
+// parseSize parses a block size, which may have a K or M suffix.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult, s = 1<<10, strings.TrimSuffix(s, "K")
+	case strings.HasSuffix(s, "M"):
+		mult, s = 1<<20, strings.TrimSuffix(s, "M")
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("bad block size %q", s)
+	}
+	return n * mult, nil
+}
+
+func (s ESpec) String() string {
+	switch s.Mode {
+	case 'z':
+		if s.Level != 0 {
+			return fmt.Sprintf("z:%d", s.Level)
+		}
+	case 'b':
+		bits := make([]string, len(s.Blocks))
+		for n, b := range s.Blocks {
+			bits[n] = b.String()
+		}
+		return "b:{" + strings.Join(bits, ",") + "}"
+	case 'e', 'c', 'g':
+		if s.Args != "" {
+			return string(s.Mode) + ":" + s.Args
+		}
+	}
+	return string(s.Mode)
+}
+
+func (b BlockSpec) String() string {
+	size := "*"
+	if b.Size != 0 {
+		size = formatSize(b.Size)
+		switch b.Count {
+		case 0:
+			size += "*"
+		case 1:
+		default:
+			size += fmt.Sprintf("*%d", b.Count)
+		}
+	}
+	return size + "=" + b.Spec.String()
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n%(1<<20) == 0:
+		return fmt.Sprintf("%dM", n>>20)
+	case n%(1<<10) == 0:
+		return fmt.Sprintf("%dK", n>>10)
+	}
+	return strconv.FormatInt(n, 10)
+}
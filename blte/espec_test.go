@@ -0,0 +1,63 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseESpec(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want ESpec
+		str  string
+	}{
+		{"n", ESpec{Mode: 'n'}, "n"},
+		{"z", ESpec{Mode: 'z'}, "z"},
+		{"z:9", ESpec{Mode: 'z', Level: 9}, "z:9"},
+		{"z:{6,mpq}", ESpec{Mode: 'z', Level: 6}, "z:6"},
+		{"b:{256K*=z}", ESpec{Mode: 'b', Blocks: []BlockSpec{{Size: 256 << 10, Spec: ESpec{Mode: 'z'}}}}, "b:{256K*=z}"},
+		{"b:{22=n,54=z,1M*3=z:9,*=n}", ESpec{Mode: 'b', Blocks: []BlockSpec{
+			{Size: 22, Count: 1, Spec: ESpec{Mode: 'n'}},
+			{Size: 54, Count: 1, Spec: ESpec{Mode: 'z'}},
+			{Size: 1 << 20, Count: 3, Spec: ESpec{Mode: 'z', Level: 9}},
+			{Count: 1, Spec: ESpec{Mode: 'n'}},
+		}}, "b:{22=n,54=z,1M*3=z:9,*=n}"},
+		{"b:{16K*=z:{6,mpq}}", ESpec{Mode: 'b', Blocks: []BlockSpec{{Size: 16 << 10, Spec: ESpec{Mode: 'z', Level: 6}}}}, "b:{16K*=z:6}"},
+		{"b:n", ESpec{Mode: 'b', Blocks: []BlockSpec{{Count: 1, Spec: ESpec{Mode: 'n'}}}}, "b:{*=n}"},
+		{"e:{1234567890ABCDEF,12345678,z}", ESpec{Mode: 'e', Args: "{1234567890ABCDEF,12345678,z}"}, "e:{1234567890ABCDEF,12345678,z}"},
+	} {
+		got, err := ParseESpec(test.in)
+		if err != nil {
+			t.Errorf("ParseESpec(%q): %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseESpec(%q) = %+v; want %+v", test.in, got, test.want)
+		}
+		if s := got.String(); s != test.str {
+			t.Errorf("ParseESpec(%q).String() = %q; want %q", test.in, s, test.str)
+		}
+	}
+
+	for _, bad := range []string{"", "x", "z:0", "z:10", "b", "b:{256K*=z", "b:{0=n}", "b:{1K*0=n}", "nz"} {
+		if _, err := ParseESpec(bad); err == nil {
+			t.Errorf("ParseESpec(%q) succeeded; want error", bad)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"fmt"
+	"io"
+)
+
+// Inspect describes how the BLTE-encoded file in r, which is size bytes long, was encoded, reading only its header and the mode byte of each chunk.
+//
+// Encoding data with the returned ESpec produces the same chunks: runs of equally sized chunks become repeated blocks, and the last run also covers a shorter final chunk.
+// Compression levels aren't recorded in the file, so zlib chunks are described as plain 'z'; encrypted chunks are described as 'e', as what they hold can't be seen without their keys.
+func Inspect(r io.ReaderAt, size int64) (ESpec, error) {
+	chunks, _, err := readHeader(io.NewSectionReader(r, 0, size), &DefaultLimits)
+	if err != nil {
+		return ESpec{}, err
+	}
+	if chunks == nil {
+		mode, err := inspectChunk(r, 8, 0)
+		if err != nil {
+			return ESpec{}, err
+		}
+		return ESpec{Mode: mode}, nil
+	}
+
+	modes := make([]byte, len(chunks))
+	offset := int64(8 + 4 + 24*len(chunks))
+	for n, c := range chunks {
+		if c.compressedSize == 0 {
+			return ESpec{}, fmt.Errorf("blte: chunk %d is empty", n)
+		}
+		if modes[n], err = inspectChunk(r, offset, n); err != nil {
+			return ESpec{}, err
+		}
+		offset += int64(c.compressedSize)
+	}
+
+	spec := ESpec{Mode: 'b'}
+	for n := 0; n < len(chunks); {
+		size, mode := chunks[n].decompressedSize, modes[n]
+		end := n + 1
+		for end < len(chunks) && chunks[end].decompressedSize == size && modes[end] == mode {
+			end++
+		}
+		b := BlockSpec{Size: int64(size), Count: end - n, Spec: ESpec{Mode: mode}}
+		switch {
+		case end == len(chunks)-1 && modes[end] == mode && chunks[end].decompressedSize < size:
+			// A shorter final chunk is what's left over from the run.
+			end++
+			b.Count = 0
+		case end == len(chunks) && b.Count > 1:
+			b.Count = 0
+		case end == len(chunks):
+			// A single final chunk holds the rest of the file.
+			b.Size = 0
+		}
+		spec.Blocks = append(spec.Blocks, b)
+		n = end
+	}
+	return spec, nil
+}
+
+// inspectChunk returns the ESpec mode of the index'th chunk, which starts at offset.
+func inspectChunk(r io.ReaderAt, offset int64, index int) (byte, error) {
+	var cm [1]byte
+	if _, err := r.ReadAt(cm[:], offset); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	switch cm[0] {
+	case 'N', 'Z', 'E':
+		return cm[0] - 'A' + 'a', nil
+	}
+	if _, ok := codecs[cm[0]]; ok {
+		return cm[0], nil
+	}
+	return 0, fmt.Errorf("blte: unsupported compression method %v in chunk %d", cm[0], index)
+}
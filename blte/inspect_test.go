@@ -0,0 +1,70 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	data := bytes.Repeat([]byte("snowstorm "), 2000)
+	for _, test := range []struct {
+		spec string
+		want string
+	}{
+		{"n", "n"},
+		{"z:9", "z"},
+		{"b:{256K*=z}", "b:{*=z}"},
+		{"b:{4K*=z}", "b:{4K*=z}"},
+		{"b:{1K=n,4K*2=z,*=n}", "b:{1K=n,4K*2=z,*=n}"},
+		{"b:{2K*3=n,4K*=z}", "b:{2K*3=n,4K*=z}"},
+		{"b:{5000=z,*=z}", "b:{5000=z,*=z}"},
+		{"b:{10000=z,*=z}", "b:{10000*=z}"},
+		{"b:{15000=z,*=z}", "b:{15000*=z}"},
+	} {
+		spec, err := ParseESpec(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := Encode(data, spec)
+		if err != nil {
+			t.Fatalf("Encode(%q): %v", test.spec, err)
+		}
+
+		got, err := Inspect(bytes.NewReader(encoded), int64(len(encoded)))
+		if err != nil {
+			t.Errorf("Inspect(%q): %v", test.spec, err)
+			continue
+		}
+		if got.String() != test.want {
+			t.Errorf("Inspect(%q) = %q; want %q", test.spec, got, test.want)
+		}
+
+		// Encoding again with what Inspect found gives the same file, since zlib is used at the same level.
+		if test.spec == test.want {
+			again, err := Encode(data, got)
+			if err != nil || !bytes.Equal(again, encoded) {
+				t.Errorf("Encode(Inspect(%q)) = %v; want the original file", test.spec, err)
+			}
+		}
+	}
+
+	if _, err := Inspect(bytes.NewReader([]byte("BLTE\x00\x00\x00\x00")), 8); err == nil {
+		t.Errorf("Inspect(truncated file) succeeded; want error")
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import "fmt"
+
+// Limits bound how much a BLTE header can make a reader allocate, so that a corrupt or hostile file can't exhaust memory. A zero field means no limit.
+type Limits struct {
+	// MaxChunks is the most chunks a chunk table may list.
+	MaxChunks int
+
+	// MaxChunkSize is the largest encoded or decoded size a chunk may have. It also bounds files without a chunk table, where they are decoded in one piece.
+	MaxChunkSize int64
+
+	// MaxHeaderSize is the largest a header, including its chunk table, may be.
+	MaxHeaderSize int64
+}
+
+// DefaultLimits are used by readers which aren't given any. They are far beyond anything Blizzard serves.
+var DefaultLimits = Limits{
+	MaxChunks:     1 << 20,
+	MaxChunkSize:  1 << 30,
+	MaxHeaderSize: 12 + 24<<20,
+}
+
+// A LimitError reports a file which exceeds a reader's Limits.
+type LimitError struct {
+	What  string
+	Value int64 // or -1 if decoding stopped at the limit
+	Limit int64
+}
+
+func (e *LimitError) Error() string {
+	if e.Value < 0 {
+		return fmt.Sprintf("blte: %s exceeds limit of %d", e.What, e.Limit)
+	}
+	return fmt.Sprintf("blte: %s of %d exceeds limit of %d", e.What, e.Value, e.Limit)
+}
+
+// check returns a LimitError if value is over limit, which is ignored if it is zero.
+func check(what string, value, limit int64) error {
+	if limit > 0 && value > limit {
+		return &LimitError{What: what, Value: value, Limit: limit}
+	}
+	return nil
+}
+
+// orDefault returns l, or DefaultLimits if l is nil.
+func (l *Limits) orDefault() *Limits {
+	if l == nil {
+		return &DefaultLimits
+	}
+	return l
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	// A header claiming the most chunks a chunk table can hold, but with none of them.
+	hostile := []byte("BLTE\x00\x00\x00\x0c\x0f\xff\xff\xff")
+	if _, err := ioutil.ReadAll(NewReader(bytes.NewReader(hostile))); err == nil {
+		t.Errorf("reading a hostile header succeeded; want error")
+	}
+
+	data := make([]byte, 100000)
+	encoded, err := Encode(data, ChunkedESpec(10000, 'z'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		limits Limits
+		what   string
+	}{
+		{Limits{MaxChunks: 5}, "chunk count"},
+		{Limits{MaxChunkSize: 5000}, "chunk 0 decoded size"},
+		{Limits{MaxHeaderSize: 100}, "header size"},
+	} {
+		r := NewReader(bytes.NewReader(encoded))
+		r.Limits = &test.limits
+		_, err := ioutil.ReadAll(r)
+		if le, ok := err.(*LimitError); !ok || le.What != test.what {
+			t.Errorf("reading with %+v: %v; want a LimitError for %s", test.limits, err, test.what)
+		}
+	}
+
+	// Files without a chunk table can't say how big they'll be, so they're stopped as they're decoded.
+	single, err := Encode(data, ESpec{Mode: 'z'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ra, err := NewReaderAtLimits(bytes.NewReader(single), int64(len(single)), &Limits{MaxChunkSize: 5000})
+	if err != nil {
+		t.Fatalf("NewReaderAtLimits: %v", err)
+	}
+	if _, err := ra.Size(); err == nil {
+		t.Errorf("Size() of a file decoding past the limit succeeded; want error")
+	} else if _, ok := err.(*LimitError); !ok {
+		t.Errorf("Size() = %v; want a LimitError", err)
+	}
+
+	if _, err := NewReaderAt(bytes.NewReader(single), int64(len(single))); err != nil {
+		t.Errorf("NewReaderAt with the default limits: %v", err)
+	}
+	binary.BigEndian.PutUint32(encoded[4:8], 1<<30)
+	if _, err := NewReaderAt(bytes.NewReader(encoded), int64(len(encoded))); err == nil {
+		t.Errorf("NewReaderAt with a huge header length succeeded; want error")
+	}
+}
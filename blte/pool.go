@@ -0,0 +1,44 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"compress/zlib"
+	"io"
+	"sync"
+)
+
+// zlibReaders holds zlib readers which have finished a chunk, since each holds a large window which is better reused than reallocated.
+var zlibReaders sync.Pool
+
+// getZlib returns a zlib reader for r, reusing a pooled one if there is one.
+func getZlib(r io.Reader) (io.ReadCloser, error) {
+	if zr, ok := zlibReaders.Get().(io.ReadCloser); ok {
+		if err := zr.(zlib.Resetter).Reset(r, nil); err != nil {
+			return nil, err
+		}
+		return zr, nil
+	}
+	return zlib.NewReader(r)
+}
+
+// putZlib returns r to the pool if it is a zlib reader. It must not be used afterwards.
+func putZlib(r io.Reader) {
+	if _, ok := r.(zlib.Resetter); ok {
+		zlibReaders.Put(r)
+	}
+}
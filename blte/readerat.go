@@ -0,0 +1,187 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// A ReaderAt provides random access to the decoded contents of a BLTE-encoded file, decoding only the chunks which overlap each read.
+//
+// It implements io.ReaderAt; wrap it in an io.SectionReader of Size bytes for io.Reader and io.Seeker. It is safe for concurrent use.
+type ReaderAt struct {
+	r io.ReaderAt
+
+	// Keys, if set, supplies the keys for any encrypted chunks.
+	Keys KeyProvider
+
+	// Verify and OnMismatch control checksum checks, as they do for a Reader.
+	Verify     VerifyMode
+	OnMismatch func(*ChecksumError)
+
+	chunked bool // whether the file has a chunk table, and so chunk checksums
+	chunks  []chunkInfo
+	offsets []int64 // where each chunk starts in r
+	starts  []int64 // where each chunk starts in the decoded file, with the decoded size as a final entry
+
+	mu        sync.Mutex
+	lastChunk int
+	lastData  []byte
+	enc       []byte // reused for each chunk's encoded contents
+
+	maxSize int64 // the most a file without a chunk table may decode to
+}
+
+// NewReaderAt reads the header of the encoded file in r, which is size bytes long.
+//
+// Files without a chunk table are a single chunk of unknown decoded size, so the whole file is decoded by the first read, or by Size.
+func NewReaderAt(r io.ReaderAt, size int64) (*ReaderAt, error) {
+	return NewReaderAtLimits(r, size, nil)
+}
+
+// NewReaderAtLimits is like NewReaderAt, but checks the file against l rather than DefaultLimits.
+func NewReaderAtLimits(r io.ReaderAt, size int64, l *Limits) (*ReaderAt, error) {
+	l = l.orDefault()
+	chunks, _, err := readHeader(io.NewSectionReader(r, 0, size), l)
+	if err != nil {
+		return nil, err
+	}
+
+	ra := &ReaderAt{r: r, lastChunk: -1, maxSize: l.MaxChunkSize}
+	if chunks == nil {
+		if err := check("chunk 0 encoded size", size-8, l.MaxChunkSize); err != nil {
+			return nil, err
+		}
+		ra.chunks = []chunkInfo{{compressedSize: uint32(size - 8)}}
+		ra.offsets = []int64{8}
+		ra.starts = []int64{0, -1}
+		return ra, nil
+	}
+
+	ra.chunked = true
+	ra.chunks = chunks
+	offset := int64(8 + 4 + 24*len(chunks))
+	var start int64
+	for _, c := range chunks {
+		ra.offsets = append(ra.offsets, offset)
+		ra.starts = append(ra.starts, start)
+		offset += int64(c.compressedSize)
+		start += int64(c.decompressedSize)
+	}
+	ra.starts = append(ra.starts, start)
+	if offset > size {
+		return nil, fmt.Errorf("blte: chunks need %d bytes, but the file is only %d", offset, size)
+	}
+	return ra, nil
+}
+
+// Size returns the decoded size of the file.
+func (r *ReaderAt) Size() (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.starts[len(r.starts)-1] < 0 {
+		if _, err := r.chunk(0); err != nil {
+			return 0, err
+		}
+	}
+	return r.starts[len(r.starts)-1], nil
+}
+
+// chunk decodes the n'th chunk, keeping the most recent one for the sequential reads of an io.SectionReader. r.mu must be held.
+func (r *ReaderAt) chunk(n int) ([]byte, error) {
+	if n == r.lastChunk {
+		return r.lastData, nil
+	}
+
+	ci := r.chunks[n]
+	if uint32(cap(r.enc)) < ci.compressedSize {
+		r.enc = make([]byte, ci.compressedSize)
+	}
+	enc := r.enc[:ci.compressedSize]
+	if _, err := r.r.ReadAt(enc, r.offsets[n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if len(enc) == 0 {
+		return nil, fmt.Errorf("blte: chunk %d is empty", n)
+	}
+	if r.chunked && r.Verify != VerifyNone {
+		if err := checkChunk(r.Verify, r.OnMismatch, n, md5.Sum(enc), ci.checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	// The last chunk's buffer is reused for this one.
+	dst := r.lastData
+	r.lastChunk, r.lastData = -1, nil
+	if uint32(cap(dst)) < ci.decompressedSize {
+		dst = make([]byte, 0, ci.decompressedSize)
+	}
+	max := r.maxSize
+	if r.chunked {
+		max = int64(ci.decompressedSize)
+	}
+	data, err := decodeChunk(dst, enc[0], bytes.NewReader(enc[1:]), n, r.Keys, max)
+	if err != nil {
+		return nil, err
+	}
+	if r.starts[n+1] < 0 {
+		// Now we know how big a file without a chunk table is.
+		r.starts[n+1] = int64(len(data))
+	} else if int64(len(data)) != r.starts[n+1]-r.starts[n] {
+		return nil, fmt.Errorf("blte: chunk %d decoded to %d bytes; header said %d", n, len(data), ci.decompressedSize)
+	}
+
+	r.lastChunk, r.lastData = n, data
+	return data, nil
+}
+
+// ReadAt reads len(b) decoded bytes starting at off.
+func (r *ReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("blte: negative offset %d", off)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The chunk holding off is the last to start at or before it.
+	n := sort.Search(len(r.chunks), func(i int) bool { return r.starts[i] > off }) - 1
+	var read int
+	for ; read < len(b) && n < len(r.chunks); n++ {
+		data, err := r.chunk(n)
+		if err != nil {
+			return read, err
+		}
+		pos := off + int64(read) - r.starts[n]
+		if pos >= int64(len(data)) {
+			continue
+		}
+		read += copy(b[read:], data[pos:])
+	}
+	if read < len(b) {
+		return read, io.EOF
+	}
+	return read, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReaderAt(t *testing.T) {
+	data := make([]byte, 10000)
+	for n := range data {
+		data[n] = byte(n * 7)
+	}
+	for _, spec := range []ESpec{
+		{Mode: 'z'},
+		ChunkedESpec(1000, 'z'),
+		{Mode: 'b', Blocks: []BlockSpec{{Size: 22, Count: 1, Spec: ESpec{Mode: 'n'}}, {Size: 4096, Count: 2, Spec: ESpec{Mode: 'z'}}, {Count: 1, Spec: ESpec{Mode: 'n'}}}},
+	} {
+		encoded, err := Encode(data, spec)
+		if err != nil {
+			t.Fatalf("Encode(%q): %v", spec, err)
+		}
+		ra, err := NewReaderAt(bytes.NewReader(encoded), int64(len(encoded)))
+		if err != nil {
+			t.Fatalf("NewReaderAt(%q): %v", spec, err)
+		}
+		if size, err := ra.Size(); err != nil || size != int64(len(data)) {
+			t.Errorf("NewReaderAt(%q).Size() = %d, %v; want %d", spec, size, err, len(data))
+		}
+
+		for _, r := range []struct{ off, n int }{{0, 10}, {20, 10}, {990, 20}, {4000, 5000}, {9990, 10}, {0, 10000}} {
+			b := make([]byte, r.n)
+			if n, err := ra.ReadAt(b, int64(r.off)); err != nil || n != r.n {
+				t.Errorf("ReadAt(%q, %d bytes at %d) = %d, %v", spec, r.n, r.off, n, err)
+			} else if !bytes.Equal(b, data[r.off:r.off+r.n]) {
+				t.Errorf("ReadAt(%q, %d bytes at %d) read the wrong data", spec, r.n, r.off)
+			}
+		}
+
+		b := make([]byte, 20)
+		if n, err := ra.ReadAt(b, 9990); err != io.EOF || n != 10 {
+			t.Errorf("ReadAt(%q) past the end = %d, %v; want 10, EOF", spec, n, err)
+		}
+
+		// Seeking, through an io.SectionReader.
+		sr := io.NewSectionReader(ra, 0, int64(len(data)))
+		if _, err := sr.Seek(5000, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		rest, err := ioutil.ReadAll(sr)
+		if err != nil || !bytes.Equal(rest, data[5000:]) {
+			t.Errorf("reading %q from 5000 returned %d bytes, %v; want the last %d", spec, len(rest), err, len(data)-5000)
+		}
+	}
+
+	// Corrupt chunks are detected when they are read.
+	encoded, err := Encode(data, ChunkedESpec(1000, 'n'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded[len(encoded)-1] ^= 0xff
+	ra, err := NewReaderAt(bytes.NewReader(encoded), int64(len(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ra.ReadAt(make([]byte, 10), 0); err != nil {
+		t.Errorf("ReadAt of an intact chunk: %v", err)
+	}
+	if _, err := ra.ReadAt(make([]byte, 10), 9990); err == nil {
+		t.Errorf("ReadAt of a corrupt chunk succeeded; want error")
+	}
+}
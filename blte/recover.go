@@ -0,0 +1,102 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+)
+
+// A RecoverMode says what a Reader does with chunks which it can't decode, or which fail their checksum.
+type RecoverMode int
+
+const (
+	// RecoverNone fails the read at the first bad chunk.
+	RecoverNone RecoverMode = iota
+
+	// RecoverZero replaces bad chunks with zeroes, so that everything after them is still at the right offset.
+	RecoverZero
+
+	// RecoverTruncate leaves bad chunks out of the output.
+	RecoverTruncate
+)
+
+// A Damage describes a bad chunk which a Reader skipped.
+type Damage struct {
+	Chunk int
+
+	// Offset is where the chunk starts, or would have started, in the decoded output, and Size is its decoded size according to the header.
+	Offset, Size int64
+
+	Err error
+}
+
+// Damage lists the chunks which have been skipped so far, when Recover is set.
+func (r *Reader) Damage() []Damage {
+	return r.damage
+}
+
+// recoverChunk reads the whole of the current chunk before decoding it, so that it can be skipped if it is bad without any of it having been returned.
+//
+// Errors reading from the underlying reader still fail the read, since nothing after them can be trusted either.
+func (r *Reader) recoverChunk() error {
+	n := int(r.currentChunk)
+	ci := r.chunks[n]
+	enc, err := readBytes(r.r, int(ci.compressedSize))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	data, err := r.decodeWhole(n, enc)
+	if err != nil {
+		r.damage = append(r.damage, Damage{Chunk: n, Offset: r.recovered, Size: int64(ci.decompressedSize), Err: err})
+		data = nil
+		if r.Recover == RecoverZero {
+			data = make([]byte, ci.decompressedSize)
+		}
+	}
+	r.recovered += int64(len(data))
+	r.chunk = bytes.NewReader(data)
+	r.chunkHash = nil
+	return nil
+}
+
+// decodeWhole checks and decodes the n'th chunk, whose encoded contents are enc.
+func (r *Reader) decodeWhole(n int, enc []byte) ([]byte, error) {
+	ci := r.chunks[n]
+	if len(enc) == 0 {
+		return nil, fmt.Errorf("blte: chunk %d is empty", n)
+	}
+	if r.Verify != VerifyNone {
+		if err := checkChunk(r.Verify, r.OnMismatch, n, md5.Sum(enc), ci.checksum); err != nil {
+			return nil, err
+		}
+	}
+	data, err := decodeChunk(nil, enc[0], bytes.NewReader(enc[1:]), n, r.Keys, int64(ci.decompressedSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != int(ci.decompressedSize) {
+		return nil, fmt.Errorf("blte: chunk %d decoded to %d bytes; header said %d", n, len(data), ci.decompressedSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReaderRecover(t *testing.T) {
+	data := bytes.Repeat([]byte("snowstorm "), 300)
+	encoded, err := Encode(data, ChunkedESpec(1000, 'z'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt the second chunk's zlib stream, just after its mode byte.
+	first := binary.BigEndian.Uint32(encoded[12:16])
+	encoded[12+24*3+int(first)+1] ^= 0xff
+
+	if _, err := ioutil.ReadAll(NewReader(bytes.NewReader(encoded))); err == nil {
+		t.Fatalf("reading without recovery succeeded; want error")
+	}
+
+	for _, test := range []struct {
+		mode RecoverMode
+		want []byte
+	}{
+		{RecoverZero, append(append(append([]byte{}, data[:1000]...), make([]byte, 1000)...), data[2000:]...)},
+		{RecoverTruncate, append(append([]byte{}, data[:1000]...), data[2000:]...)},
+	} {
+		r := NewReader(bytes.NewReader(encoded))
+		r.Recover = test.mode
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("mode %d: ioutil.ReadAll: %v", test.mode, err)
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("mode %d: read %d bytes, which weren't what was salvageable", test.mode, len(got))
+		}
+
+		damage := r.Damage()
+		if len(damage) != 1 {
+			t.Errorf("mode %d: Damage() = %v; want one damaged chunk", test.mode, damage)
+			continue
+		}
+		if d := damage[0]; d.Chunk != 1 || d.Offset != 1000 || d.Size != 1000 || d.Err == nil {
+			t.Errorf("mode %d: Damage()[0] = %+v; want chunk 1 at 1000, of 1000 bytes", test.mode, d)
+		}
+	}
+
+	// Running out of data isn't recoverable.
+	r := NewReader(bytes.NewReader(encoded[:len(encoded)-10]))
+	r.Recover = RecoverZero
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Errorf("reading a truncated file succeeded; want error")
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+)
+
+// A VerifyMode says what a reader does about chunk checksums.
+type VerifyMode int
+
+const (
+	// VerifyStrict checks every chunk, and fails the read at the first mismatch.
+	VerifyStrict VerifyMode = iota
+
+	// VerifyNone skips checksums entirely, for when the data has already been checked upstream.
+	VerifyNone
+
+	// VerifyReport checks every chunk, but only reports mismatches, to the reader's OnMismatch callback.
+	VerifyReport
+)
+
+var verifyModeNames = []string{"strict", "none", "report"}
+
+func (m VerifyMode) String() string {
+	if m < 0 || int(m) >= len(verifyModeNames) {
+		return fmt.Sprintf("VerifyMode(%d)", int(m))
+	}
+	return verifyModeNames[m]
+}
+
+// Set parses a mode by name, so a VerifyMode can be used as a flag.Value.
+func (m *VerifyMode) Set(s string) error {
+	for n, name := range verifyModeNames {
+		if s == name {
+			*m = VerifyMode(n)
+			return nil
+		}
+	}
+	return fmt.Errorf("blte: unknown verify mode %q; want strict, none or report", s)
+}
+
+// A ChecksumError reports a chunk whose contents don't match the checksum in the header.
+type ChecksumError struct {
+	Chunk     int
+	Got, Want [16]byte
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("blte: checksum mismatch in chunk %d: calculated %x, header said %x", e.Chunk, e.Got, e.Want)
+}
+
+// checkChunk applies mode to the checksum of a chunk.
+func checkChunk(mode VerifyMode, onMismatch func(*ChecksumError), chunk int, got, want [16]byte) error {
+	if mode == VerifyNone || got == want {
+		return nil
+	}
+	err := &ChecksumError{Chunk: chunk, Got: got, Want: want}
+	if mode != VerifyReport {
+		return err
+	}
+	if onMismatch != nil {
+		onMismatch(err)
+	}
+	return nil
+}
+
+// HeaderHash returns the MD5 of the BLTE header of the encoded file b, which is what it is named after on the CDN. Files without a chunk table are named after their entire contents.
+func HeaderHash(b []byte) ([md5.Size]byte, error) {
+	if len(b) < 8 || string(b[:4]) != "BLTE" {
+		return [md5.Size]byte{}, ErrBadMagic
+	}
+	hdrLen := binary.BigEndian.Uint32(b[4:8])
+	if hdrLen == 0 {
+		return md5.Sum(b), nil
+	}
+	if int64(hdrLen) > int64(len(b)) {
+		return [md5.Size]byte{}, fmt.Errorf("blte: header length %d exceeds file size %d", hdrLen, len(b))
+	}
+	return md5.Sum(b[:hdrLen]), nil
+}
+
+// Verify checks that the encoded file b is named h, and that each of its chunks matches the checksum in its header.
+//
+// Chunks are not decoded, so files with encrypted chunks can be checked without their keys.
+func Verify(b []byte, h [md5.Size]byte) error {
+	got, err := HeaderHash(b)
+	if err != nil {
+		return err
+	}
+	if got != h {
+		return fmt.Errorf("blte: header hash is %x; want %x", got, h)
+	}
+
+	hdrLen := int(binary.BigEndian.Uint32(b[4:8]))
+	if hdrLen == 0 {
+		// The hash covered the whole file.
+		return nil
+	}
+	if hdrLen < 12 {
+		return fmt.Errorf("blte: header length %d is too short", hdrLen)
+	}
+	count := int(binary.BigEndian.Uint32(b[8:12]) & 0xffffff)
+	if hdrLen != 12+24*count {
+		return fmt.Errorf("blte: header length %d doesn't fit %d chunks", hdrLen, count)
+	}
+
+	data := b[hdrLen:]
+	for n := 0; n < count; n++ {
+		info := b[12+24*n : 12+24*(n+1)]
+		size := int64(binary.BigEndian.Uint32(info[0:4]))
+		if size > int64(len(data)) {
+			return fmt.Errorf("blte: chunk %d is truncated", n)
+		}
+		if sum := md5.Sum(data[:size]); !bytes.Equal(sum[:], info[8:24]) {
+			return fmt.Errorf("blte: checksum mismatch in chunk %d: calculated %x, header said %x", n, sum, info[8:24])
+		}
+		data = data[size:]
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("blte: %d bytes of trailing data after the last chunk", len(data))
+	}
+	return nil
+}
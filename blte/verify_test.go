@@ -0,0 +1,57 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blte
+
+import (
+	"bytes"
+	"crypto/md5"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	in := bytes.Repeat([]byte("some data "), 1000)
+	for _, specStr := range []string{"n", "b:{1K*=z}"} {
+		spec, err := ParseESpec(specStr)
+		if err != nil {
+			t.Fatalf("ParseESpec(%q): %v", specStr, err)
+		}
+		encoded, err := Encode(in, spec)
+		if err != nil {
+			t.Fatalf("Encode(%q): %v", specStr, err)
+		}
+		h, err := HeaderHash(encoded)
+		if err != nil {
+			t.Fatalf("HeaderHash(%q): %v", specStr, err)
+		}
+
+		if err := Verify(encoded, h); err != nil {
+			t.Errorf("%s: Verify: %v", specStr, err)
+		}
+		if err := Verify(encoded, [md5.Size]byte{1}); err == nil {
+			t.Errorf("%s: Verify with the wrong name succeeded", specStr)
+		}
+
+		corrupt := append([]byte(nil), encoded...)
+		corrupt[len(corrupt)-1] ^= 0xff
+		if err := Verify(corrupt, h); err == nil {
+			t.Errorf("%s: Verify of corrupt data succeeded", specStr)
+		}
+		if err := Verify(encoded[:len(encoded)-1], h); err == nil {
+			t.Errorf("%s: Verify of truncated data succeeded", specStr)
+		}
+	}
+}
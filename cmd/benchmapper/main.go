@@ -0,0 +1,276 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command benchmapper measures how long the encoding, archive and filename mappers take to build, and how much memory they hold on to once built.
+//
+// Usage:
+//
+//	benchmapper [flags]
+//
+// By default it synthesizes inputs with -n entries each. Real inputs can be given instead with -encoding, -indices and -names; the encoding table may be BLTE-encoded or not, and each archive index must be named after its archive, as on the CDN.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lukegb/snowstorm/blte"
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/encoding"
+	"github.com/lukegb/snowstorm/ngdp/mndx"
+	"github.com/lukegb/snowstorm/ngdp/ngdptest"
+	"github.com/lukegb/snowstorm/ngdp/staticmap"
+)
+
+var (
+	entries    = flag.Int("n", 1000000, "number of entries in each synthesized input")
+	runs       = flag.Int("runs", 5, "number of times to build each mapper")
+	encFile    = flag.String("encoding", "", "path to an encoding table to use instead of a synthesized one")
+	indexFiles = flag.String("indices", "", "comma-separated paths of archive indices to use instead of a synthesized one")
+	namesFile  = flag.String("names", "", "path to a .csv or .json filename manifest to use instead of a synthesized one")
+	cpuProfile = flag.String("cpuprofile", "", "write a CPU profile of every run to this file")
+	memProfile = flag.String("memprofile", "", "write a heap profile, taken once every mapper is built, to this file")
+)
+
+// A result summarizes the runs of one benchmark.
+type result struct {
+	name     string
+	entries  int
+	best     time.Duration
+	mean     time.Duration
+	retained uint64
+}
+
+// heapInUse returns the number of bytes of live heap objects, once garbage has been collected.
+func heapInUse() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// measure builds a mapper runs times, recording how long it takes and how much memory the last one retains.
+//
+// build returns the mapper, which is kept alive until it has been measured, and the number of entries in it.
+func measure(name string, runs int, build func() (interface{}, int, error)) (result, interface{}, error) {
+	r := result{name: name}
+	var total time.Duration
+	var m interface{}
+	for i := 0; i < runs; i++ {
+		m = nil
+		before := heapInUse()
+
+		start := time.Now()
+		var err error
+		m, r.entries, err = build()
+		d := time.Since(start)
+		if err != nil {
+			return r, nil, fmt.Errorf("%s: %v", name, err)
+		}
+
+		if after := heapInUse(); after > before {
+			r.retained = after - before
+		}
+		total += d
+		if i == 0 || d < r.best {
+			r.best = d
+		}
+	}
+	r.mean = total / time.Duration(runs)
+	return r, m, nil
+}
+
+func readEncoding() ([]byte, error) {
+	if *encFile == "" {
+		return ngdptest.SyntheticEncodingTable(*entries), nil
+	}
+	b, err := os.ReadFile(*encFile)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(b, []byte("BLTE")) {
+		return blte.DecodeAll(bytes.NewReader(b))
+	}
+	return b, nil
+}
+
+func readIndices() (map[ngdp.CDNHash][]byte, error) {
+	indices := make(map[ngdp.CDNHash][]byte)
+	if *indexFiles == "" {
+		b, name := ngdptest.SyntheticArchiveIndex(*entries)
+		indices[name] = b
+		return indices, nil
+	}
+	for _, fn := range strings.Split(*indexFiles, ",") {
+		var name ngdp.CDNHash
+		if err := name.UnmarshalText([]byte(strings.TrimSuffix(filepath.Base(fn), ".index"))); err != nil {
+			return nil, fmt.Errorf("%s: should be named after its archive: %v", fn, err)
+		}
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		indices[name] = b
+	}
+	return indices, nil
+}
+
+// synthesizeNames builds a filename map of n files, spread across a few levels of directories.
+func synthesizeNames(n int) mndx.FilenameMap {
+	m := make(mndx.FilenameMap, n)
+	for i := 0; i < n; i++ {
+		p := fmt.Sprintf("Dir%d/Sub%d/file%d.dat", i%50, i%1000, i)
+		m[p] = &mndx.File{Name: p, Size: uint32(i), FileDataID: uint32(i)}
+	}
+	return m
+}
+
+func run() error {
+	if *runs < 1 {
+		return fmt.Errorf("-runs must be at least 1")
+	}
+
+	enc, err := readEncoding()
+	if err != nil {
+		return fmt.Errorf("reading encoding table: %v", err)
+	}
+	indices, err := readIndices()
+	if err != nil {
+		return fmt.Errorf("reading archive indices: %v", err)
+	}
+	var names mndx.FilenameMap
+	if *namesFile == "" {
+		names = synthesizeNames(*entries)
+	}
+
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return err
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	benchmarks := []struct {
+		name  string
+		build func() (interface{}, int, error)
+	}{
+		{"encoding", func() (interface{}, int, error) {
+			m, err := encoding.NewMapper(bytes.NewReader(enc))
+			if err != nil {
+				return nil, 0, err
+			}
+			n := 0
+			for range m.All() {
+				n++
+			}
+			return m, n, nil
+		}},
+		{"archive", func() (interface{}, int, error) {
+			archives := make([]ngdp.CDNHash, 0, len(indices))
+			for h := range indices {
+				archives = append(archives, h)
+			}
+			open := func(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(indices[h])), nil
+			}
+			m, err := client.NewArchiveMapperFromIndices(context.Background(), archives, open)
+			if err != nil {
+				return nil, 0, err
+			}
+			n := 0
+			for range m.Entries() {
+				n++
+			}
+			return m, n, nil
+		}},
+		{"filename", func() (interface{}, int, error) {
+			var tree *mndx.TreeDirectory
+			var err error
+			if *namesFile != "" {
+				tree, err = staticmap.Load(*namesFile)
+			} else {
+				tree, err = mndx.ToTree(names)
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			n := 0
+			for range tree.Files() {
+				n++
+			}
+			return tree, n, nil
+		}},
+	}
+
+	var results []result
+	var mappers []interface{}
+	for _, bm := range benchmarks {
+		r, m, err := measure(bm.name, *runs, bm.build)
+		if err != nil {
+			return err
+		}
+		results = append(results, r)
+		mappers = append(mappers, m)
+	}
+
+	if *memProfile != "" {
+		f, err := os.Create(*memProfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			return err
+		}
+	}
+	runtime.KeepAlive(mappers)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MAPPER\tENTRIES\tBEST\tMEAN\tRETAINED\tBYTES/ENTRY")
+	for _, r := range results {
+		perEntry := uint64(0)
+		if r.entries > 0 {
+			perEntry = r.retained / uint64(r.entries)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t%d\t%d\n", r.name, r.entries, r.best, r.mean, r.retained, perEntry)
+	}
+	return tw.Flush()
+}
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"container/list"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// A diskCache keeps objects on disk, evicting the least recently used once they take up more than maxSize bytes.
+type diskCache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	pending map[string]*sync.Mutex
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// newDiskCache returns a cache in dir, picking up anything already there. Modification times are used as access times, so that eviction order survives restarts.
+func newDiskCache(dir string, maxSize int64) (*diskCache, error) {
+	c := &diskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		pending: make(map[string]*sync.Mutex),
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	type found struct {
+		key   string
+		size  int64
+		mtime time.Time
+	}
+	var files []found
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(fi.Name(), ".") {
+			// A temporary file left behind by a crash.
+			return os.Remove(p)
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, found{filepath.ToSlash(rel), fi.Size(), fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mtime.After(files[j].mtime) })
+	for _, f := range files {
+		c.entries[f.key] = c.lru.PushBack(&cacheEntry{f.key, f.size})
+		c.size += f.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	glog.Infof("Cache holds %d objects, %d bytes", len(c.entries), c.size)
+	return c, nil
+}
+
+func (c *diskCache) path(key string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(key))
+}
+
+// lock serialises filling of each key, so that concurrent misses only fetch it once.
+func (c *diskCache) lock(key string) func() {
+	c.mu.Lock()
+	l, ok := c.pending[key]
+	if !ok {
+		l = new(sync.Mutex)
+		c.pending[key] = l
+	}
+	c.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		c.mu.Lock()
+		delete(c.pending, key)
+		c.mu.Unlock()
+	}
+}
+
+// open returns the cached copy of key, if there is one, and marks it as recently used.
+func (c *diskCache) open(key string) (*os.File, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	fn := c.path(key)
+	f, err := os.Open(fn)
+	if err != nil {
+		glog.Warningf("Opening cached %s: %v", key, err)
+		c.remove(key)
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(fn, now, now)
+	return f, true
+}
+
+// fill copies r into the cache under key, then opens it.
+func (c *diskCache) fill(key string, r io.Reader) (*os.File, error) {
+	fn := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(fn), ".cdnproxy-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(f.Name(), fn); err != nil {
+		return nil, err
+	}
+
+	// Open before evicting, so that an object bigger than the whole cache can still be served once.
+	out, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.size -= e.Value.(*cacheEntry).size
+		c.lru.Remove(e)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key, size})
+	c.size += size
+	c.evict()
+	return out, nil
+}
+
+// remove forgets about key, and deletes it from disk.
+func (c *diskCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.size -= e.Value.(*cacheEntry).size
+		c.lru.Remove(e)
+		delete(c.entries, key)
+	}
+	os.Remove(c.path(key))
+}
+
+// evict deletes the least recently used objects until the cache fits in maxSize. c.mu must be held.
+func (c *diskCache) evict() {
+	for c.maxSize > 0 && c.size > c.maxSize {
+		e := c.lru.Back()
+		if e == nil {
+			return
+		}
+		ce := e.Value.(*cacheEntry)
+		c.lru.Remove(e)
+		delete(c.entries, ce.key)
+		c.size -= ce.size
+		// Anyone still reading the file keeps their open handle.
+		if err := os.Remove(c.path(ce.key)); err != nil {
+			glog.Warningf("Evicting %s: %v", ce.key, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command cdnproxy is a caching proxy for Blizzard's CDNs, for networks where many machines download the same builds.
+//
+// Usage:
+//
+//	cdnproxy [flags]
+//
+// Point clients at it in place of a CDN host. Content-addressed objects (configs, data, patches and their indices) are cached on disk and evicted least recently used first once the cache is full; everything else is passed through to the upstream hosts uncached.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	listen    = flag.String("listen", ":8080", "HTTP listen address")
+	cacheDir  = flag.String("cache", "cdnproxy-cache", "directory to cache objects in")
+	maxSize   = flag.Int64("max-size", 100<<30, "maximum size of the cache in bytes; 0 means unlimited")
+	upstreams = flag.String("upstream", "level3.blizzard.com,us.cdn.blizzard.com", "comma-separated list of CDN hosts to fetch from, tried in order")
+	timeout   = flag.Duration("timeout", time.Minute, "how long to wait for upstream hosts to start responding")
+)
+
+// cacheable matches the paths of content-addressed objects, which never change once published.
+var cacheable = regexp.MustCompile(`^/[a-z0-9/_-]+/(config|data|patch)/[0-9a-f]{2}/[0-9a-f]{2}/[0-9a-f]{32}(\.index)?$`)
+
+type proxy struct {
+	cache     *diskCache
+	client    *http.Client
+	upstreams []string
+}
+
+// fetch requests urlPath from each upstream host in turn, until one has it.
+func (p *proxy) fetch(ctx context.Context, method, urlPath string, header http.Header) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	for _, host := range p.upstreams {
+		var req *http.Request
+		req, err = http.NewRequest(method, "http://"+host+urlPath, nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		resp, err = p.client.Do(req)
+		if err != nil {
+			glog.Warningf("%s: %s: %v", urlPath, host, err)
+			continue
+		}
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode >= 500 {
+			// Another host might do better.
+			glog.Warningf("%s: %s: %s", urlPath, host, resp.Status)
+			resp.Body.Close()
+			continue
+		}
+		return resp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no upstream host has %s", urlPath)
+}
+
+// passthrough proxies a request which can't be cached, such as one for a versions or cdns table.
+func (p *proxy) passthrough(w http.ResponseWriter, r *http.Request) {
+	header := make(http.Header)
+	for _, k := range []string{"Range", "If-None-Match", "If-Modified-Since"} {
+		if v := r.Header.Get(k); v != "" {
+			header.Set(k, v)
+		}
+	}
+	resp, err := p.fetch(r.Context(), r.Method, r.URL.RequestURI(), header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	urlPath := path.Clean(r.URL.Path)
+	if !cacheable.MatchString(urlPath) || r.URL.RawQuery != "" {
+		p.passthrough(w, r)
+		return
+	}
+	key := strings.TrimPrefix(urlPath, "/")
+
+	f, ok := p.cache.open(key)
+	if !ok {
+		unlock := p.cache.lock(key)
+		f, ok = p.cache.open(key)
+		if !ok {
+			var err error
+			f, err = p.fill(r, urlPath, key)
+			if err != nil {
+				unlock()
+				glog.Errorf("%s: %v", urlPath, err)
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+		unlock()
+	}
+	defer f.Close()
+
+	// Objects never change, so clients can keep them forever.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
+
+// fill fetches an entire object from upstream into the cache. Range requests are served from the cached copy afterwards.
+func (p *proxy) fill(r *http.Request, urlPath, key string) (*os.File, error) {
+	resp, err := p.fetch(r.Context(), http.MethodGet, urlPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream status was %q", resp.Status)
+	}
+
+	glog.Infof("Caching %s", urlPath)
+	f, err := p.cache.fill(key, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength >= 0 {
+		if fi, err := f.Stat(); err == nil && fi.Size() != resp.ContentLength {
+			f.Close()
+			p.cache.remove(key)
+			return nil, fmt.Errorf("upstream sent %d bytes; expected %d", fi.Size(), resp.ContentLength)
+		}
+	}
+	return f, nil
+}
+
+func main() {
+	flag.Parse()
+
+	cache, err := newDiskCache(*cacheDir, *maxSize)
+	if err != nil {
+		glog.Exitf("Opening cache: %v", err)
+	}
+
+	p := &proxy{
+		cache: cache,
+		client: &http.Client{
+			// Archives can take a long time to download, so only the wait for headers is bounded.
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				ResponseHeaderTimeout: *timeout,
+			},
+		},
+		upstreams: strings.Split(*upstreams, ","),
+	}
+
+	glog.Infof("Listening on %q", *listen)
+	glog.Exit(http.ListenAndServe(*listen, p))
+}
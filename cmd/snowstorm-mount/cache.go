@@ -0,0 +1,106 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+)
+
+// A cache keeps decoded files on disk, so that each is only retrieved once.
+type cache struct {
+	dir     string
+	fetcher client.Fetcher
+
+	mu      sync.Mutex
+	pending map[ngdp.ContentHash]*sync.Mutex
+}
+
+func newCache(dir string, fetcher client.Fetcher) *cache {
+	return &cache{
+		dir:     dir,
+		fetcher: fetcher,
+		pending: make(map[ngdp.ContentHash]*sync.Mutex),
+	}
+}
+
+func (c *cache) path(h ngdp.ContentHash) string {
+	hs := fmt.Sprintf("%032x", h)
+	return filepath.Join(c.dir, hs[0:2], hs[2:4], hs)
+}
+
+// lock serialises retrieval of each file, so that concurrent opens only fetch it once.
+func (c *cache) lock(h ngdp.ContentHash) func() {
+	c.mu.Lock()
+	l, ok := c.pending[h]
+	if !ok {
+		l = new(sync.Mutex)
+		c.pending[h] = l
+	}
+	c.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// open returns the cached copy of a file, retrieving it first if necessary.
+func (c *cache) open(ctx context.Context, h ngdp.ContentHash) (*os.File, error) {
+	fn := c.path(h)
+	if f, err := os.Open(fn); err == nil {
+		return f, nil
+	}
+
+	defer c.lock(h)()
+	if f, err := os.Open(fn); err == nil {
+		// Someone else fetched it while we were waiting.
+		return f, nil
+	}
+
+	resp, err := c.fetcher.Fetch(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(fn), ".cache-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(f.Name(), fn); err != nil {
+		return nil, err
+	}
+	return os.Open(fn)
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+
+	"github.com/golang/glog"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/mndx"
+)
+
+// filesystem exposes a filename tree over FUSE.
+type filesystem struct {
+	root  *mndx.TreeDirectory
+	cache *cache
+}
+
+func (f *filesystem) Root() (fs.Node, error) {
+	return &dir{f, f.root}, nil
+}
+
+func (f *filesystem) node(dent mndx.TreeDirectoryEntry) fs.Node {
+	if dent.Directory != nil {
+		return &dir{f, dent.Directory}
+	}
+	return &file{f, dent.File}
+}
+
+type dir struct {
+	fs *filesystem
+	d  *mndx.TreeDirectory
+}
+
+func (d *dir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	return nil
+}
+
+func (d *dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	dent, err := d.d.Get(name)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+	return d.fs.node(dent), nil
+}
+
+func (d *dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	dents := d.d.List()
+	out := make([]fuse.Dirent, len(dents))
+	for n, dent := range dents {
+		out[n] = fuse.Dirent{Name: dent.Name, Type: fuse.DT_File}
+		if dent.Directory != nil {
+			out[n].Type = fuse.DT_Dir
+		}
+	}
+	return out, nil
+}
+
+type file struct {
+	fs *filesystem
+	f  *mndx.TreeFile
+}
+
+func (f *file) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = 0444
+	a.Size = uint64(f.f.Size)
+	return nil
+}
+
+func (f *file) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	h := ngdp.ContentHash(f.f.EncodingKey)
+	cf, err := f.fs.cache.open(ctx, h)
+	if err != nil {
+		glog.Errorf("opening %032x: %v", h, err)
+		return nil, fuse.EIO
+	}
+
+	// Files never change, so the kernel can keep whatever it reads.
+	resp.Flags |= fuse.OpenKeepCache
+	return &handle{cf}, nil
+}
+
+// A handle is an open file, read from the cache.
+type handle struct {
+	f *os.File
+}
+
+func (h *handle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	buf := make([]byte, req.Size)
+	n, err := h.f.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		glog.Errorf("reading %s: %v", h.f.Name(), err)
+		return fuse.EIO
+	}
+	resp.Data = buf[:n]
+	return nil
+}
+
+func (h *handle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	return h.f.Close()
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command snowstorm-mount mounts the filename tree of a build as a read-only FUSE filesystem.
+//
+// Usage:
+//
+//	snowstorm-mount [flags] <product> <region> <mountpoint>
+//	snowstorm-mount [flags] -install <dir> <mountpoint>
+//	snowstorm-mount [flags] -installed <product> <mountpoint>
+//
+// Files are downloaded and decoded when they are first opened, and kept in a local cache.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/casc"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/mndx"
+)
+
+var (
+	cacheDir  = flag.String("cache", "", "directory to cache downloaded files in; defaults to a temporary directory which is removed on exit")
+	install   = flag.String("install", "", "serve files from the local storage of the installation in this directory, rather than the CDN")
+	installed = flag.String("installed", "", "serve files from the local storage of this product's installation, found in the launcher's default locations, rather than the CDN")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  %s [flags] <product> <region> <mountpoint>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s [flags] -install <dir> <mountpoint>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s [flags] -installed <product> <mountpoint>\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+// open returns the source of files, and the root of their filename tree.
+func open(ctx context.Context, args []string) (client.Fetcher, *mndx.TreeDirectory, error) {
+	if *install != "" || *installed != "" {
+		var s *casc.Storage
+		var err error
+		if *installed != "" {
+			inst, ferr := casc.FindInstallation(ngdp.ProgramCode(*installed))
+			if ferr != nil {
+				return nil, nil, fmt.Errorf("%s: %v", *installed, ferr)
+			}
+			s, err = casc.OpenBuild(inst.Dir, inst.BuildInfo)
+		} else {
+			s, err = casc.Open(*install)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		tree, err := mndx.Load(ctx, s, s.BuildConfig.Root)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, tree, nil
+	}
+
+	c, err := client.New(ctx, ngdp.ProgramCode(args[0]), ngdp.Region(args[1]))
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, err := mndx.Load(ctx, c, c.BuildConfig.Root)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, tree, nil
+}
+
+func run() error {
+	wantArgs := 3
+	if *install != "" || *installed != "" {
+		wantArgs = 1
+	}
+	if flag.NArg() != wantArgs {
+		usage()
+		os.Exit(2)
+	}
+	mountpoint := flag.Arg(wantArgs - 1)
+
+	ctx := context.Background()
+	fetcher, tree, err := open(ctx, flag.Args())
+	if err != nil {
+		return err
+	}
+
+	dir := *cacheDir
+	if dir == "" {
+		if dir, err = os.MkdirTemp("", "snowstorm-mount"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	conn, err := fuse.Mount(mountpoint, fuse.ReadOnly(), fuse.FSName("snowstorm"), fuse.Subtype("snowstorm"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Unmount cleanly on interrupt, which makes Serve return.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		if err := fuse.Unmount(mountpoint); err != nil {
+			fmt.Fprintf(os.Stderr, "unmounting %s: %v\n", mountpoint, err)
+		}
+	}()
+
+	fsys := &filesystem{
+		root:  tree,
+		cache: newCache(dir, fetcher),
+	}
+	if err := fs.Serve(conn, fsys); err != nil {
+		return err
+	}
+
+	<-conn.Ready
+	return conn.MountError
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+}
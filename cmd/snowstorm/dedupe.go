@@ -0,0 +1,97 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/dedupe"
+)
+
+type dedupeOutput struct {
+	Builds   []dedupeBuild
+	Upgrades []dedupe.Upgrade
+	Union    dedupe.Stats
+}
+
+type dedupeBuild struct {
+	Name string
+	dedupe.Stats
+}
+
+// parseBuildArg parses a <build-config>[:<cdn-config>] argument, using def for the CDN config if it is omitted.
+func parseBuildArg(arg string, def ngdp.VersionInfo) (ngdp.VersionInfo, error) {
+	v := ngdp.VersionInfo{Region: def.Region, CDNConfig: def.CDNConfig}
+	bits := strings.SplitN(arg, ":", 2)
+	if err := v.BuildConfig.UnmarshalText([]byte(bits[0])); err != nil {
+		return v, err
+	}
+	if len(bits) == 2 {
+		if err := v.CDNConfig.UnmarshalText([]byte(bits[1])); err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+func runDedupe(ctx context.Context, args []string) error {
+	llc := lowLevelClient()
+	cdn, current, err := llc.Info(ctx, ngdp.ProgramCode(args[0]), ngdp.Region(args[1]))
+	if err != nil {
+		return err
+	}
+
+	var out dedupeOutput
+	var builds []*dedupe.Build
+	for _, arg := range args[2:] {
+		v, err := parseBuildArg(arg, current)
+		if err != nil {
+			return fmt.Errorf("%s: %v", arg, err)
+		}
+		b, err := dedupe.Load(ctx, llc, cdn, v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", arg, err)
+		}
+		builds = append(builds, b)
+		out.Builds = append(out.Builds, dedupeBuild{b.Name, b.Stats()})
+		if len(builds) > 1 {
+			out.Upgrades = append(out.Upgrades, dedupe.Compare(builds[len(builds)-2], b))
+		}
+	}
+	out.Union = dedupe.Union(builds...)
+
+	return output(out, func() ([]string, [][]string) {
+		stats := func(s dedupe.Stats) []string {
+			return []string{fmt.Sprintf("%d", s.Files), fmt.Sprintf("%d", s.Bytes), fmt.Sprintf("%d", s.UnsizedFiles)}
+		}
+		var rows [][]string
+		for _, b := range out.Builds {
+			rows = append(rows, append([]string{b.Name, "total"}, stats(b.Stats)...))
+		}
+		for _, u := range out.Upgrades {
+			from := u.From + " -> " + u.To
+			rows = append(rows, append([]string{from, "shared"}, stats(u.Shared)...))
+			rows = append(rows, append([]string{from, "new"}, stats(u.New)...))
+			rows = append(rows, []string{from, "new archives", fmt.Sprintf("%d", u.NewArchives), fmt.Sprintf("%d", u.NewArchiveBytes), ""})
+		}
+		rows = append(rows, append([]string{"all", "distinct"}, stats(out.Union)...))
+		return []string{"BUILD", "", "FILES", "BYTES", "UNSIZED"}, rows
+	})
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/downloader"
+	"github.com/lukegb/snowstorm/ngdp/filetype"
+	"github.com/lukegb/snowstorm/ngdp/mndx"
+	"github.com/lukegb/snowstorm/ngdp/staticmap"
+)
+
+// parseInterleaved parses flags which may appear before, between or after positional arguments.
+func parseInterleaved(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// treeClient creates a high-level client for a program and region, along with its filename tree.
+func treeClient(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) (*client.Client, *mndx.TreeDirectory, error) {
+	c, err := newClient(ctx, program, region)
+	if err != nil {
+		return nil, nil, err
+	}
+	if *namesFile != "" {
+		tree, err := staticmap.Load(*namesFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		c.FilenameMapper = tree
+	} else if err := mndx.Decorate(ctx, c); err != nil {
+		return nil, nil, err
+	}
+
+	tree, ok := c.FilenameMapper.(*mndx.TreeDirectory)
+	if !ok {
+		return nil, nil, fmt.Errorf("filename mapper for %s does not support listing", program)
+	}
+	return c, tree, nil
+}
+
+type listEntry struct {
+	Name        string
+	Directory   bool
+	Size        uint32            `json:",omitempty"`
+	ContentHash *ngdp.ContentHash `json:",omitempty"`
+}
+
+func runLs(ctx context.Context, args []string) error {
+	_, tree, err := treeClient(ctx, ngdp.ProgramCode(args[0]), ngdp.Region(args[1]))
+	if err != nil {
+		return err
+	}
+
+	p := ""
+	if len(args) > 2 {
+		p = args[2]
+	}
+	tde, err := tree.Get(p)
+	if err != nil {
+		return fmt.Errorf("%s: %v", p, err)
+	}
+
+	dents := []mndx.TreeDirectoryEntry{tde}
+	if tde.Directory != nil {
+		dents = tde.Directory.List()
+	}
+
+	entries := make([]listEntry, len(dents))
+	for n, dent := range dents {
+		entries[n] = listEntry{
+			Name:      dent.Name,
+			Directory: dent.Directory != nil,
+		}
+		if dent.File != nil {
+			entries[n].Size = dent.File.Size
+			h := ngdp.ContentHash(dent.File.EncodingKey)
+			entries[n].ContentHash = &h
+		}
+	}
+
+	return output(entries, func() ([]string, [][]string) {
+		rows := make([][]string, len(entries))
+		for n, e := range entries {
+			if e.Directory {
+				rows[n] = []string{e.Name + "/", "", ""}
+				continue
+			}
+			rows[n] = []string{e.Name, fmt.Sprintf("%d", e.Size), fmt.Sprintf("%032x", *e.ContentHash)}
+		}
+		return nil, rows
+	})
+}
+
+func runCat(ctx context.Context, args []string) error {
+	c, _, err := treeClient(ctx, ngdp.ProgramCode(args[0]), ngdp.Region(args[1]))
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.FetchFilename(ctx, args[2])
+	if err != nil {
+		return fmt.Errorf("%s: %v", args[2], err)
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
+// writeFile atomically writes the contents of r to fn, creating parent directories as needed.
+func writeFile(fn string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(fn), ".snowstorm-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fn)
+}
+
+// extractPath returns where the file named name in the build should be extracted to within dir.
+// Names come from the CDN, so any which are absolute or would escape dir are rejected.
+func extractPath(dir, name string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(name))
+	if rel == "." || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("refusing to extract %q outside %s", name, dir)
+	}
+	return filepath.Join(dir, rel), nil
+}
+
+func runExtract(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	outDir := fs.String("o", ".", "directory to extract files into")
+	jobs := fs.Int("j", 8, "number of files to download in parallel")
+	args, err := parseInterleaved(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 3 {
+		return fmt.Errorf("want <product> <region> <glob>, got %d arguments", len(args))
+	}
+
+	c, tree, err := treeClient(ctx, ngdp.ProgramCode(args[0]), ngdp.Region(args[1]))
+	if err != nil {
+		return err
+	}
+
+	// Globs are matched case-insensitively, like the tree itself.
+	glob := strings.ToLower(strings.TrimLeft(args[2], "/"))
+	if _, err := path.Match(glob, ""); err != nil {
+		return fmt.Errorf("bad glob %q: %v", args[2], err)
+	}
+
+	type job struct {
+		path string
+		file *mndx.TreeFile
+	}
+	var todo []job
+	var totalSize int64
+	for p, f := range tree.Files() {
+		if ok, _ := path.Match(glob, strings.ToLower(p)); ok {
+			todo = append(todo, job{p, f})
+			totalSize += int64(f.Size)
+		}
+	}
+	if len(todo) == 0 {
+		return fmt.Errorf("no files match %q", args[2])
+	}
+
+	journal, done, err := openJournal("extract", *outDir, fmt.Sprintf("%032x", c.VersionInfo.BuildConfig), glob)
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	types := make(map[string]int)
+
+	bar := newProgressBar(len(todo), totalSize)
+
+	dl := downloader.New(downloader.Options{
+		Concurrency: *jobs,
+		Progress:    bar,
+		Journal:     journal,
+		OnComplete: func(j *downloader.Job, err error) {
+			if err == nil {
+				bar.Done()
+			}
+		},
+	})
+	for _, j := range todo {
+		j := j
+		dl.Add(&downloader.Job{
+			Name: j.path,
+			Size: int64(j.file.Size),
+			Open: func(ctx context.Context) (io.ReadCloser, error) {
+				resp, err := c.Fetch(ctx, j.file.EncodingKey)
+				if err != nil {
+					return nil, err
+				}
+				return resp.Body, nil
+			},
+			Save: func(ctx context.Context, r io.Reader) error {
+				typ, r, err := filetype.Sniff(j.path, r)
+				if err != nil {
+					return err
+				}
+				fn, err := extractPath(*outDir, j.path)
+				if err != nil {
+					return err
+				}
+				if err := writeFile(fn, r); err != nil {
+					return err
+				}
+				mu.Lock()
+				types[typ.Name]++
+				mu.Unlock()
+				return nil
+			},
+		})
+	}
+	err = done(dl.Run(ctx))
+	bar.Finish()
+	if err != nil {
+		return err
+	}
+
+	summary := make([]string, 0, len(types))
+	for name, n := range types {
+		summary = append(summary, fmt.Sprintf("%d %s", n, name))
+	}
+	sort.Strings(summary)
+	fmt.Fprintf(os.Stderr, "Extracted %d files: %s\n", len(todo), strings.Join(summary, ", "))
+	return nil
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractPath(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want string
+	}{
+		{"x", "out/x"},
+		{"a/b/c.txt", "out/a/b/c.txt"},
+		{"a/../x", "out/x"},
+	} {
+		got, err := extractPath("out", test.name)
+		if err != nil || got != filepath.FromSlash(test.want) {
+			t.Errorf("extractPath(out, %q) = %q, %v; want %q", test.name, got, err, test.want)
+		}
+	}
+
+	for _, name := range []string{"../x", "a/../../x", "/etc/passwd", "", "."} {
+		if got, err := extractPath("out", name); err == nil {
+			t.Errorf("extractPath(out, %q) = %q; want error", name, got)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lukegb/snowstorm/blte"
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+)
+
+type hashEntry struct {
+	File        string
+	Size        int64
+	ContentHash ngdp.ContentHash
+	ESpec       string
+	EncodedSize int64
+	CDNHash     ngdp.CDNHash
+
+	// BuildCDNHash is the CDN hash the build given with -product lists for the file, if it has it.
+	BuildCDNHash *ngdp.CDNHash `json:",omitempty"`
+}
+
+func runHash(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("hash", flag.ExitOnError)
+	especStr := fs.String("espec", "n", "encoding spec to BLTE-encode files with, such as z or b:{256K*=z}; compressed output may not match Blizzard's byte for byte")
+	product := fs.String("product", "", "also look each file up in the current build of this product")
+	region := fs.String("region", "us", "region of the build to look files up in, with -product")
+	args, err := parseInterleaved(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("want at least one file")
+	}
+
+	spec, err := blte.ParseESpec(*especStr)
+	if err != nil {
+		return err
+	}
+
+	var c *client.Client
+	if *product != "" {
+		if c, err = newClient(ctx, ngdp.ProgramCode(*product), ngdp.Region(*region)); err != nil {
+			return err
+		}
+	}
+
+	entries := make([]hashEntry, len(args))
+	for n, fn := range args {
+		e, err := hashFile(fn, spec)
+		if err != nil {
+			return fmt.Errorf("%s: %v", fn, err)
+		}
+		if c != nil {
+			if h, err := c.EncodingMapper.ToCDNHash(e.ContentHash); err == nil {
+				e.BuildCDNHash = &h
+			}
+		}
+		entries[n] = *e
+	}
+
+	return output(entries, func() ([]string, [][]string) {
+		headers := []string{"FILE", "SIZE", "CONTENT HASH", "ESPEC", "ENCODED SIZE", "CDN HASH"}
+		if c != nil {
+			headers = append(headers, "BUILD CDN HASH")
+		}
+		rows := make([][]string, len(entries))
+		for n, e := range entries {
+			rows[n] = []string{e.File, fmt.Sprintf("%d", e.Size), fmt.Sprintf("%032x", e.ContentHash), e.ESpec, fmt.Sprintf("%d", e.EncodedSize), fmt.Sprintf("%032x", e.CDNHash)}
+			if c == nil {
+				continue
+			}
+			build := "-"
+			if e.BuildCDNHash != nil {
+				build = fmt.Sprintf("%032x", *e.BuildCDNHash)
+			}
+			rows[n] = append(rows[n], build)
+		}
+		return headers, rows
+	})
+}
+
+// hashFile streams fn through a blte.Writer, so that large files needn't be held in memory.
+func hashFile(fn string, spec blte.ESpec) (*hashEntry, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var encoded countingWriter
+	w := blte.NewWriter(&encoded, spec)
+	content := md5.New()
+	size, err := io.Copy(io.MultiWriter(w, content), f)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	e := &hashEntry{
+		File:        fn,
+		Size:        size,
+		ESpec:       spec.String(),
+		EncodedSize: int64(encoded),
+		CDNHash:     w.HeaderHash(),
+	}
+	copy(e.ContentHash[:], content.Sum(nil))
+	return e, nil
+}
+
+// countingWriter discards what is written to it, counting the bytes.
+type countingWriter int64
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	*c += countingWriter(len(b))
+	return len(b), nil
+}
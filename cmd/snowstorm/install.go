@@ -0,0 +1,167 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/casc"
+	"github.com/lukegb/snowstorm/ngdp/client"
+)
+
+func runInstall(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	jobs := fs.Int("j", 8, "number of files to download in parallel")
+	tags := fs.String("tags", "", "comma-separated install manifest tags, such as Windows,x86_64,enUS; if set, the matching game files are also placed in <dir>")
+	args, err := parseInterleaved(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 3 {
+		return fmt.Errorf("want <product> <region> <dir>, got %d arguments", len(args))
+	}
+
+	program := ngdp.ProgramCode(args[0])
+	c, err := newClient(ctx, program, ngdp.Region(args[1]))
+	if err != nil {
+		return err
+	}
+
+	journal, done, err := openJournal("install", args[2], fmt.Sprintf("%032x", c.VersionInfo.BuildConfig), *tags)
+	if err != nil {
+		return err
+	}
+
+	bar := newProgressBar(0, 0)
+	defer bar.Finish()
+
+	opts := casc.InstallOptions{
+		Concurrency: *jobs,
+		Progress:    bar,
+		Journal:     journal,
+	}
+	if *tags != "" {
+		opts.Tags = strings.Split(*tags, ",")
+	}
+	return done(casc.Install(ctx, c, program, args[2], opts))
+}
+
+func runRepair(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	jobs := fs.Int("j", 8, "number of files to download in parallel")
+	tags := fs.String("tags", "", "comma-separated install manifest tags, such as Windows,x86_64,enUS; if set, the matching game files in <dir> are also checked")
+	args, err := parseInterleaved(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 3 {
+		return fmt.Errorf("want <product> <region> <dir>, got %d arguments", len(args))
+	}
+
+	program := ngdp.ProgramCode(args[0])
+	c, err := newClient(ctx, program, ngdp.Region(args[1]))
+	if err != nil {
+		return err
+	}
+
+	bar := newProgressBar(0, 0)
+	opts := casc.InstallOptions{
+		Concurrency: *jobs,
+		Progress:    bar,
+	}
+	if *tags != "" {
+		opts.Tags = strings.Split(*tags, ",")
+	}
+	report, err := casc.Repair(ctx, c, program, args[2], opts)
+	bar.Finish()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Checked %d files; repaired %d missing and %d corrupt\n", report.Checked, len(report.Missing), len(report.Corrupt))
+
+	return output(report, func() ([]string, [][]string) {
+		var rows [][]string
+		for _, h := range report.Configs {
+			rows = append(rows, []string{fmt.Sprintf("%032x", h), "config"})
+		}
+		for _, h := range report.Missing {
+			rows = append(rows, []string{fmt.Sprintf("%032x", h), "missing"})
+		}
+		for _, h := range report.Corrupt {
+			rows = append(rows, []string{fmt.Sprintf("%032x", h), "corrupt"})
+		}
+		return []string{"CDN HASH", "PROBLEM"}, rows
+	})
+}
+
+type installation struct {
+	Dir         string
+	Product     ngdp.ProgramCode
+	Region      ngdp.Region
+	Flavor      string `json:",omitempty"`
+	Version     string
+	BuildConfig ngdp.CDNHash
+	CDNConfig   ngdp.CDNHash
+}
+
+func runInstalls(ctx context.Context, args []string) error {
+	var insts []installation
+	for _, i := range casc.Detect(args...) {
+		insts = append(insts, installation{
+			Dir:         i.Dir,
+			Product:     i.Product,
+			Region:      i.Region(),
+			Flavor:      i.Flavor,
+			Version:     i.BuildInfo.Version,
+			BuildConfig: i.BuildInfo.BuildKey,
+			CDNConfig:   i.BuildInfo.CDNKey,
+		})
+	}
+
+	return output(insts, func() ([]string, [][]string) {
+		rows := make([][]string, len(insts))
+		for n, i := range insts {
+			rows[n] = []string{string(i.Product), string(i.Region), i.Version, fmt.Sprintf("%032x", i.BuildConfig), i.Dir}
+		}
+		return []string{"PRODUCT", "REGION", "VERSION", "BUILD CONFIG", "DIRECTORY"}, rows
+	})
+}
+
+// openInstalled creates a client for the active build of program in the installation at dir.
+func openInstalled(ctx context.Context, dir string, program ngdp.ProgramCode) (*client.Client, error) {
+	f, err := os.Open(filepath.Join(dir, casc.BuildInfoFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	infos, err := casc.ReadBuildInfo(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f.Name(), err)
+	}
+	for _, bi := range infos {
+		if bi.Active != 0 && bi.Product == program {
+			return casc.NewClient(ctx, lowLevelClient(), bi)
+		}
+	}
+	return nil, fmt.Errorf("no active build of %s in %s", program, dir)
+}
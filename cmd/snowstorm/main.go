@@ -0,0 +1,285 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command snowstorm is a command-line interface to NGDP.
+//
+// Usage:
+//
+//	snowstorm [flags] <command> [arguments]
+//
+// Run "snowstorm help" for a list of commands.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/armadillo"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/downloader"
+	"github.com/lukegb/snowstorm/ngdp/tactkeys"
+)
+
+var (
+	jsonOutput   = flag.Bool("json", false, "output JSON instead of tables")
+	patchRegion  = flag.String("patch-region", "us", "region of the patch server to ask for version information")
+	timeout      = flag.Duration("timeout", 5*time.Minute, "how long to wait for each HTTP request to start responding; reading the response isn't limited, so large transfers on slow links don't time out")
+	proxyURL     = flag.String("proxy", "", "URL of a proxy to send HTTP requests through, such as socks5://localhost:1080; by default, the environment's proxy settings are used")
+	userAgent    = flag.String("user-agent", "", "User-Agent to send with HTTP requests to patch servers and CDNs")
+	armadilloKey = flag.String("armadillo-key", "", "path to an Armadillo .ak key file, for products whose CDN content is encrypted")
+	cacheDir     = flag.String("cache", "", "directory to cache downloaded data files in")
+	maxRate      = flag.Int64("max-rate", 0, "limit downloads to this many bytes per second; 0 means unlimited")
+	segments     = flag.Int("segments", 0, "download large objects in up to this many ranged segments at once, which is often faster than a single connection")
+	preferHosts  = flag.String("prefer-hosts", "", "path to a list of CDN hosts to try first, as written by probe -save")
+	installedDir = flag.String("installed", "", "read the build installed in this game directory, as recorded in its .build.info, instead of the current version")
+	snapshotDir  = flag.String("snapshot", "", "read builds from the snapshots in this mirror directory, as written by mirror, instead of the CDN")
+	keysFile     = flag.String("keys", "", "path to a list of TACT keys to decrypt encrypted content with, in addition to any from the version's keyring")
+	keyList      = flag.String("key-list", "", "URL of a list of TACT keys to decrypt encrypted content with, such as "+tactkeys.DefaultKeyListURL+"; it is cached for a day")
+	namesFile    = flag.String("names", "", "path to a .csv or .json manifest of file paths and content hashes, used instead of the product's root file to name files")
+	noKeyRing    = flag.Bool("no-keyring", false, "don't fetch the keyring of versions which have one")
+	captureFile  = flag.String("capture", "", "record every request made to patch servers and CDNs in this file, as a HAR if it ends in .har and as JSON lines otherwise, to attach to bug reports")
+	journalFile  = flag.String("journal", "", "path to a journal of finished downloads, so that an interrupted mirror, extract or install can be resumed quickly; it is removed once the command succeeds")
+)
+
+// A command is a single snowstorm subcommand.
+type command struct {
+	name  string
+	args  string
+	help  string
+	nargs int // minimum number of positional arguments
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands []*command
+
+// capture records requests if -capture is set. It is closed when main exits.
+var capture *client.Capture
+
+func init() {
+	commands = []*command{
+		{"versions", "<product>", "list the current versions of a product in every region", 1, runVersions},
+		{"cdns", "<product>", "list the CDNs serving a product in every region", 1, runCDNs},
+		{"table", "<product> <name>", "dump any config table the patch server publishes for a product, such as versions, cdns, bgdl or blobs", 2, runTable},
+		{"info", "<product> <region>", "dump the build and CDN configs of a product", 2, runInfo},
+		{"ls", "<product> <region> [path]", "list a directory in a product's filename tree", 2, runLs},
+		{"cat", "<product> <region> <path>", "write the decoded contents of a file to stdout", 3, runCat},
+		{"extract", "[-o dir] [-j jobs] <product> <region> <glob>", "download every file matching a glob", 3, runExtract},
+		{"mirror", "[-o dir|url] [-archives] [-loose] [-j jobs] <product> <region>", "copy a build into a local directory with the CDN's layout", 2, runMirror},
+		{"verify", "[-j jobs] [-encoding] [-refetch product] <dir>", "check every object in a mirror against its name", 1, runVerify},
+		{"install", "[-j jobs] [-tags tags] <product> <region> <dir>", "install or update a build into local storage, as the game client would", 3, runInstall},
+		{"repair", "[-j jobs] [-tags tags] <product> <region> <dir>", "check an installation's local storage, downloading again any files which are missing or corrupt", 3, runRepair},
+		{"installs", "[dir]...", "list the games installed on this machine, looking in the launcher's default locations unless directories are given", 0, runInstalls},
+		{"dedupe", "<product> <region> <build-config>[:<cdn-config>]...", "report how much content is shared between builds, and what each upgrade must fetch", 3, runDedupe},
+		{"probe", "[-sample bytes] [-save file] <product> <region>", "measure the latency and throughput of each CDN host", 2, runProbe},
+		{"watch", "[-interval dur] [-source http|ribbit] [-exec cmd] [-notify sink]... <product>...", "poll for version changes, optionally running a command or sending a notification for each", 1, runWatch},
+		{"hash", "[-espec spec] [-product product [-region region]] <file>...", "compute the content hash of local files, and their CDN hash once BLTE-encoded", 1, runHash},
+		{"help", "", "show this help", 0, runHelp},
+	}
+}
+
+func runHelp(ctx context.Context, args []string) error {
+	usage()
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] <command> [arguments]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %s %s\n    \t%s\n", c.name, c.args, c.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// httpClient creates an HTTP client configured by -proxy, -user-agent and -timeout.
+func httpClient() *http.Client {
+	cl, err := client.NewHTTPClient(client.HTTPOptions{
+		Proxy:                 *proxyURL,
+		UserAgent:             *userAgent,
+		ResponseHeaderTimeout: *timeout,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+	return cl
+}
+
+func lowLevelClient() *client.LowLevelClient {
+	llc := &client.LowLevelClient{
+		Client:    httpClient(),
+		NoKeyRing: *noKeyRing,
+		Capture:   capture,
+		Segments:  *segments,
+	}
+	if *maxRate > 0 {
+		llc.RateLimit = client.NewRateLimiter(*maxRate)
+	}
+	if *armadilloKey != "" {
+		k, err := armadillo.ReadKeyFile(*armadilloKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		llc.ArmadilloKey = &k
+	}
+	if *preferHosts != "" {
+		hosts, err := readHostsFile(*preferHosts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		llc.PreferredHosts = hosts
+	}
+	return llc
+}
+
+// newClient creates a high-level client for a program and region, using the cache directory if one was given.
+// With -snapshot, the client reads the newest snapshot of the program and region instead, and with -installed, the build of the program installed in that directory.
+func newClient(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) (*client.Client, error) {
+	var c *client.Client
+	var err error
+	if *snapshotDir != "" {
+		c, err = openSnapshot(ctx, *snapshotDir, program, region)
+	} else if *installedDir != "" {
+		c, err = openInstalled(ctx, *installedDir, program)
+	} else {
+		c, err = client.NewWithLowLevelClient(ctx, lowLevelClient(), program, region)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if *cacheDir != "" {
+		c.Cache = blobstore.NewDiskContentStore(*cacheDir)
+	}
+	if *keysFile != "" {
+		f, err := os.Open(*keysFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		k, err := tactkeys.Read(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", *keysFile, err)
+		}
+		if c.Keys == nil {
+			c.Keys = tactkeys.New()
+		}
+		c.Keys.Merge(k)
+	}
+	if *keyList != "" {
+		k, err := tactkeys.Fetch(ctx, keyListSource(*keyList))
+		if err != nil {
+			return nil, err
+		}
+		if c.Keys == nil {
+			c.Keys = tactkeys.New()
+		}
+		c.Keys.Merge(k)
+	}
+	return c, nil
+}
+
+// keyListSource describes the remote key list at url, cached in the user's cache directory if there is one.
+func keyListSource(url string) tactkeys.Source {
+	src := tactkeys.Source{
+		URL:    url,
+		MaxAge: 24 * time.Hour,
+		Client: httpClient(),
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		sum := sha256.Sum256([]byte(url))
+		src.CachePath = filepath.Join(dir, "snowstorm", fmt.Sprintf("tactkeys-%x.txt", sum[:8]))
+	}
+	return src
+}
+
+// openJournal opens the journal named by -journal, if there is one, for the operation described by id.
+// The returned function must be called with the operation's result: it removes the journal if the operation succeeded, and keeps it for the next attempt otherwise.
+func openJournal(id ...string) (*downloader.Journal, func(error) error, error) {
+	if *journalFile == "" {
+		return nil, func(err error) error { return err }, nil
+	}
+	j, err := downloader.OpenJournal(*journalFile, strings.Join(id, " "))
+	if err != nil {
+		return nil, nil, err
+	}
+	if n := j.Len(); n > 0 {
+		fmt.Fprintf(os.Stderr, "Resuming from %s: %d already done, %d interrupted\n", *journalFile, n, len(j.Interrupted()))
+	}
+	return j, func(err error) error {
+		if err != nil {
+			if cerr := j.Close(); cerr != nil {
+				fmt.Fprintf(os.Stderr, "Closing journal: %v\n", cerr)
+			}
+			return err
+		}
+		return j.Remove()
+	}, nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	name, args := flag.Arg(0), flag.Args()[1:]
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if len(args) < c.nargs {
+			fmt.Fprintf(os.Stderr, "Usage: %s %s %s\n", os.Args[0], c.name, c.args)
+			os.Exit(2)
+		}
+		if *captureFile != "" {
+			var err error
+			if capture, err = client.CreateCapture(*captureFile); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+				os.Exit(1)
+			}
+		}
+		err := c.run(context.Background(), args)
+		if capture != nil {
+			// The capture is most useful when something went wrong, so it is written out either way.
+			if cerr := capture.Close(); cerr != nil {
+				fmt.Fprintf(os.Stderr, "%s: writing %s: %v\n", os.Args[0], *captureFile, cerr)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", c.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: unknown command %q\n", os.Args[0], name)
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws/session"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+	"github.com/lukegb/snowstorm/ngdp/blobstore/gcsblob"
+	"github.com/lukegb/snowstorm/ngdp/blobstore/s3blob"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/mirror"
+)
+
+// openStore interprets dest as an s3:// or gs:// URL, or otherwise a local directory.
+func openStore(ctx context.Context, dest string) (blobstore.Store, error) {
+	u, err := url.Parse(dest)
+	if err != nil || u.Host == "" {
+		return blobstore.Dir(dest), nil
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+
+	switch u.Scheme {
+	case "s3":
+		sess, err := session.NewSessionWithOptions(session.Options{
+			SharedConfigState: session.SharedConfigEnable,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s3blob.New(sess, u.Host, prefix), nil
+	case "gs":
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return gcsblob.New(client, u.Host, prefix), nil
+	}
+	return blobstore.Dir(dest), nil
+}
+
+func runMirror(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("mirror", flag.ExitOnError)
+	outDir := fs.String("o", ".", "directory to write the mirror into, or an s3://bucket/prefix or gs://bucket/prefix URL")
+	archives := fs.Bool("archives", false, "also mirror every archive")
+	loose := fs.Bool("loose", false, "also mirror every loose data file")
+	jobs := fs.Int("j", 8, "number of objects to download in parallel")
+	args, err := parseInterleaved(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("want <product> <region>, got %d arguments", len(args))
+	}
+
+	llc := lowLevelClient()
+	cdn, version, err := llc.Info(ctx, ngdp.ProgramCode(args[0]), ngdp.Region(args[1]))
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(ctx, *outDir)
+	if err != nil {
+		return err
+	}
+
+	journal, done, err := openJournal("mirror", *outDir, cdn.Path, fmt.Sprintf("%032x", version.BuildConfig), fmt.Sprintf("%032x", version.CDNConfig))
+	if err != nil {
+		return err
+	}
+
+	bar := newProgressBar(0, 0)
+	defer bar.Finish()
+
+	return done(mirror.Mirror(ctx, llc, cdn, version, *outDir, mirror.Options{
+		Archives:    *archives,
+		LooseFiles:  *loose,
+		Concurrency: *jobs,
+		Progress:    bar,
+		Store:       store,
+		Program:     ngdp.ProgramCode(args[0]),
+		Journal:     journal,
+	}))
+}
+
+// openSnapshot opens the most recently created snapshot of program and region in the mirror in dir.
+func openSnapshot(ctx context.Context, dir string, program ngdp.ProgramCode, region ngdp.Region) (*client.Client, error) {
+	builds, err := mirror.Snapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	store := blobstore.Dir(dir)
+	var newest *mirror.Manifest
+	for _, b := range builds {
+		m, err := mirror.ReadManifest(ctx, store, b)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %032x: %v", b, err)
+		}
+		if m.Program != program || m.Version.Region != region {
+			continue
+		}
+		if newest == nil || m.Created.After(newest.Created) {
+			newest = m
+		}
+	}
+	if newest == nil {
+		return nil, fmt.Errorf("no snapshot of %s/%s in %s", program, region, dir)
+	}
+
+	c, _, err := mirror.OpenSnapshot(ctx, store, newest.Version.BuildConfig)
+	return c, err
+}
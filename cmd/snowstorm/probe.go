@@ -0,0 +1,121 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+)
+
+type probeResult struct {
+	Host       string
+	Latency    time.Duration
+	Throughput float64
+	Error      string `json:",omitempty"`
+}
+
+// readHostsFile reads a list of hosts, one per line, as written by probe -save.
+func readHostsFile(fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hosts []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if h := strings.TrimSpace(s.Text()); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts, s.Err()
+}
+
+func runProbe(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	sample := fs.Int64("sample", 4<<20, "number of bytes of an archive to download from each host to measure throughput")
+	save := fs.String("save", "", "write the working hosts, fastest first, to this file for use with -prefer-hosts")
+	args, err := parseInterleaved(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("want <product> <region>, got %d arguments", len(args))
+	}
+
+	llc := lowLevelClient()
+	cdn, version, err := llc.Info(ctx, ngdp.ProgramCode(args[0]), ngdp.Region(args[1]))
+	if err != nil {
+		return err
+	}
+	cdnConfig, err := llc.CDNConfig(ctx, cdn, version)
+	if err != nil {
+		return err
+	}
+	if len(cdnConfig.Archives) == 0 {
+		return fmt.Errorf("CDN config lists no archives to probe with")
+	}
+	// Use an archive from the middle, which is less likely to be warm in every host's cache than the first.
+	archive := cdnConfig.Archives[len(cdnConfig.Archives)/2]
+
+	probes := make([]client.HostProbe, len(cdn.Hosts))
+	for n, host := range cdn.Hosts {
+		probes[n] = llc.Probe(ctx, cdn, host, archive, *sample)
+	}
+	client.RankProbes(probes)
+
+	results := make([]probeResult, len(probes))
+	for n, p := range probes {
+		results[n] = probeResult{Host: p.Host, Latency: p.Latency, Throughput: p.Throughput}
+		if p.Err != nil {
+			results[n].Error = p.Err.Error()
+		}
+	}
+
+	if *save != "" {
+		var b strings.Builder
+		for _, r := range results {
+			if r.Error == "" {
+				fmt.Fprintln(&b, r.Host)
+			}
+		}
+		if err := writeFile(*save, strings.NewReader(b.String())); err != nil {
+			return err
+		}
+	}
+
+	return output(results, func() ([]string, [][]string) {
+		rows := make([][]string, len(results))
+		for n, r := range results {
+			if r.Error != "" {
+				rows[n] = []string{r.Host, "", "", r.Error}
+				continue
+			}
+			rows[n] = []string{r.Host, r.Latency.Round(time.Millisecond).String(), fmt.Sprintf("%.0f", r.Throughput/1024), ""}
+		}
+		return []string{"HOST", "LATENCY", "KB/S", "ERROR"}, rows
+	})
+}
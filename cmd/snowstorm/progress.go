@@ -0,0 +1,123 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	progressBarWidth    = 30
+	progressRedrawEvery = 100 * time.Millisecond
+)
+
+// A progressBar draws a single-line progress bar on stderr, tracking both bytes and whole items.
+//
+// It is safe for concurrent use.
+type progressBar struct {
+	mu sync.Mutex
+
+	bytes, totalBytes int64
+	items, totalItems int
+
+	lastDraw time.Time
+}
+
+func newProgressBar(totalItems int, totalBytes int64) *progressBar {
+	return &progressBar{
+		totalItems: totalItems,
+		totalBytes: totalBytes,
+	}
+}
+
+// Write counts written bytes, so a progressBar can be used with io.TeeReader or io.MultiWriter.
+func (p *progressBar) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes += int64(len(b))
+	p.maybeDraw()
+	return len(b), nil
+}
+
+// Done marks an item as completed.
+func (p *progressBar) Done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.items++
+	p.maybeDraw()
+}
+
+// Finish draws the final state of the bar and moves to a new line.
+func (p *progressBar) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw()
+	fmt.Fprintln(os.Stderr)
+}
+
+func (p *progressBar) maybeDraw() {
+	if time.Since(p.lastDraw) < progressRedrawEvery {
+		return
+	}
+	p.draw()
+}
+
+func (p *progressBar) draw() {
+	p.lastDraw = time.Now()
+
+	if p.totalItems == 0 && p.totalBytes == 0 {
+		// We don't know how much there is to do, so just say how much we've done.
+		fmt.Fprintf(os.Stderr, "\r%s", humanBytes(p.bytes))
+		return
+	}
+
+	frac := 0.0
+	if p.totalBytes > 0 {
+		frac = float64(p.bytes) / float64(p.totalBytes)
+	} else if p.totalItems > 0 {
+		frac = float64(p.items) / float64(p.totalItems)
+	}
+	if frac > 1 {
+		frac = 1
+	}
+	filled := int(frac * progressBarWidth)
+
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %3.0f%% %d/%d files %s/%s",
+		strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled),
+		frac*100, p.items, p.totalItems, humanBytes(p.bytes), humanBytes(p.totalBytes))
+}
+
+var _ io.Writer = (*progressBar)(nil)
+
+// humanBytes formats a byte count using binary prefixes.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
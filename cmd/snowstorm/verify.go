@@ -0,0 +1,70 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/mirror"
+)
+
+func runVerify(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	jobs := fs.Int("j", 8, "number of objects to check in parallel")
+	checkEncoding := fs.Bool("encoding", false, "also check that every file in each mirrored encoding table is present")
+	refetch := fs.String("refetch", "", "product whose CDN to refetch missing and corrupt objects from")
+	args, err := parseInterleaved(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("want <dir>, got %d arguments", len(args))
+	}
+
+	opts := mirror.VerifyOptions{
+		Concurrency: *jobs,
+		Encoding:    *checkEncoding,
+	}
+	if *refetch != "" {
+		llc := lowLevelClient()
+		cdn, _, err := llc.Info(ctx, ngdp.ProgramCode(*refetch), ngdp.Region(*patchRegion))
+		if err != nil {
+			return err
+		}
+		opts.Refetch = llc
+		opts.CDN = cdn
+	}
+
+	problems, err := mirror.Verify(ctx, args[0], opts)
+	if err != nil {
+		return err
+	}
+	unfixed := 0
+	for _, p := range problems {
+		fmt.Println(p)
+		if !p.Fixed {
+			unfixed++
+		}
+	}
+	if unfixed > 0 {
+		return fmt.Errorf("%d of %d problems remain", unfixed, len(problems))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/configtable"
+)
+
+func runVersions(ctx context.Context, args []string) error {
+	versions, err := lowLevelClient().Versions(ctx, ngdp.ProgramCode(args[0]), ngdp.Region(*patchRegion))
+	if err != nil {
+		return err
+	}
+
+	return output(versions, func() ([]string, [][]string) {
+		rows := make([][]string, len(versions))
+		for n, v := range versions {
+			rows[n] = []string{
+				string(v.Region),
+				fmt.Sprintf("%d", v.BuildID),
+				v.VersionsName,
+				fmt.Sprintf("%032x", v.BuildConfig),
+				fmt.Sprintf("%032x", v.CDNConfig),
+				fmt.Sprintf("%032x", v.ProductConfig),
+			}
+		}
+		return []string{"REGION", "BUILD", "VERSION", "BUILD CONFIG", "CDN CONFIG", "PRODUCT CONFIG"}, rows
+	})
+}
+
+func runCDNs(ctx context.Context, args []string) error {
+	cdns, err := lowLevelClient().CDNs(ctx, ngdp.ProgramCode(args[0]), ngdp.Region(*patchRegion))
+	if err != nil {
+		return err
+	}
+
+	return output(cdns, func() ([]string, [][]string) {
+		rows := make([][]string, len(cdns))
+		for n, c := range cdns {
+			rows[n] = []string{
+				string(c.Name),
+				c.Path,
+				c.ConfigPath,
+				strings.Join(c.Hosts, " "),
+			}
+		}
+		return []string{"REGION", "PATH", "CONFIG PATH", "HOSTS"}, rows
+	})
+}
+
+func runTable(ctx context.Context, args []string) error {
+	body, err := lowLevelClient().PatchFile(ctx, ngdp.ProgramCode(args[0]), ngdp.Region(*patchRegion), args[1])
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	d := configtable.NewDecoder(body)
+	columns, err := d.Columns()
+	if err != nil {
+		return err
+	}
+	var rows []map[string]string
+	for {
+		row, err := d.DecodeMap()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+
+	return output(rows, func() ([]string, [][]string) {
+		headers := make([]string, len(columns))
+		for n, c := range columns {
+			headers[n] = strings.ToUpper(c.Name)
+		}
+		out := make([][]string, len(rows))
+		for n, row := range rows {
+			out[n] = make([]string, len(columns))
+			for m, c := range columns {
+				out[n][m] = row[c.Name]
+			}
+		}
+		return headers, out
+	})
+}
+
+type info struct {
+	CDN         ngdp.CDNInfo
+	Version     ngdp.VersionInfo
+	BuildConfig ngdp.BuildConfig
+	CDNConfig   ngdp.CDNConfig
+}
+
+func runInfo(ctx context.Context, args []string) error {
+	llc := lowLevelClient()
+	cdn, version, err := llc.Info(ctx, ngdp.ProgramCode(args[0]), ngdp.Region(args[1]))
+	if err != nil {
+		return err
+	}
+
+	cdnConfig, buildConfig, err := llc.Configs(ctx, cdn, version)
+	if err != nil {
+		return err
+	}
+
+	i := info{
+		CDN:         cdn,
+		Version:     version,
+		BuildConfig: buildConfig,
+		CDNConfig:   cdnConfig,
+	}
+	return output(i, func() ([]string, [][]string) {
+		rows := [][]string{
+			{"region", string(version.Region)},
+			{"build-id", fmt.Sprintf("%d", version.BuildID)},
+			{"version", version.VersionsName},
+			{"cdn-path", cdn.Path},
+			{"cdn-hosts", strings.Join(cdn.Hosts, " ")},
+			{"build-config", fmt.Sprintf("%032x", version.BuildConfig)},
+			{"cdn-config", fmt.Sprintf("%032x", version.CDNConfig)},
+			{"product-config", fmt.Sprintf("%032x", version.ProductConfig)},
+			{"root", fmt.Sprintf("%032x", buildConfig.Root)},
+			{"install", fmt.Sprintf("%032x %d", buildConfig.Install, buildConfig.InstallSize)},
+			{"download", fmt.Sprintf("%032x %d", buildConfig.Download, buildConfig.DownloadSize)},
+			{"encoding", fmt.Sprintf("%032x %032x", buildConfig.Encoding.ContentHash, buildConfig.Encoding.CDNHash)},
+			{"encoding-size", fmt.Sprintf("%d %d", buildConfig.EncodingSize.UncompressedSize, buildConfig.EncodingSize.CompressedSize)},
+			{"patch", fmt.Sprintf("%032x %d", buildConfig.Patch, buildConfig.PatchSize)},
+			{"patch-config", fmt.Sprintf("%032x", buildConfig.PatchConfig)},
+			{"archives", fmt.Sprintf("%d", len(cdnConfig.Archives))},
+			{"archive-group", fmt.Sprintf("%032x", cdnConfig.ArchiveGroup)},
+			{"patch-archives", fmt.Sprintf("%d", len(cdnConfig.PatchArchives))},
+			{"patch-archive-group", fmt.Sprintf("%032x", cdnConfig.PatchArchiveGroup)},
+		}
+		return nil, rows
+	})
+}
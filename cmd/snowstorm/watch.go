@@ -0,0 +1,95 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/notify"
+	"github.com/lukegb/snowstorm/ngdp/ribbit"
+	"github.com/lukegb/snowstorm/ngdp/watch"
+)
+
+func newVersionSource(source string) (watch.Source, error) {
+	switch source {
+	case "http":
+		return watch.HTTPSource{Client: lowLevelClient(), Region: ngdp.Region(*patchRegion)}, nil
+	case "ribbit":
+		return &ribbit.Client{Region: ngdp.Region(*patchRegion)}, nil
+	}
+	return nil, fmt.Errorf("unknown version source %q; want http or ribbit", source)
+}
+
+func runWatch(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := fs.Duration("interval", 5*time.Minute, "how often to poll for new versions")
+	source := fs.String("source", "http", "where to get versions from: http or ribbit")
+	regions := fs.String("regions", "", "comma-separated regions to watch, such as eu,us; if empty, every region is watched")
+	hook := fs.String("exec", "", "shell command to run when a version changes; details are passed in SNOWSTORM_* environment variables")
+	var sinks notify.Sinks
+	fs.Var(&sinks, "notify", "where to send notifications of version changes: an http(s):// webhook URL, an smtp://host?from=...&to=... URL, or exec:<command>; may be repeated")
+	args, err := parseInterleaved(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("want at least one product")
+	}
+
+	if *hook != "" {
+		sinks = append(sinks, notify.Exec{Command: *hook})
+	}
+
+	src, err := newVersionSource(*source)
+	if err != nil {
+		return err
+	}
+
+	w := &watch.Watcher{
+		Source:   src,
+		Interval: *interval,
+		OnError: func(program ngdp.ProgramCode, err error) {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", program, err)
+		},
+	}
+	for _, arg := range args {
+		w.Programs = append(w.Programs, ngdp.ProgramCode(arg))
+	}
+	if *regions != "" {
+		for _, region := range strings.Split(*regions, ",") {
+			w.Regions = append(w.Regions, ngdp.Region(region))
+		}
+	}
+	w.Subscribe(func(c watch.Change) {
+		if c.Initial {
+			fmt.Printf("%s/%s: %s (%d)\n", c.Program, c.Region, c.New.VersionsName, c.New.BuildID)
+			return
+		}
+		e := notify.ChangeEvent(c)
+		fmt.Printf("%s: %s\n", e.Time.Local().Format(time.RFC3339), e)
+		if err := sinks.Notify(ctx, e); err != nil {
+			fmt.Fprintf(os.Stderr, "%s/%s: notify: %v\n", c.Program, c.Region, err)
+		}
+	})
+	return w.Run(ctx)
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package lz4 implements the LZ4 block format, which BLTE uses for its '4' chunks.
+//
+// Only raw blocks are supported: the LZ4 frame format, with its magic number and checksums, isn't used by BLTE.
+package lz4
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	minMatch     = 4
+	lastLiterals = 5  // the last five bytes of a block are always literals
+	mfLimit      = 12 // the last match must start at least this far from the end of the block
+	maxOffset    = 65535
+
+	hashLog = 14
+)
+
+// ErrCorrupt means that a block is malformed, or doesn't decode to the expected size.
+var ErrCorrupt = errors.New("lz4: corrupt block")
+
+// A Reader is what Decode reads a block from. bufio.Reader is one.
+type Reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// Decode decodes a single block from r into dst, which must be exactly the decoded size of the block.
+//
+// Blocks aren't delimited, so Decode stops reading at the final sequence which fills dst, leaving anything after it in r.
+func Decode(dst []byte, r Reader) error {
+	pos := 0
+	for {
+		token, err := r.ReadByte()
+		if err != nil {
+			return unexpected(err)
+		}
+
+		lit := int(token >> 4)
+		if lit == 15 {
+			n, err := readLength(r)
+			if err != nil {
+				return err
+			}
+			lit += n
+		}
+		if lit > len(dst)-pos {
+			return ErrCorrupt
+		}
+		if _, err := io.ReadFull(r, dst[pos:pos+lit]); err != nil {
+			return unexpected(err)
+		}
+		pos += lit
+		if pos == len(dst) {
+			// The last sequence is only literals.
+			return nil
+		}
+
+		var ob [2]byte
+		if _, err := io.ReadFull(r, ob[:]); err != nil {
+			return unexpected(err)
+		}
+		offset := int(binary.LittleEndian.Uint16(ob[:]))
+		if offset == 0 || offset > pos {
+			return ErrCorrupt
+		}
+
+		match := int(token&15) + minMatch
+		if token&15 == 15 {
+			n, err := readLength(r)
+			if err != nil {
+				return err
+			}
+			match += n
+		}
+		if match > len(dst)-pos {
+			return ErrCorrupt
+		}
+		// Matches may overlap the bytes they produce, so they're copied a byte at a time.
+		for n := 0; n < match; n++ {
+			dst[pos+n] = dst[pos-offset+n]
+		}
+		pos += match
+	}
+}
+
+// readLength reads the extra bytes of a literal or match length, which continue while they are 255.
+func readLength(r io.ByteReader) (int, error) {
+	var n int
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, unexpected(err)
+		}
+		n += int(b)
+		if b != 255 {
+			return n, nil
+		}
+	}
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Encode compresses src as a single block.
+//
+// It makes a single greedy pass, so it compresses less tightly than the reference implementation's high-compression modes, but its output can be decoded by any LZ4 decoder.
+func Encode(src []byte) []byte {
+	dst := make([]byte, 0, len(src)+len(src)/255+16)
+	var table [1 << hashLog]int32 // positions of recently seen sequences, plus one
+
+	anchor, pos := 0, 0
+	for pos+mfLimit <= len(src) {
+		seq := binary.LittleEndian.Uint32(src[pos:])
+		h := (seq * 2654435761) >> (32 - hashLog)
+		cand := int(table[h]) - 1
+		table[h] = int32(pos + 1)
+		if cand < 0 || pos-cand > maxOffset || binary.LittleEndian.Uint32(src[cand:]) != seq {
+			pos++
+			continue
+		}
+
+		match := minMatch
+		for pos+match < len(src)-lastLiterals && src[cand+match] == src[pos+match] {
+			match++
+		}
+		dst = appendSequence(dst, src[anchor:pos], pos-cand, match)
+		pos += match
+		anchor = pos
+	}
+	return appendSequence(dst, src[anchor:], 0, 0)
+}
+
+// appendSequence appends literals followed by a match, or just the literals if match is zero.
+func appendSequence(dst, literals []byte, offset, match int) []byte {
+	token := byte(nibble(len(literals)) << 4)
+	if match != 0 {
+		token |= byte(nibble(match - minMatch))
+	}
+	dst = append(dst, token)
+	if len(literals) >= 15 {
+		dst = appendLength(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	if match == 0 {
+		return dst
+	}
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if match-minMatch >= 15 {
+		dst = appendLength(dst, match-minMatch-15)
+	}
+	return dst
+}
+
+// nibble returns the part of a length which fits in a token; 15 means more follows.
+func nibble(n int) int {
+	if n > 15 {
+		return 15
+	}
+	return n
+}
+
+func appendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lz4
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	// Produced by the reference lz4 tool.
+	block, _ := hex.DecodeString("af736e6f7773746f726d200a000650746f726d21")
+	want := "snowstorm snowstorm snowstorm snowstorm!"
+
+	got := make([]byte, len(want))
+	r := bufio.NewReader(bytes.NewReader(append(block, "next"...)))
+	if err := Decode(got, r); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("Decode = %q; want %q", got, want)
+	}
+	if rest, _ := ioutil.ReadAll(r); string(rest) != "next" {
+		t.Errorf("Decode left %q unread; want %q", rest, "next")
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		block string
+		size  int
+	}{
+		{"too long", "50736e6f7773", 3},
+		{"truncated", "50736e6f", 5},
+		{"offset too far", "10730a00", 8},
+		{"zero offset", "10730000", 8},
+	} {
+		block, _ := hex.DecodeString(test.block)
+		if err := Decode(make([]byte, test.size), bufio.NewReader(bytes.NewReader(block))); err == nil {
+			t.Errorf("%s: Decode succeeded; want error", test.name)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	for _, src := range [][]byte{
+		nil,
+		[]byte("short"),
+		bytes.Repeat([]byte("snowstorm "), 10000),
+		bytes.Repeat([]byte{0}, 70000),
+		random,
+		append(bytes.Repeat([]byte("ab"), 300), random[:1000]...),
+	} {
+		enc := Encode(src)
+		got := make([]byte, len(src))
+		if err := Decode(got, bufio.NewReader(bytes.NewReader(enc))); err != nil {
+			t.Errorf("Decode(Encode(%d bytes)): %v", len(src), err)
+			continue
+		}
+		if !bytes.Equal(got, src) {
+			t.Errorf("Decode(Encode(%d bytes)) returned the wrong data", len(src))
+		}
+	}
+
+	if enc := Encode(bytes.Repeat([]byte("snowstorm "), 10000)); len(enc) > 1000 {
+		t.Errorf("Encode of repetitive data is %d bytes; want it compressed", len(enc))
+	}
+}
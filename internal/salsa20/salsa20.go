@@ -0,0 +1,156 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package salsa20 implements the Salsa20/20 stream cipher with both 128- and 256-bit keys.
+//
+// Blizzard uses 128-bit keys, which golang.org/x/crypto/salsa20 doesn't support.
+package salsa20
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// NonceSize is the size of a Salsa20 nonce.
+	NonceSize = 8
+
+	blockSize = 64
+)
+
+var (
+	sigma = [4]uint32{0x61707865, 0x3320646e, 0x79622d32, 0x6b206574} // "expand 32-byte k"
+	tau   = [4]uint32{0x61707865, 0x3120646e, 0x79622d36, 0x6b206574} // "expand 16-byte k"
+)
+
+type stream struct {
+	state [16]uint32
+	block [blockSize]byte
+	used  int
+}
+
+// NewCipher returns a cipher.Stream which encrypts or decrypts using Salsa20/20.
+//
+// key must be 16 or 32 bytes long, and nonce must be 8 bytes long.
+func NewCipher(key, nonce []byte) (cipher.Stream, error) {
+	if len(nonce) != NonceSize {
+		return nil, fmt.Errorf("salsa20: nonce must be %d bytes, not %d", NonceSize, len(nonce))
+	}
+
+	var c [4]uint32
+	var k1, k2 []byte
+	switch len(key) {
+	case 16:
+		c, k1, k2 = tau, key, key
+	case 32:
+		c, k1, k2 = sigma, key[:16], key[16:]
+	default:
+		return nil, fmt.Errorf("salsa20: key must be 16 or 32 bytes, not %d", len(key))
+	}
+
+	s := &stream{used: blockSize}
+	s.state[0] = c[0]
+	for n := 0; n < 4; n++ {
+		s.state[1+n] = binary.LittleEndian.Uint32(k1[n*4:])
+		s.state[11+n] = binary.LittleEndian.Uint32(k2[n*4:])
+	}
+	s.state[5] = c[1]
+	s.state[6] = binary.LittleEndian.Uint32(nonce[0:4])
+	s.state[7] = binary.LittleEndian.Uint32(nonce[4:8])
+	s.state[10] = c[2]
+	s.state[15] = c[3]
+	return s, nil
+}
+
+// NewCipherAt is like NewCipher, but returns a cipher.Stream which starts offset bytes into the keystream.
+func NewCipherAt(key, nonce []byte, offset uint64) (cipher.Stream, error) {
+	c, err := NewCipher(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	s := c.(*stream)
+	block := offset / blockSize
+	s.state[8] = uint32(block)
+	s.state[9] = uint32(block >> 32)
+	if skip := int(offset % blockSize); skip != 0 {
+		s.nextBlock()
+		s.used = skip
+	}
+	return s, nil
+}
+
+func quarterRound(a, b, c, d uint32) (uint32, uint32, uint32, uint32) {
+	b ^= rotl(a+d, 7)
+	c ^= rotl(b+a, 9)
+	d ^= rotl(c+b, 13)
+	a ^= rotl(d+c, 18)
+	return a, b, c, d
+}
+
+func rotl(x uint32, n uint) uint32 {
+	return x<<n | x>>(32-n)
+}
+
+// nextBlock generates the next block of keystream and increments the counter.
+func (s *stream) nextBlock() {
+	x := s.state
+	for i := 0; i < 10; i++ {
+		// Columns.
+		x[0], x[4], x[8], x[12] = quarterRound(x[0], x[4], x[8], x[12])
+		x[5], x[9], x[13], x[1] = quarterRound(x[5], x[9], x[13], x[1])
+		x[10], x[14], x[2], x[6] = quarterRound(x[10], x[14], x[2], x[6])
+		x[15], x[3], x[7], x[11] = quarterRound(x[15], x[3], x[7], x[11])
+		// Rows.
+		x[0], x[1], x[2], x[3] = quarterRound(x[0], x[1], x[2], x[3])
+		x[5], x[6], x[7], x[4] = quarterRound(x[5], x[6], x[7], x[4])
+		x[10], x[11], x[8], x[9] = quarterRound(x[10], x[11], x[8], x[9])
+		x[15], x[12], x[13], x[14] = quarterRound(x[15], x[12], x[13], x[14])
+	}
+	for n := range x {
+		binary.LittleEndian.PutUint32(s.block[n*4:], x[n]+s.state[n])
+	}
+	s.used = 0
+
+	s.state[8]++
+	if s.state[8] == 0 {
+		s.state[9]++
+	}
+}
+
+// XORKeyStream implements cipher.Stream.
+func (s *stream) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("salsa20: output smaller than input")
+	}
+	for n := range src {
+		if s.used == blockSize {
+			s.nextBlock()
+		}
+		dst[n] = src[n] ^ s.block[s.used]
+		s.used++
+	}
+}
+
+// XORKeyStream encrypts or decrypts src into dst in one go. dst and src may overlap entirely.
+func XORKeyStream(dst, src, nonce, key []byte) error {
+	s, err := NewCipher(key, nonce)
+	if err != nil {
+		return err
+	}
+	s.XORKeyStream(dst, src)
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package salsa20
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// These are from the eSTREAM Salsa20/20 test vectors, set 1, vector 0.
+func TestXORKeyStream(t *testing.T) {
+	for _, test := range []struct {
+		key  string
+		want string
+	}{
+		{
+			"80000000000000000000000000000000",
+			"4dfa5e481da23ea09a31022050859936da52fcee218005164f267cb65f5cfd7f2b4f97e0ff16924a52df269515110a07f9e460bc65ef95da58f740b7d1dbb0aa",
+		},
+		{
+			"8000000000000000000000000000000000000000000000000000000000000000",
+			"e3be8fdd8beca2e3ea8ef9475b29a6e7003951e1097a5c38d23b7a5fad9f6844b22c97559e2723c7cbbd3fe4fc8d9a0744652a83e72a9c461876af4d7ef1a117",
+		},
+	} {
+		want := mustHex(test.want)
+		got := make([]byte, len(want))
+		if err := XORKeyStream(got, got, make([]byte, NonceSize), mustHex(test.key)); err != nil {
+			t.Fatalf("XORKeyStream: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("keystream for key %s = %x; want %x", test.key, got, want)
+		}
+	}
+}
+
+func TestStreamingMatchesOneShot(t *testing.T) {
+	key, nonce := mustHex("0102030405060708090a0b0c0d0e0f10"), mustHex("1112131415161718")
+	src := make([]byte, 300)
+	for n := range src {
+		src[n] = byte(n)
+	}
+
+	want := make([]byte, len(src))
+	if err := XORKeyStream(want, src, nonce, key); err != nil {
+		t.Fatalf("XORKeyStream: %v", err)
+	}
+
+	s, err := NewCipher(key, nonce)
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	got := make([]byte, len(src))
+	for _, split := range [][2]int{{0, 1}, {1, 63}, {63, 65}, {65, 200}, {200, 300}} {
+		s.XORKeyStream(got[split[0]:split[1]], src[split[0]:split[1]])
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("streamed output differs from one-shot output")
+	}
+}
+
+func TestBadSizes(t *testing.T) {
+	if _, err := NewCipher(make([]byte, 24), make([]byte, NonceSize)); err == nil {
+		t.Errorf("NewCipher with 24-byte key: nil error; want error")
+	}
+	if _, err := NewCipher(make([]byte, 16), make([]byte, 12)); err == nil {
+		t.Errorf("NewCipher with 12-byte nonce: nil error; want error")
+	}
+}
+
+func TestNewCipherAt(t *testing.T) {
+	key, nonce := mustHex("0102030405060708090a0b0c0d0e0f10"), mustHex("1112131415161718")
+	want := make([]byte, 300)
+	if err := XORKeyStream(want, want, nonce, key); err != nil {
+		t.Fatalf("XORKeyStream: %v", err)
+	}
+
+	for _, offset := range []int{0, 1, 64, 100, 299} {
+		s, err := NewCipherAt(key, nonce, uint64(offset))
+		if err != nil {
+			t.Fatalf("NewCipherAt: %v", err)
+		}
+		got := make([]byte, len(want)-offset)
+		s.XORKeyStream(got, got)
+		if !bytes.Equal(got, want[offset:]) {
+			t.Errorf("keystream at offset %d differs", offset)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package armadillo decrypts CDN content which has been wrapped in Armadillo encryption.
+//
+// Armadillo encrypts whole objects on the CDN, configs included, with Salsa20. The key is distributed separately as a .ak file,
+// and the nonce is the last 8 bytes of each object's CDN hash.
+package armadillo
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lukegb/snowstorm/internal/salsa20"
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+const keyFileSize = 20
+
+var (
+	// ErrKeyRequired means that content is Armadillo-encrypted, but no key was supplied to decrypt it.
+	ErrKeyRequired = errors.New("armadillo: content is encrypted, but no Armadillo key was supplied")
+
+	// ErrBadChecksum means that a key file is corrupt.
+	ErrBadChecksum = errors.New("armadillo: key file checksum mismatch")
+)
+
+// A Key is an Armadillo key.
+type Key [16]byte
+
+// ReadKey parses a .ak key file, which holds a key followed by the first four bytes of its MD5.
+func ReadKey(r io.Reader) (Key, error) {
+	var k Key
+	b := make([]byte, keyFileSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return k, fmt.Errorf("armadillo: reading key file: %v", err)
+	}
+	copy(k[:], b)
+	sum := md5.Sum(k[:])
+	if !bytes.Equal(sum[:4], b[16:]) {
+		return k, ErrBadChecksum
+	}
+	return k, nil
+}
+
+// ReadKeyFile reads the .ak key file at fn.
+func ReadKeyFile(fn string) (Key, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return Key{}, err
+	}
+	defer f.Close()
+	return ReadKey(f)
+}
+
+// NewReader decrypts r, which reads the object named h starting offset bytes in.
+func (k Key) NewReader(r io.Reader, h ngdp.CDNHash, offset int64) (io.Reader, error) {
+	s, err := salsa20.NewCipherAt(k[:], h[len(h)-salsa20.NonceSize:], uint64(offset))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.StreamReader{S: s, R: r}, nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package armadillo
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io/ioutil"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+func TestReadKey(t *testing.T) {
+	want := Key{0x01, 0x02, 0x03}
+	sum := md5.Sum(want[:])
+	b := append(append([]byte{}, want[:]...), sum[:4]...)
+
+	got, err := ReadKey(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("ReadKey: %v", err)
+	}
+	if got != want {
+		t.Errorf("ReadKey = %x; want %x", got, want)
+	}
+
+	b[19] ^= 0xff
+	if _, err := ReadKey(bytes.NewReader(b)); err != ErrBadChecksum {
+		t.Errorf("ReadKey with bad checksum: %v; want %v", err, ErrBadChecksum)
+	}
+
+	if _, err := ReadKey(bytes.NewReader(b[:10])); err == nil {
+		t.Errorf("ReadKey with short file: nil error; want error")
+	}
+}
+
+func TestNewReaderRoundTrip(t *testing.T) {
+	k := Key{0xaa, 0xbb}
+	h := ngdp.CDNHash{15: 0x42}
+	plain := []byte("# Build Configuration\n\nroot = 0123\n")
+
+	r, err := k.NewReader(bytes.NewReader(plain), h, 0)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	enc, _ := ioutil.ReadAll(r)
+	if bytes.Equal(enc, plain) {
+		t.Fatalf("encrypted data is the same as the plaintext")
+	}
+
+	// Decrypting from part way through should match too.
+	r, err = k.NewReader(bytes.NewReader(enc[10:]), h, 10)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	got, _ := ioutil.ReadAll(r)
+	if !bytes.Equal(got, plain[10:]) {
+		t.Errorf("decrypted = %q; want %q", got, plain[10:])
+	}
+}
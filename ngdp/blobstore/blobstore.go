@@ -0,0 +1,113 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package blobstore abstracts over the places that mirrors and caches can keep their objects, such as a local directory or a cloud storage bucket.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotExist is returned when a blob isn't in a Store.
+var ErrNotExist = errors.New("blobstore: blob does not exist")
+
+// A Store holds blobs, named by slash-separated keys.
+type Store interface {
+	// Open returns the contents of the blob at key.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Size returns the size of the blob at key.
+	Size(ctx context.Context, key string) (int64, error)
+
+	// Put stores the contents of r at key, replacing anything already there.
+	//
+	// If Put fails, the blob at key is left untouched; readers never see a partially-written blob.
+	Put(ctx context.Context, key string, r io.Reader) error
+}
+
+// A Remover is a Store which can also delete blobs.
+type Remover interface {
+	// Remove deletes the blob at key. Removing a blob which doesn't exist is not an error.
+	Remove(ctx context.Context, key string) error
+}
+
+// A Dir is a Store which keeps blobs as files in a local directory.
+type Dir string
+
+func (d Dir) path(key string) string {
+	return filepath.Join(string(d), filepath.FromSlash(key))
+}
+
+// Open returns the contents of the file at key.
+func (d Dir) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(d.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+// Size returns the size of the file at key.
+func (d Dir) Size(ctx context.Context, key string) (int64, error) {
+	fi, err := os.Stat(d.path(key))
+	if os.IsNotExist(err) {
+		return 0, ErrNotExist
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// Put writes r to a temporary file, then moves it into place at key.
+func (d Dir) Put(ctx context.Context, key string, r io.Reader) error {
+	fn := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(fn), ".blob-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fn)
+}
+
+// Remove deletes the file at key.
+func (d Dir) Remove(ctx context.Context, key string) error {
+	err := os.Remove(d.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+var (
+	_ Store   = Dir("")
+	_ Remover = Dir("")
+)
@@ -0,0 +1,155 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobstore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+func TestDir(t *testing.T) {
+	ctx := context.Background()
+	d := Dir(t.TempDir())
+
+	if _, err := d.Open(ctx, "a/b/c"); err != ErrNotExist {
+		t.Errorf("Open of missing blob: %v; want %v", err, ErrNotExist)
+	}
+	if _, err := d.Size(ctx, "a/b/c"); err != ErrNotExist {
+		t.Errorf("Size of missing blob: %v; want %v", err, ErrNotExist)
+	}
+
+	for _, want := range []string{"hello", "replaced"} {
+		if err := d.Put(ctx, "a/b/c", strings.NewReader(want)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		r, err := d.Open(ctx, "a/b/c")
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("Open returned %q; want %q", got, want)
+		}
+		if size, err := d.Size(ctx, "a/b/c"); err != nil || size != int64(len(want)) {
+			t.Errorf("Size = %d, %v; want %d, nil", size, err, len(want))
+		}
+	}
+}
+
+func TestContentStores(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for name, cs := range map[string]ContentStore{
+		"disk":   NewDiskContentStore(dir),
+		"memory": NewMemoryContentStore(),
+	} {
+		h := ngdp.CDNHash{0xab, 0xcd, 0xef}
+		if ok, err := cs.Has(ctx, h); ok || err != nil {
+			t.Errorf("%s: Has before Put = %v, %v; want false, nil", name, ok, err)
+		}
+		if _, err := cs.Get(ctx, h); err != ErrNotExist {
+			t.Errorf("%s: Get before Put: %v; want %v", name, err, ErrNotExist)
+		}
+		if err := cs.Put(ctx, h, strings.NewReader("BLTE")); err != nil {
+			t.Fatalf("%s: Put: %v", name, err)
+		}
+		if ok, err := cs.Has(ctx, h); !ok || err != nil {
+			t.Errorf("%s: Has after Put = %v, %v; want true, nil", name, ok, err)
+		}
+		r, err := cs.Get(ctx, h)
+		if err != nil {
+			t.Fatalf("%s: Get: %v", name, err)
+		}
+		got, _ := ioutil.ReadAll(r)
+		r.Close()
+		if string(got) != "BLTE" {
+			t.Errorf("%s: Get returned %q; want %q", name, got, "BLTE")
+		}
+
+		if err := cs.(ContentRemover).Remove(ctx, ngdp.CDNHash{0x01}); err != nil {
+			t.Errorf("%s: Remove of a missing file: %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "ab", "cd", "abcdef00000000000000000000000000")); err != nil {
+		t.Errorf("disk store didn't use the CDN layout: %v", err)
+	}
+}
+
+func TestSharedStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewSharedStore(NewMemoryContentStore())
+
+	a, b, c := ngdp.CDNHash{0xa}, ngdp.CDNHash{0xb}, ngdp.CDNHash{0xc}
+	hero, herot := ngdp.CDNHash{0x1}, ngdp.CDNHash{0x2}
+	s.Retain(hero, []ngdp.CDNHash{a, b, b})
+	s.Retain(herot, []ngdp.CDNHash{b, c})
+	s.Retain(herot, []ngdp.CDNHash{b, c}) // no-op
+	if builds, files := s.Builds(); builds != 2 || files != 3 {
+		t.Errorf("Builds = %d, %d; want 2, 3", builds, files)
+	}
+
+	orphan := ngdp.CDNHash{0xd}
+	for _, h := range []ngdp.CDNHash{a, b, c, orphan} {
+		if err := s.Put(ctx, h, strings.NewReader("BLTE")); err != nil {
+			t.Fatalf("Put(%032x): %v", h, err)
+		}
+	}
+
+	has := func(h ngdp.CDNHash) bool {
+		ok, err := s.Has(ctx, h)
+		if err != nil {
+			t.Fatalf("Has(%032x): %v", h, err)
+		}
+		return ok
+	}
+
+	// Only the file no build refers to is collected.
+	if n, err := s.GC(ctx); n != 1 || err != nil {
+		t.Errorf("GC = %d, %v; want 1, nil", n, err)
+	}
+	if has(orphan) || !has(a) || !has(b) || !has(c) {
+		t.Errorf("after first GC, has %v %v %v %v; want only a, b and c", has(a), has(b), has(c), has(orphan))
+	}
+
+	// b is still needed by herot.
+	s.Release(hero)
+	if n, err := s.GC(ctx); n != 1 || err != nil {
+		t.Errorf("GC after releasing hero = %d, %v; want 1, nil", n, err)
+	}
+	if has(a) || !has(b) || !has(c) {
+		t.Errorf("after releasing hero, has %v %v %v; want only b and c", has(a), has(b), has(c))
+	}
+
+	s.Release(herot)
+	if n, err := s.GC(ctx); n != 2 || err != nil {
+		t.Errorf("GC after releasing herot = %d, %v; want 2, nil", n, err)
+	}
+	if builds, files := s.Builds(); builds != 0 || files != 0 {
+		t.Errorf("Builds = %d, %d; want 0, 0", builds, files)
+	}
+}
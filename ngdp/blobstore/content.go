@@ -0,0 +1,157 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sync"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+// A ContentStore holds BLTE-encoded files, named by their CDN hash.
+//
+// It is implemented by HashedStore and MemoryContentStore here, and by casc.Writer for a game's local storage.
+type ContentStore interface {
+	// Get returns the file with the given CDN hash, or ErrNotExist.
+	Get(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error)
+
+	// Put stores the contents of r under h.
+	Put(ctx context.Context, h ngdp.CDNHash, r io.Reader) error
+
+	// Has returns true if the file with the given CDN hash is present.
+	Has(ctx context.Context, h ngdp.CDNHash) (bool, error)
+}
+
+// A ContentRemover is a ContentStore which can also delete files.
+type ContentRemover interface {
+	// Remove deletes the file with the given CDN hash. Removing a file which isn't present is not an error.
+	Remove(ctx context.Context, h ngdp.CDNHash) error
+}
+
+// ContentKey returns the key a file is stored under beneath prefix, in the same layout the CDN uses: prefix/ab/cd/abcd...
+func ContentKey(prefix string, h ngdp.CDNHash) string {
+	hs := fmt.Sprintf("%032x", h)
+	return path.Join(prefix, hs[0:2], hs[2:4], hs)
+}
+
+// A HashedStore is a ContentStore which keeps files in a Store, laid out as by ContentKey.
+//
+// With a prefix of "<cdn path>/data", it reads and writes the same objects as a mirror.
+type HashedStore struct {
+	Store  Store
+	Prefix string
+}
+
+// NewDiskContentStore returns a ContentStore which keeps files in the local directory dir.
+func NewDiskContentStore(dir string) *HashedStore {
+	return &HashedStore{Store: Dir(dir)}
+}
+
+// Get returns the file with the given CDN hash.
+func (s *HashedStore) Get(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error) {
+	return s.Store.Open(ctx, ContentKey(s.Prefix, h))
+}
+
+// Put stores the contents of r under h.
+func (s *HashedStore) Put(ctx context.Context, h ngdp.CDNHash, r io.Reader) error {
+	return s.Store.Put(ctx, ContentKey(s.Prefix, h), r)
+}
+
+// Has returns true if the file with the given CDN hash is present.
+func (s *HashedStore) Has(ctx context.Context, h ngdp.CDNHash) (bool, error) {
+	_, err := s.Store.Size(ctx, ContentKey(s.Prefix, h))
+	if err == ErrNotExist {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Remove deletes the file with the given CDN hash, if the underlying Store is a Remover.
+func (s *HashedStore) Remove(ctx context.Context, h ngdp.CDNHash) error {
+	r, ok := s.Store.(Remover)
+	if !ok {
+		return fmt.Errorf("blobstore: %T can't remove blobs", s.Store)
+	}
+	return r.Remove(ctx, ContentKey(s.Prefix, h))
+}
+
+// A MemoryContentStore is a ContentStore which keeps everything in memory, and forgets it all on restart.
+//
+// It is safe for concurrent use.
+type MemoryContentStore struct {
+	mu    sync.RWMutex
+	files map[ngdp.CDNHash][]byte
+}
+
+// NewMemoryContentStore returns an empty MemoryContentStore.
+func NewMemoryContentStore() *MemoryContentStore {
+	return &MemoryContentStore{
+		files: make(map[ngdp.CDNHash][]byte),
+	}
+}
+
+// Get returns the file with the given CDN hash.
+func (s *MemoryContentStore) Get(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.files[h]
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// Put stores the contents of r under h.
+func (s *MemoryContentStore) Put(ctx context.Context, h ngdp.CDNHash, r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[h] = b
+	return nil
+}
+
+// Has returns true if the file with the given CDN hash is present.
+func (s *MemoryContentStore) Has(ctx context.Context, h ngdp.CDNHash) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.files[h]
+	return ok, nil
+}
+
+// Remove deletes the file with the given CDN hash.
+func (s *MemoryContentStore) Remove(ctx context.Context, h ngdp.CDNHash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, h)
+	return nil
+}
+
+var (
+	_ ContentStore   = (*HashedStore)(nil)
+	_ ContentStore   = (*MemoryContentStore)(nil)
+	_ ContentRemover = (*HashedStore)(nil)
+	_ ContentRemover = (*MemoryContentStore)(nil)
+)
@@ -0,0 +1,87 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gcsblob implements a blobstore.Store backed by a Google Cloud Storage bucket.
+package gcsblob
+
+import (
+	"context"
+	"io"
+	"path"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+)
+
+// A Store keeps blobs as objects in a GCS bucket, under a common prefix.
+type Store struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+// New returns a Store which keeps blobs in bucket, with keys prefixed by prefix.
+func New(client *storage.Client, bucket, prefix string) *Store {
+	return &Store{
+		bucket: client.Bucket(bucket),
+		prefix: prefix,
+	}
+}
+
+func (s *Store) object(key string) *storage.ObjectHandle {
+	return s.bucket.Object(path.Join(s.prefix, key))
+}
+
+// Open returns the contents of the object at key.
+func (s *Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := s.object(key).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, blobstore.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Size returns the size of the object at key.
+func (s *Store) Size(ctx context.Context, key string) (int64, error) {
+	attrs, err := s.object(key).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return 0, blobstore.ErrNotExist
+	}
+	if err != nil {
+		return 0, err
+	}
+	return attrs.Size, nil
+}
+
+// Put uploads r to key.
+func (s *Store) Put(ctx context.Context, key string, r io.Reader) error {
+	// Cancelling the context is the only way to abandon an upload without committing it.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := s.object(key).NewWriter(ctx)
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+var _ blobstore.Store = (*Store)(nil)
@@ -0,0 +1,104 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package memcacheblob implements a blobstore.ContentStore backed by memcached, so that several servers can share one cache.
+package memcacheblob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+)
+
+// DefaultMaxItemSize is memcached's default limit on the size of an item, less some room for the key and item header.
+const DefaultMaxItemSize = 1<<20 - 1024
+
+// A ContentStore keeps files as memcached items, with keys made of a common prefix and the file's CDN hash.
+//
+// The memcache client has no notion of a context, so calls cannot be cancelled; they are bounded by the client's Timeout instead.
+type ContentStore struct {
+	client *memcache.Client
+	prefix string
+
+	// TTL is how long files are kept for after they are stored. If zero, files are kept until memcached evicts them.
+	TTL time.Duration
+
+	// MaxItemSize is the size of the largest file which will be stored. Larger files are silently skipped, since memcached would refuse them anyway.
+	MaxItemSize int
+}
+
+// New returns a ContentStore which keeps files in the memcached servers client uses, with keys prefixed by prefix.
+func New(client *memcache.Client, prefix string) *ContentStore {
+	return &ContentStore{client: client, prefix: prefix, MaxItemSize: DefaultMaxItemSize}
+}
+
+func (s *ContentStore) key(h ngdp.CDNHash) string {
+	return fmt.Sprintf("%s%032x", s.prefix, h)
+}
+
+// Get returns the file with the given CDN hash.
+func (s *ContentStore) Get(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error) {
+	it, err := s.client.Get(s.key(h))
+	if err == memcache.ErrCacheMiss {
+		return nil, blobstore.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(it.Value)), nil
+}
+
+// Put stores the contents of r under h, unless it is larger than MaxItemSize.
+func (s *ContentStore) Put(ctx context.Context, h ngdp.CDNHash, r io.Reader) error {
+	// Read one byte more than the limit, to find out if it has been exceeded without reading the whole file.
+	b, err := ioutil.ReadAll(io.LimitReader(r, int64(s.MaxItemSize)+1))
+	if err != nil {
+		return err
+	}
+	if len(b) > s.MaxItemSize {
+		return nil
+	}
+
+	// memcached takes expirations of more than 30 days to be Unix timestamps.
+	exp := int32(s.TTL / time.Second)
+	if s.TTL > 30*24*time.Hour {
+		exp = int32(time.Now().Add(s.TTL).Unix())
+	}
+	return s.client.Set(&memcache.Item{
+		Key:        s.key(h),
+		Value:      b,
+		Expiration: exp,
+	})
+}
+
+// Has returns true if the file with the given CDN hash is present.
+func (s *ContentStore) Has(ctx context.Context, h ngdp.CDNHash) (bool, error) {
+	_, err := s.client.Get(s.key(h))
+	if err == memcache.ErrCacheMiss {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+var _ blobstore.ContentStore = (*ContentStore)(nil)
@@ -0,0 +1,102 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package redisblob implements a blobstore.ContentStore backed by Redis, so that several servers can share one cache.
+package redisblob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+)
+
+// A ContentStore keeps files as Redis strings, with keys made of a common prefix and the file's CDN hash.
+type ContentStore struct {
+	pool   *redis.Pool
+	prefix string
+
+	// TTL is how long files are kept for after they are stored. If zero, files are kept until Redis evicts them.
+	TTL time.Duration
+}
+
+// New returns a ContentStore which keeps files in the Redis server pool connects to, with keys prefixed by prefix.
+func New(pool *redis.Pool, prefix string) *ContentStore {
+	return &ContentStore{pool: pool, prefix: prefix}
+}
+
+// Dial returns a ContentStore which keeps files in the Redis server at the given redis:// URL.
+func Dial(url, prefix string) *ContentStore {
+	return New(&redis.Pool{
+		Dial:        func() (redis.Conn, error) { return redis.DialURL(url) },
+		MaxIdle:     8,
+		IdleTimeout: 5 * time.Minute,
+	}, prefix)
+}
+
+func (s *ContentStore) key(h ngdp.CDNHash) string {
+	return fmt.Sprintf("%s%032x", s.prefix, h)
+}
+
+// do runs a single command on a connection from the pool.
+func (s *ContentStore) do(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.Do(cmd, args...)
+}
+
+// Get returns the file with the given CDN hash.
+func (s *ContentStore) Get(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error) {
+	b, err := redis.Bytes(s.do(ctx, "GET", s.key(h)))
+	if err == redis.ErrNil {
+		return nil, blobstore.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// Put stores the contents of r under h. Redis sets values atomically, so readers never see part of a file.
+func (s *ContentStore) Put(ctx context.Context, h ngdp.CDNHash, r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{s.key(h), b}
+	if s.TTL > 0 {
+		args = append(args, "PX", int64(s.TTL/time.Millisecond))
+	}
+	_, err = s.do(ctx, "SET", args...)
+	return err
+}
+
+// Has returns true if the file with the given CDN hash is present.
+func (s *ContentStore) Has(ctx context.Context, h ngdp.CDNHash) (bool, error) {
+	return redis.Bool(s.do(ctx, "EXISTS", s.key(h)))
+}
+
+var _ blobstore.ContentStore = (*ContentStore)(nil)
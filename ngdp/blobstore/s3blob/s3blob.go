@@ -0,0 +1,104 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package s3blob implements a blobstore.Store backed by an Amazon S3 bucket.
+package s3blob
+
+import (
+	"context"
+	"io"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+)
+
+// A Store keeps blobs as objects in an S3 bucket, under a common prefix.
+type Store struct {
+	bucket string
+	prefix string
+
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+// New returns a Store which keeps blobs in bucket, with keys prefixed by prefix.
+func New(sess *session.Session, bucket, prefix string) *Store {
+	client := s3.New(sess)
+	return &Store{
+		bucket:   bucket,
+		prefix:   prefix,
+		client:   client,
+		uploader: s3manager.NewUploaderWithClient(client),
+	}
+}
+
+func (s *Store) key(key string) *string {
+	return aws.String(path.Join(s.prefix, key))
+}
+
+func isNotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	// HEAD requests have no body, so S3 can't say NoSuchKey.
+	return ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound")
+}
+
+// Open returns the contents of the object at key.
+func (s *Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.key(key),
+	})
+	if isNotFound(err) {
+		return nil, blobstore.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// Size returns the size of the object at key.
+func (s *Store) Size(ctx context.Context, key string) (int64, error) {
+	out, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.key(key),
+	})
+	if isNotFound(err) {
+		return 0, blobstore.ErrNotExist
+	}
+	if err != nil {
+		return 0, err
+	}
+	return aws.Int64Value(out.ContentLength), nil
+}
+
+// Put uploads r to key. S3 only makes objects visible once their upload completes.
+func (s *Store) Put(ctx context.Context, key string, r io.Reader) error {
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.key(key),
+		Body:   r,
+	})
+	return err
+}
+
+var _ blobstore.Store = (*Store)(nil)
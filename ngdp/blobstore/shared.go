@@ -0,0 +1,131 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+// A SharedStore is a ContentStore shared between the builds of several programs, so that files common to them are only downloaded and stored once.
+//
+// Each build holds a reference to every file it lists. Once the last build referring to a file has been released, GC removes it from the underlying store.
+// References are only kept in memory: files left behind by a previous process are never collected.
+// It is safe for concurrent use.
+type SharedStore struct {
+	ContentStore
+
+	mu      sync.Mutex
+	refs    map[ngdp.CDNHash]int
+	builds  map[ngdp.CDNHash][]ngdp.CDNHash
+	garbage map[ngdp.CDNHash]bool
+}
+
+// NewSharedStore returns a SharedStore keeping its files in cs. To collect garbage, cs must also be a ContentRemover.
+func NewSharedStore(cs ContentStore) *SharedStore {
+	return &SharedStore{
+		ContentStore: cs,
+		refs:         make(map[ngdp.CDNHash]int),
+		builds:       make(map[ngdp.CDNHash][]ngdp.CDNHash),
+		garbage:      make(map[ngdp.CDNHash]bool),
+	}
+}
+
+// Retain records that the build named by its build config hash refers to files. Retaining a build which is already retained does nothing.
+func (s *SharedStore) Retain(build ngdp.CDNHash, files []ngdp.CDNHash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.builds[build]; ok {
+		return
+	}
+
+	// Keep our own copy, without duplicates, so each file is released exactly as often as it was retained.
+	seen := make(map[ngdp.CDNHash]bool, len(files))
+	own := make([]ngdp.CDNHash, 0, len(files))
+	for _, h := range files {
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+		own = append(own, h)
+		s.refs[h]++
+		delete(s.garbage, h)
+	}
+	s.builds[build] = own
+}
+
+// Release drops the references held by the build named by its build config hash. Files no other build refers to are removed by the next GC.
+func (s *SharedStore) Release(build ngdp.CDNHash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range s.builds[build] {
+		s.refs[h]--
+		if s.refs[h] <= 0 {
+			delete(s.refs, h)
+			s.garbage[h] = true
+		}
+	}
+	delete(s.builds, build)
+}
+
+// Builds returns the number of builds currently retained, and the number of distinct files they refer to.
+func (s *SharedStore) Builds() (builds, files int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.builds), len(s.refs)
+}
+
+// Put stores the contents of r under h. Files which no retained build refers to are stored, but are removed by the next GC.
+func (s *SharedStore) Put(ctx context.Context, h ngdp.CDNHash, r io.Reader) error {
+	if err := s.ContentStore.Put(ctx, h, r); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refs[h] == 0 {
+		s.garbage[h] = true
+	}
+	return nil
+}
+
+// GC removes every file which is no longer referred to by any retained build, returning how many it removed.
+//
+// Files can't be retained or stored while GC is running, so that nothing is retained again just as it is removed.
+func (s *SharedStore) GC(ctx context.Context) (int, error) {
+	rm, ok := s.ContentStore.(ContentRemover)
+	if !ok {
+		return 0, fmt.Errorf("blobstore: %T can't remove files", s.ContentStore)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for h := range s.garbage {
+		if err := rm.Remove(ctx, h); err != nil {
+			return n, err
+		}
+		delete(s.garbage, h)
+		n++
+	}
+	return n, nil
+}
+
+var _ ContentStore = (*SharedStore)(nil)
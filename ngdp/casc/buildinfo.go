@@ -0,0 +1,178 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/configtable"
+)
+
+// BuildInfoFilename is the name of the file at the root of an installation which describes the installed build.
+const BuildInfoFilename = ".build.info"
+
+// A BuildInfo is a single row of a .build.info file.
+type BuildInfo struct {
+	Branch        string
+	Active        int
+	BuildKey      ngdp.CDNHash `configtable:"Build Key"`
+	CDNKey        ngdp.CDNHash `configtable:"CDN Key"`
+	InstallKey    ngdp.CDNHash `configtable:"Install Key"`
+	IMSize        string       `configtable:"IM Size"` // often left empty by the launcher, so not a number
+	CDNPath       string       `configtable:"CDN Path"`
+	CDNHosts      []string     `configtable:"CDN Hosts"`
+	CDNServers    []string     `configtable:"CDN Servers"`
+	Tags          string
+	Armadillo     string
+	LastActivated string `configtable:"Last Activated"`
+	Version       string
+	KeyRing       ngdp.CDNHash
+	Product       ngdp.ProgramCode
+}
+
+// ReadBuildInfo parses a .build.info file.
+func ReadBuildInfo(r io.Reader) ([]BuildInfo, error) {
+	var infos []BuildInfo
+	if err := configtable.DecodeAll(r, &infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// NewBuildInfo creates an active .build.info row for the build c refers to, filled in as the launcher would after installing it with the given install tags.
+func NewBuildInfo(c *client.Client, program ngdp.ProgramCode, tags []string) (BuildInfo, error) {
+	installKey, err := c.EncodingMapper.ToCDNHash(c.BuildConfig.Install)
+	if err != nil {
+		return BuildInfo{}, errors.Wrap(err, "looking up install manifest")
+	}
+
+	bi := BuildInfo{
+		Branch:        string(c.VersionInfo.Region),
+		Active:        1,
+		BuildKey:      c.VersionInfo.BuildConfig,
+		CDNKey:        c.VersionInfo.CDNConfig,
+		InstallKey:    installKey,
+		CDNPath:       c.CDNInfo.Path,
+		CDNHosts:      c.CDNInfo.Hosts,
+		LastActivated: time.Now().UTC().Format(time.RFC3339),
+		Version:       c.VersionInfo.VersionsName,
+		KeyRing:       c.VersionInfo.KeyRing,
+		Product:       program,
+	}
+	if c.BuildConfig.InstallSize != 0 {
+		bi.IMSize = fmt.Sprintf("%d", c.BuildConfig.InstallSize)
+	}
+	for _, h := range c.CDNInfo.Hosts {
+		bi.CDNServers = append(bi.CDNServers, fmt.Sprintf("http://%s/?maxhosts=4", h))
+	}
+	if len(tags) > 0 {
+		// The launcher records a tag set for each of speech and text; we use the same tags for both.
+		t := strings.Join(tags, " ")
+		bi.Tags = t + " speech?:" + t + " text?"
+	}
+	return bi, nil
+}
+
+// CDNInfo returns the CDN that the launcher downloaded the build described by bi from.
+// If the row lists no CDN hosts, the hosts of its CDN servers are used instead.
+func (bi BuildInfo) CDNInfo() ngdp.CDNInfo {
+	cdn := ngdp.CDNInfo{
+		Name:  ngdp.Region(bi.Branch),
+		Path:  bi.CDNPath,
+		Hosts: bi.CDNHosts,
+	}
+	if len(cdn.Hosts) == 0 {
+		for _, s := range bi.CDNServers {
+			if u, err := url.Parse(s); err == nil && u.Host != "" {
+				cdn.Hosts = append(cdn.Hosts, u.Host)
+			}
+		}
+	}
+	return cdn
+}
+
+// VersionInfo returns the version described by bi. Its BuildID is taken from the last part of the version name, if that is a number.
+func (bi BuildInfo) VersionInfo() ngdp.VersionInfo {
+	v := ngdp.VersionInfo{
+		Region:       ngdp.Region(bi.Branch),
+		BuildConfig:  bi.BuildKey,
+		CDNConfig:    bi.CDNKey,
+		VersionsName: bi.Version,
+		KeyRing:      bi.KeyRing,
+	}
+	if n := strings.LastIndex(bi.Version, "."); n != -1 {
+		v.BuildID, _ = strconv.Atoi(bi.Version[n+1:])
+	}
+	return v
+}
+
+// NewClient creates a client for exactly the build described by bi, such as the one installed in a game directory, rather than the current version of its product.
+func NewClient(ctx context.Context, llc *client.LowLevelClient, bi BuildInfo) (*client.Client, error) {
+	cdn := bi.CDNInfo()
+	if len(cdn.Hosts) == 0 {
+		return nil, fmt.Errorf("casc: .build.info row for %s/%s lists no CDN hosts", bi.Product, bi.Branch)
+	}
+	return client.NewWithVersion(ctx, llc, cdn, bi.VersionInfo())
+}
+
+// buildInfoHeader is the header line written by WriteBuildInfo.
+const buildInfoHeader = "Branch!STRING:0|Active!DEC:1|Build Key!HEX:16|CDN Key!HEX:16|Install Key!HEX:16|IM Size!DEC:4|CDN Path!STRING:0|CDN Hosts!STRING:0|CDN Servers!STRING:0|Tags!STRING:0|Armadillo!STRING:0|Last Activated!STRING:0|Version!STRING:0|KeyRing!HEX:16|Product!STRING:0"
+
+// hexOrEmpty formats a hash as hex, or as an empty string if it is unset.
+func hexOrEmpty(h ngdp.CDNHash) string {
+	if h.Equal(ngdp.CDNHash{}) {
+		return ""
+	}
+	return fmt.Sprintf("%032x", h)
+}
+
+// WriteBuildInfo writes a .build.info file containing the given rows.
+func WriteBuildInfo(w io.Writer, infos []BuildInfo) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, buildInfoHeader)
+	for _, bi := range infos {
+		fmt.Fprintln(bw, strings.Join([]string{
+			bi.Branch,
+			fmt.Sprintf("%d", bi.Active),
+			hexOrEmpty(bi.BuildKey),
+			hexOrEmpty(bi.CDNKey),
+			hexOrEmpty(bi.InstallKey),
+			bi.IMSize,
+			bi.CDNPath,
+			strings.Join(bi.CDNHosts, " "),
+			strings.Join(bi.CDNServers, " "),
+			bi.Tags,
+			bi.Armadillo,
+			bi.LastActivated,
+			bi.Version,
+			hexOrEmpty(bi.KeyRing),
+			string(bi.Product),
+		}, "|"))
+	}
+	return bw.Flush()
+}
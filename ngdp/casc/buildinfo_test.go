@@ -0,0 +1,152 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casc
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/ngdptest"
+)
+
+const exampleBuildInfo = `Branch!STRING:0|Active!DEC:1|Build Key!HEX:16|CDN Key!HEX:16|Install Key!HEX:16|IM Size!DEC:4|CDN Path!STRING:0|CDN Hosts!STRING:0|CDN Servers!STRING:0|Tags!STRING:0|Armadillo!STRING:0|Last Activated!STRING:0|Version!STRING:0|KeyRing!HEX:16|Product!STRING:0
+eu|1|a423790b9bcee8ac532ceb39fe550685|c8043457fcf9eb6dac433e53fa47f5ab|0123456789abcdef0123456789abcdef|5891|tpr/Hero-Live-a|blzddist1-a.akamaihd.net level3.blizzard.com|http://blzddist1-a.akamaihd.net/?maxhosts=4 http://level3.blizzard.com/?maxhosts=4|Windows x86_64 EU? acct-GBR? geoip-GB? enUS speech?:Windows x86_64 EU? acct-GBR? geoip-GB? enUS text?||2017-06-01T12:00:00Z|2.25.3.54339||hero
+`
+
+func TestReadBuildInfo(t *testing.T) {
+	got, err := ReadBuildInfo(strings.NewReader(exampleBuildInfo))
+	if err != nil {
+		t.Fatalf("ReadBuildInfo: %v", err)
+	}
+
+	want := []BuildInfo{{
+		Branch:        "eu",
+		Active:        1,
+		BuildKey:      ngdp.CDNHash{0xa4, 0x23, 0x79, 0x0b, 0x9b, 0xce, 0xe8, 0xac, 0x53, 0x2c, 0xeb, 0x39, 0xfe, 0x55, 0x06, 0x85},
+		CDNKey:        ngdp.CDNHash{0xc8, 0x04, 0x34, 0x57, 0xfc, 0xf9, 0xeb, 0x6d, 0xac, 0x43, 0x3e, 0x53, 0xfa, 0x47, 0xf5, 0xab},
+		InstallKey:    ngdp.CDNHash{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef},
+		IMSize:        "5891",
+		CDNPath:       "tpr/Hero-Live-a",
+		CDNHosts:      []string{"blzddist1-a.akamaihd.net", "level3.blizzard.com"},
+		CDNServers:    []string{"http://blzddist1-a.akamaihd.net/?maxhosts=4", "http://level3.blizzard.com/?maxhosts=4"},
+		Tags:          "Windows x86_64 EU? acct-GBR? geoip-GB? enUS speech?:Windows x86_64 EU? acct-GBR? geoip-GB? enUS text?",
+		LastActivated: "2017-06-01T12:00:00Z",
+		Version:       "2.25.3.54339",
+		Product:       "hero",
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadBuildInfo = %#v; want %#v", got, want)
+	}
+}
+
+func TestWriteBuildInfo(t *testing.T) {
+	want, err := ReadBuildInfo(strings.NewReader(exampleBuildInfo))
+	if err != nil {
+		t.Fatalf("ReadBuildInfo: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBuildInfo(&buf, want); err != nil {
+		t.Fatalf("WriteBuildInfo: %v", err)
+	}
+
+	got, err := ReadBuildInfo(&buf)
+	if err != nil {
+		t.Fatalf("ReadBuildInfo(WriteBuildInfo(...)): %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadBuildInfo(WriteBuildInfo(...)) = %#v; want %#v", got, want)
+	}
+}
+
+func TestBuildInfoVersion(t *testing.T) {
+	infos, err := ReadBuildInfo(strings.NewReader(exampleBuildInfo))
+	if err != nil {
+		t.Fatalf("ReadBuildInfo: %v", err)
+	}
+	bi := infos[0]
+
+	if got, want := bi.VersionInfo(), (ngdp.VersionInfo{
+		Region:       "eu",
+		BuildConfig:  bi.BuildKey,
+		CDNConfig:    bi.CDNKey,
+		BuildID:      54339,
+		VersionsName: "2.25.3.54339",
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("VersionInfo = %#v; want %#v", got, want)
+	}
+
+	want := ngdp.CDNInfo{Name: "eu", Path: "tpr/Hero-Live-a", Hosts: []string{"blzddist1-a.akamaihd.net", "level3.blizzard.com"}}
+	if got := bi.CDNInfo(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CDNInfo = %#v; want %#v", got, want)
+	}
+	bi.CDNHosts = nil
+	if got := bi.CDNInfo(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CDNInfo without CDN Hosts = %#v; want %#v", got, want)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	s := ngdptest.NewServer()
+	defer s.Close()
+
+	ctx := context.Background()
+	llc := s.LowLevelClient()
+	old := []byte("old build")
+	oldVersion := s.AddBuild("test", "eu", old)
+	s.AddBuild("test", "eu", []byte("new build"))
+	cdn, err := llc.CDN(ctx, "test", "eu")
+	if err != nil {
+		t.Fatalf("CDN: %v", err)
+	}
+
+	bi := BuildInfo{
+		Branch:   "eu",
+		Active:   1,
+		BuildKey: oldVersion.BuildConfig,
+		CDNKey:   oldVersion.CDNConfig,
+		CDNPath:  cdn.Path,
+		CDNHosts: cdn.Hosts,
+		Product:  "test",
+	}
+	c, err := NewClient(ctx, llc, bi)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if !c.VersionInfo.BuildConfig.Equal(oldVersion.BuildConfig) {
+		t.Errorf("client build config = %032x; want %032x", c.VersionInfo.BuildConfig, oldVersion.BuildConfig)
+	}
+	resp, err := c.Fetch(ctx, ngdp.ContentHash(md5.Sum(old)))
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	defer resp.Body.Close()
+	if got, err := ioutil.ReadAll(resp.Body); err != nil || !bytes.Equal(got, old) {
+		t.Errorf("Fetch returned %q, %v; want %q", got, err, old)
+	}
+
+	bi.CDNHosts = nil
+	if _, err := NewClient(ctx, llc, bi); err == nil {
+		t.Errorf("NewClient with no CDN hosts succeeded")
+	}
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package casc reads the local storage of an installed game, so that files can be retrieved without going to the CDN.
+package casc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/lukegb/snowstorm/blte"
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/encoding"
+	"github.com/lukegb/snowstorm/ngdp/keyvalue"
+)
+
+var (
+	// ErrNoActiveBuild means that the .build.info file doesn't mark any build as active.
+	ErrNoActiveBuild = errors.New("casc: no active build in .build.info")
+
+	// ErrNoDataDirectory means that no local storage could be found inside the installation.
+	ErrNoDataDirectory = errors.New("casc: no data directory found")
+
+	// ErrNotInStorage means that the requested file isn't present in local storage.
+	ErrNotInStorage = errors.New("casc: file not in local storage")
+)
+
+// dataDirNames are the names used by different games for the directory holding local storage.
+var dataDirNames = []string{"Data", "HeroesData", "SC2Data"}
+
+// A Storage provides access to the files of an installed game.
+//
+// Its Fetch and FetchFilename methods behave like those of client.Client, so it can be used in place of one.
+type Storage struct {
+	// Dir is the root of the installation.
+	Dir string
+
+	// DataDir is the directory holding local storage, inside Dir.
+	DataDir string
+
+	BuildInfo   BuildInfo
+	BuildConfig *ngdp.BuildConfig
+	CDNConfig   *ngdp.CDNConfig
+
+	EncodingMapper *encoding.Mapper
+	FilenameMapper ngdp.FilenameMapper
+
+	index map[indexKey]indexEntry
+
+	mu    sync.Mutex
+	files map[int]*os.File
+}
+
+// Open opens the local storage of the installation at dir, using the build marked as active in its .build.info.
+func Open(dir string) (*Storage, error) {
+	f, err := os.Open(filepath.Join(dir, BuildInfoFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	infos, err := ReadBuildInfo(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing .build.info")
+	}
+	for _, bi := range infos {
+		if bi.Active != 0 {
+			return OpenBuild(dir, bi)
+		}
+	}
+	return nil, ErrNoActiveBuild
+}
+
+// OpenBuild opens the local storage of the installation at dir, using the build described by bi.
+func OpenBuild(dir string, bi BuildInfo) (*Storage, error) {
+	s := &Storage{
+		Dir:       dir,
+		BuildInfo: bi,
+		files:     make(map[int]*os.File),
+	}
+
+	if s.DataDir = findDataDir(dir); s.DataDir == "" {
+		return nil, ErrNoDataDirectory
+	}
+
+	var err error
+	if s.index, _, err = loadIndices(s.DataDir); err != nil {
+		return nil, errors.Wrap(err, "loading indices")
+	}
+
+	var buildConfig ngdp.BuildConfig
+	if err := s.decodeConfig(bi.BuildKey, &buildConfig); err != nil {
+		return nil, errors.Wrap(err, "parsing build config")
+	}
+	s.BuildConfig = &buildConfig
+
+	var cdnConfig ngdp.CDNConfig
+	if err := s.decodeConfig(bi.CDNKey, &cdnConfig); err != nil {
+		return nil, errors.Wrap(err, "parsing cdn config")
+	}
+	s.CDNConfig = &cdnConfig
+
+	r, err := s.FetchCDNHash(buildConfig.Encoding.CDNHash)
+	if err != nil {
+		s.Close()
+		return nil, errors.Wrap(err, "opening encoding table")
+	}
+	defer r.Close()
+	if s.EncodingMapper, err = encoding.NewMapper(r); err != nil {
+		s.Close()
+		return nil, errors.Wrap(err, "parsing encoding table")
+	}
+
+	return s, nil
+}
+
+// findDataDir returns the directory holding local storage inside the installation at dir, or "" if there isn't one.
+func findDataDir(dir string) string {
+	for _, n := range dataDirNames {
+		dd := filepath.Join(dir, n)
+		if fi, err := os.Stat(filepath.Join(dd, "data")); err == nil && fi.IsDir() {
+			return dd
+		}
+	}
+	return ""
+}
+
+// configPath returns where a config file is kept in the local storage at dataDir.
+func configPath(dataDir string, h ngdp.CDNHash) string {
+	hs := fmt.Sprintf("%032x", h)
+	return filepath.Join(dataDir, "config", hs[0:2], hs[2:4], hs)
+}
+
+// ConfigPath returns where a config file is kept in local storage.
+func (s *Storage) ConfigPath(h ngdp.CDNHash) string {
+	return configPath(s.DataDir, h)
+}
+
+func (s *Storage) decodeConfig(h ngdp.CDNHash, v interface{}) error {
+	f, err := os.Open(s.ConfigPath(h))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return keyvalue.Decode(f, v)
+}
+
+// dataFile returns the open data.### file with the given number.
+func (s *Storage) dataFile(n int) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f, ok := s.files[n]; ok {
+		return f, nil
+	}
+	f, err := os.Open(filepath.Join(s.DataDir, "data", fmt.Sprintf("data.%03d", n)))
+	if err != nil {
+		return nil, err
+	}
+	s.files[n] = f
+	return f, nil
+}
+
+// Has returns true if the file with the given CDN hash is present in local storage.
+func (s *Storage) Has(h ngdp.CDNHash) bool {
+	_, ok := s.index[toIndexKey(h)]
+	return ok
+}
+
+// FetchRaw retrieves the still-encoded BLTE data of a file by its CDN hash.
+func (s *Storage) FetchRaw(h ngdp.CDNHash) (io.ReadCloser, error) {
+	e, ok := s.index[toIndexKey(h)]
+	if !ok {
+		return nil, ErrNotInStorage
+	}
+	if e.size < dataHeaderSize {
+		return nil, fmt.Errorf("casc: %032x has a size of %d, which is too small", h, e.size)
+	}
+
+	f, err := s.dataFile(e.archive)
+	if err != nil {
+		return nil, err
+	}
+
+	// The data files are shared, so closing the body doesn't close them.
+	return io.NopCloser(io.NewSectionReader(f, e.offset+dataHeaderSize, int64(e.size)-dataHeaderSize)), nil
+}
+
+// FetchCDNHash retrieves and decodes a file by its CDN hash.
+func (s *Storage) FetchCDNHash(h ngdp.CDNHash) (io.ReadCloser, error) {
+	r, err := s.FetchRaw(h)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(blte.NewReader(r)), nil
+}
+
+// Fetch retrieves a given file by the hash of its contents.
+func (s *Storage) Fetch(ctx context.Context, h ngdp.ContentHash) (*client.Response, error) {
+	cdnHashes, err := s.EncodingMapper.ToCDNHashes(h)
+	if err != nil {
+		return nil, err
+	}
+	if len(cdnHashes) == 0 {
+		return nil, encoding.ErrUnknownContentHash
+	}
+
+	// Local storage usually holds only one of a file's encodings, so use whichever is present.
+	cdnHash := cdnHashes[0]
+	for _, ch := range cdnHashes {
+		if s.Has(ch) {
+			cdnHash = ch
+			break
+		}
+	}
+
+	body, err := s.FetchCDNHash(cdnHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.Response{
+		Body:             body,
+		ContentHash:      h,
+		CDNHash:          cdnHash,
+		RetrievedCDNHash: cdnHash,
+	}, nil
+}
+
+// FetchFilename retrieves a given file by its filename.
+//
+// FetchFilename requires that a FilenameMapper has been registered.
+// For Heroes of the Storm, mndx.Load can be used to build an appropriate mapper.
+func (s *Storage) FetchFilename(ctx context.Context, fn string) (*client.Response, error) {
+	if s.FilenameMapper == nil {
+		return nil, client.ErrNoFilenameMapper
+	}
+
+	h, ok := s.FilenameMapper.ToContentHash(fn)
+	if !ok {
+		return nil, client.ErrNotExists
+	}
+
+	return s.Fetch(ctx, h)
+}
+
+// Close closes any open data files.
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for n, f := range s.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.files, n)
+	}
+	return firstErr
+}
+
+var _ client.Fetcher = (*Storage)(nil)
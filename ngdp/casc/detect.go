@@ -0,0 +1,230 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casc
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/configtable"
+)
+
+// FlavorInfoFilename is the name of the file which marks the subdirectory of an installation holding one flavor of a game, such as retail or classic World of Warcraft.
+const FlavorInfoFilename = ".flavor.info"
+
+// ErrNotInstalled means that no installation of the requested product could be found.
+var ErrNotInstalled = errors.New("casc: product not installed")
+
+// knownProducts maps the directory names the launcher installs games into to their program codes, for .build.info files too old to name the product.
+var knownProducts = map[string]ngdp.ProgramCode{
+	"Heroes of the Storm": ngdp.ProgramHotS,
+	"World of Warcraft":   "wow",
+	"StarCraft II":        "s2",
+	"StarCraft":           "s1",
+	"Overwatch":           "pro",
+	"Diablo III":          "d3",
+	"Hearthstone":         "hsb",
+	"Warcraft III":        "w3",
+}
+
+// An Installation is a game installed on this machine.
+type Installation struct {
+	// Dir is the root of the installation, holding its .build.info. It can be passed to Open or OpenBuild.
+	Dir string
+
+	// Product is the program code of the installed game.
+	Product ngdp.ProgramCode
+
+	// Flavor is the subdirectory of Dir holding this product's game files, as named by its .flavor.info, or "" if there is none.
+	// Games like World of Warcraft install several products side by side in one directory, each in its own flavor.
+	Flavor string
+
+	// BuildInfo is the active .build.info row for the product.
+	BuildInfo BuildInfo
+}
+
+// Region returns the region the installation was installed from.
+func (i Installation) Region() ngdp.Region {
+	return ngdp.Region(i.BuildInfo.Branch)
+}
+
+// SearchPaths returns the directories which the Battle.net launcher installs games into by default on this platform.
+// On platforms without a launcher, it returns the equivalent directories inside the default Wine prefix.
+func SearchPaths() []string {
+	var dirs []string
+	switch runtime.GOOS {
+	case "windows":
+		for _, env := range []string{"ProgramFiles(x86)", "ProgramFiles", "ProgramW6432"} {
+			if d := os.Getenv(env); d != "" {
+				dirs = append(dirs, d)
+			}
+		}
+	case "darwin":
+		dirs = append(dirs, "/Applications")
+	default:
+		if home, err := os.UserHomeDir(); err == nil {
+			dirs = append(dirs,
+				filepath.Join(home, ".wine", "drive_c", "Program Files (x86)"),
+				filepath.Join(home, ".wine", "drive_c", "Program Files"))
+		}
+	}
+	return dirs
+}
+
+// Detect looks for installed games in each of roots and their immediate subdirectories, or in SearchPaths if roots is empty.
+//
+// Directories which don't exist are ignored, as are installations whose .build.info can't be read.
+func Detect(roots ...string) []Installation {
+	if len(roots) == 0 {
+		roots = SearchPaths()
+	}
+
+	seen := make(map[string]bool)
+	var insts []Installation
+	check := func(dir string) {
+		if seen[dir] {
+			return
+		}
+		seen[dir] = true
+		if _, err := os.Stat(filepath.Join(dir, BuildInfoFilename)); err != nil {
+			return
+		}
+		found, err := DetectDir(dir)
+		if err != nil {
+			glog.Warningf("Ignoring installation at %s: %v", dir, err)
+			return
+		}
+		insts = append(insts, found...)
+	}
+
+	for _, root := range roots {
+		check(root)
+		dents, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, dent := range dents {
+			if dent.IsDir() {
+				check(filepath.Join(root, dent.Name()))
+			}
+		}
+	}
+	return insts
+}
+
+// DetectDir describes the installation at dir, returning one Installation for each product it has an active build of.
+func DetectDir(dir string) ([]Installation, error) {
+	f, err := os.Open(filepath.Join(dir, BuildInfoFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	infos, err := ReadBuildInfo(f)
+	if err != nil {
+		return nil, fmt.Errorf("casc: parsing %s: %v", BuildInfoFilename, err)
+	}
+
+	flavors, err := readFlavors(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var insts []Installation
+	for _, bi := range infos {
+		if bi.Active == 0 {
+			continue
+		}
+		inst := Installation{
+			Dir:       dir,
+			Product:   bi.Product,
+			BuildInfo: bi,
+		}
+		if inst.Product == "" {
+			inst.Product = knownProducts[filepath.Base(dir)]
+		}
+		inst.Flavor = flavors[inst.Product]
+		insts = append(insts, inst)
+	}
+	if len(insts) == 0 {
+		return nil, ErrNoActiveBuild
+	}
+	return insts, nil
+}
+
+// readFlavors maps each product with a flavor directory inside dir to the directory's name.
+func readFlavors(dir string) (map[ngdp.ProgramCode]string, error) {
+	dents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	flavors := make(map[ngdp.ProgramCode]string)
+	for _, dent := range dents {
+		if !dent.IsDir() {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, dent.Name(), FlavorInfoFilename))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		product, err := readFlavorInfo(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("casc: parsing %s: %v", filepath.Join(dent.Name(), FlavorInfoFilename), err)
+		}
+		flavors[product] = dent.Name()
+	}
+	return flavors, nil
+}
+
+// readFlavorInfo returns the product named by a .flavor.info file.
+func readFlavorInfo(r io.Reader) (ngdp.ProgramCode, error) {
+	var fi struct {
+		ProductFlavor ngdp.ProgramCode `configtable:"Product Flavor"`
+	}
+	if err := configtable.NewDecoder(r).Decode(&fi); err != nil {
+		return "", err
+	}
+	return fi.ProductFlavor, nil
+}
+
+// FindInstallation returns the installation of program found by Detect in roots, or in SearchPaths if roots is empty.
+// If it is installed more than once, the most recently activated installation is returned.
+func FindInstallation(program ngdp.ProgramCode, roots ...string) (*Installation, error) {
+	var found []Installation
+	for _, inst := range Detect(roots...) {
+		if inst.Product == program {
+			found = append(found, inst)
+		}
+	}
+	if len(found) == 0 {
+		return nil, ErrNotInstalled
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].BuildInfo.LastActivated > found[j].BuildInfo.LastActivated
+	})
+	return &found[0], nil
+}
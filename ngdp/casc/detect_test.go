@@ -0,0 +1,92 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casc
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+const wowBuildInfo = `Branch!STRING:0|Active!DEC:1|Build Key!HEX:16|CDN Key!HEX:16|Last Activated!STRING:0|Version!STRING:0|Product!STRING:0
+eu|1|00000000000000000000000000000001|00000000000000000000000000000002|2017-06-01T12:00:00Z|7.2.5.24367|wow
+eu|1|00000000000000000000000000000003|00000000000000000000000000000004|2017-06-02T12:00:00Z|1.13.0.28211|wow_classic
+eu|0|00000000000000000000000000000005|00000000000000000000000000000006|2017-05-01T12:00:00Z|7.2.0.23937|wow
+`
+
+const oldBuildInfo = `Branch!STRING:0|Active!DEC:1|Build Key!HEX:16|CDN Key!HEX:16|Last Activated!STRING:0|Version!STRING:0
+us|1|00000000000000000000000000000007|00000000000000000000000000000008|2017-06-03T12:00:00Z|2.25.3.54339
+`
+
+func writeTestFile(t *testing.T, fn, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	root := t.TempDir()
+	wow := filepath.Join(root, "World of Warcraft")
+	writeTestFile(t, filepath.Join(wow, BuildInfoFilename), wowBuildInfo)
+	writeTestFile(t, filepath.Join(wow, "_retail_", FlavorInfoFilename), "Product Flavor!STRING:0\nwow\n")
+	writeTestFile(t, filepath.Join(wow, "_classic_", FlavorInfoFilename), "Product Flavor!STRING:0\nwow_classic\n")
+	hots := filepath.Join(root, "Heroes of the Storm")
+	writeTestFile(t, filepath.Join(hots, BuildInfoFilename), oldBuildInfo)
+	writeTestFile(t, filepath.Join(root, "Broken", BuildInfoFilename), "not a build info\n")
+	if err := os.MkdirAll(filepath.Join(root, "Something Else"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	type found struct {
+		Dir     string
+		Product ngdp.ProgramCode
+		Flavor  string
+		Region  ngdp.Region
+		Version string
+	}
+	var got []found
+	for _, inst := range Detect(root, filepath.Join(root, "Missing")) {
+		got = append(got, found{inst.Dir, inst.Product, inst.Flavor, inst.Region(), inst.BuildInfo.Version})
+	}
+	want := []found{
+		{hots, "hero", "", "us", "2.25.3.54339"},
+		{wow, "wow", "_retail_", "eu", "7.2.5.24367"},
+		{wow, "wow_classic", "_classic_", "eu", "1.13.0.28211"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Detect = %+v; want %+v", got, want)
+	}
+
+	inst, err := FindInstallation("wow_classic", root)
+	if err != nil {
+		t.Fatalf("FindInstallation: %v", err)
+	}
+	if inst.Dir != wow || inst.BuildInfo.BuildKey != (ngdp.CDNHash{15: 3}) {
+		t.Errorf("FindInstallation found %s with build %032x; want %s with build 3", inst.Dir, inst.BuildInfo.BuildKey, wow)
+	}
+
+	if _, err := FindInstallation("s2", root); err != ErrNotInstalled {
+		t.Errorf("FindInstallation(s2) = %v; want ErrNotInstalled", err)
+	}
+}
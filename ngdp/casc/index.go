@@ -0,0 +1,311 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/jenkins"
+)
+
+const (
+	// indexKeySize is the number of bytes of each CDN hash kept in the local indices.
+	indexKeySize = 9
+
+	// indexBucketCount is the number of .idx files which the keys are spread across.
+	indexBucketCount = 0x10
+
+	// indexVersion is the version of .idx file written by this package.
+	indexVersion = 7
+
+	// indexOffsetBits is the number of bits of each packed offset used for the offset within a data file; the rest are the data file number.
+	indexOffsetBits = 30
+
+	// maxDataFileSize is the largest offset that can be stored in an index entry.
+	maxDataFileSize = 1 << indexOffsetBits
+
+	// dataHeaderSize is the size of the header which precedes every file in a data.### file.
+	dataHeaderSize = 0x1e
+)
+
+type indexKey [indexKeySize]byte
+
+func toIndexKey(h ngdp.CDNHash) indexKey {
+	var k indexKey
+	copy(k[:], h[:indexKeySize])
+	return k
+}
+
+// bucket returns which .idx file a key is stored in.
+func (k indexKey) bucket() uint8 {
+	var x uint8
+	for _, b := range k {
+		x ^= b
+	}
+	return (x & 0xf) ^ (x >> 4)
+}
+
+// An indexEntry locates a file within the data.### files.
+type indexEntry struct {
+	archive int
+	offset  int64
+	size    uint32 // includes the data header
+}
+
+type indexHeader struct {
+	version        uint16
+	bucket         uint8
+	sizeBytes      uint8
+	offsetBytes    uint8
+	keyBytes       uint8
+	offsetBits     uint8
+	maxArchiveSize uint64
+}
+
+// readIndex parses a single .idx file.
+func readIndex(r io.Reader) (*indexHeader, map[indexKey]indexEntry, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, nil, fmt.Errorf("casc: reading index header length: %v", err)
+	}
+	hdrLen := binary.LittleEndian.Uint32(buf[0:4])
+	if hdrLen < 0x10 || hdrLen > 0x100 {
+		return nil, nil, fmt.Errorf("casc: unexpected index header length %d", hdrLen)
+	}
+
+	buf = make([]byte, hdrLen)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, nil, fmt.Errorf("casc: reading index header: %v", err)
+	}
+	h := &indexHeader{
+		version:        binary.LittleEndian.Uint16(buf[0:2]),
+		bucket:         buf[2],
+		sizeBytes:      buf[4],
+		offsetBytes:    buf[5],
+		keyBytes:       buf[6],
+		offsetBits:     buf[7],
+		maxArchiveSize: binary.LittleEndian.Uint64(buf[8:16]),
+	}
+	if h.version != 7 {
+		return nil, nil, fmt.Errorf("casc: unsupported index version %d", h.version)
+	}
+	if h.keyBytes != indexKeySize || h.sizeBytes > 8 || h.offsetBytes > 8 || h.offsetBits >= 64 {
+		return nil, nil, fmt.Errorf("casc: unsupported index layout (key %d, size %d, offset %d bytes)", h.keyBytes, h.sizeBytes, h.offsetBytes)
+	}
+
+	// The entries block is aligned to 16 bytes.
+	pos := 8 + int64(hdrLen)
+	if pad := (0x10 - pos%0x10) % 0x10; pad != 0 {
+		if _, err := io.CopyN(io.Discard, r, pad); err != nil {
+			return nil, nil, fmt.Errorf("casc: skipping index padding: %v", err)
+		}
+	}
+
+	buf = make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, nil, fmt.Errorf("casc: reading index entries header: %v", err)
+	}
+	entriesLen := binary.LittleEndian.Uint32(buf[0:4])
+	entryLen := uint32(h.keyBytes) + uint32(h.offsetBytes) + uint32(h.sizeBytes)
+	if entriesLen%entryLen != 0 {
+		return nil, nil, fmt.Errorf("casc: index entries block is %d bytes, which isn't a multiple of %d", entriesLen, entryLen)
+	}
+
+	entries := make(map[indexKey]indexEntry)
+	buf = make([]byte, entryLen)
+	offsetMask := uint64(1)<<h.offsetBits - 1
+	for n := uint32(0); n < entriesLen/entryLen; n++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, nil, fmt.Errorf("casc: reading index entry %d: %v", n, err)
+		}
+
+		var k indexKey
+		copy(k[:], buf[:indexKeySize])
+		b := buf[indexKeySize:]
+
+		// The offset is big-endian, and its top bits are the archive number.
+		var off uint64
+		for _, x := range b[:h.offsetBytes] {
+			off = off<<8 | uint64(x)
+		}
+		b = b[h.offsetBytes:]
+
+		// The size is little-endian.
+		var size uint64
+		for x := int(h.sizeBytes) - 1; x >= 0; x-- {
+			size = size<<8 | uint64(b[x])
+		}
+
+		entries[k] = indexEntry{
+			archive: int(off >> h.offsetBits),
+			offset:  int64(off & offsetMask),
+			size:    uint32(size),
+		}
+	}
+
+	return h, entries, nil
+}
+
+// indexFilename returns the name of the .idx file for a bucket and version.
+func indexFilename(dataDir string, bucket uint8, version uint32) string {
+	return filepath.Join(dataDir, "data", fmt.Sprintf("%02x%08x.idx", bucket, version))
+}
+
+// newestIndices finds the newest .idx file for each bucket, returning their names and versions.
+//
+// Buckets with no index file have an empty name.
+func newestIndices(dataDir string) (fns [indexBucketCount]string, versions [indexBucketCount]uint32, err error) {
+	matches, err := filepath.Glob(filepath.Join(dataDir, "data", "*.idx"))
+	if err != nil {
+		return fns, versions, err
+	}
+
+	// Index files are named with two hex digits of bucket, followed by eight of version.
+	for _, fn := range matches {
+		name := strings.TrimSuffix(filepath.Base(fn), ".idx")
+		if len(name) != 10 {
+			continue
+		}
+		bucket, err := strconv.ParseUint(name[0:2], 16, 8)
+		if err != nil || bucket >= indexBucketCount {
+			continue
+		}
+		version, err := strconv.ParseUint(name[2:], 16, 32)
+		if err != nil {
+			continue
+		}
+		if fns[bucket] == "" || uint32(version) > versions[bucket] {
+			fns[bucket] = fn
+			versions[bucket] = uint32(version)
+		}
+	}
+	return fns, versions, nil
+}
+
+// loadIndices reads the current .idx file for each bucket, returning their combined entries and versions.
+//
+// The current version of each bucket is the one recorded in the shmem file if that index is present, or the newest one otherwise.
+func loadIndices(dataDir string) (map[indexKey]indexEntry, [indexBucketCount]uint32, error) {
+	fns, versions, err := newestIndices(dataDir)
+	if err != nil {
+		return nil, versions, err
+	}
+
+	if _, err := os.Stat(filepath.Join(dataDir, "data", ShmemFilename)); err == nil {
+		shmem, err := loadShmem(dataDir)
+		if err != nil {
+			return nil, versions, errors.Wrap(err, "reading shmem")
+		}
+		for b, v := range shmem.Versions {
+			fn := indexFilename(dataDir, uint8(b), v)
+			if _, err := os.Stat(fn); err == nil {
+				fns[b], versions[b] = fn, v
+			}
+		}
+	}
+
+	index := make(map[indexKey]indexEntry)
+	for _, fn := range fns {
+		if fn == "" {
+			continue
+		}
+		if err := loadIndex(index, fn); err != nil {
+			return nil, versions, errors.Wrap(err, filepath.Base(fn))
+		}
+	}
+	return index, versions, nil
+}
+
+func loadIndex(index map[indexKey]indexEntry, fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, entries, err := readIndex(f)
+	if err != nil {
+		return err
+	}
+	for k, e := range entries {
+		index[k] = e
+	}
+	return nil
+}
+
+type indexKeys []indexKey
+
+func (s indexKeys) Len() int           { return len(s) }
+func (s indexKeys) Less(i, j int) bool { return bytes.Compare(s[i][:], s[j][:]) < 0 }
+func (s indexKeys) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// writeIndex writes a version 7 .idx file for a bucket, with its entries sorted by key.
+func writeIndex(w io.Writer, bucket uint8, entries map[indexKey]indexEntry) error {
+	hdr := make([]byte, 0x10)
+	binary.LittleEndian.PutUint16(hdr[0:2], indexVersion)
+	hdr[2] = bucket
+	hdr[4] = 4 // size bytes
+	hdr[5] = 5 // offset bytes
+	hdr[6] = indexKeySize
+	hdr[7] = indexOffsetBits
+	binary.LittleEndian.PutUint64(hdr[8:16], 0x4000000000) // the value used by the official client
+
+	var buf bytes.Buffer
+	hdrHash, _ := jenkins.HashLittle2(hdr, 0, 0)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(hdr)))
+	binary.Write(&buf, binary.LittleEndian, hdrHash)
+	buf.Write(hdr)
+	buf.Write(make([]byte, 8)) // pad to 16 bytes
+
+	keys := make(indexKeys, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Sort(keys)
+
+	// The entries' hash is chained through every entry in turn.
+	body := make([]byte, 0, len(keys)*18)
+	var pc, pb uint32
+	for _, k := range keys {
+		e := entries[k]
+		ent := make([]byte, 18)
+		copy(ent, k[:])
+		off := uint64(e.archive)<<indexOffsetBits | uint64(e.offset)
+		ent[9] = byte(off >> 32)
+		binary.BigEndian.PutUint32(ent[10:14], uint32(off))
+		binary.LittleEndian.PutUint32(ent[14:18], e.size)
+		pc, pb = jenkins.HashLittle2(ent, pc, pb)
+		body = append(body, ent...)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(len(body)))
+	binary.Write(&buf, binary.LittleEndian, pc)
+	buf.Write(body)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casc
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// makeIndex builds a .idx file containing the given entries.
+func makeIndex(bucket uint8, entries map[indexKey]indexEntry) []byte {
+	var buf bytes.Buffer
+	if err := writeIndex(&buf, bucket, entries); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadIndex(t *testing.T) {
+	want := map[indexKey]indexEntry{
+		{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}: {archive: 0, offset: 0, size: 0x1e + 10},
+		{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09}: {archive: 3, offset: 0x3fffff00, size: 0x12345678},
+	}
+
+	h, got, err := readIndex(bytes.NewReader(makeIndex(4, want)))
+	if err != nil {
+		t.Fatalf("readIndex: %v", err)
+	}
+	if h.bucket != 4 {
+		t.Errorf("h.bucket = %d; want 4", h.bucket)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readIndex = %#v; want %#v", got, want)
+	}
+}
+
+func TestReadIndexBadVersion(t *testing.T) {
+	b := makeIndex(0, nil)
+	b[8] = 6
+	if _, _, err := readIndex(bytes.NewReader(b)); err == nil {
+		t.Errorf("readIndex: nil error; want error")
+	}
+}
+
+func TestReadIndexTruncated(t *testing.T) {
+	b := makeIndex(0, map[indexKey]indexEntry{{1}: {size: 0x20}})
+	if _, _, err := readIndex(bytes.NewReader(b[:len(b)-1])); err == nil {
+		t.Errorf("readIndex: nil error; want error")
+	}
+}
+
+func TestIndexKeyBucket(t *testing.T) {
+	for _, test := range []struct {
+		k    indexKey
+		want uint8
+	}{
+		{indexKey{}, 0},
+		{indexKey{0x01}, 1},
+		{indexKey{0x10}, 1},
+		{indexKey{0x12, 0x34}, 4}, // 0x12^0x34 = 0x26; 0x6^0x2 = 4
+	} {
+		if got := test.k.bucket(); got != test.want {
+			t.Errorf("%x.bucket() = %d; want %d", test.k, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casc
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"github.com/lukegb/snowstorm/blte"
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/download"
+	"github.com/lukegb/snowstorm/ngdp/downloader"
+	"github.com/lukegb/snowstorm/ngdp/install"
+)
+
+const defaultInstallConcurrency = 8
+
+// InstallOptions control what Install does.
+type InstallOptions struct {
+	// Concurrency is the number of files to download at once. Defaults to 8.
+	Concurrency int
+
+	// Progress, if set, receives a copy of every byte downloaded.
+	Progress io.Writer
+
+	// BytesPerSecond limits the total download rate. Zero means unlimited.
+	BytesPerSecond int64
+
+	// Tags, if non-nil, selects the files from the build's install manifest to place in the installation directory, such as "Windows", "x86_64" and "enUS".
+	// If nil, only local storage is populated.
+	Tags []string
+
+	// Journal, if set, records which files from the install manifest have been placed, so that an interrupted install can be resumed without checking them again.
+	// Local storage needs no journal, as its index already records what it holds.
+	Journal *downloader.Journal
+}
+
+// Install downloads the build that c refers to into local storage in the installation at dir, and marks it as the active build in dir's .build.info.
+//
+// Files which are already present are not downloaded again, so Install can also update an existing installation to a newer build, or resume an interrupted one.
+func Install(ctx context.Context, c *client.Client, program ngdp.ProgramCode, dir string, opts InstallOptions) error {
+	return installBuild(ctx, c, program, dir, opts, nil)
+}
+
+// A RepairReport lists what Repair found wrong with an installation.
+type RepairReport struct {
+	// Checked is the number of files in local storage which were checked.
+	Checked int
+
+	// Missing lists the files which were absent from local storage.
+	Missing []ngdp.CDNHash
+
+	// Corrupt lists the files which were present, but didn't match their CDN hash.
+	Corrupt []ngdp.CDNHash
+
+	// Configs lists the config files which were missing or didn't match their hash.
+	Configs []ngdp.CDNHash
+
+	// Files lists the names of the files from the install manifest which were missing or didn't match their content hash.
+	Files []string
+}
+
+// OK reports whether nothing was found wrong.
+func (r *RepairReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Corrupt) == 0 && len(r.Configs) == 0 && len(r.Files) == 0
+}
+
+// Verify checks the installation at dir against the build that c refers to, in the same way as Repair, but downloads and repairs nothing.
+// The report it returns lists what Repair would download again.
+//
+// Verify only reads the installation, so it is safe to use on one which is read-only, or which another program is using.
+func Verify(ctx context.Context, c *client.Client, dir string, opts InstallOptions) (*RepairReport, error) {
+	dataDir := findDataDir(dir)
+	if dataDir == "" {
+		return nil, ErrNoDataDirectory
+	}
+	index, _, err := loadIndices(dataDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading indices")
+	}
+	s := &Storage{
+		Dir:     dir,
+		DataDir: dataDir,
+		index:   index,
+		files:   make(map[int]*os.File),
+	}
+	defer s.Close()
+
+	report := &RepairReport{}
+	for _, h := range buildConfigs(c) {
+		if !fileHasHash(configPath(dataDir, h), ngdp.ContentHash(h)) {
+			report.Configs = append(report.Configs, h)
+		}
+	}
+	for _, h := range buildFiles(c) {
+		if !s.Has(h) {
+			report.Missing = append(report.Missing, h)
+			continue
+		}
+		report.Checked++
+		r, err := s.FetchRaw(h)
+		if err == nil {
+			err = checkStored(r, h)
+		}
+		if err != nil {
+			glog.Warningf("%032x is corrupt: %v", h, err)
+			report.Corrupt = append(report.Corrupt, h)
+		}
+	}
+	if opts.Tags != nil {
+		if err := installLooseFiles(ctx, c, dir, opts, nil, report, true); err != nil {
+			return report, errors.Wrap(err, "checking files from install manifest")
+		}
+	}
+	return report, nil
+}
+
+// Repair checks every file the build that c refers to needs against the local storage in the installation at dir, and downloads again only those which are missing or corrupt.
+//
+// Like Install, it then places the files from the install manifest matching opts.Tags, if any, and marks the build as active.
+func Repair(ctx context.Context, c *client.Client, program ngdp.ProgramCode, dir string, opts InstallOptions) (*RepairReport, error) {
+	if findDataDir(dir) == "" {
+		return nil, ErrNoDataDirectory
+	}
+	report := &RepairReport{}
+	if err := installBuild(ctx, c, program, dir, opts, report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// buildConfigs returns the config files of the build c refers to.
+func buildConfigs(c *client.Client) []ngdp.CDNHash {
+	configs := []ngdp.CDNHash{c.VersionInfo.BuildConfig, c.VersionInfo.CDNConfig}
+	if !c.VersionInfo.KeyRing.Equal(ngdp.CDNHash{}) {
+		configs = append(configs, c.VersionInfo.KeyRing)
+	}
+	return configs
+}
+
+// buildFiles returns the files the build c refers to keeps in local storage: everything in the encoding table, plus the encoding table itself.
+func buildFiles(c *client.Client) []ngdp.CDNHash {
+	seen := make(map[ngdp.CDNHash]bool)
+	var hs []ngdp.CDNHash
+	for _, h := range append([]ngdp.CDNHash{c.BuildConfig.Encoding.CDNHash}, c.EncodingMapper.CDNHashes()...) {
+		if !seen[h] {
+			seen[h] = true
+			hs = append(hs, h)
+		}
+	}
+	return hs
+}
+
+// installBuild implements Install and Repair. If report is non-nil, files already in local storage are checked, and those which are broken are recorded in it and replaced.
+func installBuild(ctx context.Context, c *client.Client, program ngdp.ProgramCode, dir string, opts InstallOptions, report *RepairReport) error {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultInstallConcurrency
+	}
+
+	dataDir := findDataDir(dir)
+	if dataDir == "" {
+		dataDir = filepath.Join(dir, dataDirNames[0])
+	}
+	w, err := NewWriter(dataDir)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	glog.Infof("Installing build config %032x and CDN config %032x", c.VersionInfo.BuildConfig, c.VersionInfo.CDNConfig)
+	for _, h := range buildConfigs(c) {
+		if report != nil {
+			if fileHasHash(configPath(dataDir, h), ngdp.ContentHash(h)) {
+				continue
+			}
+			report.Configs = append(report.Configs, h)
+		}
+		if err := installConfig(ctx, c, w, h); err != nil {
+			return errors.Wrapf(err, "installing config %032x", h)
+		}
+	}
+
+	var todo []ngdp.CDNHash
+	for _, h := range buildFiles(c) {
+		if ok, err := w.Has(ctx, h); err != nil {
+			return err
+		} else if ok {
+			if report == nil {
+				continue
+			}
+			report.Checked++
+			r, err := w.Get(ctx, h)
+			if err == nil {
+				err = checkStored(r, h)
+			}
+			if err == nil {
+				continue
+			}
+			glog.Warningf("%032x is corrupt: %v", h, err)
+			report.Corrupt = append(report.Corrupt, h)
+			w.forget(h)
+		} else if report != nil {
+			report.Missing = append(report.Missing, h)
+		}
+		todo = append(todo, h)
+	}
+	glog.Infof("Installing %d files", len(todo))
+
+	// Download the files the game needs first in the order the download manifest gives, if there is one.
+	priorities := make(map[ngdp.CDNHash]int)
+	if m, err := download.Fetch(ctx, c, c.BuildConfig.Download); err != nil {
+		glog.Warningf("Reading download manifest: %v; downloading in no particular order", err)
+	} else {
+		for _, e := range m.Entries {
+			priorities[e.CDNHash] = int(e.Priority)
+		}
+	}
+
+	dl := downloader.New(downloader.Options{
+		Concurrency:    opts.Concurrency,
+		BytesPerSecond: opts.BytesPerSecond,
+		Progress:       opts.Progress,
+	})
+	for _, h := range todo {
+		h := h
+		priority, ok := priorities[h]
+		if !ok {
+			priority = math.MaxInt8 + 1
+		}
+		dl.Add(&downloader.Job{
+			Name:     fmt.Sprintf("installing %032x", h),
+			Priority: priority,
+			Open: func(ctx context.Context) (io.ReadCloser, error) {
+				resp, err := c.FetchRaw(ctx, h)
+				if err != nil && client.IsNotFound(err) {
+					// The encoding table lists some files which aren't actually on the CDN.
+					glog.Warningf("%032x is missing from the CDN; skipping", h)
+					return nil, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+				return resp.Body, nil
+			},
+			Save: func(ctx context.Context, r io.Reader) error {
+				// Writes to local storage are serialised, so download the whole file first.
+				b, err := ioutil.ReadAll(r)
+				if err != nil {
+					return err
+				}
+				return w.Put(ctx, h, bytes.NewReader(b))
+			},
+		})
+	}
+	if err := dl.Run(ctx); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if opts.Tags != nil {
+		// Repair must look at every file, whatever the journal says.
+		journal := opts.Journal
+		if report != nil {
+			journal = nil
+		}
+		if err := installLooseFiles(ctx, c, dir, opts, journal, report, false); err != nil {
+			return errors.Wrap(err, "installing files from install manifest")
+		}
+	}
+
+	bi, err := NewBuildInfo(c, program, opts.Tags)
+	if err != nil {
+		return err
+	}
+	return activateBuild(dir, bi)
+}
+
+// checkStored checks that r, the BLTE-encoded data of a file in local storage, matches h, and closes it.
+func checkStored(r io.ReadCloser, h ngdp.CDNHash) error {
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return blte.Verify(b, h)
+}
+
+func installConfig(ctx context.Context, c *client.Client, w *Writer, h ngdp.CDNHash) error {
+	r, err := c.LowLevelClient.FetchRaw(ctx, *c.CDNInfo, ngdp.ContentTypeConfig, h, "")
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return w.WriteConfig(h, r)
+}
+
+// installLooseFiles places the files from c's install manifest which match opts.Tags into dir, skipping any which are already up to date or which journal records as placed.
+// If report is non-nil, those which are not up to date are recorded in it, and if verifyOnly is set, nothing is placed.
+func installLooseFiles(ctx context.Context, c *client.Client, dir string, opts InstallOptions, journal *downloader.Journal, report *RepairReport, verifyOnly bool) error {
+	m, err := install.Fetch(ctx, c, c.BuildConfig.Install)
+	if err != nil {
+		return err
+	}
+	entries, err := m.Filter(opts.Tags...)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Installing %d files from install manifest", len(entries))
+	dl := downloader.New(downloader.Options{
+		Concurrency:    opts.Concurrency,
+		BytesPerSecond: opts.BytesPerSecond,
+		Progress:       opts.Progress,
+		Journal:        journal,
+	})
+	for _, e := range entries {
+		e := e
+		rel := filepath.FromSlash(strings.Replace(e.Name, "\\", "/", -1))
+		if filepath.IsAbs(rel) || strings.HasPrefix(filepath.Clean(rel), "..") {
+			return fmt.Errorf("casc: install manifest entry %q escapes the installation directory", e.Name)
+		}
+		fn := filepath.Join(dir, rel)
+		if report != nil {
+			if fileHasHash(fn, e.ContentHash) {
+				continue
+			}
+			report.Files = append(report.Files, e.Name)
+			if verifyOnly {
+				continue
+			}
+		}
+		dl.Add(&downloader.Job{
+			Name: fmt.Sprintf("placing %s %032x", e.Name, e.ContentHash),
+			Size: int64(e.Size),
+			Open: func(ctx context.Context) (io.ReadCloser, error) {
+				if fileHasHash(fn, e.ContentHash) {
+					return nil, nil
+				}
+				resp, err := c.Fetch(ctx, e.ContentHash)
+				if err != nil {
+					return nil, err
+				}
+				return resp.Body, nil
+			},
+			Save: func(ctx context.Context, r io.Reader) error {
+				return placeFile(fn, r, e.ContentHash)
+			},
+		})
+	}
+	return dl.Run(ctx)
+}
+
+func fileHasHash(fn string, h ngdp.ContentHash) bool {
+	f, err := os.Open(fn)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return false
+	}
+	var got ngdp.ContentHash
+	copy(got[:], hasher.Sum(nil))
+	return got.Equal(h)
+}
+
+// placeFile writes the contents of r to fn, replacing it only once the contents are known to match h.
+func placeFile(fn string, r io.Reader, h ngdp.ContentHash) error {
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(fn), ".install-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	hash := md5.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	var got ngdp.ContentHash
+	copy(got[:], hash.Sum(nil))
+	if !got.Equal(h) {
+		return fmt.Errorf("casc: %s has content hash %032x; want %032x", fn, got, h)
+	}
+	return os.Rename(f.Name(), fn)
+}
+
+// activateBuild adds bi to the .build.info file in dir, replacing any existing row for the same branch and product.
+func activateBuild(dir string, bi BuildInfo) error {
+	fn := filepath.Join(dir, BuildInfoFilename)
+
+	var infos []BuildInfo
+	if f, err := os.Open(fn); err == nil {
+		infos, err = ReadBuildInfo(f)
+		f.Close()
+		if err != nil {
+			return errors.Wrap(err, "parsing existing .build.info")
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	replaced := false
+	for n, old := range infos {
+		if old.Branch == bi.Branch && old.Product == bi.Product {
+			infos[n] = bi
+			replaced = true
+		}
+	}
+	if !replaced {
+		infos = append(infos, bi)
+	}
+
+	f, err := os.CreateTemp(dir, ".build.info-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := WriteBuildInfo(f, infos); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fn)
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casc
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/ngdptest"
+)
+
+func TestRepair(t *testing.T) {
+	s := ngdptest.NewServer()
+	defer s.Close()
+
+	var files [][]byte
+	for n := 0; n < 10; n++ {
+		files = append(files, []byte(fmt.Sprintf("file %d", n)))
+	}
+	s.AddBuild("test", "eu", files...)
+
+	ctx := context.Background()
+	c, err := client.NewWithLowLevelClient(ctx, s.LowLevelClient(), "test", "eu")
+	if err != nil {
+		t.Fatalf("NewWithLowLevelClient: %v", err)
+	}
+	// The test build has no install manifest, but .build.info must name one; no tags are given, so it is never read.
+	c.BuildConfig.Install = ngdp.ContentHash(md5.Sum(files[0]))
+
+	dir := t.TempDir()
+	if _, err := Repair(ctx, c, "test", dir, InstallOptions{}); err != ErrNoDataDirectory {
+		t.Errorf("Repair of an empty directory returned %v; want %v", err, ErrNoDataDirectory)
+	}
+	if _, err := Verify(ctx, c, dir, InstallOptions{}); err != ErrNoDataDirectory {
+		t.Errorf("Verify of an empty directory returned %v; want %v", err, ErrNoDataDirectory)
+	}
+	if err := Install(ctx, c, "test", dir, InstallOptions{}); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	if report, err := Verify(ctx, c, dir, InstallOptions{}); err != nil {
+		t.Fatalf("Verify: %v", err)
+	} else if !report.OK() {
+		t.Errorf("Verify of a healthy install = %+v; want OK", *report)
+	}
+	report, err := Repair(ctx, c, "test", dir, InstallOptions{})
+	if err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if want := (RepairReport{Checked: len(files) + 1}); !reflect.DeepEqual(*report, want) {
+		t.Errorf("Repair of a healthy install = %+v; want %+v", *report, want)
+	}
+
+	// Corrupt one file, and lose another from the index.
+	corrupt := ngdp.CDNHash(md5.Sum(ngdptest.EncodeBLTE(files[0])))
+	missing := ngdp.CDNHash(md5.Sum(ngdptest.EncodeBLTE(files[1])))
+	dataDir := filepath.Join(dir, dataDirNames[0])
+	w, err := NewWriter(dataDir)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	e := w.index[toIndexKey(corrupt)]
+	f, err := os.OpenFile(filepath.Join(dataDir, "data", fmt.Sprintf("data.%03d", e.archive)), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("X"), e.offset+int64(e.size)-1); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	w.forget(missing)
+	if err := w.Close(); err != nil {
+		t.Fatalf("w.Close: %v", err)
+	}
+
+	want := RepairReport{
+		Checked: len(files),
+		Missing: []ngdp.CDNHash{missing},
+		Corrupt: []ngdp.CDNHash{corrupt},
+	}
+	// Verify twice, to check that it doesn't fix anything.
+	for n := 0; n < 2; n++ {
+		report, err = Verify(ctx, c, dir, InstallOptions{})
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if !reflect.DeepEqual(*report, want) {
+			t.Errorf("Verify of a broken install = %+v; want %+v", *report, want)
+		}
+	}
+
+	report, err = Repair(ctx, c, "test", dir, InstallOptions{})
+	if err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if !reflect.DeepEqual(*report, want) {
+		t.Errorf("Repair of a broken install = %+v; want %+v", *report, want)
+	}
+
+	st, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer st.Close()
+	for _, b := range files[:2] {
+		resp, err := st.Fetch(ctx, ngdp.ContentHash(md5.Sum(b)))
+		if err != nil {
+			t.Fatalf("Fetch(%q): %v", b, err)
+		}
+		got, err := ioutil.ReadAll(resp.Body)
+		if err != nil || string(got) != string(b) {
+			t.Errorf("after Repair, Fetch returned %q, %v; want %q", got, err, b)
+		}
+	}
+}
+
+func TestPlaceFile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "Data", "file.txt")
+	good := []byte("good contents")
+	h := ngdp.ContentHash(md5.Sum(good))
+
+	if err := placeFile(fn, bytes.NewReader(good), h); err != nil {
+		t.Fatalf("placeFile: %v", err)
+	}
+	if !fileHasHash(fn, h) {
+		t.Errorf("%s does not have hash %032x after placeFile", fn, h)
+	}
+
+	// A download which doesn't match must not replace what's already there.
+	if err := placeFile(fn, bytes.NewReader([]byte("bad contents")), h); err == nil {
+		t.Errorf("placeFile with mismatched contents succeeded")
+	}
+	if !fileHasHash(fn, h) {
+		t.Errorf("placeFile with mismatched contents replaced %s", fn)
+	}
+	if entries, err := ioutil.ReadDir(filepath.Dir(fn)); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 1 {
+		t.Errorf("placeFile left %d files behind; want 1", len(entries))
+	}
+}
+
+// dirContents returns the contents and modification time of every file under dir, keyed by path.
+func dirContents(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	contents := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		contents[path] = fmt.Sprintf("%s %x", fi.ModTime(), md5.Sum(b))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func TestVerifyReadOnly(t *testing.T) {
+	s := ngdptest.NewServer()
+	defer s.Close()
+	s.AddBuild("test", "eu", []byte("one"), []byte("two"))
+
+	ctx := context.Background()
+	c, err := client.NewWithLowLevelClient(ctx, s.LowLevelClient(), "test", "eu")
+	if err != nil {
+		t.Fatalf("NewWithLowLevelClient: %v", err)
+	}
+	c.BuildConfig.Install = ngdp.ContentHash(md5.Sum([]byte("one")))
+
+	dir := t.TempDir()
+	if err := Install(ctx, c, "test", dir, InstallOptions{}); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	// Lose a config, so that Verify has something to report.
+	if err := os.Remove(configPath(filepath.Join(dir, dataDirNames[0]), c.VersionInfo.CDNConfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	before := dirContents(t, dir)
+	report, err := Verify(ctx, c, dir, InstallOptions{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if want := []ngdp.CDNHash{c.VersionInfo.CDNConfig}; !reflect.DeepEqual(report.Configs, want) {
+		t.Errorf("Verify reported broken configs %v; want %v", report.Configs, want)
+	}
+	if after := dirContents(t, dir); !reflect.DeepEqual(after, before) {
+		t.Errorf("Verify changed the installation: before %v; after %v", before, after)
+	}
+}
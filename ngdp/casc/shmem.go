@@ -0,0 +1,211 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ShmemFilename is the name of the shmem file, inside the data directory of local storage.
+	ShmemFilename = "shmem"
+
+	shmemHeaderType    = 4
+	shmemHeaderTypeV5  = 5
+	shmemFreeSpaceType = 1
+
+	shmemPathSize      = 0x100
+	shmemFreeSpanCount = 1090
+	shmemSpanFieldSize = 5
+)
+
+// A ShmemBlock is an entry in the shmem header's block table, which the official client uses for its own bookkeeping.
+type ShmemBlock struct {
+	Size   uint32
+	Offset uint32
+}
+
+// A FreeSpan is a region of a data.### file which is no longer used by any file.
+type FreeSpan struct {
+	Archive int
+	Offset  int64
+	Size    int64
+}
+
+// A Shmem is the file which Blizzard's agent uses to coordinate access to local storage.
+//
+// It records the current version of each bucket's .idx file, and which parts of the data files are free to be reused.
+type Shmem struct {
+	// HeaderType is the version of the file's header: 4, or 5 as written by newer clients, which is kept when the file is written back.
+	// Zero means 4.
+	HeaderType uint32
+
+	// DataPath is the path of the data directory, prefixed with "Global\".
+	DataPath string
+
+	Blocks    []ShmemBlock
+	Versions  [indexBucketCount]uint32
+	FreeSpace []FreeSpan
+}
+
+func readSpanField(b []byte) uint64 {
+	var v uint64
+	for _, x := range b[:shmemSpanFieldSize] {
+		v = v<<8 | uint64(x)
+	}
+	return v
+}
+
+func putSpanField(b []byte, v uint64) {
+	for n := shmemSpanFieldSize - 1; n >= 0; n-- {
+		b[n] = byte(v)
+		v >>= 8
+	}
+}
+
+// ReadShmem parses a shmem file.
+func ReadShmem(r io.Reader) (*Shmem, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 8+shmemPathSize {
+		return nil, fmt.Errorf("casc: shmem is too short")
+	}
+
+	typ := binary.LittleEndian.Uint32(b[0:4])
+	if typ != shmemHeaderType && typ != shmemHeaderTypeV5 {
+		return nil, fmt.Errorf("casc: unsupported shmem header type %d", typ)
+	}
+	next := int(binary.LittleEndian.Uint32(b[4:8]))
+	versionsAt := next - indexBucketCount*4
+	if versionsAt < 8+shmemPathSize || next > len(b) {
+		return nil, fmt.Errorf("casc: shmem header size %d is out of range", next)
+	}
+
+	s := &Shmem{
+		HeaderType: typ,
+		DataPath:   string(bytes.TrimRight(b[8:8+shmemPathSize], "\x00")),
+	}
+	for p := 8 + shmemPathSize; p+8 <= versionsAt; p += 8 {
+		s.Blocks = append(s.Blocks, ShmemBlock{
+			Size:   binary.LittleEndian.Uint32(b[p : p+4]),
+			Offset: binary.LittleEndian.Uint32(b[p+4 : p+8]),
+		})
+	}
+	for n := range s.Versions {
+		s.Versions[n] = binary.LittleEndian.Uint32(b[versionsAt+n*4:])
+	}
+
+	// The free space table follows the header.
+	fs := b[next:]
+	const fsLen = 8 + 0x18 + 2*shmemFreeSpanCount*shmemSpanFieldSize
+	if len(fs) < fsLen {
+		return nil, fmt.Errorf("casc: shmem free space table is truncated")
+	}
+	if typ := binary.LittleEndian.Uint32(fs[0:4]); typ != shmemFreeSpaceType {
+		return nil, fmt.Errorf("casc: unexpected shmem free space block type %d", typ)
+	}
+	count := int(binary.LittleEndian.Uint32(fs[4:8]))
+	if count > shmemFreeSpanCount {
+		return nil, fmt.Errorf("casc: shmem has %d free spans, more than the maximum of %d", count, shmemFreeSpanCount)
+	}
+	sizes := fs[8+0x18:]
+	offsets := sizes[shmemFreeSpanCount*shmemSpanFieldSize:]
+	for n := 0; n < count; n++ {
+		off := readSpanField(offsets[n*shmemSpanFieldSize:])
+		s.FreeSpace = append(s.FreeSpace, FreeSpan{
+			Archive: int(off >> indexOffsetBits),
+			Offset:  int64(off & (maxDataFileSize - 1)),
+			Size:    int64(readSpanField(sizes[n*shmemSpanFieldSize:])),
+		})
+	}
+	return s, nil
+}
+
+// WriteShmem writes a shmem file.
+func WriteShmem(w io.Writer, s *Shmem) error {
+	if len(s.DataPath) >= shmemPathSize {
+		return fmt.Errorf("casc: shmem data path %q is too long", s.DataPath)
+	}
+	if len(s.FreeSpace) > shmemFreeSpanCount {
+		return fmt.Errorf("casc: %d free spans is more than the maximum of %d", len(s.FreeSpace), shmemFreeSpanCount)
+	}
+
+	typ := s.HeaderType
+	if typ == 0 {
+		typ = shmemHeaderType
+	}
+	if typ != shmemHeaderType && typ != shmemHeaderTypeV5 {
+		return fmt.Errorf("casc: unsupported shmem header type %d", typ)
+	}
+
+	next := 8 + shmemPathSize + len(s.Blocks)*8 + indexBucketCount*4
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, typ)
+	binary.Write(&buf, binary.LittleEndian, uint32(next))
+	path := make([]byte, shmemPathSize)
+	copy(path, s.DataPath)
+	buf.Write(path)
+	for _, blk := range s.Blocks {
+		binary.Write(&buf, binary.LittleEndian, blk.Size)
+		binary.Write(&buf, binary.LittleEndian, blk.Offset)
+	}
+	binary.Write(&buf, binary.LittleEndian, s.Versions)
+
+	binary.Write(&buf, binary.LittleEndian, uint32(shmemFreeSpaceType))
+	binary.Write(&buf, binary.LittleEndian, uint32(len(s.FreeSpace)))
+	buf.Write(make([]byte, 0x18))
+	sizes := make([]byte, shmemFreeSpanCount*shmemSpanFieldSize)
+	offsets := make([]byte, shmemFreeSpanCount*shmemSpanFieldSize)
+	for n, span := range s.FreeSpace {
+		putSpanField(sizes[n*shmemSpanFieldSize:], uint64(span.Size))
+		putSpanField(offsets[n*shmemSpanFieldSize:], uint64(span.Archive)<<indexOffsetBits|uint64(span.Offset))
+	}
+	buf.Write(sizes)
+	buf.Write(offsets)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// shmemDataPath returns the data path the official client records for the local storage at dataDir.
+func shmemDataPath(dataDir string) string {
+	p, err := filepath.Abs(filepath.Join(dataDir, "data"))
+	if err != nil {
+		p = filepath.Join(dataDir, "data")
+	}
+	return `Global\` + strings.ToLower(p)
+}
+
+// loadShmem reads the shmem file in the local storage at dataDir, returning a new one if there isn't one yet.
+func loadShmem(dataDir string) (*Shmem, error) {
+	f, err := os.Open(filepath.Join(dataDir, "data", ShmemFilename))
+	if os.IsNotExist(err) {
+		return &Shmem{DataPath: shmemDataPath(dataDir)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadShmem(f)
+}
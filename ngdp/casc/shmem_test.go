@@ -0,0 +1,108 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casc
+
+import (
+	"bytes"
+	"crypto/md5"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/ngdptest"
+)
+
+func TestShmemRoundTrip(t *testing.T) {
+	for _, typ := range []uint32{shmemHeaderType, shmemHeaderTypeV5} {
+		want := &Shmem{
+			HeaderType: typ,
+			DataPath:   `Global\c:\games\heroes of the storm\heroesdata\data`,
+			Blocks:     []ShmemBlock{{Size: 0x1000, Offset: 0x2000}},
+			FreeSpace: []FreeSpan{
+				{Archive: 0, Offset: 0x100, Size: 0x20},
+				{Archive: 3, Offset: 0x3fffff00, Size: 0x12345},
+			},
+		}
+		for n := range want.Versions {
+			want.Versions[n] = uint32(n * 3)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteShmem(&buf, want); err != nil {
+			t.Fatalf("WriteShmem: %v", err)
+		}
+		got, err := ReadShmem(&buf)
+		if err != nil {
+			t.Fatalf("ReadShmem: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadShmem(WriteShmem(...)) = %#v; want %#v", got, want)
+		}
+	}
+}
+
+func TestWriterKeepsShmemType(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dataDir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(dataDir, "data", ShmemFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WriteShmem(f, &Shmem{HeaderType: shmemHeaderTypeV5, DataPath: shmemDataPath(dataDir)})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatalf("WriteShmem: %v", err)
+	}
+
+	w, err := NewWriter(dataDir)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	b := ngdptest.EncodeBLTE([]byte("hello"))
+	if err := w.Write(ngdp.CDNHash(md5.Sum(b)), bytes.NewReader(b)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s, err := loadShmem(dataDir)
+	if err != nil {
+		t.Fatalf("loadShmem: %v", err)
+	}
+	if s.HeaderType != shmemHeaderTypeV5 {
+		t.Errorf("after Flush, shmem header type is %d; want %d", s.HeaderType, shmemHeaderTypeV5)
+	}
+}
+
+func TestReadShmemBadType(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteShmem(&buf, &Shmem{}); err != nil {
+		t.Fatalf("WriteShmem: %v", err)
+	}
+	b := buf.Bytes()
+	b[0] = 3
+	if _, err := ReadShmem(bytes.NewReader(b)); err == nil {
+		t.Errorf("ReadShmem: nil error; want error")
+	}
+}
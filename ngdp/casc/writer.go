@@ -0,0 +1,342 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+)
+
+// A Writer adds files to local storage, creating it if necessary.
+//
+// Files are appended to the data.### files as they are written, but the .idx files which make them visible are only rewritten by Flush and Close.
+// It is safe for concurrent use.
+type Writer struct {
+	dataDir string
+
+	mu       sync.Mutex
+	index    map[indexKey]indexEntry
+	versions [indexBucketCount]uint32
+	dirty    [indexBucketCount]bool
+	shmem    *Shmem
+
+	f    *os.File
+	fn   int
+	size int64
+}
+
+// NewWriter opens the local storage at dataDir for writing, creating it if it doesn't exist.
+func NewWriter(dataDir string) (*Writer, error) {
+	for _, d := range []string{"data", "config"} {
+		if err := os.MkdirAll(filepath.Join(dataDir, d), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	index, versions, err := loadIndices(dataDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading indices")
+	}
+	shmem, err := loadShmem(dataDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading shmem")
+	}
+	w := &Writer{
+		dataDir:  dataDir,
+		index:    index,
+		versions: versions,
+		shmem:    shmem,
+	}
+
+	// Don't reuse a version number the official client might still know about.
+	for b, v := range shmem.Versions {
+		if v > w.versions[b] {
+			w.versions[b] = v
+		}
+	}
+
+	// Carry on appending to the last data file.
+	for _, e := range index {
+		if e.archive > w.fn {
+			w.fn = e.archive
+		}
+	}
+	if err := w.openDataFile(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// openDataFile opens the current data file for appending, moving on to the next one if it is full.
+func (w *Writer) openDataFile() error {
+	for {
+		f, err := os.OpenFile(filepath.Join(w.dataDir, "data", fmt.Sprintf("data.%03d", w.fn)), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		size, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if size < maxDataFileSize {
+			w.f, w.size = f, size
+			return nil
+		}
+		f.Close()
+		w.fn++
+	}
+}
+
+// Has returns true if the file with the given CDN hash has been stored.
+func (w *Writer) Has(ctx context.Context, h ngdp.CDNHash) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.index[toIndexKey(h)]
+	return ok, nil
+}
+
+// Get returns the BLTE-encoded data of a file which has been stored, even if it hasn't been flushed yet.
+func (w *Writer) Get(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error) {
+	w.mu.Lock()
+	e, ok := w.index[toIndexKey(h)]
+	w.mu.Unlock()
+	if !ok {
+		return nil, blobstore.ErrNotExist
+	}
+	if e.size < dataHeaderSize {
+		return nil, fmt.Errorf("casc: %032x has a size of %d, which is too small", h, e.size)
+	}
+
+	f, err := os.Open(filepath.Join(w.dataDir, "data", fmt.Sprintf("data.%03d", e.archive)))
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, e.offset+dataHeaderSize, int64(e.size)-dataHeaderSize), f}, nil
+}
+
+// forget drops a stored file from the index, so that it can be written again.
+// The space it took up in the data files is not reclaimed.
+func (w *Writer) forget(h ngdp.CDNHash) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	k := toIndexKey(h)
+	if _, ok := w.index[k]; ok {
+		delete(w.index, k)
+		w.dirty[k.bucket()] = true
+	}
+}
+
+// Put is like Write, for use as a blobstore.ContentStore.
+func (w *Writer) Put(ctx context.Context, h ngdp.CDNHash, r io.Reader) error {
+	return w.Write(h, r)
+}
+
+// WriteConfig stores a config file.
+func (w *Writer) WriteConfig(h ngdp.CDNHash, r io.Reader) error {
+	fn := configPath(w.dataDir, h)
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(fn), ".casc-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fn)
+}
+
+// Write stores the BLTE-encoded data of a file under its CDN hash.
+//
+// Writes are serialised, so r should be quick to read from; data coming from the network is best buffered first.
+func (w *Writer) Write(h ngdp.CDNHash, r io.Reader) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	k := toIndexKey(h)
+	if _, ok := w.index[k]; ok {
+		return nil
+	}
+
+	// Leave room for the header, which needs the size of the data.
+	offset := w.size
+	hdr := make([]byte, dataHeaderSize)
+	if _, err := w.f.Write(hdr); err != nil {
+		return w.abandon(offset, err)
+	}
+	n, err := io.Copy(w.f, r)
+	if err != nil {
+		return w.abandon(offset, err)
+	}
+	size := dataHeaderSize + n
+	if size > 0xffffffff {
+		return w.abandon(offset, fmt.Errorf("casc: %032x is too large to store", h))
+	}
+
+	// The header starts with the CDN hash, reversed. The checksums which follow the flags are left as zero.
+	for i := range h {
+		hdr[i] = h[len(h)-1-i]
+	}
+	binary.LittleEndian.PutUint32(hdr[16:20], uint32(size))
+	if _, err := w.f.WriteAt(hdr, offset); err != nil {
+		return w.abandon(offset, err)
+	}
+
+	w.index[k] = indexEntry{
+		archive: w.fn,
+		offset:  offset,
+		size:    uint32(size),
+	}
+	w.dirty[k.bucket()] = true
+	w.size += size
+
+	if w.size >= maxDataFileSize {
+		if err := w.f.Close(); err != nil {
+			return err
+		}
+		w.fn++
+		return w.openDataFile()
+	}
+	return nil
+}
+
+// abandon discards a partially written file, returning err.
+func (w *Writer) abandon(offset int64, err error) error {
+	if terr := w.f.Truncate(offset); terr != nil {
+		return errors.Wrapf(err, "truncating after failure: %v", terr)
+	}
+	if _, serr := w.f.Seek(offset, io.SeekStart); serr != nil {
+		return errors.Wrapf(err, "seeking after failure: %v", serr)
+	}
+	return err
+}
+
+// Flush writes new .idx files for every bucket which has changed, and updates the shmem file to point at them, making the files written so far visible to readers.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+
+	var buckets [indexBucketCount]map[indexKey]indexEntry
+	for k, e := range w.index {
+		b := k.bucket()
+		if !w.dirty[b] {
+			continue
+		}
+		if buckets[b] == nil {
+			buckets[b] = make(map[indexKey]indexEntry)
+		}
+		buckets[b][k] = e
+	}
+
+	for b, entries := range buckets {
+		if entries == nil {
+			continue
+		}
+		if err := w.writeIndex(uint8(b), entries); err != nil {
+			return errors.Wrapf(err, "writing index for bucket %02x", b)
+		}
+		w.dirty[b] = false
+	}
+
+	w.shmem.Versions = w.versions
+	return w.writeShmem()
+}
+
+// writeShmem replaces the shmem file, so that the official client sees the new indices.
+func (w *Writer) writeShmem() error {
+	fn := filepath.Join(w.dataDir, "data", ShmemFilename)
+	f, err := os.CreateTemp(filepath.Dir(fn), ".casc-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := WriteShmem(f, w.shmem); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fn)
+}
+
+func (w *Writer) writeIndex(bucket uint8, entries map[indexKey]indexEntry) error {
+	oldFn := indexFilename(w.dataDir, bucket, w.versions[bucket])
+	version := w.versions[bucket] + 1
+	fn := indexFilename(w.dataDir, bucket, version)
+
+	f, err := os.CreateTemp(filepath.Dir(fn), ".casc-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := writeIndex(f, bucket, entries); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), fn); err != nil {
+		return err
+	}
+	w.versions[bucket] = version
+
+	if err := os.Remove(oldFn); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+var _ blobstore.ContentStore = (*Writer)(nil)
+
+// Close flushes the indices and closes the current data file.
+func (w *Writer) Close() error {
+	if err := w.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
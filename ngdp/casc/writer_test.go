@@ -0,0 +1,109 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casc
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "casc")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[ngdp.CDNHash][]byte{
+		{0x01}:       []byte("hello"),
+		{0x02, 0x03}: []byte("world"),
+		{0xff}:       {},
+	}
+
+	w, err := NewWriter(dir)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	for h, b := range files {
+		if err := w.Write(h, bytes.NewReader(b)); err != nil {
+			t.Fatalf("w.Write(%032x): %v", h, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("w.Close: %v", err)
+	}
+
+	// Reopen, to check that the writer picks up where it left off.
+	w, err = NewWriter(dir)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	extra := ngdp.CDNHash{0x04}
+	files[extra] = []byte("again")
+	if ok, err := w.Has(ctx, ngdp.CDNHash{0x01}); !ok || err != nil {
+		t.Errorf("w.Has(01...) = %v, %v after reopening; want true, nil", ok, err)
+	}
+	if err := w.Put(ctx, extra, bytes.NewReader(files[extra])); err != nil {
+		t.Fatalf("w.Put(%032x): %v", extra, err)
+	}
+
+	// Files are readable through the writer before they're flushed.
+	r, err := w.Get(ctx, extra)
+	if err != nil {
+		t.Fatalf("w.Get(%032x): %v", extra, err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, files[extra]) {
+		t.Errorf("w.Get(%032x) = %q, %v; want %q, nil", extra, got, err, files[extra])
+	}
+	if _, err := w.Get(ctx, ngdp.CDNHash{0x05}); err != blobstore.ErrNotExist {
+		t.Errorf("w.Get(05...): %v; want %v", err, blobstore.ErrNotExist)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("w.Close: %v", err)
+	}
+
+	index, _, err := loadIndices(dir)
+	if err != nil {
+		t.Fatalf("loadIndices: %v", err)
+	}
+	s := &Storage{DataDir: dir, index: index, files: make(map[int]*os.File)}
+	defer s.Close()
+	for h, want := range files {
+		r, err := s.FetchRaw(h)
+		if err != nil {
+			t.Errorf("s.FetchRaw(%032x): %v", h, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("reading %032x: %v", h, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("s.FetchRaw(%032x) = %q; want %q", h, got, want)
+		}
+	}
+}
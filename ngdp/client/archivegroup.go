@@ -0,0 +1,175 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+const (
+	archiveIndexFooterSize   = 28
+	archiveIndexChecksumSize = 8
+
+	// Group index entries carry a 2-byte archive number as well as the 4-byte offset.
+	archiveGroupEntrySize   = 0x1a
+	archiveGroupOffsetBytes = 6
+)
+
+// writeIndexBlocks writes records, each of which starts with a CDN hash, using the block layout shared by archive and group indices.
+// It returns the hash of the index's footer, which is the name of the index.
+func writeIndexBlocks(w io.Writer, records [][]byte, entrySize int, offsetBytes byte) (ngdp.CDNHash, error) {
+	perBlock := archiveIndexChunkSize / entrySize
+
+	var lastKeys, blockHashes bytes.Buffer
+	block := make([]byte, archiveIndexChunkSize)
+	for start := 0; start < len(records); start += perBlock {
+		end := start + perBlock
+		if end > len(records) {
+			end = len(records)
+		}
+		for n := range block {
+			block[n] = 0
+		}
+		for n, rec := range records[start:end] {
+			copy(block[n*entrySize:], rec)
+		}
+		if _, err := w.Write(block); err != nil {
+			return ngdp.CDNHash{}, err
+		}
+		lastKeys.Write(records[end-1][:md5.Size])
+		sum := md5.Sum(block)
+		blockHashes.Write(sum[:archiveIndexChecksumSize])
+	}
+
+	toc := append(lastKeys.Bytes(), blockHashes.Bytes()...)
+	if _, err := w.Write(toc); err != nil {
+		return ngdp.CDNHash{}, err
+	}
+
+	tocHash := md5.Sum(toc)
+	footer := make([]byte, archiveIndexFooterSize)
+	copy(footer, tocHash[:archiveIndexChecksumSize])
+	copy(footer[8:], []byte{1, 0, 0, archiveIndexChunkSize >> 10, offsetBytes, 4, md5.Size, archiveIndexChecksumSize})
+	binary.LittleEndian.PutUint32(footer[16:20], uint32(len(records)))
+	footerHash := md5.Sum(footer[8:])
+	copy(footer[20:], footerHash[:archiveIndexChecksumSize])
+	if _, err := w.Write(footer); err != nil {
+		return ngdp.CDNHash{}, err
+	}
+	return ngdp.CDNHash(md5.Sum(footer)), nil
+}
+
+// WriteArchiveGroupIndex merges the indices of archives into a single archive group index, as referenced by a CDN config's ArchiveGroup, and writes it to w.
+//
+// It returns the name of the group index, which matches the CDN config if archives are given in the same order as the CDN config lists them.
+func WriteArchiveGroupIndex(ctx context.Context, w io.Writer, archives []ngdp.CDNHash, open ArchiveIndexOpener) (ngdp.CDNHash, error) {
+	if len(archives) > 1<<16 {
+		return ngdp.CDNHash{}, fmt.Errorf("client: too many archives for a group index: %d", len(archives))
+	}
+
+	type groupEntry struct {
+		ArchiveEntry
+		archive uint16
+	}
+	entries := make(map[ngdp.CDNHash]groupEntry)
+	for n, archiveHash := range archives {
+		r, err := open(ctx, archiveHash)
+		if err != nil {
+			return ngdp.CDNHash{}, errors.Wrapf(err, "opening index of archive %032x", archiveHash)
+		}
+		m, err := ReadArchiveIndex(r, archiveHash)
+		r.Close()
+		if err != nil {
+			return ngdp.CDNHash{}, errors.Wrapf(err, "reading index of archive %032x", archiveHash)
+		}
+		for h, e := range m {
+			// If a file appears in several archives, the first one wins.
+			if _, ok := entries[h]; !ok {
+				entries[h] = groupEntry{e, uint16(n)}
+			}
+		}
+	}
+
+	keys := make([]ngdp.CDNHash, 0, len(entries))
+	for h := range entries {
+		keys = append(keys, h)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Less(keys[j]) })
+
+	records := make([][]byte, len(keys))
+	for n, h := range keys {
+		e := entries[h]
+		rec := make([]byte, archiveGroupEntrySize)
+		copy(rec, h[:])
+		binary.BigEndian.PutUint32(rec[0x10:], e.Size)
+		binary.BigEndian.PutUint16(rec[0x14:], e.archive)
+		binary.BigEndian.PutUint32(rec[0x16:], e.Offset)
+		records[n] = rec
+	}
+	return writeIndexBlocks(w, records, archiveGroupEntrySize, archiveGroupOffsetBytes)
+}
+
+// ReadArchiveGroupIndex parses an archive group index, returning the location of every file it contains.
+//
+// archives must be the list of archives the group index was built from, in the same order.
+func ReadArchiveGroupIndex(r io.Reader, archives []ngdp.CDNHash) (map[ngdp.CDNHash]ArchiveEntry, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < archiveIndexFooterSize {
+		return nil, fmt.Errorf("client: group index is too short to contain a footer")
+	}
+	footer := b[len(b)-archiveIndexFooterSize:]
+	if footer[12] != archiveGroupOffsetBytes {
+		return nil, fmt.Errorf("client: index has %d-byte offsets; not a group index", footer[12])
+	}
+	count := int(binary.LittleEndian.Uint32(footer[16:20]))
+
+	perBlock := archiveIndexChunkSize / archiveGroupEntrySize
+	m := make(map[ngdp.CDNHash]ArchiveEntry, count)
+	for n := 0; n < count; n++ {
+		off := (n/perBlock)*archiveIndexChunkSize + (n%perBlock)*archiveGroupEntrySize
+		if off+archiveGroupEntrySize > len(b)-archiveIndexFooterSize {
+			return nil, fmt.Errorf("client: group index is truncated")
+		}
+		rec := b[off : off+archiveGroupEntrySize]
+		archive := int(binary.BigEndian.Uint16(rec[0x14:]))
+		if archive >= len(archives) {
+			return nil, fmt.Errorf("client: group index refers to archive %d, but only %d were given", archive, len(archives))
+		}
+		var h ngdp.CDNHash
+		copy(h[:], rec)
+		m[h] = ArchiveEntry{
+			Archive: archives[archive],
+			Size:    binary.BigEndian.Uint32(rec[0x10:]),
+			Offset:  binary.BigEndian.Uint32(rec[0x16:]),
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+// makeArchiveIndex builds the index of a single archive containing entries.
+func makeArchiveIndex(t *testing.T, entries map[ngdp.CDNHash]ArchiveEntry) ([]byte, ngdp.CDNHash) {
+	var buf bytes.Buffer
+	name, err := WriteArchiveIndex(&buf, entries)
+	if err != nil {
+		t.Fatalf("WriteArchiveIndex: %v", err)
+	}
+	return buf.Bytes(), name
+}
+
+func TestWriteArchiveGroupIndex(t *testing.T) {
+	archives := []ngdp.CDNHash{{0xa1}, {0xa2}}
+	want := make(map[ngdp.CDNHash]ArchiveEntry)
+	indices := make(map[ngdp.CDNHash][]byte)
+	for n, a := range archives {
+		entries := make(map[ngdp.CDNHash]ArchiveEntry)
+		// Enough entries to need several blocks.
+		for i := 0; i < 200; i++ {
+			h := ngdp.CDNHash(md5.Sum([]byte{byte(n), byte(i)}))
+			e := ArchiveEntry{a, uint32(i + 1), uint32(i * 1000)}
+			entries[h] = e
+			want[h] = e
+		}
+		b, name := makeArchiveIndex(t, entries)
+		footer := b[len(b)-archiveIndexFooterSize:]
+		if got := ngdp.CDNHash(md5.Sum(footer)); !got.Equal(name) {
+			t.Fatalf("index name = %032x; want MD5 of footer %032x", name, got)
+		}
+		got, err := ReadArchiveIndex(bytes.NewReader(b), a)
+		if err != nil {
+			t.Fatalf("ReadArchiveIndex: %v", err)
+		}
+		if !reflect.DeepEqual(got, entries) {
+			t.Fatalf("ReadArchiveIndex returned %d entries; want %d", len(got), len(entries))
+		}
+		indices[a] = b
+	}
+
+	open := func(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(indices[h])), nil
+	}
+	var buf bytes.Buffer
+	name, err := WriteArchiveGroupIndex(context.Background(), &buf, archives, open)
+	if err != nil {
+		t.Fatalf("WriteArchiveGroupIndex: %v", err)
+	}
+	b := buf.Bytes()
+	if got := ngdp.CDNHash(md5.Sum(b[len(b)-archiveIndexFooterSize:])); !got.Equal(name) {
+		t.Errorf("group index name = %032x; want MD5 of footer %032x", name, got)
+	}
+
+	got, err := ReadArchiveGroupIndex(bytes.NewReader(b), archives)
+	if err != nil {
+		t.Fatalf("ReadArchiveGroupIndex: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadArchiveGroupIndex returned %d entries; want %d", len(got), len(want))
+	}
+
+	m, err := NewArchiveMapperFromIndices(context.Background(), archives, open)
+	if err != nil {
+		t.Fatalf("NewArchiveMapperFromIndices: %v", err)
+	}
+	entries := make(map[ngdp.CDNHash]ArchiveEntry)
+	var last ngdp.CDNHash
+	for h, e := range m.Entries() {
+		if len(entries) > 0 && !last.Less(h) {
+			t.Errorf("Entries yielded %032x after %032x; want ascending order", h, last)
+		}
+		entries[h] = e
+		last = h
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("Entries yielded %d entries; want %d", len(entries), len(want))
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"crypto/md5"
 	"encoding/binary"
 	"io"
+	"iter"
 	"sort"
 
 	"golang.org/x/sync/errgroup"
@@ -81,19 +82,24 @@ func (e *ArchiveMapper) Map(in ngdp.CDNHash) (entry ArchiveEntry, ok bool) {
 	return ArchiveEntry{}, false
 }
 
-func buildArchiveMap(ctx context.Context, llc *LowLevelClient, cdnInfo ngdp.CDNInfo, archiveHash ngdp.CDNHash) (map[ngdp.CDNHash]archiveIndexEntry, error) {
-	// Retrieve the archive index.
-	resp, err := llc.get(ctx, cdnInfo, ngdp.ContentTypeData, archiveHash, ".index")
-	if err != nil {
-		return nil, err
+// Entries yields the CDN hash and location of every file in the archive set, in order of CDN hash.
+func (e *ArchiveMapper) Entries() iter.Seq2[ngdp.CDNHash, ArchiveEntry] {
+	return func(yield func(ngdp.CDNHash, ArchiveEntry) bool) {
+		for _, ent := range e.m {
+			if !yield(*ent.file, ent.asArchiveEntry()) {
+				return
+			}
+		}
 	}
-	defer resp.Body.Close()
+}
 
+// ReadArchiveIndex parses the index of a single archive, returning the location of every file it contains.
+func ReadArchiveIndex(r io.Reader, archiveHash ngdp.CDNHash) (map[ngdp.CDNHash]ArchiveEntry, error) {
 	chunk := make([]byte, archiveIndexChunkSize)
-	m := make(map[ngdp.CDNHash]archiveIndexEntry)
+	m := make(map[ngdp.CDNHash]ArchiveEntry)
 	for {
 		// Read each chunk, one at a time.
-		if _, err := io.ReadFull(resp.Body, chunk); err != nil {
+		if _, err := io.ReadFull(r, chunk); err != nil {
 			if err == io.ErrUnexpectedEOF || err == io.EOF {
 				// We've reached the end of this archive.
 				break
@@ -126,19 +132,63 @@ func buildArchiveMap(ctx context.Context, llc *LowLevelClient, cdnInfo ngdp.CDNI
 			size := binary.BigEndian.Uint32(entry[0x10:0x14])
 			offset := binary.BigEndian.Uint32(entry[0x14:0x18])
 
-			m[cdnHash] = archiveIndexEntry{
-				file:    &cdnHash,
-				archive: &archiveHash,
-				size:    size,
-				offset:  offset,
+			m[cdnHash] = ArchiveEntry{
+				Archive: archiveHash,
+				Size:    size,
+				Offset:  offset,
 			}
 		}
 	}
 	return m, nil
 }
 
-// NewArchiveMapper creates a new archive mapper from the provided set of archives.
+// An indexReader parses a single archive index.
+type indexReader func(r io.Reader, archiveHash ngdp.CDNHash) (map[ngdp.CDNHash]ArchiveEntry, error)
+
+func buildArchiveMap(ctx context.Context, open ArchiveIndexOpener, read indexReader, archiveHash ngdp.CDNHash) ([]archiveIndexEntry, error) {
+	// Retrieve the archive index.
+	r, err := open(ctx, archiveHash)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	m, err := read(r, archiveHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// Share a single copy of the archive hash between every entry.
+	archive := &archiveHash
+	slc := make([]archiveIndexEntry, 0, len(m))
+	for cdnHash, e := range m {
+		cdnHash := cdnHash
+		slc = append(slc, archiveIndexEntry{
+			file:    &cdnHash,
+			archive: archive,
+			size:    e.Size,
+			offset:  e.Offset,
+		})
+	}
+	return slc, nil
+}
+
+// An ArchiveIndexOpener opens the index of a single archive.
+type ArchiveIndexOpener func(ctx context.Context, archiveHash ngdp.CDNHash) (io.ReadCloser, error)
+
+// NewArchiveMapper creates a new archive mapper from the provided set of archives, fetching their indices from the CDN.
 func (llc *LowLevelClient) NewArchiveMapper(ctx context.Context, cdnInfo ngdp.CDNInfo, archives []ngdp.CDNHash) (*ArchiveMapper, error) {
+	return NewArchiveMapperFromIndices(ctx, archives, func(ctx context.Context, archiveHash ngdp.CDNHash) (io.ReadCloser, error) {
+		return llc.FetchRaw(ctx, cdnInfo, ngdp.ContentTypeData, archiveHash, ".index")
+	})
+}
+
+// NewArchiveMapperFromIndices creates a new archive mapper from the provided set of archives, using open to retrieve their indices.
+func NewArchiveMapperFromIndices(ctx context.Context, archives []ngdp.CDNHash, open ArchiveIndexOpener) (*ArchiveMapper, error) {
+	return newArchiveMapper(ctx, archives, open, ReadArchiveIndex)
+}
+
+func newArchiveMapper(ctx context.Context, archives []ngdp.CDNHash, open ArchiveIndexOpener, read indexReader) (*ArchiveMapper, error) {
 	// Calculate required worker count.
 	workerCount := archiveConcurrentIndexFetches
 	if workerCount > len(archives) {
@@ -146,7 +196,7 @@ func (llc *LowLevelClient) NewArchiveMapper(ctx context.Context, cdnInfo ngdp.CD
 	}
 
 	workChan := make(chan ngdp.CDNHash)
-	resultChan := make(chan map[ngdp.CDNHash]archiveIndexEntry)
+	resultChan := make(chan []archiveIndexEntry)
 	g, ctx := errgroup.WithContext(ctx)
 
 	// Enqueue work into workChan.
@@ -166,7 +216,7 @@ func (llc *LowLevelClient) NewArchiveMapper(ctx context.Context, cdnInfo ngdp.CD
 	for n := 0; n < workerCount; n++ {
 		g.Go(func() error {
 			for archiveHash := range workChan {
-				m, err := buildArchiveMap(ctx, llc, cdnInfo, archiveHash)
+				m, err := buildArchiveMap(ctx, open, read, archiveHash)
 				if err != nil {
 					return err
 				}
@@ -190,13 +240,9 @@ func (llc *LowLevelClient) NewArchiveMapper(ctx context.Context, cdnInfo ngdp.CD
 	// Process results.
 	var slcs [][]archiveIndexEntry
 	count := 0
-	for miniMap := range resultChan {
-		slc := make([]archiveIndexEntry, 0, len(miniMap))
-		for _, v := range miniMap {
-			slc = append(slc, v)
-		}
+	for slc := range resultChan {
 		slcs = append(slcs, slc)
-		count += len(miniMap)
+		count += len(slc)
 	}
 
 	// Produce final.
@@ -0,0 +1,54 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+func BenchmarkReadArchiveIndex(b *testing.B) {
+	archive := ngdp.CDNHash{0xa1}
+	for _, n := range []int{1000, 10000, 100000} {
+		entries := make(map[ngdp.CDNHash]ArchiveEntry, n)
+		for i := 0; i < n; i++ {
+			var k [8]byte
+			binary.BigEndian.PutUint64(k[:], uint64(i))
+			entries[ngdp.CDNHash(md5.Sum(k[:]))] = ArchiveEntry{archive, uint32(i + 1), uint32(i * 1000)}
+		}
+		var buf bytes.Buffer
+		if _, err := WriteArchiveIndex(&buf, entries); err != nil {
+			b.Fatalf("WriteArchiveIndex: %v", err)
+		}
+		index := buf.Bytes()
+
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			b.SetBytes(int64(len(index)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := ReadArchiveIndex(bytes.NewReader(index), archive); err != nil {
+					b.Fatalf("ReadArchiveIndex: %v", err)
+				}
+			}
+		})
+	}
+}
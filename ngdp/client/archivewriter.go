@@ -0,0 +1,96 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+const archiveIndexEntrySize = 0x18
+
+// WriteArchiveIndex writes an archive index listing entries to w.
+//
+// It returns the name of the index, which is also the name of the archive it describes.
+func WriteArchiveIndex(w io.Writer, entries map[ngdp.CDNHash]ArchiveEntry) (ngdp.CDNHash, error) {
+	keys := make([]ngdp.CDNHash, 0, len(entries))
+	for h := range entries {
+		keys = append(keys, h)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Less(keys[j]) })
+
+	records := make([][]byte, len(keys))
+	for n, h := range keys {
+		rec := make([]byte, archiveIndexEntrySize)
+		copy(rec, h[:])
+		binary.BigEndian.PutUint32(rec[0x10:], entries[h].Size)
+		binary.BigEndian.PutUint32(rec[0x14:], entries[h].Offset)
+		records[n] = rec
+	}
+	return writeIndexBlocks(w, records, archiveIndexEntrySize, 4)
+}
+
+// An ArchiveWriter builds a new archive out of BLTE-encoded files.
+//
+// Files are written to the archive as they are added; once they have all been added, WriteIndex writes the archive's index.
+type ArchiveWriter struct {
+	w       io.Writer
+	offset  int64
+	entries map[ngdp.CDNHash]ArchiveEntry
+}
+
+// NewArchiveWriter creates an ArchiveWriter which writes the archive's contents to w.
+func NewArchiveWriter(w io.Writer) *ArchiveWriter {
+	return &ArchiveWriter{
+		w:       w,
+		entries: make(map[ngdp.CDNHash]ArchiveEntry),
+	}
+}
+
+// Add appends the BLTE-encoded file b, whose CDN hash is h, to the archive. Files which have already been added are skipped.
+func (a *ArchiveWriter) Add(h ngdp.CDNHash, b []byte) error {
+	if _, ok := a.entries[h]; ok {
+		return nil
+	}
+	if a.offset+int64(len(b)) > math.MaxUint32 {
+		return fmt.Errorf("client: archive would exceed %d bytes", int64(math.MaxUint32))
+	}
+	if _, err := a.w.Write(b); err != nil {
+		return err
+	}
+	a.entries[h] = ArchiveEntry{
+		Size:   uint32(len(b)),
+		Offset: uint32(a.offset),
+	}
+	a.offset += int64(len(b))
+	return nil
+}
+
+// Size returns the number of bytes written to the archive so far.
+func (a *ArchiveWriter) Size() int64 {
+	return a.offset
+}
+
+// WriteIndex writes the index of the archive to w, returning the archive's name.
+func (a *ArchiveWriter) WriteIndex(w io.Writer) (ngdp.CDNHash, error) {
+	return WriteArchiveIndex(w, a.entries)
+}
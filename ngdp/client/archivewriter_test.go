@@ -0,0 +1,79 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+func TestArchiveWriter(t *testing.T) {
+	var archive bytes.Buffer
+	aw := NewArchiveWriter(&archive)
+
+	files := make(map[ngdp.CDNHash][]byte)
+	for n := 0; n < 300; n++ {
+		b := []byte(fmt.Sprintf("BLTE file %d", n))
+		h := ngdp.CDNHash(md5.Sum(b))
+		files[h] = b
+		if err := aw.Add(h, b); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	// Adding a file twice stores it once.
+	for h, b := range files {
+		if err := aw.Add(h, b); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		break
+	}
+	if aw.Size() != int64(archive.Len()) {
+		t.Errorf("Size = %d; want %d", aw.Size(), archive.Len())
+	}
+
+	var idx bytes.Buffer
+	name, err := aw.WriteIndex(&idx)
+	if err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	b := idx.Bytes()
+	if got := ngdp.CDNHash(md5.Sum(b[len(b)-archiveIndexFooterSize:])); !got.Equal(name) {
+		t.Errorf("archive name = %032x; want MD5 of index footer %032x", name, got)
+	}
+
+	entries, err := ReadArchiveIndex(bytes.NewReader(b), name)
+	if err != nil {
+		t.Fatalf("ReadArchiveIndex: %v", err)
+	}
+	if len(entries) != len(files) {
+		t.Errorf("index has %d entries; want %d", len(entries), len(files))
+	}
+	for h, want := range files {
+		e, ok := entries[h]
+		if !ok {
+			t.Errorf("index is missing %032x", h)
+			continue
+		}
+		if got := archive.Bytes()[e.Offset : e.Offset+e.Size]; !bytes.Equal(got, want) {
+			t.Errorf("archive contains %q for %032x; want %q", got, h, want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/configtable"
+	"github.com/lukegb/snowstorm/ngdp/productconfig"
+)
+
+// getPatch retrieves a file from the patch server, checking that it was found.
+func (c *LowLevelClient) getPatch(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region, suffix string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, patchURL(program, region, suffix), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(ctx, req, attribute.String("ngdp.program", string(program)))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errBadStatus{resp.StatusCode, resp.Status, http.StatusOK}
+	}
+	return resp.Body, nil
+}
+
+// PatchFile retrieves a file from the patch server by name, such as "versions", "cdns" or "bgdl", without parsing it.
+//
+// The region is only used to pick which patch server to ask.
+func (c *LowLevelClient) PatchFile(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region, name string) (io.ReadCloser, error) {
+	return c.getPatch(ctx, program, region, name)
+}
+
+// Blobs retrieves the hashes of the game and install blobs for a program, for every region.
+//
+// The region is only used to pick which patch server to ask.
+func (c *LowLevelClient) Blobs(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) ([]ngdp.BlobInfo, error) {
+	body, err := c.getPatch(ctx, program, region, suffixBlobs)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var blobs []ngdp.BlobInfo
+	if err := configtable.DecodeAll(body, &blobs); err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+func (c *LowLevelClient) blob(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region, suffix string) (*productconfig.Config, error) {
+	body, err := c.getPatch(ctx, program, region, suffix)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return productconfig.Parse(body)
+}
+
+// GameBlob retrieves the game blob for a program, which describes how the launcher presents and starts it.
+//
+// Game blobs predate product configs, but share their layout.
+func (c *LowLevelClient) GameBlob(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) (*productconfig.Config, error) {
+	return c.blob(ctx, program, region, suffixGameBlob)
+}
+
+// InstallBlob retrieves the install blob for a program, which describes how the launcher installs it.
+//
+// Install blobs share the layout of product configs.
+func (c *LowLevelClient) InstallBlob(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) (*productconfig.Config, error) {
+	return c.blob(ctx, program, region, suffixInstBlob)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/ngdptest"
+)
+
+func TestBlobs(t *testing.T) {
+	s := ngdptest.NewServer()
+	defer s.Close()
+	s.Put("hero/blobs", []byte("Region!STRING:0|InstallBlobMD5!HEX:16|GameBlobMD5!HEX:16\n"+
+		"eu|0123456789abcdef0123456789abcdef|fedcba9876543210fedcba9876543210\n"))
+	s.Put("hero/blob/game", []byte(`{"all": {"config": {"product": "Hero", "data_dir": "HeroesData/"}}}`))
+	llc := s.LowLevelClient()
+	ctx := context.Background()
+
+	blobs, err := llc.Blobs(ctx, "hero", "us")
+	if err != nil {
+		t.Fatalf("Blobs: %v", err)
+	}
+	want := []ngdp.BlobInfo{{
+		Region:         "eu",
+		InstallBlobMD5: ngdp.ContentHash{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef},
+		GameBlobMD5:    ngdp.ContentHash{0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10, 0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10},
+	}}
+	if !reflect.DeepEqual(blobs, want) {
+		t.Errorf("Blobs = %#v; want %#v", blobs, want)
+	}
+
+	game, err := llc.GameBlob(ctx, "hero", "us")
+	if err != nil {
+		t.Fatalf("GameBlob: %v", err)
+	}
+	settings, err := game.Settings("", "")
+	if err != nil {
+		t.Fatalf("Settings: %v", err)
+	}
+	if settings.Product != "Hero" || settings.DataDir != "HeroesData/" {
+		t.Errorf("GameBlob settings = %+v; want product Hero with data dir HeroesData/", settings)
+	}
+
+	if _, err := llc.InstallBlob(ctx, "hero", "us"); !client.IsNotFound(err) {
+		t.Errorf("InstallBlob of missing blob = %v; want a not found error", err)
+	}
+}
@@ -0,0 +1,397 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A CaptureFormat is a file format for captured requests.
+type CaptureFormat int
+
+const (
+	// CaptureHAR is the HTTP Archive format understood by browsers' developer tools. Entries are held in memory until the Capture is closed.
+	CaptureHAR CaptureFormat = iota
+
+	// CaptureJSONL writes one HAR entry per line as soon as each request finishes, so it suits long-running programs.
+	CaptureJSONL
+)
+
+// CaptureFormatOf guesses the format of a capture file from its extension: .har files are HAR, and everything else is JSONL.
+func CaptureFormatOf(fn string) CaptureFormat {
+	if strings.EqualFold(filepath.Ext(fn), ".har") {
+		return CaptureHAR
+	}
+	return CaptureJSONL
+}
+
+// A CaptureHeader is a single HTTP header.
+type CaptureHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// A CaptureRequest describes a request in a CaptureEntry.
+type CaptureRequest struct {
+	Method      string          `json:"method"`
+	URL         string          `json:"url"`
+	HTTPVersion string          `json:"httpVersion"`
+	Headers     []CaptureHeader `json:"headers"`
+	QueryString []CaptureHeader `json:"queryString"`
+	Cookies     []CaptureHeader `json:"cookies"`
+	HeadersSize int             `json:"headersSize"`
+	BodySize    int             `json:"bodySize"`
+}
+
+// A CaptureContent describes the body of a response in a CaptureEntry. The body itself isn't recorded.
+type CaptureContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+// A CaptureResponse describes a response in a CaptureEntry.
+type CaptureResponse struct {
+	Status      int             `json:"status"`
+	StatusText  string          `json:"statusText"`
+	HTTPVersion string          `json:"httpVersion"`
+	Headers     []CaptureHeader `json:"headers"`
+	Cookies     []CaptureHeader `json:"cookies"`
+	Content     CaptureContent  `json:"content"`
+	RedirectURL string          `json:"redirectURL"`
+	HeadersSize int             `json:"headersSize"`
+	BodySize    int64           `json:"bodySize"`
+}
+
+// CaptureTimings break down how long a request took, in milliseconds.
+type CaptureTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// A CaptureEntry is the metadata of a single request and its response, laid out as a HAR entry.
+type CaptureEntry struct {
+	StartedDateTime time.Time       `json:"startedDateTime"`
+	Time            float64         `json:"time"`
+	Request         CaptureRequest  `json:"request"`
+	Response        CaptureResponse `json:"response"`
+	Cache           struct{}        `json:"cache"`
+	Timings         CaptureTimings  `json:"timings"`
+
+	// Error is set if the request failed, or its body couldn't be read in full. A request which failed outright has a Response.Status of zero.
+	Error string `json:"_error,omitempty"`
+}
+
+type harFile struct {
+	Log *harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string `json:"version"`
+	Creator struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"creator"`
+	Entries []CaptureEntry `json:"entries"`
+}
+
+// A Capture records the metadata of every request made by a LowLevelClient, so that problems with patch servers and CDNs can be reported and reproduced.
+// It is safe for concurrent use.
+type Capture struct {
+	format CaptureFormat
+	w      io.Writer
+	closer io.Closer
+
+	mu      sync.Mutex
+	entries []CaptureEntry
+	err     error
+}
+
+// NewCapture creates a Capture which writes to w in the given format.
+func NewCapture(w io.Writer, format CaptureFormat) *Capture {
+	return &Capture{format: format, w: w}
+}
+
+// CreateCapture creates a Capture which writes to the file fn, in the format given by its extension. Closing the Capture closes the file.
+func CreateCapture(fn string) (*Capture, error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return nil, err
+	}
+	c := NewCapture(f, CaptureFormatOf(fn))
+	c.closer = f
+	return c, nil
+}
+
+func captureHeaders(h http.Header) []CaptureHeader {
+	hs := []CaptureHeader{}
+	for name, values := range h {
+		for _, v := range values {
+			hs = append(hs, CaptureHeader{name, v})
+		}
+	}
+	return hs
+}
+
+func captureQuery(req *http.Request) []CaptureHeader {
+	qs := []CaptureHeader{}
+	for name, values := range req.URL.Query() {
+		for _, v := range values {
+			qs = append(qs, CaptureHeader{name, v})
+		}
+	}
+	return qs
+}
+
+// record adds an entry, reporting any error writing it when the Capture is closed.
+func (c *Capture) record(e CaptureEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.format == CaptureHAR {
+		c.entries = append(c.entries, e)
+		return
+	}
+	if c.err != nil {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		c.err = err
+		return
+	}
+	if _, err := c.w.Write(append(b, '\n')); err != nil {
+		c.err = err
+	}
+}
+
+// begin starts an entry for a request.
+func (c *Capture) begin(req *http.Request, start time.Time) CaptureEntry {
+	return CaptureEntry{
+		StartedDateTime: start,
+		Request: CaptureRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: "HTTP/1.1",
+			Headers:     captureHeaders(req.Header),
+			QueryString: captureQuery(req),
+			Cookies:     []CaptureHeader{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Response: CaptureResponse{
+			Headers:     []CaptureHeader{},
+			Cookies:     []CaptureHeader{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+	}
+}
+
+// failed records a request which got no response.
+func (c *Capture) failed(e CaptureEntry, err error) {
+	e.Time = msSince(e.StartedDateTime)
+	e.Timings.Wait = e.Time
+	e.Error = err.Error()
+	c.record(e)
+}
+
+// responded starts recording a response, returning a body which completes the entry when it is closed.
+func (c *Capture) responded(e CaptureEntry, resp *http.Response) io.ReadCloser {
+	e.Timings.Wait = msSince(e.StartedDateTime)
+	e.Response.Status = resp.StatusCode
+	e.Response.StatusText = http.StatusText(resp.StatusCode)
+	e.Response.HTTPVersion = resp.Proto
+	e.Response.Headers = captureHeaders(resp.Header)
+	e.Response.Content.MimeType = resp.Header.Get("Content-Type")
+	return &capturedBody{ReadCloser: resp.Body, c: c, e: e}
+}
+
+// Close finishes the capture, writing it out if it is a HAR, and closes the underlying file if CreateCapture opened it.
+func (c *Capture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.err
+	if c.format == CaptureHAR && err == nil {
+		har := harFile{Log: &harLog{Version: "1.2", Entries: c.entries}}
+		har.Log.Creator.Name = "snowstorm"
+		har.Log.Creator.Version = "1"
+		if har.Log.Entries == nil {
+			har.Log.Entries = []CaptureEntry{}
+		}
+		enc := json.NewEncoder(c.w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(har)
+	}
+	if c.closer != nil {
+		if cerr := c.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func msSince(t time.Time) float64 {
+	return float64(time.Since(t)) / float64(time.Millisecond)
+}
+
+// capturedBody completes a CaptureEntry when the response body is closed.
+type capturedBody struct {
+	io.ReadCloser
+
+	c      *Capture
+	e      CaptureEntry
+	n      int64
+	err    error
+	closed bool
+}
+
+func (b *capturedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *capturedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.closed {
+		return err
+	}
+	b.closed = true
+
+	b.e.Time = msSince(b.e.StartedDateTime)
+	b.e.Timings.Receive = b.e.Time - b.e.Timings.Wait
+	b.e.Response.Content.Size = b.n
+	b.e.Response.BodySize = b.n
+	if b.err != nil {
+		b.e.Error = b.err.Error()
+	}
+	b.c.record(b.e)
+	return err
+}
+
+// ReadCapture reads the entries of a capture in either format.
+func ReadCapture(r io.Reader) ([]CaptureEntry, error) {
+	d := json.NewDecoder(r)
+	var entries []CaptureEntry
+	for {
+		var raw json.RawMessage
+		if err := d.Decode(&raw); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "client: reading capture")
+		}
+
+		var har harFile
+		if err := json.Unmarshal(raw, &har); err == nil && har.Log != nil {
+			entries = append(entries, har.Log.Entries...)
+			continue
+		}
+		var e CaptureEntry
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return nil, errors.Wrap(err, "client: reading capture")
+		}
+		entries = append(entries, e)
+	}
+}
+
+// A Replay is an http.RoundTripper which answers requests as they were answered in a capture, so that a reported problem can be reproduced in a test.
+//
+// Each request is matched with the first unused entry for the same method and URL path, so retries see the responses that followed in the capture.
+// Failed requests and unsuccessful responses are reproduced as they were recorded. Since bodies aren't captured, successful responses are passed to Fallback, such as an ngdptest server holding the files, or given an empty body if it is nil.
+// Requests which don't match any entry are passed to Fallback, or fail if it is nil.
+type Replay struct {
+	Fallback http.RoundTripper
+
+	mu      sync.Mutex
+	entries []CaptureEntry
+	used    []bool
+}
+
+// NewReplay creates a Replay of entries.
+func NewReplay(entries []CaptureEntry, fallback http.RoundTripper) *Replay {
+	return &Replay{
+		Fallback: fallback,
+		entries:  entries,
+		used:     make([]bool, len(entries)),
+	}
+}
+
+// match finds and uses up the entry for req.
+func (r *Replay) match(req *http.Request) (CaptureEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for n, e := range r.entries {
+		if r.used[n] || e.Request.Method != req.Method {
+			continue
+		}
+		u, err := req.URL.Parse(e.Request.URL)
+		if err != nil || u.RequestURI() != req.URL.RequestURI() {
+			continue
+		}
+		r.used[n] = true
+		return e, true
+	}
+	return CaptureEntry{}, false
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Replay) RoundTrip(req *http.Request) (*http.Response, error) {
+	e, ok := r.match(req)
+	if !ok {
+		if r.Fallback != nil {
+			return r.Fallback.RoundTrip(req)
+		}
+		return nil, fmt.Errorf("client: no captured response for %s %s", req.Method, req.URL)
+	}
+	if e.Response.Status == 0 {
+		return nil, fmt.Errorf("client: captured error: %s", e.Error)
+	}
+	if e.Response.Status/100 == 2 && r.Fallback != nil {
+		return r.Fallback.RoundTrip(req)
+	}
+
+	resp := &http.Response{
+		Status:     fmt.Sprintf("%d %s", e.Response.Status, e.Response.StatusText),
+		StatusCode: e.Response.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}
+	for _, h := range e.Response.Headers {
+		resp.Header.Add(h.Name, h.Value)
+	}
+	return resp, nil
+}
@@ -0,0 +1,437 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/golang/glog"
+	"github.com/lukegb/snowstorm/blte"
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/armadillo"
+)
+
+const (
+	// maxDownloadResumes is how many times Download picks up an interrupted transfer itself, as long as each attempt makes progress.
+	maxDownloadResumes = 5
+
+	// minSegmentSize is the smallest segment a segmented download is split into.
+	minSegmentSize = 1 << 20
+)
+
+// Download saves the object named cdnHash to the file fn, with the same contents FetchRaw would return.
+//
+// The object is written to fn+".part" as it arrives, and a journal in fn+".part.journal" records which object that is.
+// If the transfer is interrupted, it is picked up where it stopped using a Range request, either by Download itself or by a later call for the same object.
+// Once the whole object has arrived it is checked against cdnHash, and only then renamed to fn.
+func (c *LowLevelClient) Download(ctx context.Context, cdnInfo ngdp.CDNInfo, contentType ngdp.ContentType, cdnHash ngdp.CDNHash, suffix, fn string) error {
+	return c.download(ctx, cdnInfo, contentType, cdnHash, suffix, fn, objectHasher(contentType, suffix))
+}
+
+// DownloadArchive is like Download, but for archives. Archives are not named after their contents, so only their length is checked.
+func (c *LowLevelClient) DownloadArchive(ctx context.Context, cdnInfo ngdp.CDNInfo, contentType ngdp.ContentType, archive ngdp.CDNHash, fn string) error {
+	return c.download(ctx, cdnInfo, contentType, archive, "", fn, nil)
+}
+
+func (c *LowLevelClient) download(ctx context.Context, cdnInfo ngdp.CDNInfo, contentType ngdp.ContentType, h ngdp.CDNHash, suffix, fn string, hasher func(io.Reader) ([md5.Size]byte, error)) error {
+	p, err := openPartial(fn, fmt.Sprintf("%s/%032x%s", contentType, h, suffix))
+	if err != nil {
+		return err
+	}
+	defer p.close()
+
+	if c.Segments > 1 && p.size == 0 {
+		if err := c.downloadSegments(ctx, cdnInfo, contentType, h, suffix, p); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		before := p.size
+		err := c.resume(ctx, cdnInfo, contentType, h, suffix, p)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil || p.size <= before || attempt >= maxDownloadResumes {
+			return err
+		}
+		glog.Warningf("client: %s: %v after %d bytes; resuming", p.object, err, p.size)
+	}
+
+	if hasher != nil {
+		if _, err := p.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		got, err := hasher(bufio.NewReader(p.f))
+		if err != nil || !h.Equal(ngdp.CDNHash(got)) {
+			// Whatever went wrong is in the data already saved, so start afresh next time.
+			p.discard()
+			if err != nil {
+				return fmt.Errorf("client: checking %s: %v", p.object, err)
+			}
+			return fmt.Errorf("client: %s hashes to %032x", p.object, got)
+		}
+	}
+	return p.finish()
+}
+
+// resume fetches the rest of the object p holds, appending it to p.
+func (c *LowLevelClient) resume(ctx context.Context, cdnInfo ngdp.CDNInfo, contentType ngdp.ContentType, h ngdp.CDNHash, suffix string, p *partialFile) error {
+	if p.total >= 0 && p.size == p.total {
+		return nil
+	}
+
+	path := cdnPath(cdnInfo, contentType, h, suffix)
+	attrs := []attribute.KeyValue{
+		attribute.String("ngdp.content_type", string(contentType)),
+		attribute.String("ngdp.hash", fmt.Sprintf("%032x%s", h, suffix)),
+		attribute.Int64("ngdp.offset", p.size),
+	}
+	var resp *http.Response
+	var err error
+	if p.size > 0 {
+		resp, err = c.fetch(ctx, cdnInfo, path, fmt.Sprintf("bytes=%d-", p.size), http.StatusPartialContent, attrs...)
+		if err != nil && !refusedRange(err) {
+			return err
+		}
+		if err == nil {
+			if start, total, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || start != p.size || (p.total >= 0 && total != p.total) {
+				resp.Body.Close()
+				err = fmt.Errorf("client: unexpected Content-Range %q resuming from %d", resp.Header.Get("Content-Range"), p.size)
+			}
+		}
+		if err != nil {
+			// The server can't or won't continue where we left off, so start again from the beginning.
+			glog.Warningf("client: can't resume %s: %v", p.object, err)
+			if err := p.reset(); err != nil {
+				return err
+			}
+		}
+	}
+	if p.size == 0 {
+		resp, err = c.fetch(ctx, cdnInfo, path, "", http.StatusOK, attrs...)
+		if err != nil {
+			return err
+		}
+		if err := p.start(resp.ContentLength); err != nil {
+			resp.Body.Close()
+			return err
+		}
+	}
+
+	// Without a key, the body is checked for encryption only at the start of the object.
+	body := c.newProgress(h, p.size, p.total).wrap(resp.Body)
+	if c.ArmadilloKey != nil || p.size == 0 {
+		if body, err = c.decrypt(body, contentType, h, suffix, p.size); err != nil {
+			return err
+		}
+	}
+	defer body.Close()
+
+	n, err := io.Copy(p.f, body)
+	p.size += n
+	if err != nil {
+		return err
+	}
+	if p.total >= 0 && p.size != p.total {
+		return fmt.Errorf("client: %s is %d bytes long, but only %d arrived", p.object, p.total, p.size)
+	}
+	return nil
+}
+
+// downloadSegments fetches the object p holds in up to c.Segments ranged segments at once.
+// If the object is too small to be worth splitting, or the server won't serve ranges of it, it does nothing and leaves the download to resume.
+func (c *LowLevelClient) downloadSegments(ctx context.Context, cdnInfo ngdp.CDNInfo, contentType ngdp.ContentType, h ngdp.CDNHash, suffix string, p *partialFile) error {
+	// Ask for a single byte to learn the object's length.
+	resp, err := c.fetch(ctx, cdnInfo, cdnPath(cdnInfo, contentType, h, suffix), "bytes=0-0", http.StatusPartialContent,
+		attribute.String("ngdp.content_type", string(contentType)),
+		attribute.String("ngdp.hash", fmt.Sprintf("%032x%s", h, suffix)),
+	)
+	if refusedRange(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	_, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	if !ok || total < 0 {
+		return nil
+	}
+	segments := int64(c.Segments)
+	if max := total / minSegmentSize; segments > max {
+		segments = max
+	}
+	if segments < 2 {
+		return nil
+	}
+
+	if err := p.startSegmented(total); err != nil {
+		return err
+	}
+	prog := c.newProgress(h, 0, total)
+	g, gctx := errgroup.WithContext(ctx)
+	for n := int64(0); n < segments; n++ {
+		start, end := total*n/segments, total*(n+1)/segments
+		g.Go(func() error {
+			return c.fetchSegment(gctx, cdnInfo, contentType, h, suffix, p, prog, start, end)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	p.size = total
+	_, err = p.f.Seek(total, io.SeekStart)
+	return err
+}
+
+// fetchSegment fetches bytes [start, end) of the object p holds into the same place in p, retrying if it fails. Progress is counted by prog.
+func (c *LowLevelClient) fetchSegment(ctx context.Context, cdnInfo ngdp.CDNInfo, contentType ngdp.ContentType, h ngdp.CDNHash, suffix string, p *partialFile, prog *progress, start, end int64) error {
+	done := start
+	for attempt := 0; ; attempt++ {
+		err := c.fetchRange(ctx, cdnInfo, contentType, h, suffix, p, prog, &done, end)
+		if err == nil || ctx.Err() != nil || !isTransient(err) || err == armadillo.ErrKeyRequired || attempt >= maxDownloadResumes {
+			return err
+		}
+		glog.Warningf("client: %s: segment %d-%d: %v after %d bytes; retrying", p.object, start, end, err, done-start)
+	}
+}
+
+// fetchRange makes a single attempt at fetching bytes [*done, end) of the object p holds into p, advancing *done past whatever arrives.
+func (c *LowLevelClient) fetchRange(ctx context.Context, cdnInfo ngdp.CDNInfo, contentType ngdp.ContentType, h ngdp.CDNHash, suffix string, p *partialFile, prog *progress, done *int64, end int64) error {
+	resp, err := c.fetch(ctx, cdnInfo, cdnPath(cdnInfo, contentType, h, suffix), fmt.Sprintf("bytes=%d-%d", *done, end-1), http.StatusPartialContent,
+		attribute.String("ngdp.content_type", string(contentType)),
+		attribute.String("ngdp.hash", fmt.Sprintf("%032x%s", h, suffix)),
+		attribute.Int64("ngdp.offset", *done),
+		attribute.Int64("ngdp.size", end-*done),
+	)
+	if err != nil {
+		return err
+	}
+	if start, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || start != *done {
+		resp.Body.Close()
+		return fmt.Errorf("client: unexpected Content-Range %q fetching from %d", resp.Header.Get("Content-Range"), *done)
+	}
+
+	body := prog.wrap(resp.Body)
+	if c.ArmadilloKey != nil || *done == 0 {
+		if body, err = c.decrypt(body, contentType, h, suffix, *done); err != nil {
+			return err
+		}
+	}
+	defer body.Close()
+
+	n, err := io.Copy(io.NewOffsetWriter(p.f, *done), io.LimitReader(body, end-*done))
+	*done += n
+	if err == nil && *done != end {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// refusedRange reports whether err means the server would not serve the range asked for, sending the whole object or nothing.
+func refusedRange(err error) bool {
+	e, ok := err.(errBadStatus)
+	return ok && (e.statusCode == http.StatusOK || e.statusCode == http.StatusRequestedRangeNotSatisfiable)
+}
+
+// parseContentRange parses the Content-Range header of a 206 response, returning the offset of its first byte and the length of the whole object, or -1 if unknown.
+func parseContentRange(s string) (start, total int64, ok bool) {
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, false
+	}
+	bits := strings.SplitN(strings.TrimPrefix(s, "bytes "), "/", 2)
+	dash := strings.Index(bits[0], "-")
+	if len(bits) != 2 || dash == -1 {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(bits[0][:dash], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if bits[1] == "*" {
+		return start, -1, true
+	}
+	total, err = strconv.ParseInt(bits[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, total, true
+}
+
+// A partialFile is an object being downloaded, alongside a journal recording which object it is.
+type partialFile struct {
+	fn      string
+	object  string
+	f       *os.File
+	size    int64
+	total   int64 // -1 if unknown
+	journal string
+}
+
+// openPartial opens the partial download of object destined for fn, keeping what an earlier call saved if its journal says it was for the same object.
+func openPartial(fn, object string) (*partialFile, error) {
+	p := &partialFile{
+		fn:      fn,
+		object:  object,
+		total:   -1,
+		journal: fn + ".part.journal",
+	}
+
+	flags := os.O_RDWR | os.O_CREATE
+	if b, err := ioutil.ReadFile(p.journal); err == nil {
+		var object string
+		var total int64
+		if _, err := fmt.Sscanf(string(b), "%q %d\n", &object, &total); err != nil || object != p.object {
+			flags |= os.O_TRUNC
+		} else {
+			p.total = total
+		}
+	} else if os.IsNotExist(err) {
+		flags |= os.O_TRUNC
+	} else {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(fn+".part", flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	p.f = f
+	if p.size, err = f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if p.total >= 0 && p.size > p.total {
+		if err := p.reset(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// start records that the whole object, of length total (or -1 if unknown), is about to be written from the beginning.
+func (p *partialFile) start(total int64) error {
+	p.total = total
+	return ioutil.WriteFile(p.journal, []byte(fmt.Sprintf("%q %d\n", p.object, total)), 0644)
+}
+
+// startSegmented is like start, but for a download whose segments arrive out of order.
+// Its journal names no object, so that an interrupted segmented download is started afresh rather than resumed from a hole.
+func (p *partialFile) startSegmented(total int64) error {
+	p.total = total
+	return ioutil.WriteFile(p.journal, []byte(fmt.Sprintf("%q %d\n", "", total)), 0644)
+}
+
+// reset throws away everything saved so far.
+func (p *partialFile) reset() error {
+	p.size, p.total = 0, -1
+	if err := p.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := p.f.Seek(0, io.SeekStart)
+	return err
+}
+
+// discard removes the partial download and its journal.
+func (p *partialFile) discard() {
+	p.close()
+	os.Remove(p.f.Name())
+	os.Remove(p.journal)
+}
+
+// finish moves the completed download into place.
+func (p *partialFile) finish() error {
+	if err := p.f.Sync(); err != nil {
+		return err
+	}
+	if err := p.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(p.f.Name(), p.fn); err != nil {
+		return err
+	}
+	return os.Remove(p.journal)
+}
+
+func (p *partialFile) close() {
+	p.f.Close()
+}
+
+// objectHasher returns a function which calculates the name of an object from its contents.
+func objectHasher(contentType ngdp.ContentType, suffix string) func(io.Reader) ([md5.Size]byte, error) {
+	switch {
+	case suffix == ".index":
+		// Archive indices are named after their footer.
+		return func(r io.Reader) ([md5.Size]byte, error) {
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				return [md5.Size]byte{}, err
+			}
+			if len(b) < archiveIndexFooterSize {
+				return [md5.Size]byte{}, fmt.Errorf("index is too short to contain a footer")
+			}
+			return md5.Sum(b[len(b)-archiveIndexFooterSize:]), nil
+		}
+	case suffix == "" && (contentType == ngdp.ContentTypeData || contentType == ngdp.ContentTypePatch):
+		return blteHeaderHash
+	}
+	return func(r io.Reader) ([md5.Size]byte, error) {
+		var sum [md5.Size]byte
+		hasher := md5.New()
+		if _, err := io.Copy(hasher, r); err != nil {
+			return sum, err
+		}
+		copy(sum[:], hasher.Sum(nil))
+		return sum, nil
+	}
+}
+
+// blteHeaderHash is blte.HeaderHash, reading no more of r than it needs to.
+func blteHeaderHash(r io.Reader) ([md5.Size]byte, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return [md5.Size]byte{}, err
+	}
+	if hdrLen := binary.BigEndian.Uint32(hdr[4:]); hdrLen > 8 {
+		rest := make([]byte, hdrLen-8)
+		if _, err := io.ReadFull(r, rest); err != nil {
+			return [md5.Size]byte{}, err
+		}
+		hdr = append(hdr, rest...)
+	} else if hdrLen == 0 {
+		rest, err := ioutil.ReadAll(r)
+		if err != nil {
+			return [md5.Size]byte{}, err
+		}
+		hdr = append(hdr, rest...)
+	}
+	return blte.HeaderHash(hdr)
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lukegb/snowstorm/blte"
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+// objectServer serves data at every path, cutting the first failFirst responses short after half of it. It returns the CDN and the Range header of each request.
+func objectServer(t *testing.T, data []byte, failFirst int) (ngdp.CDNInfo, func() []string) {
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		fail := len(ranges) <= failFirst
+		mu.Unlock()
+
+		if fail {
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			w.Write(data[:len(data)/2])
+			w.(http.Flusher).Flush()
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+
+	cdn := ngdp.CDNInfo{Path: "tpr/test", Hosts: []string{strings.TrimPrefix(srv.URL, "http://")}}
+	return cdn, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ranges...)
+	}
+}
+
+func checkDownloaded(t *testing.T, fn string, want []byte) {
+	t.Helper()
+	got, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("reading download: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("downloaded %d bytes; want the %d bytes served", len(got), len(want))
+	}
+	for _, leftover := range []string{fn + ".part", fn + ".part.journal"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s left behind after download", filepath.Base(leftover))
+		}
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	data := []byte("# config\n" + strings.Repeat("key = value\n", 1000))
+	h := ngdp.CDNHash(md5.Sum(data))
+	cdn, ranges := objectServer(t, data, 1)
+	fn := filepath.Join(t.TempDir(), "config")
+
+	c := &LowLevelClient{}
+	if err := c.Download(context.Background(), cdn, ngdp.ContentTypeConfig, h, "", fn); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	checkDownloaded(t, fn, data)
+	if want := []string{"", fmt.Sprintf("bytes=%d-", len(data)/2)}; !reflect.DeepEqual(ranges(), want) {
+		t.Errorf("Range headers = %q; want %q", ranges(), want)
+	}
+}
+
+func TestDownloadResumeFromJournal(t *testing.T) {
+	data := []byte("# config\n" + strings.Repeat("key = value\n", 1000))
+	h := ngdp.CDNHash(md5.Sum(data))
+	ctx := context.Background()
+	c := &LowLevelClient{}
+
+	for _, test := range []struct {
+		name      string
+		object    string
+		wantRange string
+	}{
+		{"same object", fmt.Sprintf("config/%032x", h), "bytes=1000-"},
+		{"other object", fmt.Sprintf("config/%032x", ngdp.CDNHash{1}), ""},
+	} {
+		cdn, ranges := objectServer(t, data, 0)
+		fn := filepath.Join(t.TempDir(), "config")
+		if err := ioutil.WriteFile(fn+".part", data[:1000], 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn+".part.journal", []byte(fmt.Sprintf("%q %d\n", test.object, len(data))), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Download(ctx, cdn, ngdp.ContentTypeConfig, h, "", fn); err != nil {
+			t.Fatalf("%s: Download: %v", test.name, err)
+		}
+		checkDownloaded(t, fn, data)
+		if got := ranges(); !reflect.DeepEqual(got, []string{test.wantRange}) {
+			t.Errorf("%s: Range headers = %q; want %q", test.name, got, test.wantRange)
+		}
+	}
+}
+
+func TestDownloadChecksHash(t *testing.T) {
+	data, err := blte.Encode(bytes.Repeat([]byte("snowstorm"), 1000), blte.ESpec{Mode: 'b', Blocks: []blte.BlockSpec{{Size: 1024, Spec: blte.ESpec{Mode: 'n'}}}})
+	if err != nil {
+		t.Fatalf("blte.Encode: %v", err)
+	}
+	h, err := blte.HeaderHash(data)
+	if err != nil {
+		t.Fatalf("blte.HeaderHash: %v", err)
+	}
+	cdn, _ := objectServer(t, data, 1)
+	ctx := context.Background()
+	c := &LowLevelClient{}
+
+	fn := filepath.Join(t.TempDir(), "data")
+	if err := c.Download(ctx, cdn, ngdp.ContentTypeData, ngdp.CDNHash(h), "", fn); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	checkDownloaded(t, fn, data)
+
+	fn = filepath.Join(t.TempDir(), "data")
+	if err := c.Download(ctx, cdn, ngdp.ContentTypeData, ngdp.CDNHash{1}, "", fn); err == nil {
+		t.Errorf("Download with the wrong hash succeeded")
+	}
+	for _, leftover := range []string{fn, fn + ".part", fn + ".part.journal"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s left behind after a failed download", filepath.Base(leftover))
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	for _, test := range []struct {
+		in           string
+		start, total int64
+		ok           bool
+	}{
+		{"bytes 100-199/200", 100, 200, true},
+		{"bytes 0-9/*", 0, -1, true},
+		{"bytes */200", 0, 0, false},
+		{"100-199/200", 0, 0, false},
+	} {
+		start, total, ok := parseContentRange(test.in)
+		if start != test.start || total != test.total || ok != test.ok {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v; want %d, %d, %v", test.in, start, total, ok, test.start, test.total, test.ok)
+		}
+	}
+}
+
+func TestDownloadSegments(t *testing.T) {
+	data := []byte("# config\n" + strings.Repeat("key = value\n", 3*minSegmentSize/12))
+	h := ngdp.CDNHash(md5.Sum(data))
+	third := len(data) / 3
+	cutShort := fmt.Sprintf("bytes=%d-%d", third, 2*third-1)
+
+	var mu sync.Mutex
+	var ranges []string
+	failed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		fail := !failed && r.Header.Get("Range") == cutShort
+		failed = failed || fail
+		mu.Unlock()
+
+		if fail {
+			// Fail the middle segment once, halfway through.
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", third, 2*third-1, len(data)))
+			w.Header().Set("Content-Length", fmt.Sprint(third))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[third : third+third/2])
+			w.(http.Flusher).Flush()
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+	cdn := ngdp.CDNInfo{Path: "tpr/test", Hosts: []string{strings.TrimPrefix(srv.URL, "http://")}}
+
+	fn := filepath.Join(t.TempDir(), "config")
+	c := &LowLevelClient{Segments: 4}
+	if err := c.Download(context.Background(), cdn, ngdp.ContentTypeConfig, h, "", fn); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	checkDownloaded(t, fn, data)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]bool{
+		"bytes=0-0":                        true,
+		fmt.Sprintf("bytes=0-%d", third-1): true,
+		cutShort:                           true,
+		fmt.Sprintf("bytes=%d-%d", third+third/2, 2*third-1): true,
+		fmt.Sprintf("bytes=%d-%d", 2*third, len(data)-1):     true,
+	}
+	if len(ranges) != len(want) {
+		t.Errorf("Range headers = %q; want the 3 segments, a retry and the probe", ranges)
+	}
+	for _, r := range ranges {
+		if !want[r] {
+			t.Errorf("unexpected Range header %q", r)
+		}
+	}
+}
+
+func TestDownloadSegmentsSmallObject(t *testing.T) {
+	data := []byte("# config\n")
+	cdn, ranges := objectServer(t, data, 0)
+	fn := filepath.Join(t.TempDir(), "config")
+
+	c := &LowLevelClient{Segments: 4}
+	if err := c.Download(context.Background(), cdn, ngdp.ContentTypeConfig, ngdp.CDNHash(md5.Sum(data)), "", fn); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	checkDownloaded(t, fn, data)
+	if want := []string{"bytes=0-0", ""}; !reflect.DeepEqual(ranges(), want) {
+		t.Errorf("Range headers = %q; want %q", ranges(), want)
+	}
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultFailoverRetries    = 2
+	defaultFailoverRetryDelay = 250 * time.Millisecond
+	defaultMaxRetryDelay      = 10 * time.Second
+)
+
+// defaultFailover is used by LowLevelClients which don't set their own.
+var defaultFailover = &Failover{}
+
+// ErrNoHosts is returned when asked to fetch from a CDN which lists no hosts.
+var ErrNoHosts = errors.New("client: CDN has no hosts")
+
+// A HostOrder chooses the order in which a Failover tries a CDN's hosts.
+type HostOrder int
+
+const (
+	// InOrder tries hosts in the order the CDN lists them.
+	InOrder HostOrder = iota
+
+	// Weighted tries hosts in a random order, favouring those which have failed least often.
+	Weighted
+)
+
+// A Failover retries CDN requests which fail transiently, moving on to the CDN's other hosts when one keeps failing.
+//
+// Network errors and 408, 429 and 5xx responses are transient. Other failures, such as 404 Not Found, are returned at once, since every host serves the same objects.
+// Only the request itself is retried: errors while reading a response body are left to the caller.
+//
+// The zero value is ready to use. A Failover is safe for concurrent use, and records the health of every host it has tried.
+type Failover struct {
+	// Order is the order in which hosts are tried.
+	Order HostOrder
+
+	// Retries is the number of times a host is retried before moving on to the next. Defaults to 2; a negative value disables retries.
+	Retries int
+
+	// RetryDelay is how long to wait before the first retry of a host; the delay doubles with each subsequent retry, and is jittered by up to half. Defaults to 250ms.
+	RetryDelay time.Duration
+
+	// MaxRetryDelay caps the delay between retries. Defaults to 10s.
+	MaxRetryDelay time.Duration
+
+	mu     sync.Mutex
+	health map[string]*HostHealth
+}
+
+// HostHealth records how requests to a CDN host have fared.
+type HostHealth struct {
+	Successes int
+	Failures  int
+
+	// ConsecutiveFailures is the number of failures since the last success.
+	ConsecutiveFailures int
+
+	// LastError is the error of the most recent failure, which happened at LastFailure.
+	LastError   error
+	LastFailure time.Time
+}
+
+// Health returns the health of every host f has tried.
+func (f *Failover) Health() map[string]HostHealth {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]HostHealth, len(f.health))
+	for host, h := range f.health {
+		out[host] = *h
+	}
+	return out
+}
+
+func (f *Failover) record(host string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.health == nil {
+		f.health = make(map[string]*HostHealth)
+	}
+	h := f.health[host]
+	if h == nil {
+		h = new(HostHealth)
+		f.health[host] = h
+	}
+	if err == nil {
+		h.Successes++
+		h.ConsecutiveFailures = 0
+		return
+	}
+	h.Failures++
+	h.ConsecutiveFailures++
+	h.LastError = err
+	h.LastFailure = time.Now()
+}
+
+// order returns hosts in the order they should be tried.
+func (f *Failover) order(hosts []string) []string {
+	out := make([]string, len(hosts))
+	copy(out, hosts)
+	if f.Order != Weighted {
+		return out
+	}
+
+	f.mu.Lock()
+	weights := make([]float64, len(out))
+	var total float64
+	for n, host := range out {
+		// Hosts we know nothing about count as having succeeded once and failed once.
+		successes, failures := 1, 1
+		if h := f.health[host]; h != nil {
+			successes += h.Successes
+			failures += h.Failures
+		}
+		weights[n] = float64(successes) / float64(successes+failures)
+		total += weights[n]
+	}
+	f.mu.Unlock()
+
+	// Pick hosts one at a time, each with probability proportional to its weight.
+	for n := range out {
+		r := rand.Float64() * total
+		pick := len(out) - 1
+		for m := n; m < len(out); m++ {
+			if r < weights[m] {
+				pick = m
+				break
+			}
+			r -= weights[m]
+		}
+		out[n], out[pick] = out[pick], out[n]
+		weights[n], weights[pick] = weights[pick], weights[n]
+		total -= weights[n]
+	}
+	return out
+}
+
+// do calls try for each of hosts in turn until one succeeds, retrying transient failures.
+func (f *Failover) do(ctx context.Context, hosts []string, try func(host string) (*http.Response, error)) (*http.Response, error) {
+	retries := f.Retries
+	if retries == 0 {
+		retries = defaultFailoverRetries
+	}
+	maxDelay := f.MaxRetryDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxRetryDelay
+	}
+
+	var lastErr error
+	for _, host := range f.order(hosts) {
+		delay := f.RetryDelay
+		if delay <= 0 {
+			delay = defaultFailoverRetryDelay
+		}
+		for attempt := 0; ; attempt++ {
+			resp, err := try(host)
+			if ctx.Err() != nil {
+				return resp, err
+			}
+			if err == nil || !isTransient(err) {
+				// The host answered, even if not with what we wanted.
+				f.record(host, nil)
+				return resp, err
+			}
+			f.record(host, err)
+			lastErr = err
+			if attempt >= retries {
+				break
+			}
+
+			wait := jitter(delay)
+			glog.Warningf("client: %s: %v; retrying in %v", host, err, wait)
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return nil, ctx.Err()
+			}
+			if delay *= 2; delay > maxDelay {
+				delay = maxDelay
+			}
+		}
+		glog.Warningf("client: giving up on %s: %v", host, lastErr)
+	}
+	return nil, lastErr
+}
+
+// isTransient reports whether err is a failure which might not happen again.
+func isTransient(err error) bool {
+	e, ok := errors.Cause(err).(errBadStatus)
+	if !ok {
+		return true
+	}
+	switch {
+	case e.statusCode == http.StatusRequestTimeout, e.statusCode == http.StatusTooManyRequests:
+		return true
+	case e.statusCode >= 500:
+		return true
+	}
+	return false
+}
+
+// jitter returns a random duration between half of d and d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+// statusServer starts a server which answers every request with status, returning its host and a count of requests.
+func statusServer(t *testing.T, status int, body string) (string, *int32) {
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://"), &n
+}
+
+func TestFailover(t *testing.T) {
+	bad, badCount := statusServer(t, http.StatusServiceUnavailable, "")
+	good, goodCount := statusServer(t, http.StatusOK, "# config\n")
+	cdn := ngdp.CDNInfo{Path: "tpr/test", Hosts: []string{bad, good}}
+	h := ngdp.CDNHash{1, 2, 3}
+
+	c := &LowLevelClient{}
+	body, err := c.FetchRaw(context.Background(), cdn, ngdp.ContentTypeConfig, h, "")
+	if err != nil {
+		t.Fatalf("FetchRaw without Failover: %v; want the default Failover to move on to the second host", err)
+	}
+	body.Close()
+	if *goodCount != 1 {
+		t.Errorf("without Failover, second host got %d requests; want 1", *goodCount)
+	}
+
+	atomic.StoreInt32(badCount, 0)
+	c.Failover = &Failover{Retries: 2, RetryDelay: time.Millisecond}
+	body, err = c.FetchRaw(context.Background(), cdn, ngdp.ContentTypeConfig, h, "")
+	if err != nil {
+		t.Fatalf("FetchRaw: %v", err)
+	}
+	got, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil || string(got) != "# config\n" {
+		t.Errorf("FetchRaw = %q, %v; want %q", got, err, "# config\n")
+	}
+	if *badCount != 3 {
+		t.Errorf("first host got %d requests; want 3", *badCount)
+	}
+
+	health := c.Failover.Health()
+	if hh := health[bad]; hh.Failures != 3 || hh.ConsecutiveFailures != 3 || hh.Successes != 0 || hh.LastError == nil {
+		t.Errorf("health of failing host = %+v; want 3 failures", hh)
+	}
+	if hh := health[good]; hh.Failures != 0 || hh.Successes != 1 {
+		t.Errorf("health of working host = %+v; want 1 success", hh)
+	}
+}
+
+func TestFailoverNotFound(t *testing.T) {
+	missing, missingCount := statusServer(t, http.StatusNotFound, "")
+	good, goodCount := statusServer(t, http.StatusOK, "# config\n")
+	cdn := ngdp.CDNInfo{Path: "tpr/test", Hosts: []string{missing, good}}
+
+	c := &LowLevelClient{Failover: &Failover{RetryDelay: time.Millisecond}}
+	_, err := c.FetchRaw(context.Background(), cdn, ngdp.ContentTypeConfig, ngdp.CDNHash{1}, "")
+	if !IsNotFound(err) {
+		t.Errorf("FetchRaw error = %v; want not found", err)
+	}
+	if *missingCount != 1 || *goodCount != 0 {
+		t.Errorf("hosts got %d and %d requests; want 1 and 0", *missingCount, *goodCount)
+	}
+}
+
+func TestFailoverWeighted(t *testing.T) {
+	f := &Failover{Order: Weighted}
+	for i := 0; i < 100; i++ {
+		f.record("flaky", errBadStatus{http.StatusServiceUnavailable, "Service Unavailable", http.StatusOK})
+		f.record("solid", nil)
+	}
+
+	first := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		order := f.order([]string{"flaky", "solid", "unknown"})
+		if len(order) != 3 {
+			t.Fatalf("order returned %v; want all three hosts", order)
+		}
+		first[order[0]]++
+	}
+	if first["solid"] <= first["unknown"] || first["unknown"] <= first["flaky"] {
+		t.Errorf("hosts tried first = %v; want solid most often and flaky least", first)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{errBadStatus{http.StatusServiceUnavailable, "Service Unavailable", http.StatusOK}, true},
+		{errBadStatus{http.StatusTooManyRequests, "Too Many Requests", http.StatusOK}, true},
+		{errBadStatus{http.StatusNotFound, "Not Found", http.StatusOK}, false},
+		{errBadStatus{http.StatusForbidden, "Forbidden", http.StatusOK}, false},
+		{errors.New("connection reset by peer"), true},
+	} {
+		if got := isTransient(test.err); got != test.want {
+			t.Errorf("isTransient(%v) = %v; want %v", test.err, got, test.want)
+		}
+	}
+}
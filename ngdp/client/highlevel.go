@@ -17,9 +17,11 @@ limitations under the License.
 package client
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/golang/glog"
@@ -27,7 +29,10 @@ import (
 
 	"github.com/lukegb/snowstorm/blte"
 	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
 	"github.com/lukegb/snowstorm/ngdp/encoding"
+	"github.com/lukegb/snowstorm/ngdp/productconfig"
+	"github.com/lukegb/snowstorm/ngdp/tactkeys"
 )
 
 var (
@@ -44,6 +49,9 @@ var (
 
 	// ErrNotExists means that the requested file does not exist.
 	ErrNotExists = errors.New("client: no such file")
+
+	// ErrNoProductConfig means that the version doesn't name a product config.
+	ErrNoProductConfig = errors.New("client: version has no product config")
 )
 
 type errBadStatus struct {
@@ -57,6 +65,19 @@ func (e errBadStatus) Error() string {
 	return fmt.Sprintf("client: server status was \"%d %s\"; wanted \"%d %s\"", e.statusCode, e.status, e.wantedStatusCode, http.StatusText(e.wantedStatusCode))
 }
 
+// IsNotFound returns true if err was caused by the server responding with 404 Not Found.
+func IsNotFound(err error) bool {
+	e, ok := errors.Cause(err).(errBadStatus)
+	return ok && e.statusCode == http.StatusNotFound
+}
+
+// A Fetcher retrieves files by the hash of their contents.
+//
+// It is implemented by Client, and by other sources of NGDP content such as a game's local storage.
+type Fetcher interface {
+	Fetch(ctx context.Context, h ngdp.ContentHash) (*Response, error)
+}
+
 // A Client provides a nice interface to interacting with NGDP, to make retrieving individual files easy.
 type Client struct {
 	LowLevelClient *LowLevelClient
@@ -70,21 +91,43 @@ type Client struct {
 	ArchiveMapper  *ArchiveMapper
 	EncodingMapper *encoding.Mapper
 	FilenameMapper ngdp.FilenameMapper
+
+	// Keys, if set, supplies the keys for decrypting encrypted content.
+	// New fills it from the version's keyring, if it has one; more keys can be added to it at any time.
+	Keys *tactkeys.Keyring
+
+	// Verify says how Fetch checks BLTE chunk checksums. Mismatches are logged rather than returned under blte.VerifyReport.
+	Verify blte.VerifyMode
+
+	// CDNHashStrategy chooses which CDN hash to fetch for files listed with more than one.
+	CDNHashStrategy CDNHashStrategy
+
+	// Cache, if set, is consulted before the CDN, and keeps a copy of everything retrieved from it.
+	Cache blobstore.ContentStore
 }
 
 // New creates a new Client for the given ProgramCode and Region.
 //
 // It will automatically create an ArchiveMapper and Encoder as appropriate.
 func New(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) (*Client, error) {
+	return NewWithLowLevelClient(ctx, &LowLevelClient{}, program, region)
+}
+
+// NewWithLowLevelClient is like New, but makes its requests through llc.
+func NewWithLowLevelClient(ctx context.Context, llc *LowLevelClient, program ngdp.ProgramCode, region ngdp.Region) (*Client, error) {
 	glog.Info("Initialising new NGDP Client")
-	llc := &LowLevelClient{}
 
 	// Fetch CDN and Version info.
 	cdn, version, err := llc.Info(ctx, program, region)
 	if err != nil {
 		return nil, err
 	}
+	return NewWithVersion(ctx, llc, cdn, version)
+}
 
+// NewWithVersion creates a new Client for a specific version, fetched from cdn, rather than whichever is current.
+// Only version's BuildConfig, CDNConfig and KeyRing need to be filled in.
+func NewWithVersion(ctx context.Context, llc *LowLevelClient, cdn ngdp.CDNInfo, version ngdp.VersionInfo) (*Client, error) {
 	// Fetch Build and CDN configs.
 	cdnConfig, buildConfig, err := llc.Configs(ctx, cdn, version)
 	if err != nil {
@@ -97,6 +140,15 @@ func New(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) (*Cl
 		return nil, err
 	}
 
+	// Fetch the keys for any encrypted content.
+	keys := tactkeys.New()
+	if !llc.NoKeyRing {
+		keys, err = llc.KeyRing(ctx, cdn, version)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &Client{
 		LowLevelClient: llc,
 
@@ -108,6 +160,8 @@ func New(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) (*Cl
 
 		ArchiveMapper:  archiveMapper,
 		EncodingMapper: encodingMapper,
+
+		Keys: keys,
 	}, nil
 }
 
@@ -125,56 +179,153 @@ type Response struct {
 	// RetrievedCDNHash is the CDN hash of the file which was actually retrieved.
 	// If the file was inside an archive, then this will be the archive's CDN hash.
 	RetrievedCDNHash ngdp.CDNHash
+
+	// Size is the decoded size of Body, if Fetch could tell it from the file's chunk table, or zero otherwise.
+	Size int64
+}
+
+// A CDNHashStrategy chooses between the CDN hashes of a file which the encoding table lists more than once.
+type CDNHashStrategy int
+
+const (
+	// FirstCDNHash picks the first CDN hash listed.
+	FirstCDNHash CDNHashStrategy = iota
+
+	// PreferArchived picks the first CDN hash which is in an archive, since archived files can be fetched over connections which are already open.
+	PreferArchived
+
+	// PreferSmallest picks the CDN hash with the smallest encoded size. Only archive indices list sizes, so loose files are only picked if none is archived.
+	PreferSmallest
+)
+
+// CDNHash converts a content hash to the CDN hash which Fetch retrieves, choosing between several with CDNHashStrategy.
+func (c *Client) CDNHash(h ngdp.ContentHash) (ngdp.CDNHash, error) {
+	hashes, err := c.EncodingMapper.ToCDNHashes(h)
+	if err != nil {
+		return ngdp.CDNHash{}, err
+	}
+	if len(hashes) == 0 {
+		return ngdp.CDNHash{}, encoding.ErrUnknownContentHash
+	}
+
+	return c.CDNHashStrategy.choose(hashes, c.ArchiveMapper), nil
+}
+
+func (s CDNHashStrategy) choose(hashes []ngdp.CDNHash, am *ArchiveMapper) ngdp.CDNHash {
+	best := hashes[0]
+	if s == FirstCDNHash || len(hashes) == 1 || am == nil {
+		return best
+	}
+	var bestSize uint32
+	archived := false
+	for _, ch := range hashes {
+		e, ok := am.Map(ch)
+		if !ok {
+			continue
+		}
+		if s == PreferArchived {
+			return ch
+		}
+		if !archived || e.Size < bestSize {
+			best, bestSize, archived = ch, e.Size, true
+		}
+	}
+	return best
 }
 
 // Fetch retrieves a given file by the hash of its contents. After all, CASC is content-addressable storage.
 func (c *Client) Fetch(ctx context.Context, h ngdp.ContentHash) (*Response, error) {
-	r := &Response{
-		ContentHash: h,
+	// Convert the content hash to a CDN hash.
+	cdnHash, err := c.CDNHash(h)
+	if err != nil {
+		return nil, err
 	}
 
-	// Convert the content hash to a CDN hash.
-	cdnHash, err := c.EncodingMapper.ToCDNHash(h)
+	r, err := c.FetchRaw(ctx, cdnHash)
 	if err != nil {
 		return nil, err
 	}
-	r.CDNHash = cdnHash
+	r.ContentHash = h
 
-	// Check to see if this is inside an archive.
-	var resp *http.Response
-	if entry, ok := c.ArchiveMapper.Map(cdnHash); ok {
-		// We're inside an archive - make a Range request.
-		r.RetrievedCDNHash = entry.Archive
-		req, err := http.NewRequest(http.MethodGet, cdnURL(*c.CDNInfo, ngdp.ContentTypeData, entry.Archive, ""), nil)
-		if err != nil {
-			return nil, err
-		}
+	// Run the content through the BLTE decoder. It deserves it.
+	br := blte.NewReaderContext(ctx, r.Body)
+	if c.Keys != nil {
+		br.Keys = c.Keys
+	}
+	br.Verify = c.Verify
+	br.OnMismatch = func(err *blte.ChecksumError) {
+		glog.Warningf("%032x: %v", cdnHash, err)
+	}
+	r.Body = newWrappedCloser(br, r.Body)
+	if size, ok := br.Size(); ok {
+		r.Size = size
+	}
+	return r, nil
+}
 
-		req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", entry.Offset, entry.Offset+entry.Size))
+// FetchRaw retrieves a given file by its CDN hash, without decoding it.
+//
+// The Body of the returned Response is the file's BLTE-encoded data, and its ContentHash is left unset.
+func (c *Client) FetchRaw(ctx context.Context, cdnHash ngdp.CDNHash) (*Response, error) {
+	if c.Cache == nil {
+		return c.fetchRawFromCDN(ctx, cdnHash)
+	}
 
-		resp, err = c.LowLevelClient.do(ctx, req)
-		if err != nil {
-			return nil, err
-		}
+	body, err := c.Cache.Get(ctx, cdnHash)
+	if err == nil {
+		return &Response{
+			Body:             body,
+			CDNHash:          cdnHash,
+			RetrievedCDNHash: cdnHash,
+		}, nil
+	}
+	if err != blobstore.ErrNotExist {
+		glog.Warningf("Reading %032x from cache: %v", cdnHash, err)
+	}
 
-		if resp.StatusCode != http.StatusPartialContent {
-			return nil, errBadStatus{resp.StatusCode, resp.Status, http.StatusPartialContent}
-		}
-	} else {
+	r, err := c.fetchRawFromCDN(ctx, cdnHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file has to be read in full before it can be cached.
+	b, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Cache.Put(ctx, cdnHash, bytes.NewReader(b)); err != nil {
+		glog.Warningf("Writing %032x to cache: %v", cdnHash, err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return r, nil
+}
+
+func (c *Client) fetchRawFromCDN(ctx context.Context, cdnHash ngdp.CDNHash) (*Response, error) {
+	r := &Response{
+		CDNHash: cdnHash,
+	}
+
+	// Check to see if this is inside an archive.
+	entry, ok := c.ArchiveMapper.Map(cdnHash)
+	if !ok {
 		// We're not inside an archive, make a normal request.
 		r.RetrievedCDNHash = cdnHash
-		resp, err = c.LowLevelClient.get(ctx, *c.CDNInfo, ngdp.ContentTypeData, cdnHash, "")
+		body, err := c.LowLevelClient.FetchRaw(ctx, *c.CDNInfo, ngdp.ContentTypeData, cdnHash, "")
 		if err != nil {
 			return nil, err
 		}
-
-		if resp.StatusCode != http.StatusOK {
-			return nil, errBadStatus{resp.StatusCode, resp.Status, http.StatusOK}
-		}
+		r.Body = body
+		return r, nil
 	}
 
-	// Run the content through the BLTE decoder. It deserves it.
-	r.Body = newWrappedCloser(blte.NewReader(resp.Body), resp.Body)
+	// We're inside an archive - make a Range request.
+	r.RetrievedCDNHash = entry.Archive
+	body, err := c.LowLevelClient.FetchArchived(ctx, *c.CDNInfo, ngdp.ContentTypeData, entry)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = body
 	return r, nil
 }
 
@@ -194,3 +345,18 @@ func (c *Client) FetchFilename(ctx context.Context, fn string) (*Response, error
 
 	return c.Fetch(ctx, h)
 }
+
+// PatchArchiveMapper fetches the indices of the patch archives listed in the CDN config, so that patches can be found within them.
+//
+// Unlike the ArchiveMapper, it isn't created by New, since only clients which patch one build into another need it.
+func (c *Client) PatchArchiveMapper(ctx context.Context) (*PatchArchiveMapper, error) {
+	return c.LowLevelClient.NewPatchArchiveMapper(ctx, *c.CDNInfo, c.CDNConfig.PatchArchives)
+}
+
+// ProductConfig retrieves and parses the product config of the client's version.
+func (c *Client) ProductConfig(ctx context.Context) (*productconfig.Config, error) {
+	if c.VersionInfo.ProductConfig.Equal(ngdp.CDNHash{}) {
+		return nil, ErrNoProductConfig
+	}
+	return c.LowLevelClient.ProductConfig(ctx, *c.CDNInfo, *c.VersionInfo)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+func TestCDNHashStrategy(t *testing.T) {
+	loose := ngdp.CDNHash{0x01}
+	big := ngdp.CDNHash{0x02}
+	small := ngdp.CDNHash{0x03}
+	archive := ngdp.CDNHash{0xa1}
+	b, name := makeArchiveIndex(t, map[ngdp.CDNHash]ArchiveEntry{
+		big:   {archive, 5000, 0},
+		small: {archive, 100, 5000},
+	})
+	open := func(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	am, err := NewArchiveMapperFromIndices(context.Background(), []ngdp.CDNHash{name}, open)
+	if err != nil {
+		t.Fatalf("NewArchiveMapperFromIndices: %v", err)
+	}
+
+	for _, test := range []struct {
+		strategy CDNHashStrategy
+		hashes   []ngdp.CDNHash
+		want     ngdp.CDNHash
+	}{
+		{FirstCDNHash, []ngdp.CDNHash{loose, big, small}, loose},
+		{PreferArchived, []ngdp.CDNHash{loose, big, small}, big},
+		{PreferSmallest, []ngdp.CDNHash{loose, big, small}, small},
+		{PreferArchived, []ngdp.CDNHash{loose, {0x04}}, loose},
+		{PreferSmallest, []ngdp.CDNHash{loose, {0x04}}, loose},
+	} {
+		if got := test.strategy.choose(test.hashes, am); got != test.want {
+			t.Errorf("strategy %d: choose(%032x) = %032x; want %032x", test.strategy, test.hashes, got, test.want)
+		}
+	}
+}
+
+func TestClientProductConfig(t *testing.T) {
+	cdn, _ := objectServer(t, []byte(`{"all": {"config": {"product": "WoW"}}}`), 0)
+	cdn.ConfigPath = "tpr/configs/data"
+
+	c := &Client{
+		LowLevelClient: &LowLevelClient{},
+		CDNInfo:        &cdn,
+		VersionInfo:    &ngdp.VersionInfo{},
+	}
+	if _, err := c.ProductConfig(context.Background()); err != ErrNoProductConfig {
+		t.Errorf("ProductConfig without a product config hash = %v; want %v", err, ErrNoProductConfig)
+	}
+
+	c.VersionInfo.ProductConfig = ngdp.CDNHash{0x01}
+	pc, err := c.ProductConfig(context.Background())
+	if err != nil {
+		t.Fatalf("ProductConfig: %v", err)
+	}
+	s, err := pc.Settings("", "")
+	if err != nil || s.Product != "WoW" {
+		t.Errorf("Settings = %+v, %v; want product WoW", s, err)
+	}
+}
@@ -17,58 +17,200 @@ limitations under the License.
 package client
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/golang/glog"
 	"github.com/lukegb/snowstorm/blte"
 	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/armadillo"
 	"github.com/lukegb/snowstorm/ngdp/configtable"
 	"github.com/lukegb/snowstorm/ngdp/encoding"
 	"github.com/lukegb/snowstorm/ngdp/keyvalue"
+	"github.com/lukegb/snowstorm/ngdp/productconfig"
+	"github.com/lukegb/snowstorm/ngdp/tactkeys"
 	"github.com/pkg/errors"
 )
 
 var (
 	suffixCDNs     = "cdns"
 	suffixVersions = "versions"
+	suffixBlobs    = "blobs"
+	suffixGameBlob = "blob/game"
+	suffixInstBlob = "blob/install"
 )
 
 // A LowLevelClient provides simple wrappers to make basic NGDP operations easier.
 type LowLevelClient struct {
 	Client *http.Client
+
+	// ArmadilloKey, if set, is used to decrypt everything retrieved from the CDN.
+	ArmadilloKey *armadillo.Key
+
+	// PreferredHosts, if set, lists CDN hosts best first, such as from a previous run of RankProbes.
+	// CDN moves any of these hosts to the front of the list it returns.
+	PreferredHosts []string
+
+	// NoKeyRing stops New from fetching the keyring of versions which have one.
+	NoKeyRing bool
+
+	// Capture, if set, records the metadata of every request made to patch servers and CDNs.
+	Capture *Capture
+
+	// Failover retries CDN requests which fail transiently, trying each of the CDN's hosts.
+	// If nil, a Failover with the default settings, shared by every such client, is used.
+	Failover *Failover
+
+	// Segments, if more than one, makes Download and DownloadArchive fetch large objects in up to that many ranged segments at once, which is often faster than a single connection.
+	// Each segment is retried by itself, but an interrupted segmented download is started again from the beginning by the next call.
+	Segments int
+
+	// Progress, if set, is called as each object is read from the CDN, such as by Fetch, EncodingTable or when retrieving archive indices.
+	// It counts the bytes received from the CDN, before any decryption or decoding. During a segmented download it may be called from several goroutines at once.
+	Progress ProgressFunc
+
+	// RateLimit, if set, caps the rate at which responses from patch servers and CDNs are read. It may be shared with other clients to cap them all together.
+	RateLimit *RateLimiter
 }
 
 // Fetch retrieves a piece of data content by its CDNHash.
 func (c *LowLevelClient) Fetch(ctx context.Context, cdnInfo ngdp.CDNInfo, cdnHash ngdp.CDNHash) (io.ReadCloser, error) {
-	resp, err := c.get(ctx, cdnInfo, ngdp.ContentTypeData, cdnHash, "")
+	body, err := c.FetchRaw(ctx, cdnInfo, ngdp.ContentTypeData, cdnHash, "")
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errBadStatus{resp.StatusCode, resp.Status, http.StatusOK}
+	r := blte.NewReaderContext(ctx, body)
+	return newWrappedCloser(r, body), nil
+}
+
+// FetchRaw retrieves an object from the CDN exactly as it is stored, without BLTE decoding it.
+// If the client has an ArmadilloKey, the object is decrypted.
+//
+// The suffix is appended to the object's path; it is usually empty, or ".index" for archive indices.
+func (c *LowLevelClient) FetchRaw(ctx context.Context, cdnInfo ngdp.CDNInfo, contentType ngdp.ContentType, cdnHash ngdp.CDNHash, suffix string) (io.ReadCloser, error) {
+	resp, err := c.fetch(ctx, cdnInfo, cdnPath(cdnInfo, contentType, cdnHash, suffix), "", http.StatusOK,
+		attribute.String("ngdp.content_type", string(contentType)),
+		attribute.String("ngdp.hash", fmt.Sprintf("%032x%s", cdnHash, suffix)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	body := c.newProgress(cdnHash, 0, resp.ContentLength).wrap(resp.Body)
+	return c.decrypt(body, contentType, cdnHash, suffix, 0)
+}
+
+// FetchArchived retrieves a single object from within an archive, using a Range request.
+// If the client has an ArmadilloKey, the object is decrypted.
+func (c *LowLevelClient) FetchArchived(ctx context.Context, cdnInfo ngdp.CDNInfo, contentType ngdp.ContentType, entry ArchiveEntry) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-%d", entry.Offset, entry.Offset+entry.Size-1)
+	resp, err := c.fetch(ctx, cdnInfo, cdnPath(cdnInfo, contentType, entry.Archive, ""), byteRange, http.StatusPartialContent,
+		attribute.String("ngdp.content_type", string(contentType)),
+		attribute.String("ngdp.hash", fmt.Sprintf("%032x", entry.Archive)),
+		attribute.Int64("ngdp.offset", int64(entry.Offset)),
+		attribute.Int64("ngdp.size", int64(entry.Size)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	body := c.newProgress(entry.Archive, 0, int64(entry.Size)).wrap(resp.Body)
+	return c.decrypt(body, contentType, entry.Archive, "", int64(entry.Offset))
+}
+
+// decrypt removes Armadillo encryption from body, which holds the object named h starting offset bytes in.
+//
+// Without a key, it instead checks that configs and data look unencrypted, so that a missing key is reported clearly rather than as a parse error.
+func (c *LowLevelClient) decrypt(body io.ReadCloser, contentType ngdp.ContentType, h ngdp.CDNHash, suffix string, offset int64) (io.ReadCloser, error) {
+	if c.ArmadilloKey != nil {
+		r, err := c.ArmadilloKey.NewReader(body, h, offset)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		return newWrappedCloser(r, body), nil
 	}
 
-	r := blte.NewReader(resp.Body)
-	return newWrappedCloser(r, resp.Body), nil
+	var magic []byte
+	switch {
+	case suffix != "":
+		return body, nil
+	case contentType == ngdp.ContentTypeConfig:
+		magic = []byte("#")
+	case contentType == ngdp.ContentTypeData:
+		magic = []byte("BLTE")
+	default:
+		return body, nil
+	}
+
+	br := bufio.NewReader(body)
+	if got, err := br.Peek(len(magic)); err == nil && !bytes.Equal(got, magic) {
+		body.Close()
+		return nil, armadillo.ErrKeyRequired
+	}
+	return newWrappedCloser(br, body), nil
 }
 
-func (c *LowLevelClient) get(ctx context.Context, cdnInfo ngdp.CDNInfo, contentType ngdp.ContentType, cdnHash ngdp.CDNHash, suffix string) (*http.Response, error) {
+// fetch retrieves path from one of cdn's hosts, with byteRange as the Range header if it is set.
+// The response is returned only if its status is want; otherwise its body is closed and an error is returned.
+func (c *LowLevelClient) fetch(ctx context.Context, cdn ngdp.CDNInfo, path, byteRange string, want int, attrs ...attribute.KeyValue) (*http.Response, error) {
+	if len(cdn.Hosts) == 0 {
+		return nil, ErrNoHosts
+	}
+	try := func(host string) (*http.Response, error) {
+		return c.fetchFrom(ctx, host, path, byteRange, want, attrs...)
+	}
+	f := c.Failover
+	if f == nil {
+		f = defaultFailover
+	}
+	return f.do(ctx, cdn.Hosts, try)
+}
+
+// fetchFrom makes a single attempt at fetch, against host.
+func (c *LowLevelClient) fetchFrom(ctx context.Context, host, path, byteRange string, want int, attrs ...attribute.KeyValue) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, "http://"+host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Add("Range", byteRange)
+	}
 
-	req, err := http.NewRequest(http.MethodGet, cdnURL(cdnInfo, contentType, cdnHash, suffix), nil)
+	resp, err := c.do(ctx, req, attrs...)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.do(ctx, req)
+	if resp.StatusCode != want {
+		resp.Body.Close()
+		return nil, errBadStatus{resp.StatusCode, resp.Status, want}
+	}
+	return resp, nil
 }
 
-func (c *LowLevelClient) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+// do makes a request, tracing it with attrs as well as the request's own details.
+func (c *LowLevelClient) do(ctx context.Context, req *http.Request, attrs ...attribute.KeyValue) (*http.Response, error) {
+	// Only the host is attached to metrics, to keep their cardinality down.
+	hostAttrs := []attribute.KeyValue{attribute.String("net.peer.name", req.URL.Host)}
+	ctx, span := tracer.Start(ctx, "ngdp.request", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(append(attrs,
+		attribute.String("http.method", req.Method),
+		attribute.String("http.url", req.URL.String()),
+		attribute.String("net.peer.name", req.URL.Host),
+	)...))
+	start := time.Now()
 	req = req.WithContext(ctx)
 
 	cl := c.Client
@@ -76,16 +218,49 @@ func (c *LowLevelClient) do(ctx context.Context, req *http.Request) (*http.Respo
 		cl = http.DefaultClient
 	}
 
-	return cl.Do(req)
+	var ce CaptureEntry
+	if c.Capture != nil {
+		ce = c.Capture.begin(req, start)
+	}
+
+	resp, err := cl.Do(req)
+	if err != nil {
+		if c.Capture != nil {
+			c.Capture.failed(ce, err)
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		requestCount.Add(ctx, 1, metric.WithAttributes(append(hostAttrs, attribute.Int("http.status_code", 0))...))
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	requestCount.Add(ctx, 1, metric.WithAttributes(append(hostAttrs, attribute.Int("http.status_code", resp.StatusCode))...))
+
+	if c.Capture != nil {
+		resp.Body = c.Capture.responded(ce, resp)
+	}
+	if c.RateLimit != nil {
+		resp.Body = &limitedBody{resp.Body, ctx, c.RateLimit}
+	}
+	resp.Body = &tracedBody{
+		ReadCloser: resp.Body,
+		ctx:        ctx,
+		span:       span,
+		attrs:      hostAttrs,
+		start:      start,
+	}
+	return resp, nil
 }
 
-func (c *LowLevelClient) cdns(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) ([]ngdp.CDNInfo, error) {
+// CDNs retrieves the CDN information for a program, for every region.
+func (c *LowLevelClient) CDNs(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) ([]ngdp.CDNInfo, error) {
 	req, err := http.NewRequest(http.MethodGet, patchURL(program, region, suffixCDNs), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.do(ctx, req)
+	resp, err := c.do(ctx, req, attribute.String("ngdp.program", string(program)))
 	if err != nil {
 		return nil, err
 	}
@@ -96,26 +271,22 @@ func (c *LowLevelClient) cdns(ctx context.Context, program ngdp.ProgramCode, reg
 	}
 
 	var cdns []ngdp.CDNInfo
-	d := configtable.NewDecoder(resp.Body)
-	for {
-		var cdn ngdp.CDNInfo
-		if err := d.Decode(&cdn); err == io.EOF {
-			break
-		} else if err != nil {
-			return nil, err
-		}
-		cdns = append(cdns, cdn)
+	if err := configtable.DecodeAll(resp.Body, &cdns); err != nil {
+		return nil, err
 	}
 	return cdns, nil
 }
 
-func (c *LowLevelClient) versions(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) ([]ngdp.VersionInfo, error) {
+// Versions retrieves the version information for a program, for every region.
+//
+// The region is only used to pick which patch server to ask.
+func (c *LowLevelClient) Versions(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) ([]ngdp.VersionInfo, error) {
 	req, err := http.NewRequest(http.MethodGet, patchURL(program, region, suffixVersions), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.do(ctx, req)
+	resp, err := c.do(ctx, req, attribute.String("ngdp.program", string(program)))
 	if err != nil {
 		return nil, err
 	}
@@ -126,21 +297,15 @@ func (c *LowLevelClient) versions(ctx context.Context, program ngdp.ProgramCode,
 	}
 
 	var versions []ngdp.VersionInfo
-	d := configtable.NewDecoder(resp.Body)
-	for {
-		var version ngdp.VersionInfo
-		if err := d.Decode(&version); err == io.EOF {
-			break
-		} else if err != nil {
-			return nil, err
-		}
-		versions = append(versions, version)
+	if err := configtable.DecodeAll(resp.Body, &versions); err != nil {
+		return nil, err
 	}
 	return versions, nil
 }
 
-func cdnURL(cdnInfo ngdp.CDNInfo, contentType ngdp.ContentType, cdnHash ngdp.CDNHash, suffix string) string {
-	return fmt.Sprintf("http://%s/%s/%s/%02x/%02x/%032x%s", cdnInfo.Hosts[0], cdnInfo.Path, contentType, cdnHash[0], cdnHash[1], cdnHash, suffix)
+// cdnPath returns the path of an object on any of cdnInfo's hosts.
+func cdnPath(cdnInfo ngdp.CDNInfo, contentType ngdp.ContentType, cdnHash ngdp.CDNHash, suffix string) string {
+	return fmt.Sprintf("/%s/%s/%02x/%02x/%032x%s", cdnInfo.Path, contentType, cdnHash[0], cdnHash[1], cdnHash, suffix)
 }
 
 func patchURL(program ngdp.ProgramCode, region ngdp.Region, suffix string) string {
@@ -148,14 +313,17 @@ func patchURL(program ngdp.ProgramCode, region ngdp.Region, suffix string) strin
 }
 
 func (c *LowLevelClient) CDN(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) (ngdp.CDNInfo, error) {
-	cdns, err := c.cdns(ctx, program, region)
+	cdns, err := c.CDNs(ctx, program, region)
 	if err != nil {
 		return ngdp.CDNInfo{}, errors.Wrap(err, "retrieving CDN info")
 	}
 
-	for _, c := range cdns {
-		if c.Name == region {
-			return c, nil
+	for _, cdn := range cdns {
+		if cdn.Name == region {
+			if c.PreferredHosts != nil {
+				cdn.Hosts = preferHosts(cdn.Hosts, c.PreferredHosts)
+			}
+			return cdn, nil
 		}
 	}
 
@@ -163,7 +331,7 @@ func (c *LowLevelClient) CDN(ctx context.Context, program ngdp.ProgramCode, regi
 }
 
 func (c *LowLevelClient) Version(ctx context.Context, program ngdp.ProgramCode, region ngdp.Region) (ngdp.VersionInfo, error) {
-	versions, err := c.versions(ctx, program, region)
+	versions, err := c.Versions(ctx, program, region)
 	if err != nil {
 		return ngdp.VersionInfo{}, errors.Wrap(err, "retrieving version info")
 	}
@@ -178,37 +346,61 @@ func (c *LowLevelClient) Version(ctx context.Context, program ngdp.ProgramCode,
 }
 
 func (c *LowLevelClient) BuildConfig(ctx context.Context, cdn ngdp.CDNInfo, version ngdp.VersionInfo) (ngdp.BuildConfig, error) {
-	resp, err := c.get(ctx, cdn, ngdp.ContentTypeConfig, version.BuildConfig, "")
+	body, err := c.FetchRaw(ctx, cdn, ngdp.ContentTypeConfig, version.BuildConfig, "")
 	if err != nil {
 		return ngdp.BuildConfig{}, errors.Wrap(err, "retrieving build config")
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return ngdp.BuildConfig{}, errBadStatus{resp.StatusCode, resp.Status, http.StatusOK}
-	}
+	defer body.Close()
 
 	var buildConfig ngdp.BuildConfig
-	if err := keyvalue.Decode(resp.Body, &buildConfig); err != nil {
+	if err := keyvalue.Decode(body, &buildConfig); err != nil {
 		return ngdp.BuildConfig{}, errors.Wrap(err, "parsing build config")
 	}
 
 	return buildConfig, nil
 }
 
-func (c *LowLevelClient) CDNConfig(ctx context.Context, cdn ngdp.CDNInfo, version ngdp.VersionInfo) (ngdp.CDNConfig, error) {
-	resp, err := c.get(ctx, cdn, ngdp.ContentTypeConfig, version.CDNConfig, "")
+// KeyRing retrieves the encryption keys named by version's KeyRing. If the version has no KeyRing, the returned Keyring is empty.
+func (c *LowLevelClient) KeyRing(ctx context.Context, cdn ngdp.CDNInfo, version ngdp.VersionInfo) (*tactkeys.Keyring, error) {
+	if version.KeyRing.Equal(ngdp.CDNHash{}) {
+		return tactkeys.New(), nil
+	}
+
+	body, err := c.FetchRaw(ctx, cdn, ngdp.ContentTypeConfig, version.KeyRing, "")
 	if err != nil {
-		return ngdp.CDNConfig{}, errors.Wrap(err, "retrieving cdn config")
+		return nil, errors.Wrap(err, "retrieving keyring")
+	}
+	defer body.Close()
+
+	k, err := tactkeys.ReadConfig(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing keyring")
+	}
+	return k, nil
+}
+
+// ProductConfig retrieves the product config for version, from the CDN's config path.
+func (c *LowLevelClient) ProductConfig(ctx context.Context, cdn ngdp.CDNInfo, version ngdp.VersionInfo) (*productconfig.Config, error) {
+	h := version.ProductConfig
+	path := fmt.Sprintf("/%s/%02x/%02x/%032x", cdn.ConfigPath, h[0], h[1], h)
+	resp, err := c.fetch(ctx, cdn, path, "", http.StatusOK, attribute.String("ngdp.hash", fmt.Sprintf("%032x", h)))
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return ngdp.CDNConfig{}, errBadStatus{resp.StatusCode, resp.Status, http.StatusOK}
+	return productconfig.Parse(resp.Body)
+}
+
+func (c *LowLevelClient) CDNConfig(ctx context.Context, cdn ngdp.CDNInfo, version ngdp.VersionInfo) (ngdp.CDNConfig, error) {
+	body, err := c.FetchRaw(ctx, cdn, ngdp.ContentTypeConfig, version.CDNConfig, "")
+	if err != nil {
+		return ngdp.CDNConfig{}, errors.Wrap(err, "retrieving cdn config")
 	}
+	defer body.Close()
 
 	var cdnConfig ngdp.CDNConfig
-	if err := keyvalue.Decode(resp.Body, &cdnConfig); err != nil {
+	if err := keyvalue.Decode(body, &cdnConfig); err != nil {
 		return ngdp.CDNConfig{}, errors.Wrap(err, "parsing cdn config")
 	}
 
@@ -216,17 +408,13 @@ func (c *LowLevelClient) CDNConfig(ctx context.Context, cdn ngdp.CDNInfo, versio
 }
 
 func (c *LowLevelClient) EncodingTable(ctx context.Context, cdn ngdp.CDNInfo, encodingHash ngdp.CDNHash) (*encoding.Mapper, error) {
-	resp, err := c.get(ctx, cdn, ngdp.ContentTypeData, encodingHash, "")
+	body, err := c.FetchRaw(ctx, cdn, ngdp.ContentTypeData, encodingHash, "")
 	if err != nil {
 		return nil, errors.Wrap(err, "downloading encoding table")
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errBadStatus{resp.StatusCode, resp.Status, http.StatusOK}
-	}
+	defer body.Close()
 
-	mapper, err := encoding.NewMapper(blte.NewReader(resp.Body))
+	mapper, err := encoding.NewMapper(blte.NewReaderContext(ctx, body))
 	if err != nil {
 		return nil, errors.Wrap(err, "parsing encoding table")
 	}
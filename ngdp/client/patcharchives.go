@@ -0,0 +1,104 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+// readUintBE reads a big-endian unsigned integer of any width up to 8 bytes.
+func readUintBE(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// ReadPatchArchiveIndex parses the index of a single patch archive, returning the location of every patch it contains.
+//
+// Unlike ReadArchiveIndex, it takes the block size and the widths of each entry's fields from the index's footer,
+// since patch archive indices do not necessarily share the data archives' layout.
+func ReadPatchArchiveIndex(r io.Reader, archiveHash ngdp.CDNHash) (map[ngdp.CDNHash]ArchiveEntry, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < archiveIndexFooterSize {
+		return nil, fmt.Errorf("client: patch index is too short to contain a footer")
+	}
+	footer := b[len(b)-archiveIndexFooterSize:]
+	blockSize := int(footer[11]) * 1024
+	offsetBytes, sizeBytes, keySize := int(footer[12]), int(footer[13]), int(footer[14])
+	count := int(binary.LittleEndian.Uint32(footer[16:20]))
+
+	if keySize != len(ngdp.CDNHash{}) {
+		return nil, fmt.Errorf("client: patch index has %d-byte keys; only full keys are supported", keySize)
+	}
+	if offsetBytes > 4 || sizeBytes > 4 {
+		return nil, fmt.Errorf("client: patch index has %d-byte offsets and %d-byte sizes; at most 4 bytes are supported", offsetBytes, sizeBytes)
+	}
+	entrySize := keySize + sizeBytes + offsetBytes
+	if blockSize < entrySize {
+		return nil, fmt.Errorf("client: patch index has %d-byte blocks, too small for %d-byte entries", blockSize, entrySize)
+	}
+
+	perBlock := blockSize / entrySize
+	m := make(map[ngdp.CDNHash]ArchiveEntry, count)
+	for n := 0; n < count; n++ {
+		off := (n/perBlock)*blockSize + (n%perBlock)*entrySize
+		if off+entrySize > len(b)-archiveIndexFooterSize {
+			return nil, fmt.Errorf("client: patch index is truncated")
+		}
+		rec := b[off : off+entrySize]
+		var h ngdp.CDNHash
+		copy(h[:], rec)
+		m[h] = ArchiveEntry{
+			Archive: archiveHash,
+			Size:    uint32(readUintBE(rec[keySize : keySize+sizeBytes])),
+			Offset:  uint32(readUintBE(rec[keySize+sizeBytes:])),
+		}
+	}
+	return m, nil
+}
+
+// A PatchArchiveMapper maps patch CDN hashes to their location within the set of patch archives.
+type PatchArchiveMapper struct {
+	ArchiveMapper
+}
+
+// NewPatchArchiveMapper creates a new patch archive mapper from the provided set of patch archives, fetching their indices from the CDN.
+func (llc *LowLevelClient) NewPatchArchiveMapper(ctx context.Context, cdnInfo ngdp.CDNInfo, archives []ngdp.CDNHash) (*PatchArchiveMapper, error) {
+	return NewPatchArchiveMapperFromIndices(ctx, archives, func(ctx context.Context, archiveHash ngdp.CDNHash) (io.ReadCloser, error) {
+		return llc.FetchRaw(ctx, cdnInfo, ngdp.ContentTypePatch, archiveHash, ".index")
+	})
+}
+
+// NewPatchArchiveMapperFromIndices creates a new patch archive mapper from the provided set of patch archives, using open to retrieve their indices.
+func NewPatchArchiveMapperFromIndices(ctx context.Context, archives []ngdp.CDNHash, open ArchiveIndexOpener) (*PatchArchiveMapper, error) {
+	m, err := newArchiveMapper(ctx, archives, open, ReadPatchArchiveIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &PatchArchiveMapper{*m}, nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+func TestReadPatchArchiveIndex(t *testing.T) {
+	archive := ngdp.CDNHash{0xa1}
+	entries := make(map[ngdp.CDNHash]ArchiveEntry)
+	for i := 0; i < 200; i++ {
+		entries[ngdp.CDNHash(md5.Sum([]byte{byte(i)}))] = ArchiveEntry{archive, uint32(i + 1), uint32(i * 1000)}
+	}
+	b, _ := makeArchiveIndex(t, entries)
+
+	got, err := ReadPatchArchiveIndex(bytes.NewReader(b), archive)
+	if err != nil {
+		t.Fatalf("ReadPatchArchiveIndex: %v", err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("ReadPatchArchiveIndex returned %d entries; want %d", len(got), len(entries))
+	}
+}
+
+func TestPatchArchiveMapperNarrowOffsets(t *testing.T) {
+	// An index whose entries have 2-byte offsets, which ReadArchiveIndex can't parse.
+	archive := ngdp.CDNHash{0xa2}
+	h := ngdp.CDNHash{0x01, 0x02}
+	rec := make([]byte, 0x16)
+	copy(rec, h[:])
+	binary.BigEndian.PutUint32(rec[0x10:], 300)
+	binary.BigEndian.PutUint16(rec[0x14:], 0x1234)
+	var buf bytes.Buffer
+	if _, err := writeIndexBlocks(&buf, [][]byte{rec}, 0x16, 2); err != nil {
+		t.Fatalf("writeIndexBlocks: %v", err)
+	}
+
+	open := func(ctx context.Context, a ngdp.CDNHash) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	}
+	m, err := NewPatchArchiveMapperFromIndices(context.Background(), []ngdp.CDNHash{archive}, open)
+	if err != nil {
+		t.Fatalf("NewPatchArchiveMapperFromIndices: %v", err)
+	}
+
+	got, ok := m.Map(h)
+	if want := (ArchiveEntry{archive, 300, 0x1234}); !ok || got != want {
+		t.Errorf("Map(%032x) = %+v, %v; want %+v, true", h, got, ok, want)
+	}
+	if _, ok := m.Map(ngdp.CDNHash{0xff}); ok {
+		t.Errorf("Map of missing hash succeeded")
+	}
+}
+
+func TestClientPatchArchiveMapper(t *testing.T) {
+	h := ngdp.CDNHash{0x01}
+	b, name := makeArchiveIndex(t, map[ngdp.CDNHash]ArchiveEntry{h: {Size: 10, Offset: 20}})
+	cdn, _ := objectServer(t, b, 0)
+
+	c := &Client{
+		LowLevelClient: &LowLevelClient{},
+		CDNInfo:        &cdn,
+		CDNConfig:      &ngdp.CDNConfig{PatchArchives: []ngdp.CDNHash{name}},
+	}
+	m, err := c.PatchArchiveMapper(context.Background())
+	if err != nil {
+		t.Fatalf("PatchArchiveMapper: %v", err)
+	}
+	if got, ok := m.Map(h); !ok || got != (ArchiveEntry{name, 10, 20}) {
+		t.Errorf("Map(%032x) = %+v, %v; want %+v, true", h, got, ok, ArchiveEntry{name, 10, 20})
+	}
+}
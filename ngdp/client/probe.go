@@ -0,0 +1,121 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+// A HostProbe is the result of probing a single CDN host.
+type HostProbe struct {
+	Host string
+
+	// Latency is the time taken to receive the first byte of an archive index.
+	Latency time.Duration
+
+	// Throughput is the rate, in bytes per second, at which a sample of an archive was received.
+	Throughput float64
+
+	// Err is set if the host could not be probed; the other measurements are then meaningless.
+	Err error
+}
+
+// Probe measures how well host serves the objects of cdn: latency by fetching the index of archive, and throughput by fetching the first sampleSize bytes of the archive itself.
+func (c *LowLevelClient) Probe(ctx context.Context, cdn ngdp.CDNInfo, host string, archive ngdp.CDNHash, sampleSize int64) HostProbe {
+	p := HostProbe{Host: host}
+
+	// Probes go straight to host, without failover, so that they measure it alone.
+	start := time.Now()
+	resp, err := c.fetchFrom(ctx, host, cdnPath(cdn, ngdp.ContentTypeData, archive, ".index"), "", http.StatusOK)
+	if err != nil {
+		p.Err = err
+		return p
+	}
+	p.Latency = time.Since(start)
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		p.Err = err
+		return p
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+host+cdnPath(cdn, ngdp.ContentTypeData, archive, ""), nil)
+	if err != nil {
+		p.Err = err
+		return p
+	}
+	req.Header.Add("Range", fmt.Sprintf("bytes=0-%d", sampleSize-1))
+
+	start = time.Now()
+	resp, err = c.do(ctx, req)
+	if err != nil {
+		p.Err = err
+		return p
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		p.Err = errBadStatus{resp.StatusCode, resp.Status, http.StatusPartialContent}
+		return p
+	}
+	// Hosts which ignore the Range header send the whole archive, so stop reading once we have enough.
+	n, err := io.CopyN(ioutil.Discard, resp.Body, sampleSize)
+	if err != nil && err != io.EOF {
+		p.Err = err
+		return p
+	}
+	p.Throughput = float64(n) / time.Since(start).Seconds()
+	return p
+}
+
+// RankProbes sorts probes best first: hosts which worked before those which did not, and then by throughput.
+func RankProbes(probes []HostProbe) {
+	sort.SliceStable(probes, func(i, j int) bool {
+		if (probes[i].Err == nil) != (probes[j].Err == nil) {
+			return probes[i].Err == nil
+		}
+		return probes[i].Throughput > probes[j].Throughput
+	})
+}
+
+// preferHosts reorders hosts so that any listed in preferred come first, in the order they are listed there.
+func preferHosts(hosts, preferred []string) []string {
+	rank := make(map[string]int)
+	for n, h := range preferred {
+		if _, ok := rank[h]; !ok {
+			rank[h] = n
+		}
+	}
+	out := make([]string, len(hosts))
+	copy(out, hosts)
+	sort.SliceStable(out, func(i, j int) bool {
+		ri, iok := rank[out[i]]
+		rj, jok := rank[out[j]]
+		if iok != jok {
+			return iok
+		}
+		return iok && ri < rj
+	})
+	return out
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRankProbes(t *testing.T) {
+	probes := []HostProbe{
+		{Host: "broken", Err: errors.New("oops")},
+		{Host: "slow", Throughput: 10},
+		{Host: "fast", Throughput: 1000},
+	}
+	RankProbes(probes)
+
+	var got []string
+	for _, p := range probes {
+		got = append(got, p.Host)
+	}
+	if want := []string{"fast", "slow", "broken"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RankProbes order = %v; want %v", got, want)
+	}
+}
+
+func TestPreferHosts(t *testing.T) {
+	hosts := []string{"a", "b", "c", "d"}
+	got := preferHosts(hosts, []string{"c", "x", "a"})
+	if want := []string{"c", "a", "b", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("preferHosts = %v; want %v", got, want)
+	}
+	if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("preferHosts modified its input: %v", hosts)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+// A ProgressFunc is told how much of the object named h has been downloaded so far. Total is the size of the whole object, or -1 if it isn't known.
+type ProgressFunc func(done, total int64, h ngdp.CDNHash)
+
+// progress counts the bytes downloaded of a single object, reporting them to a ProgressFunc. A nil *progress counts nothing.
+type progress struct {
+	fn    ProgressFunc
+	h     ngdp.CDNHash
+	total int64
+	done  atomic.Int64
+}
+
+// newProgress starts counting the download of h, if the client has a Progress function.
+func (c *LowLevelClient) newProgress(h ngdp.CDNHash, done, total int64) *progress {
+	if c.Progress == nil {
+		return nil
+	}
+	p := &progress{fn: c.Progress, h: h, total: total}
+	p.done.Store(done)
+	return p
+}
+
+func (p *progress) add(n int) {
+	if p == nil || n <= 0 {
+		return
+	}
+	p.fn(p.done.Add(int64(n)), p.total, p.h)
+}
+
+// wrap returns body, counting what is read from it.
+func (p *progress) wrap(body io.ReadCloser) io.ReadCloser {
+	if p == nil {
+		return body
+	}
+	return progressReader{body, p}
+}
+
+type progressReader struct {
+	io.ReadCloser
+	p *progress
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.p.add(n)
+	return n, err
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/md5"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+// progressRecorder records the most progress reported for each object.
+type progressRecorder struct {
+	mu    sync.Mutex
+	done  map[ngdp.CDNHash]int64
+	total map[ngdp.CDNHash]int64
+}
+
+func (r *progressRecorder) report(done, total int64, h ngdp.CDNHash) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done == nil {
+		r.done = make(map[ngdp.CDNHash]int64)
+		r.total = make(map[ngdp.CDNHash]int64)
+	}
+	if done > r.done[h] {
+		r.done[h] = done
+	}
+	r.total[h] = total
+}
+
+func TestProgress(t *testing.T) {
+	data := []byte("# config\n" + strings.Repeat("key = value\n", 3*minSegmentSize/12))
+	h := ngdp.CDNHash(md5.Sum(data))
+	cdn, _ := objectServer(t, data, 0)
+	ctx := context.Background()
+
+	for _, segments := range []int{0, 3} {
+		var rec progressRecorder
+		c := &LowLevelClient{Progress: rec.report, Segments: segments}
+
+		body, err := c.FetchRaw(ctx, cdn, ngdp.ContentTypeConfig, h, "")
+		if err != nil {
+			t.Fatalf("FetchRaw: %v", err)
+		}
+		ioutil.ReadAll(body)
+		body.Close()
+		if rec.done[h] != int64(len(data)) || rec.total[h] != int64(len(data)) {
+			t.Errorf("FetchRaw reported %d of %d bytes; want %d of %d", rec.done[h], rec.total[h], len(data), len(data))
+		}
+
+		rec = progressRecorder{}
+		if err := c.Download(ctx, cdn, ngdp.ContentTypeConfig, h, "", filepath.Join(t.TempDir(), "config")); err != nil {
+			t.Fatalf("Download: %v", err)
+		}
+		if rec.done[h] != int64(len(data)) || rec.total[h] != int64(len(data)) {
+			t.Errorf("Download with %d segments reported %d of %d bytes; want %d of %d", segments, rec.done[h], rec.total[h], len(data), len(data))
+		}
+	}
+}
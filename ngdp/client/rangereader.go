@@ -0,0 +1,174 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/golang/glog"
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+)
+
+// rangeReadAhead is the least a rangeReaderAt fetches at once, so that the many small reads of a BLTE header don't each become a request.
+const rangeReadAhead = 64 << 10
+
+// OpenRaw provides random access to the BLTE-encoded data of a file by its CDN hash, returning its length alongside it.
+//
+// Unlike FetchRaw, it doesn't download the whole file: each read becomes a Range request for just the bytes needed, from the archive holding the file if there is one.
+// ctx is used for every read. If the file is in the cache, it is read from there instead.
+func (c *Client) OpenRaw(ctx context.Context, cdnHash ngdp.CDNHash) (io.ReaderAt, int64, error) {
+	if c.Cache != nil {
+		body, err := c.Cache.Get(ctx, cdnHash)
+		if err == nil {
+			b, err := ioutil.ReadAll(body)
+			body.Close()
+			if err != nil {
+				return nil, 0, err
+			}
+			return bytes.NewReader(b), int64(len(b)), nil
+		}
+		if err != blobstore.ErrNotExist {
+			glog.Warningf("Reading %032x from cache: %v", cdnHash, err)
+		}
+	}
+
+	if entry, ok := c.ArchiveMapper.Map(cdnHash); ok {
+		ra := c.LowLevelClient.newRangeReaderAt(ctx, *c.CDNInfo, entry.Archive, int64(entry.Offset), int64(entry.Size))
+		return ra, ra.size, nil
+	}
+
+	// Ask for a single byte to learn the file's length.
+	resp, err := c.LowLevelClient.fetch(ctx, *c.CDNInfo, cdnPath(*c.CDNInfo, ngdp.ContentTypeData, cdnHash, ""), "bytes=0-0", http.StatusPartialContent,
+		attribute.String("ngdp.content_type", string(ngdp.ContentTypeData)),
+		attribute.String("ngdp.hash", fmt.Sprintf("%032x", cdnHash)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp.Body.Close()
+	_, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	if !ok || total < 0 {
+		return nil, 0, fmt.Errorf("client: unexpected Content-Range %q for %032x", resp.Header.Get("Content-Range"), cdnHash)
+	}
+	ra := c.LowLevelClient.newRangeReaderAt(ctx, *c.CDNInfo, cdnHash, 0, total)
+	return ra, ra.size, nil
+}
+
+// A rangeReaderAt reads the size bytes starting at base in the data object h on the CDN, using Range requests.
+// It keeps the most recent block it fetched, so that neighbouring small reads are answered without another request.
+type rangeReaderAt struct {
+	c    *LowLevelClient
+	ctx  context.Context
+	cdn  ngdp.CDNInfo
+	h    ngdp.CDNHash
+	base int64
+	size int64
+	prog *progress
+
+	mu     sync.Mutex
+	buf    []byte
+	bufOff int64
+}
+
+func (c *LowLevelClient) newRangeReaderAt(ctx context.Context, cdn ngdp.CDNInfo, h ngdp.CDNHash, base, size int64) *rangeReaderAt {
+	return &rangeReaderAt{
+		c:    c,
+		ctx:  ctx,
+		cdn:  cdn,
+		h:    h,
+		base: base,
+		size: size,
+		prog: c.newProgress(h, 0, size),
+	}
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *rangeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("client: negative offset %d", off)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	want := int64(len(p))
+	if want > r.size-off {
+		want = r.size - off
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if off < r.bufOff || off+want > r.bufOff+int64(len(r.buf)) {
+		n := want
+		if n < rangeReadAhead {
+			n = rangeReadAhead
+		}
+		if n > r.size-off {
+			n = r.size - off
+		}
+		b, err := r.fetch(off, n)
+		if err != nil {
+			return 0, err
+		}
+		r.buf, r.bufOff = b, off
+	}
+	n := copy(p, r.buf[off-r.bufOff:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetch retrieves n bytes starting at off.
+func (r *rangeReaderAt) fetch(off, n int64) ([]byte, error) {
+	start := r.base + off
+	resp, err := r.c.fetch(r.ctx, r.cdn, cdnPath(r.cdn, ngdp.ContentTypeData, r.h, ""), fmt.Sprintf("bytes=%d-%d", start, start+n-1), http.StatusPartialContent,
+		attribute.String("ngdp.content_type", string(ngdp.ContentTypeData)),
+		attribute.String("ngdp.hash", fmt.Sprintf("%032x", r.h)),
+		attribute.Int64("ngdp.offset", start),
+		attribute.Int64("ngdp.size", n),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if got, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || got != start {
+		resp.Body.Close()
+		return nil, fmt.Errorf("client: unexpected Content-Range %q fetching from %d", resp.Header.Get("Content-Range"), start)
+	}
+
+	body := r.prog.wrap(resp.Body)
+	if r.c.ArmadilloKey != nil || off == 0 {
+		if body, err = r.c.decrypt(body, ngdp.ContentTypeData, r.h, "", start); err != nil {
+			return nil, err
+		}
+	}
+	defer body.Close()
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(body, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+func TestOpenRaw(t *testing.T) {
+	object := []byte("BLTE" + strings.Repeat("0123456789", 100000))
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		name   string
+		served []byte
+		mapper *ArchiveMapper
+		base   int64
+	}{
+		{"loose", object, &ArchiveMapper{}, 0},
+		{"archived", append(append([]byte("other files"), object...), "more files"...), nil, int64(len("other files"))},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h, archive := ngdp.CDNHash{1}, ngdp.CDNHash{2}
+			mapper := test.mapper
+			if mapper == nil {
+				mapper = &ArchiveMapper{archiveIndexEntries{{file: &h, archive: &archive, size: uint32(len(object)), offset: uint32(test.base)}}}
+			}
+			cdn, ranges := objectServer(t, test.served, 0)
+			c := &Client{
+				LowLevelClient: &LowLevelClient{},
+				CDNInfo:        &cdn,
+				ArchiveMapper:  mapper,
+			}
+
+			ra, size, err := c.OpenRaw(ctx, h)
+			if err != nil {
+				t.Fatalf("OpenRaw: %v", err)
+			}
+			if size != int64(len(object)) {
+				t.Errorf("OpenRaw size = %d; want %d", size, len(object))
+			}
+			before := len(ranges())
+
+			// Small reads close together share a request.
+			for _, off := range []int64{500000, 500010, 0, 4} {
+				got := make([]byte, 10)
+				if _, err := ra.ReadAt(got, off); err != nil {
+					t.Fatalf("ReadAt(%d): %v", off, err)
+				}
+				if want := object[off : off+10]; !bytes.Equal(got, want) {
+					t.Errorf("ReadAt(%d) = %q; want %q", off, got, want)
+				}
+			}
+			if got := len(ranges()) - before; got != 2 {
+				t.Errorf("ReadAt made %d requests; want 2", got)
+			}
+
+			got := make([]byte, 10)
+			if n, err := ra.ReadAt(got, size-5); n != 5 || err != io.EOF {
+				t.Errorf("ReadAt past the end = %d, %v; want 5, io.EOF", n, err)
+			}
+			if !bytes.Equal(got[:5], object[len(object)-5:]) {
+				t.Errorf("ReadAt past the end read %q; want %q", got[:5], object[len(object)-5:])
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// A RateLimiter caps the rate at which responses are read, using a token bucket which holds up to a second's worth of bytes.
+//
+// A RateLimiter may be shared between clients, limiting them all together. It is safe for concurrent use.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter which allows bytesPerSecond bytes to be read each second.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{rate: float64(bytesPerSecond), tokens: float64(bytesPerSecond)}
+}
+
+// SetRate changes the limit to bytesPerSecond, such as when an updater moves between the foreground and the background.
+func (l *RateLimiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = float64(bytesPerSecond)
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+}
+
+// refill adds the tokens earned since the last refill. l.mu must be held.
+func (l *RateLimiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+	}
+	l.last = now
+}
+
+// reserve takes n tokens from the bucket, returning how long to wait before using them.
+// The bucket may go into debt, which later callers wait for in turn.
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// wait blocks until n more bytes may be read.
+func (l *RateLimiter) wait(ctx context.Context, n int) error {
+	delay := l.reserve(n)
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limitedBody is a response body read no faster than its RateLimiter allows.
+type limitedBody struct {
+	io.ReadCloser
+	ctx context.Context
+	l   *RateLimiter
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if lerr := b.l.wait(b.ctx, n); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(1000)
+	if d := l.reserve(1000); d != 0 {
+		t.Errorf("reserve from a full bucket = %v; want 0", d)
+	}
+	// The bucket is empty, so further reads wait for it to refill, each after the last.
+	if d := l.reserve(500); d < 400*time.Millisecond || d > 500*time.Millisecond {
+		t.Errorf("reserve(500) from an empty bucket = %v; want about 500ms", d)
+	}
+	if d := l.reserve(500); d < 900*time.Millisecond || d > time.Second {
+		t.Errorf("second reserve(500) = %v; want about 1s", d)
+	}
+
+	l.SetRate(10000)
+	if d := l.reserve(10); d < 90*time.Millisecond || d > 102*time.Millisecond {
+		t.Errorf("reserve after SetRate = %v; want the debt repaid at the new rate, about 100ms", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx, 10000); err != context.Canceled {
+		t.Errorf("wait with a cancelled context = %v; want %v", err, context.Canceled)
+	}
+}
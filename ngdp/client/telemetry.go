@@ -0,0 +1,83 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Requests are traced and measured using the global OpenTelemetry providers, which do nothing unless a program installs real ones.
+const instrumentationName = "github.com/lukegb/snowstorm/ngdp/client"
+
+var (
+	tracer = otel.Tracer(instrumentationName)
+	meter  = otel.Meter(instrumentationName)
+
+	requestCount, _    = meter.Int64Counter("ngdp.client.requests", metric.WithDescription("Requests made to CDN and patch servers"))
+	requestBytes, _    = meter.Int64Counter("ngdp.client.bytes", metric.WithDescription("Bytes of response bodies read from CDN and patch servers"), metric.WithUnit("By"))
+	requestDuration, _ = meter.Float64Histogram("ngdp.client.duration", metric.WithDescription("Time from sending a request to closing its response body"), metric.WithUnit("s"))
+)
+
+// tracedBody ends a request's span, and records its metrics, when the response body is closed.
+type tracedBody struct {
+	io.ReadCloser
+
+	ctx   context.Context
+	span  trace.Span
+	attrs []attribute.KeyValue
+	start time.Time
+
+	n      int64
+	err    error
+	closed bool
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.closed {
+		return err
+	}
+	b.closed = true
+
+	b.span.SetAttributes(attribute.Int64("ngdp.bytes", b.n))
+	if b.err != nil {
+		b.span.RecordError(b.err)
+		b.span.SetStatus(codes.Error, b.err.Error())
+	}
+	b.span.End()
+
+	requestBytes.Add(b.ctx, b.n, metric.WithAttributes(b.attrs...))
+	requestDuration.Record(b.ctx, time.Since(b.start).Seconds(), metric.WithAttributes(b.attrs...))
+	return err
+}
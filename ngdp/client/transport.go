@@ -0,0 +1,101 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPOptions describe the *http.Client that NewHTTPClient builds for a LowLevelClient, which uses it for both patch server and CDN requests.
+type HTTPOptions struct {
+	// Transport, if set, makes the requests. Otherwise, a copy of http.DefaultTransport is used.
+	// Proxy, TLSConfig and ResponseHeaderTimeout can only be used with the default transport, or one which is an *http.Transport.
+	Transport http.RoundTripper
+
+	// Proxy, if set, is the URL of the proxy to send every request through, such as http://proxy:3128 or socks5://localhost:1080.
+	// Otherwise, the environment's proxy settings are used, as with http.ProxyFromEnvironment.
+	Proxy string
+
+	// TLSConfig, if set, configures TLS connections, such as to trust an intercepting proxy's certificate.
+	TLSConfig *tls.Config
+
+	// UserAgent, if set, is sent with every request which doesn't already have a User-Agent.
+	UserAgent string
+
+	// Timeout limits the time taken by each request, including reading its response. Zero means no limit.
+	// It covers the whole transfer, so it should be generous enough for the largest archive over the slowest link.
+	Timeout time.Duration
+
+	// ResponseHeaderTimeout limits how long to wait for a server to start responding to each request, but not how long reading the response takes. Zero means no limit.
+	ResponseHeaderTimeout time.Duration
+}
+
+// NewHTTPClient creates an *http.Client as described by opts.
+func NewHTTPClient(opts HTTPOptions) (*http.Client, error) {
+	rt := opts.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if opts.Proxy != "" || opts.TLSConfig != nil || opts.ResponseHeaderTimeout != 0 {
+		t, ok := rt.(*http.Transport)
+		if !ok {
+			return nil, fmt.Errorf("client: can't set a proxy, TLS config or response header timeout on a %T", rt)
+		}
+		t = t.Clone()
+		if opts.Proxy != "" {
+			u, err := url.Parse(opts.Proxy)
+			if err != nil {
+				return nil, fmt.Errorf("client: bad proxy URL: %v", err)
+			}
+			t.Proxy = http.ProxyURL(u)
+		}
+		if opts.TLSConfig != nil {
+			t.TLSClientConfig = opts.TLSConfig
+		}
+		if opts.ResponseHeaderTimeout != 0 {
+			t.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+		}
+		rt = t
+	}
+	if opts.UserAgent != "" {
+		rt = userAgentTransport{rt, opts.UserAgent}
+	}
+	return &http.Client{
+		Transport: rt,
+		Timeout:   opts.Timeout,
+	}, nil
+}
+
+// userAgentTransport sets the User-Agent of requests which don't have one.
+type userAgentTransport struct {
+	http.RoundTripper
+	userAgent string
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") != "" {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the request they are given.
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.RoundTripper.RoundTrip(req)
+}
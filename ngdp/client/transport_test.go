@@ -0,0 +1,104 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestNewHTTPClient(t *testing.T) {
+	// An HTTP proxy receives requests for other hosts.
+	var gotHost, gotUserAgent string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost, gotUserAgent = r.URL.Host, r.UserAgent()
+	}))
+	defer proxy.Close()
+
+	cl, err := NewHTTPClient(HTTPOptions{Proxy: proxy.URL, UserAgent: "snowstorm-test/1"})
+	if err != nil {
+		t.Fatalf("NewHTTPClient: %v", err)
+	}
+	resp, err := cl.Get("http://cdn.example.invalid/tpr/hero/config/00/00/0000")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if gotHost != "cdn.example.invalid" {
+		t.Errorf("proxy saw a request for %q; want %q", gotHost, "cdn.example.invalid")
+	}
+	if gotUserAgent != "snowstorm-test/1" {
+		t.Errorf("User-Agent = %q; want %q", gotUserAgent, "snowstorm-test/1")
+	}
+
+	// A custom transport is used as-is, but can't be given a proxy.
+	custom := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, http.ErrNotSupported
+	})
+	if _, err := NewHTTPClient(HTTPOptions{Transport: custom}); err != nil {
+		t.Errorf("NewHTTPClient with a custom transport: %v", err)
+	}
+	if _, err := NewHTTPClient(HTTPOptions{Transport: custom, Proxy: proxy.URL}); err == nil {
+		t.Errorf("NewHTTPClient with a custom transport and a proxy succeeded")
+	}
+}
+
+func TestNewHTTPClientResponseHeaderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-headers" {
+			<-release
+			return
+		}
+		// A slow body is fine, as long as the headers arrive in time.
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	cl, err := NewHTTPClient(HTTPOptions{ResponseHeaderTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewHTTPClient: %v", err)
+	}
+	if cl.Timeout != 0 {
+		t.Errorf("client Timeout = %v; want 0, so that whole transfers aren't limited", cl.Timeout)
+	}
+
+	resp, err := cl.Get(srv.URL + "/slow-body")
+	if err != nil {
+		t.Fatalf("Get with a slow body: %v", err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(b) != "ok" {
+		t.Errorf("reading a slow body = %q, %v; want %q", b, err, "ok")
+	}
+
+	if resp, err := cl.Get(srv.URL + "/slow-headers"); err == nil {
+		resp.Body.Close()
+		t.Errorf("Get with slow headers succeeded; want a timeout")
+	}
+}
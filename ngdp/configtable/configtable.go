@@ -21,6 +21,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
@@ -31,8 +32,50 @@ const (
 	columnDelimiter = "|"
 
 	structTag = "configtable"
+
+	commentPrefix = "#"
+	seqnPrefix    = "## seqn = "
 )
 
+// A ColumnType decodes the values of a kind of column which isn't built in, for tables with columns of new types.
+type ColumnType interface {
+	// CanDecode reports whether values from a column of this type, byteLen bytes wide, can be decoded into fields of type to.
+	// Fields of type string can always be decoded into: they are given the raw value, without calling the ColumnType.
+	CanDecode(byteLen int, to reflect.Type) bool
+
+	// Decode decodes value into to, whose type CanDecode has accepted.
+	Decode(byteLen int, value string, to reflect.Value) error
+}
+
+var columnTypes = map[string]ColumnType{}
+
+// RegisterType makes tables with columns of the given type readable, replacing any ColumnType already registered for it. The name is matched case-insensitively, and can't be one of the built-in STRING, HEX or DEC.
+//
+// Types should be registered before any tables are decoded, as the registry isn't locked.
+func RegisterType(name string, t ColumnType) {
+	name = strings.ToLower(name)
+	if name == "string" || name == "hex" || name == "dec" {
+		panic(fmt.Sprintf("configtable: cannot replace built-in type %q", name))
+	}
+	columnTypes[name] = t
+}
+
+var bigIntType = reflect.TypeOf(big.Int{})
+
+// isBigInt reports whether t is big.Int or *big.Int.
+func isBigInt(t reflect.Type) bool {
+	return t == bigIntType || (t.Kind() == reflect.Ptr && t.Elem() == bigIntType)
+}
+
+// isInt reports whether k is one of the integer kinds byteWidth handles.
+func isInt(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
 type column struct {
 	name    string
 	colType string
@@ -41,24 +84,80 @@ type column struct {
 
 // A Decoder reads a Blizzard config table from an input stream.
 type Decoder struct {
+	// Strict makes Decode fail if an exported field of the struct has no matching column, rather than leaving it unset. This catches columns being renamed or removed.
+	Strict bool
+
+	// Lenient makes rows with fewer or more columns than the header decode anyway: missing columns are left unset, and extra ones ignored. Otherwise such a row is an error, which stops the Decoder.
+	// It also accepts columns of unknown types, which can only be decoded into strings.
+	Lenient bool
+
 	columns     []column
 	columnNames map[string]int
 	s           *bufio.Scanner
 	err         error
+
+	seqn int
+
+	// peeked holds a line read ahead by Seqn, if hasPeeked is set.
+	peeked    string
+	hasPeeked bool
 }
 
+// line returns the next line which isn't a comment, noting the sequence number if it passes one.
 func (d *Decoder) line() (string, error) {
-	if d.err != nil {
-		return "", d.err
+	if d.hasPeeked {
+		d.hasPeeked = false
+		return d.peeked, nil
 	}
-	if !d.s.Scan() {
-		d.err = d.s.Err()
-		if d.err == nil {
-			d.err = io.EOF
+	for {
+		if d.err != nil {
+			return "", d.err
+		}
+		if !d.s.Scan() {
+			d.err = d.s.Err()
+			if d.err == nil {
+				d.err = io.EOF
+			}
+			return "", d.err
+		}
+		// bufio.ScanLines drops one carriage return before each newline, but not any more.
+		ln := strings.TrimRight(d.s.Text(), "\r")
+		if strings.HasPrefix(ln, seqnPrefix) {
+			seqn, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(ln, seqnPrefix)))
+			if err != nil {
+				d.err = fmt.Errorf("configtable: parsing sequence number: %v", err)
+				return "", d.err
+			}
+			d.seqn = seqn
+			continue
 		}
-		return "", d.err
+		if strings.HasPrefix(ln, commentPrefix) || strings.TrimSpace(ln) == "" {
+			continue
+		}
+		return ln, nil
 	}
-	return d.s.Text(), nil
+}
+
+// Seqn returns the table's sequence number, from its "## seqn = N" comment line, or 0 if it has none.
+// Blizzard bumps the sequence number whenever the data changes, so a poller can compare it to skip decoding unchanged tables.
+//
+// The sequence number usually follows the header, so Seqn reads up to the first row to find it; it is still returned by the next call to Decode.
+func (d *Decoder) Seqn() (int, error) {
+	if err := d.readHeader(); err == io.EOF {
+		return d.seqn, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if !d.hasPeeked {
+		ln, err := d.line()
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if err == nil {
+			d.peeked, d.hasPeeked = ln, true
+		}
+	}
+	return d.seqn, nil
 }
 
 func (d *Decoder) readHeader() error {
@@ -71,7 +170,8 @@ func (d *Decoder) readHeader() error {
 	if err != nil {
 		return err
 	}
-	fullHeaders := strings.Split(headerLine, columnDelimiter)
+	// Some tables have a trailing delimiter after the last column.
+	fullHeaders := strings.Split(strings.TrimSuffix(headerLine, columnDelimiter), columnDelimiter)
 
 	columns := make([]column, len(fullHeaders))
 	columnNames := make(map[string]int)
@@ -93,7 +193,8 @@ func (d *Decoder) readHeader() error {
 			return d.err
 		}
 
-		if blizzType[0] != "string" && blizzType[0] != "hex" && blizzType[0] != "dec" {
+		_, custom := columnTypes[blizzType[0]]
+		if blizzType[0] != "string" && blizzType[0] != "hex" && blizzType[0] != "dec" && !custom && !d.Lenient {
 			d.err = fmt.Errorf("configtable: unsupported type %q", bits[1])
 			return d.err
 		}
@@ -143,7 +244,11 @@ func isValidPairing(from column, to reflect.Type) bool {
 		// can convert "string" into a slice of strings
 		return true
 
-	case from.colType == "dec":
+	case from.colType == "dec" && isBigInt(to):
+		// can convert dec of any width into a big.Int
+		return true
+
+	case from.colType == "dec" && isInt(k):
 		// can convert dec into an integer of sufficient width
 		bw, _ := byteWidth(k)
 		return bw >= from.byteLen
@@ -157,6 +262,9 @@ func isValidPairing(from column, to reflect.Type) bool {
 			// can convert hex into an array of bytes of exactly the correct length
 			return to.Len() == from.byteLen
 		}
+
+	case columnTypes[from.colType] != nil:
+		return columnTypes[from.colType].CanDecode(from.byteLen, to)
 	}
 	return false
 }
@@ -177,6 +285,17 @@ func convertTo(columnDelimiter *string, from column, value string, to reflect.Va
 		bitsV := reflect.ValueOf(bits)
 		to.Set(bitsV)
 
+	case from.colType == "dec" && isBigInt(to.Type()):
+		v, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return fmt.Errorf("parsing %q: not a decimal integer", value)
+		}
+		if k == reflect.Ptr {
+			to.Set(reflect.ValueOf(v))
+		} else {
+			to.Set(reflect.ValueOf(v).Elem())
+		}
+
 	case from.colType == "dec":
 		// can convert dec into an integer of sufficient width
 		bw, unsigned := byteWidth(k)
@@ -214,11 +333,125 @@ func convertTo(columnDelimiter *string, from column, value string, to reflect.Va
 				to.Index(newN).SetUint(uint64(v))
 			}
 		}
+
+	case columnTypes[from.colType] != nil:
+		return columnTypes[from.colType].Decode(from.byteLen, value, to)
 	}
 
 	return nil
 }
 
+// row reads the next row of the table, split into its columns.
+func (d *Decoder) row() ([]string, error) {
+	ln, err := d.line()
+	if err != nil {
+		return nil, err
+	}
+
+	bits := strings.Split(ln, columnDelimiter)
+	if len(bits) == len(d.columns)+1 && bits[len(bits)-1] == "" {
+		// A trailing delimiter, as on the header.
+		bits = bits[:len(d.columns)]
+	}
+	switch {
+	case len(bits) == len(d.columns):
+	case !d.Lenient:
+		d.err = fmt.Errorf("configtable: column count mismatch: saw %d columns, expected %d", len(bits), len(d.columns))
+		return nil, d.err
+	case len(bits) > len(d.columns):
+		bits = bits[:len(d.columns)]
+	}
+	return bits, nil
+}
+
+// ColumnInfo describes one column of a config table, as declared in its header.
+type ColumnInfo struct {
+	Name string
+
+	// Type is the column's type, lowercased: "string", "hex", "dec", or one added with RegisterType.
+	Type string
+
+	// ByteLen is the width the header gives the column, in bytes; it is 0 for most strings.
+	ByteLen int
+}
+
+// String formats the column as it appears in the header, such as "BuildConfig!HEX:16".
+func (c ColumnInfo) String() string {
+	return fmt.Sprintf("%s%s%s:%d", c.Name, typeDelimiter, strings.ToUpper(c.Type), c.ByteLen)
+}
+
+// Columns returns the table's columns, in order, reading the header if it hasn't been already.
+func (d *Decoder) Columns() ([]ColumnInfo, error) {
+	if err := d.readHeader(); err != nil {
+		return nil, err
+	}
+	columns := make([]ColumnInfo, len(d.columns))
+	for n, c := range d.columns {
+		columns[n] = ColumnInfo{c.name, c.colType, c.byteLen}
+	}
+	return columns, nil
+}
+
+// DecodeMap decodes a line from the config table into a map from column names to their values, for when the table's columns aren't known ahead of time.
+func (d *Decoder) DecodeMap() (map[string]string, error) {
+	if err := d.readHeader(); err != nil {
+		return nil, err
+	}
+	bits, err := d.row()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(bits))
+	for n, s := range bits {
+		m[d.columns[n].name] = s
+	}
+	return m, nil
+}
+
+// DecodeTyped is like DecodeMap, but converts each value according to its column's type: STRING columns become strings, DEC columns int64s (or *big.Ints, if wider than 8 bytes) and HEX columns []bytes.
+// Values of other types are left as strings.
+func (d *Decoder) DecodeTyped() (map[string]interface{}, error) {
+	if err := d.readHeader(); err != nil {
+		return nil, err
+	}
+	bits, err := d.row()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{}, len(bits))
+	for n, s := range bits {
+		c := d.columns[n]
+		switch c.colType {
+		case "dec":
+			if c.byteLen > 8 {
+				v, ok := new(big.Int).SetString(s, 10)
+				if !ok {
+					d.err = fmt.Errorf("configtable: parsing %q: not a decimal integer", s)
+					return nil, d.err
+				}
+				m[c.name] = v
+				continue
+			}
+			v, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				d.err = fmt.Errorf("configtable: parsing %q: %v", s, err)
+				return nil, d.err
+			}
+			m[c.name] = v
+		case "hex":
+			v, err := hex.DecodeString(s)
+			if err != nil {
+				d.err = fmt.Errorf("configtable: parsing %q: %v", s, err)
+				return nil, d.err
+			}
+			m[c.name] = v
+		default:
+			m[c.name] = s
+		}
+	}
+	return m, nil
+}
+
 // Decode decodes a line from the config table into a provided struct.
 func (d *Decoder) Decode(s interface{}) error {
 	if err := d.readHeader(); err != nil {
@@ -260,7 +493,9 @@ func (d *Decoder) Decode(s interface{}) error {
 		}
 
 		columnID, ok := d.columnNames[columnName]
-		if !ok {
+		if !ok && d.Strict {
+			return fmt.Errorf("configtable: no column %q for field %s", columnName, f.Name)
+		} else if !ok {
 			continue
 		}
 
@@ -274,17 +509,11 @@ func (d *Decoder) Decode(s interface{}) error {
 		}
 	}
 
-	ln, err := d.line()
+	bits, err := d.row()
 	if err != nil {
 		return err
 	}
 
-	bits := strings.Split(ln, columnDelimiter)
-	if len(bits) != len(d.columns) {
-		d.err = fmt.Errorf("configtable: column count mismatch: saw %d columns, expected %d", len(bits), len(d.columns))
-		return d.err
-	}
-
 	for n, s := range bits {
 		v, ok := columnToField[n]
 		if !ok {
@@ -311,3 +540,38 @@ func NewDecoder(r io.Reader) *Decoder {
 		s: bufio.NewScanner(r),
 	}
 }
+
+// DecodeAll decodes every remaining row of the config table into dst, which must be a pointer to a slice of structs or of struct pointers. Rows are appended to the slice.
+func (d *Decoder) DecodeAll(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("configtable: cannot decode all into non-slice-pointer")
+	}
+	sv := v.Elem()
+	et := sv.Type().Elem()
+	isPtr := et.Kind() == reflect.Ptr
+	if isPtr {
+		et = et.Elem()
+	}
+	if et.Kind() != reflect.Struct {
+		return fmt.Errorf("configtable: cannot decode all into slice of %v", sv.Type().Elem())
+	}
+
+	for {
+		row := reflect.New(et)
+		if err := d.Decode(row.Interface()); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !isPtr {
+			row = row.Elem()
+		}
+		sv.Set(reflect.Append(sv, row))
+	}
+}
+
+// DecodeAll decodes every row of the config table in r into dst, as Decoder.DecodeAll does.
+func DecodeAll(r io.Reader, dst interface{}) error {
+	return NewDecoder(r).DecodeAll(dst)
+}
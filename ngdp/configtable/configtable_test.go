@@ -18,6 +18,9 @@ package configtable
 
 import (
 	"io"
+	"math/big"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -143,6 +146,322 @@ baa|bab|bac bad-bae baf|bag-bah bai-baj|zab
 	}
 }
 
+func TestDecodeComments(t *testing.T) {
+	type S struct {
+		Name string
+	}
+
+	for _, test := range []struct {
+		table    string
+		wantSeqn int
+	}{
+		{"Name!STRING:0\n## seqn = 1234\nfoo\n\n# a comment\nbar\n", 1234},
+		{"## seqn = 5678\nName!STRING:0\nfoo\nbar\n", 5678},
+		{"Name!STRING:0\nfoo\nbar\n", 0},
+	} {
+		// Seqn can be asked for before any rows are decoded, without losing the first.
+		d := NewDecoder(strings.NewReader(test.table))
+		if seqn, err := d.Seqn(); err != nil || seqn != test.wantSeqn {
+			t.Errorf("%q: d.Seqn() = %d, %v; want %d", test.table, seqn, err, test.wantSeqn)
+		}
+		var got []string
+		for {
+			var s S
+			if err := d.Decode(&s); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%q: d.Decode: %v", test.table, err)
+			}
+			got = append(got, s.Name)
+		}
+		if want := []string{"foo", "bar"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: decoded %q; want %q", test.table, got, want)
+		}
+		if seqn, err := d.Seqn(); err != nil || seqn != test.wantSeqn {
+			t.Errorf("%q: at EOF: d.Seqn() = %d, %v; want %d", test.table, seqn, err, test.wantSeqn)
+		}
+	}
+
+	d := NewDecoder(strings.NewReader("Name!STRING:0\n## seqn = many\nfoo\n"))
+	if _, err := d.Seqn(); err == nil {
+		t.Errorf("bad seqn: d.Seqn() = nil error; want error")
+	}
+}
+
+func TestDecodeAll(t *testing.T) {
+	type S struct {
+		Name string
+		Path string
+	}
+
+	var got []S
+	if err := DecodeAll(strings.NewReader(exampleTable), &got); err != nil {
+		t.Fatalf("DecodeAll: %v", err)
+	}
+	want := []S{{"blah", "blah"}, {"foo", "foo"}, {"baa", "bab"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeAll = %#v; want %#v", got, want)
+	}
+
+	var gotPtrs []*S
+	if err := DecodeAll(strings.NewReader(exampleTable), &gotPtrs); err != nil {
+		t.Fatalf("DecodeAll(pointers): %v", err)
+	}
+	if len(gotPtrs) != len(want) || *gotPtrs[2] != want[2] {
+		t.Errorf("DecodeAll(pointers) = %v; want %v", gotPtrs, want)
+	}
+
+	for _, dst := range []interface{}{got, &S{}, &[]string{}} {
+		if err := DecodeAll(strings.NewReader(exampleTable), dst); err == nil {
+			t.Errorf("DecodeAll(%T) = nil error; want error", dst)
+		}
+	}
+	if err := DecodeAll(strings.NewReader("Name!STRING:0\nfoo|bar\n"), &got); err == nil {
+		t.Errorf("DecodeAll(bad row) = nil error; want error")
+	}
+}
+
+func TestDecodeMap(t *testing.T) {
+	d := NewDecoder(strings.NewReader(complexExampleTable))
+	columns, err := d.Columns()
+	if err != nil {
+		t.Fatalf("d.Columns: %v", err)
+	}
+	want := []ColumnInfo{
+		{"Region", "string", 0},
+		{"BuildConfig", "hex", 16},
+		{"CDNConfig", "hex", 16},
+		{"KeyRing", "hex", 16},
+		{"BuildId", "dec", 4},
+		{"VersionsName", "string", 0},
+		{"ProductConfig", "hex", 16},
+		{"OtherNumber", "dec", 4},
+	}
+	if !reflect.DeepEqual(columns, want) {
+		t.Errorf("d.Columns() = %v; want %v", columns, want)
+	}
+	if got, want := columns[1].String(), "BuildConfig!HEX:16"; got != want {
+		t.Errorf("columns[1].String() = %q; want %q", got, want)
+	}
+
+	got, err := d.DecodeMap()
+	if err != nil {
+		t.Fatalf("d.DecodeMap: %v", err)
+	}
+	if got["Region"] != "us" || got["BuildId"] != "44247" || got["KeyRing"] != "" || got["CDNConfig"] != "c8043457fcf9eb6dac433e53fa47f5" || len(got) != len(columns) {
+		t.Errorf("d.DecodeMap() = %v", got)
+	}
+	if _, err := d.DecodeMap(); err != io.EOF {
+		t.Errorf("at EOF: d.DecodeMap: %v; want EOF", err)
+	}
+}
+
+func TestDecodeTyped(t *testing.T) {
+	d := NewDecoder(strings.NewReader(complexExampleTable))
+	got, err := d.DecodeTyped()
+	if err != nil {
+		t.Fatalf("d.DecodeTyped: %v", err)
+	}
+	want := map[string]interface{}{
+		"Region":        "us",
+		"BuildConfig":   []byte{0xa4, 0x23, 0x79, 0x0b, 0x9b, 0xce, 0xe8, 0xac, 0x53, 0x2c, 0xeb, 0x39, 0xfe, 0x55, 0x06, 0x85},
+		"CDNConfig":     []byte{0xc8, 0x04, 0x34, 0x57, 0xfc, 0xf9, 0xeb, 0x6d, 0xac, 0x43, 0x3e, 0x53, 0xfa, 0x47, 0xf5},
+		"KeyRing":       []byte{},
+		"BuildId":       int64(44247),
+		"VersionsName":  "2.5.0.44247",
+		"ProductConfig": []byte{0xf0, 0x34, 0x48, 0xa5, 0xaa, 0x6c, 0x9f, 0x1e, 0x93, 0x07, 0x33, 0x59, 0x46, 0xaf, 0x05},
+		"OtherNumber":   int64(27),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("d.DecodeTyped() = %#v; want %#v", got, want)
+	}
+
+	d = NewDecoder(strings.NewReader("Number!DEC:4\nlots\n"))
+	if _, err := d.DecodeTyped(); err == nil {
+		t.Errorf("d.DecodeTyped(bad number) = nil error; want error")
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	type S struct {
+		Name    string
+		Renamed string `configtable:"Missing"`
+	}
+
+	d := NewDecoder(strings.NewReader(exampleTable))
+	var s S
+	if err := d.Decode(&s); err != nil || s.Name != "blah" {
+		t.Errorf("lax: d.Decode = %v, %#v; want nil error", err, s)
+	}
+
+	d = NewDecoder(strings.NewReader(exampleTable))
+	d.Strict = true
+	if err := d.Decode(&s); err == nil || !strings.Contains(err.Error(), `"Missing"`) {
+		t.Errorf("strict: d.Decode = %v; want error naming the missing column", err)
+	}
+}
+
+func TestDecodeLenient(t *testing.T) {
+	type S struct {
+		Name  string
+		Path  string
+		Hosts string
+	}
+
+	d := NewDecoder(strings.NewReader("Name!STRING:0|Path!STRING:0|Hosts!STRING:0\nshort|row\nlong|row|hosts|extra\nfine|row|hosts\n"))
+	d.Lenient = true
+	wants := []S{
+		{"short", "row", ""},
+		{"long", "row", "hosts"},
+		{"fine", "row", "hosts"},
+	}
+	for n, want := range wants {
+		var s S
+		if err := d.Decode(&s); err != nil {
+			t.Errorf("%d: d.Decode: %v", n, err)
+		}
+		if s != want {
+			t.Errorf("%d: got=%#v; want=%#v", n, s, want)
+		}
+	}
+}
+
+func TestDecodeBigInt(t *testing.T) {
+	const huge = "340282366920938463463374607431768211455" // 2**128 - 1
+	var s struct {
+		Value big.Int
+		Ptr   *big.Int
+	}
+	d := NewDecoder(strings.NewReader("Value!DEC:16|Ptr!DEC:32\n" + huge + "|" + huge + "\n"))
+	if err := d.Decode(&s); err != nil {
+		t.Fatalf("d.Decode: %v", err)
+	}
+	if s.Value.String() != huge || s.Ptr == nil || s.Ptr.String() != huge {
+		t.Errorf("d.Decode = %v, %v; want %v", &s.Value, s.Ptr, huge)
+	}
+
+	// A DEC:16 column can't be decoded into an ordinary integer.
+	var small struct{ Value uint64 }
+	d = NewDecoder(strings.NewReader("Value!DEC:16\n" + huge + "\n"))
+	if err := d.Decode(&small); err == nil {
+		t.Errorf("d.Decode(uint64) = nil error; want error")
+	}
+
+	d = NewDecoder(strings.NewReader("Value!DEC:16\n" + huge + "\n"))
+	m, err := d.DecodeTyped()
+	if err != nil {
+		t.Fatalf("d.DecodeTyped: %v", err)
+	}
+	if v, ok := m["Value"].(*big.Int); !ok || v.String() != huge {
+		t.Errorf("d.DecodeTyped()[Value] = %#v; want *big.Int %v", m["Value"], huge)
+	}
+}
+
+// flagsType decodes columns of comma-separated flags into a set.
+type flagsType struct{}
+
+func (flagsType) CanDecode(byteLen int, to reflect.Type) bool {
+	return to == reflect.TypeOf(map[string]bool{})
+}
+
+func (flagsType) Decode(byteLen int, value string, to reflect.Value) error {
+	flags := make(map[string]bool)
+	for _, f := range strings.Split(value, ",") {
+		flags[f] = true
+	}
+	to.Set(reflect.ValueOf(flags))
+	return nil
+}
+
+func TestRegisterType(t *testing.T) {
+	const table = "Name!STRING:0|Flags!TESTFLAGS:0\nfoo|a,b\n"
+	var s struct {
+		Name  string
+		Flags map[string]bool
+	}
+
+	if err := NewDecoder(strings.NewReader(table)).Decode(&s); err == nil {
+		t.Errorf("before RegisterType: d.Decode = nil error; want error")
+	}
+
+	// Lenient Decoders accept unknown types, but only as strings.
+	d := NewDecoder(strings.NewReader(table))
+	d.Lenient = true
+	var raw struct{ Flags string }
+	if err := d.Decode(&raw); err != nil || raw.Flags != "a,b" {
+		t.Errorf("lenient: d.Decode = %v, %q; want %q", err, raw.Flags, "a,b")
+	}
+
+	RegisterType("TestFlags", flagsType{})
+	if err := NewDecoder(strings.NewReader(table)).Decode(&s); err != nil {
+		t.Fatalf("d.Decode: %v", err)
+	}
+	if want := map[string]bool{"a": true, "b": true}; !reflect.DeepEqual(s.Flags, want) {
+		t.Errorf("d.Decode = %#v; want Flags %v", s, want)
+	}
+	if err := NewDecoder(strings.NewReader(table)).Decode(&raw); err != nil || raw.Flags != "a,b" {
+		t.Errorf("into string: d.Decode = %v, %q; want %q", err, raw.Flags, "a,b")
+	}
+
+	var wrong struct{ Flags int }
+	if err := NewDecoder(strings.NewReader(table)).Decode(&wrong); err == nil {
+		t.Errorf("d.Decode(int) = nil error; want error")
+	}
+}
+
+// TestDecodeCorpus decodes tables in the shapes patch servers have been seen to send, which should all decode alike whatever their line endings or trailing delimiters.
+func TestDecodeCorpus(t *testing.T) {
+	type version struct {
+		Region       string
+		BuildConfig  [16]byte
+		KeyRing      []byte
+		BuildID      int `configtable:"BuildId"`
+		VersionsName string
+	}
+	type cdn struct {
+		Name       string
+		Path       string
+		Hosts      []string `configtable:"Hosts, "`
+		ConfigPath string
+	}
+
+	for _, test := range []struct {
+		files    []string
+		dst      func() interface{}
+		wantSeqn int
+	}{
+		{[]string{"versions.txt", "versions-crlf.txt", "versions-trailing-pipe.txt"}, func() interface{} { return &[]version{} }, 1234},
+		{[]string{"cdns.txt", "cdns-crlf-trailing-pipe.txt", "cdns-crlf-no-final-newline.txt"}, func() interface{} { return &[]cdn{} }, 5678},
+	} {
+		var want interface{}
+		for _, fn := range test.files {
+			f, err := os.Open(filepath.Join("testdata", fn))
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := NewDecoder(f)
+			got := test.dst()
+			err = d.DecodeAll(got)
+			f.Close()
+			if err != nil {
+				t.Errorf("%s: d.DecodeAll: %v", fn, err)
+				continue
+			}
+			if seqn, err := d.Seqn(); err != nil || seqn != test.wantSeqn {
+				t.Errorf("%s: d.Seqn() = %d, %v; want %d", fn, seqn, err, test.wantSeqn)
+			}
+			if reflect.ValueOf(got).Elem().Len() != 2 {
+				t.Errorf("%s: decoded %d rows; want 2", fn, reflect.ValueOf(got).Elem().Len())
+			}
+			if want == nil {
+				want = got
+			} else if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: decoded %+v; want %+v, as from %s", fn, got, want, test.files[0])
+			}
+		}
+	}
+}
+
 func TestDecodeNonStructPtr(t *testing.T) {
 	d := NewDecoder(strings.NewReader(exampleTable))
 
@@ -0,0 +1,164 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dedupe measures how much content is shared between builds.
+//
+// This is mostly useful for capacity planning: a mirror which already holds one build only needs to fetch what is new in the next.
+package dedupe
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/encoding"
+)
+
+// A file is a single encoded file referenced by a build.
+type file struct {
+	size     uint32
+	archive  ngdp.CDNHash
+	archived bool
+}
+
+// A Build is the set of encoded files referenced by a single build's encoding table.
+type Build struct {
+	Name  string
+	files map[ngdp.CDNHash]file
+}
+
+// NewBuild creates a Build from its encoding table, using archives to find the size of each file.
+//
+// Files which are not stored in any archive are counted, but their sizes are unknown.
+func NewBuild(name string, enc *encoding.Mapper, archives *client.ArchiveMapper) *Build {
+	b := &Build{
+		Name:  name,
+		files: make(map[ngdp.CDNHash]file),
+	}
+	for _, h := range enc.All() {
+		var f file
+		if e, ok := archives.Map(h); ok {
+			f = file{size: e.Size, archive: e.Archive, archived: true}
+		}
+		b.files[h] = f
+	}
+	return b
+}
+
+// Load retrieves the encoding table and archive indices for the build described by version.
+func Load(ctx context.Context, llc *client.LowLevelClient, cdn ngdp.CDNInfo, version ngdp.VersionInfo) (*Build, error) {
+	cdnConfig, buildConfig, err := llc.Configs(ctx, cdn, version)
+	if err != nil {
+		return nil, err
+	}
+	enc, archives, err := llc.Mappers(ctx, cdn, cdnConfig, buildConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	name := version.VersionsName
+	if name == "" {
+		name = fmt.Sprintf("%032x", version.BuildConfig)
+	}
+	return NewBuild(name, enc, archives), nil
+}
+
+// Stats counts a set of files.
+type Stats struct {
+	Files int
+	Bytes uint64
+
+	// UnsizedFiles is the number of files which are not in an archive, and so are not included in Bytes.
+	UnsizedFiles int
+}
+
+func (s *Stats) add(f file) {
+	s.Files++
+	if f.archived {
+		s.Bytes += uint64(f.size)
+	} else {
+		s.UnsizedFiles++
+	}
+}
+
+// Stats returns the totals for every file in b.
+func (b *Build) Stats() Stats {
+	var s Stats
+	for _, f := range b.files {
+		s.add(f)
+	}
+	return s
+}
+
+// An Upgrade describes what changes between two builds.
+type Upgrade struct {
+	From, To string
+
+	// Shared are the files in To which were already in From.
+	Shared Stats
+
+	// New are the files in To which were not in From.
+	New Stats
+
+	// NewArchives is the number of archives which contain files in To, but none in From.
+	// Mirrors fetch whole archives, so NewArchiveBytes, the size of those files, is a lower bound on what a mirror must download.
+	NewArchives     int
+	NewArchiveBytes uint64
+}
+
+// Compare works out what is needed to upgrade from one build to another.
+func Compare(from, to *Build) Upgrade {
+	u := Upgrade{From: from.Name, To: to.Name}
+
+	oldArchives := make(map[ngdp.CDNHash]bool)
+	for _, f := range from.files {
+		if f.archived {
+			oldArchives[f.archive] = true
+		}
+	}
+
+	newArchives := make(map[ngdp.CDNHash]bool)
+	for h, f := range to.files {
+		if _, ok := from.files[h]; ok {
+			u.Shared.add(f)
+		} else {
+			u.New.add(f)
+		}
+		if f.archived && !oldArchives[f.archive] {
+			newArchives[f.archive] = true
+			u.NewArchiveBytes += uint64(f.size)
+		}
+	}
+	u.NewArchives = len(newArchives)
+	return u
+}
+
+// Union returns the totals for every distinct file referenced by any of builds.
+func Union(builds ...*Build) Stats {
+	seen := make(map[ngdp.CDNHash]bool)
+	var s Stats
+	for _, b := range builds {
+		for h, f := range b.files {
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			s.add(f)
+		}
+	}
+	return s
+}
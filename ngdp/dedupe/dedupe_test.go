@@ -0,0 +1,59 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dedupe
+
+import (
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+func TestCompare(t *testing.T) {
+	archiveA, archiveB := ngdp.CDNHash{0xaa}, ngdp.CDNHash{0xbb}
+	from := &Build{Name: "1", files: map[ngdp.CDNHash]file{
+		{1}: {size: 100, archive: archiveA, archived: true},
+		{2}: {size: 200, archive: archiveA, archived: true},
+		{3}: {},
+	}}
+	to := &Build{Name: "2", files: map[ngdp.CDNHash]file{
+		{1}: {size: 100, archive: archiveA, archived: true},
+		{3}: {},
+		{4}: {size: 400, archive: archiveB, archived: true},
+		{5}: {size: 500, archive: archiveA, archived: true},
+		{6}: {},
+	}}
+
+	got := Compare(from, to)
+	want := Upgrade{
+		From:            "1",
+		To:              "2",
+		Shared:          Stats{Files: 2, Bytes: 100, UnsizedFiles: 1},
+		New:             Stats{Files: 3, Bytes: 900, UnsizedFiles: 1},
+		NewArchives:     1,
+		NewArchiveBytes: 400,
+	}
+	if got != want {
+		t.Errorf("Compare = %+v; want %+v", got, want)
+	}
+
+	if got, want := Union(from, to), (Stats{Files: 6, Bytes: 1200, UnsizedFiles: 2}); got != want {
+		t.Errorf("Union = %+v; want %+v", got, want)
+	}
+	if got, want := to.Stats(), (Stats{Files: 5, Bytes: 1000, UnsizedFiles: 2}); got != want {
+		t.Errorf("Stats = %+v; want %+v", got, want)
+	}
+}
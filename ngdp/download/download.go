@@ -0,0 +1,199 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package download parses download manifests, which list every file in a build in the order the client should download them.
+package download
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/install"
+)
+
+var (
+	// ErrBadMagic means that the manifest doesn't start with "DL".
+	ErrBadMagic = errors.New("download: bad magic")
+
+	// ErrUnknownTag means that a tag passed to Filter isn't in the manifest. Download manifests share their tags with install manifests.
+	ErrUnknownTag = install.ErrUnknownTag
+)
+
+// An Entry is a single file to be downloaded.
+type Entry struct {
+	CDNHash ngdp.CDNHash
+	Size    uint64
+
+	// Priority orders downloads; lower values are needed sooner, and files with priority 0 are needed before the game can start.
+	// For version 3 manifests, the manifest's base priority has already been subtracted.
+	Priority int8
+
+	// Checksum is only set if the manifest has checksums.
+	Checksum uint32
+
+	// Flags is only set for version 2 manifests and later.
+	Flags []byte
+}
+
+// A Manifest is a parsed download manifest.
+type Manifest struct {
+	Version      uint8
+	HasChecksums bool
+
+	// BasePriority is only set for version 3 manifests.
+	BasePriority int8
+
+	Entries []Entry
+	Tags    []install.Tag
+}
+
+// Parse parses a decoded download manifest.
+func Parse(rd io.Reader) (*Manifest, error) {
+	r := bufio.NewReader(rd)
+
+	var hdr struct {
+		Magic        [2]byte
+		Version      uint8
+		HashSize     uint8
+		HasChecksums uint8
+		NumEntries   uint32
+		NumTags      uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("download: reading header: %v", err)
+	}
+	if string(hdr.Magic[:]) != "DL" {
+		return nil, ErrBadMagic
+	}
+	if hdr.Version < 1 || hdr.Version > 3 {
+		return nil, fmt.Errorf("download: unsupported version %d", hdr.Version)
+	}
+	if hdr.HashSize != 16 {
+		return nil, fmt.Errorf("download: unsupported hash size %d", hdr.HashSize)
+	}
+
+	m := &Manifest{
+		Version:      hdr.Version,
+		HasChecksums: hdr.HasChecksums != 0,
+		Entries:      make([]Entry, hdr.NumEntries),
+		Tags:         make([]install.Tag, hdr.NumTags),
+	}
+
+	var numFlags int
+	if hdr.Version >= 2 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("download: reading header: %v", err)
+		}
+		numFlags = int(b)
+	}
+	if hdr.Version >= 3 {
+		var ext [4]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, fmt.Errorf("download: reading header: %v", err)
+		}
+		m.BasePriority = int8(ext[0])
+	}
+
+	// Size (5 bytes) and priority (1 byte), then the optional checksum and flags.
+	entryLen := 6 + numFlags
+	if m.HasChecksums {
+		entryLen += 4
+	}
+	buf := make([]byte, entryLen)
+	for n := range m.Entries {
+		e := &m.Entries[n]
+		if _, err := io.ReadFull(r, e.CDNHash[:]); err != nil {
+			return nil, fmt.Errorf("download: reading entry %d: %v", n, err)
+		}
+		b := buf
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, fmt.Errorf("download: reading entry %d: %v", n, err)
+		}
+		e.Size = uint64(b[0])<<32 | uint64(binary.BigEndian.Uint32(b[1:5]))
+		e.Priority = int8(b[5]) - m.BasePriority
+		b = b[6:]
+		if m.HasChecksums {
+			e.Checksum = binary.BigEndian.Uint32(b)
+			b = b[4:]
+		}
+		if numFlags > 0 {
+			e.Flags = append([]byte(nil), b...)
+		}
+	}
+
+	bitmapLen := (int(hdr.NumEntries) + 7) / 8
+	for n := range m.Tags {
+		name, err := r.ReadString(0)
+		if err != nil {
+			return nil, fmt.Errorf("download: reading tag %d: %v", n, err)
+		}
+		name = name[:len(name)-1]
+		var typ uint16
+		if err := binary.Read(r, binary.BigEndian, &typ); err != nil {
+			return nil, fmt.Errorf("download: reading tag %q: %v", name, err)
+		}
+		bitmap := make([]byte, bitmapLen)
+		if _, err := io.ReadFull(r, bitmap); err != nil {
+			return nil, fmt.Errorf("download: reading tag %q: %v", name, err)
+		}
+		m.Tags[n] = install.NewTag(name, install.TagType(typ), bitmap)
+	}
+	return m, nil
+}
+
+// Fetch retrieves and parses the download manifest with content hash h, which is usually a build config's Download.
+func Fetch(ctx context.Context, f client.Fetcher, h ngdp.ContentHash) (*Manifest, error) {
+	resp, err := f.Fetch(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return Parse(resp.Body)
+}
+
+// Tag returns the tag with the given name.
+func (m *Manifest) Tag(name string) (install.Tag, bool) {
+	return install.FindTag(m.Tags, name)
+}
+
+// Filter returns the entries which match the given tags, in the order they should be downloaded.
+//
+// An entry matches if, for each type of tag given, it carries at least one of the tags of that type.
+// Entries are ordered by priority, and otherwise keep their order in the manifest.
+func (m *Manifest) Filter(tags ...string) ([]Entry, error) {
+	match, err := install.MatchTags(m.Tags, tags...)
+	if err != nil {
+		return nil, err
+	}
+	var out []Entry
+	for n, e := range m.Entries {
+		if match(n) {
+			out = append(out, e)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Priority < out[j].Priority
+	})
+	return out, nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package download
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/install"
+)
+
+// makeManifest builds a download manifest containing entries and tags.
+func makeManifest(version uint8, checksums bool, numFlags int, basePriority int8, entries []Entry, tags []install.Tag) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("DL")
+	buf.WriteByte(version)
+	buf.WriteByte(16)
+	if checksums {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(len(entries)))
+	binary.Write(&buf, binary.BigEndian, uint16(len(tags)))
+	if version >= 2 {
+		buf.WriteByte(byte(numFlags))
+	}
+	if version >= 3 {
+		buf.Write([]byte{byte(basePriority), 0, 0, 0})
+	}
+	for _, e := range entries {
+		buf.Write(e.CDNHash[:])
+		buf.WriteByte(byte(e.Size >> 32))
+		binary.Write(&buf, binary.BigEndian, uint32(e.Size))
+		buf.WriteByte(byte(e.Priority + basePriority))
+		if checksums {
+			binary.Write(&buf, binary.BigEndian, e.Checksum)
+		}
+		buf.Write(e.Flags)
+	}
+	for _, t := range tags {
+		buf.WriteString(t.Name)
+		buf.WriteByte(0)
+		binary.Write(&buf, binary.BigEndian, t.Type)
+		buf.Write(t.Bitmap())
+	}
+	return buf.Bytes()
+}
+
+var exampleTags = []install.Tag{
+	install.NewTag("Windows", install.TagTypePlatform, []byte{0xa0}), // 101
+	install.NewTag("OSX", install.TagTypePlatform, []byte{0x60}),     // 011
+	install.NewTag("enUS", install.TagTypeLocale, []byte{0xe0}),      // 111
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		version      uint8
+		checksums    bool
+		numFlags     int
+		basePriority int8
+		entries      []Entry
+	}{
+		{"v1", 1, false, 0, 0, []Entry{
+			{ngdp.CDNHash{1}, 100, 0, 0, nil},
+			{ngdp.CDNHash{2}, 1 << 33, 2, 0, nil},
+			{ngdp.CDNHash{3}, 300, 1, 0, nil},
+		}},
+		{"v1 with checksums", 1, true, 0, 0, []Entry{
+			{ngdp.CDNHash{1}, 100, 0, 0xdeadbeef, nil},
+			{ngdp.CDNHash{2}, 200, 2, 0xcafebabe, nil},
+			{ngdp.CDNHash{3}, 300, 1, 1, nil},
+		}},
+		{"v2 with flags", 2, false, 1, 0, []Entry{
+			{ngdp.CDNHash{1}, 100, 0, 0, []byte{1}},
+			{ngdp.CDNHash{2}, 200, 2, 0, []byte{0}},
+			{ngdp.CDNHash{3}, 300, 1, 0, []byte{3}},
+		}},
+		{"v3 with base priority", 3, true, 0, -1, []Entry{
+			{ngdp.CDNHash{1}, 100, 0, 1, nil},
+			{ngdp.CDNHash{2}, 200, 2, 2, nil},
+			{ngdp.CDNHash{3}, 300, 1, 3, nil},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := makeManifest(test.version, test.checksums, test.numFlags, test.basePriority, test.entries, exampleTags)
+			m, err := Parse(bytes.NewReader(b))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if m.Version != test.version || m.HasChecksums != test.checksums || m.BasePriority != test.basePriority {
+				t.Errorf("header = (%d, %v, %d); want (%d, %v, %d)", m.Version, m.HasChecksums, m.BasePriority, test.version, test.checksums, test.basePriority)
+			}
+			if !reflect.DeepEqual(m.Entries, test.entries) {
+				t.Errorf("m.Entries = %v; want %v", m.Entries, test.entries)
+			}
+			if !reflect.DeepEqual(m.Tags, exampleTags) {
+				t.Errorf("m.Tags = %v; want %v", m.Tags, exampleTags)
+			}
+
+			if _, err := Parse(bytes.NewReader(b[:len(b)-1])); err == nil {
+				t.Errorf("Parse of truncated manifest: nil error; want error")
+			}
+		})
+	}
+}
+
+func TestParseBadMagic(t *testing.T) {
+	if _, err := Parse(bytes.NewReader([]byte("IN\x01\x10\x00\x00\x00\x00\x00\x00\x00"))); err != ErrBadMagic {
+		t.Errorf("Parse: %v; want %v", err, ErrBadMagic)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	entries := []Entry{
+		{ngdp.CDNHash{1}, 100, 2, 0, nil},
+		{ngdp.CDNHash{2}, 200, 1, 0, nil},
+		{ngdp.CDNHash{3}, 300, 0, 0, nil},
+	}
+	m, err := Parse(bytes.NewReader(makeManifest(1, false, 0, 0, entries, exampleTags)))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	for _, test := range []struct {
+		tags []string
+		want []Entry
+	}{
+		{nil, []Entry{entries[2], entries[1], entries[0]}},
+		{[]string{"Windows"}, []Entry{entries[2], entries[0]}},
+		{[]string{"OSX", "enUS"}, []Entry{entries[2], entries[1]}},
+	} {
+		got, err := m.Filter(test.tags...)
+		if err != nil {
+			t.Errorf("m.Filter(%v): %v", test.tags, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("m.Filter(%v) = %v; want %v", test.tags, got, test.want)
+		}
+	}
+
+	if _, err := m.Filter("Linux"); err == nil {
+		t.Errorf("m.Filter(Linux): nil error; want error")
+	}
+}
@@ -0,0 +1,364 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package downloader runs many downloads at once, in priority order, within global concurrency and bandwidth limits.
+package downloader
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/lukegb/snowstorm/ngdp/client"
+)
+
+const (
+	defaultConcurrency = 8
+	defaultRetries     = 3
+	defaultRetryDelay  = time.Second
+
+	readChunkSize = 32 * 1024
+)
+
+// A Job is a single download.
+type Job struct {
+	// Name identifies the job in errors and logs.
+	Name string
+
+	// Priority orders jobs; lower values are run first. Download manifest priorities can be used directly.
+	Priority int
+
+	// Size is the expected number of bytes to be read, if known. It is only used for progress reporting.
+	Size int64
+
+	// Open starts the download. If it returns a nil ReadCloser and a nil error, there is nothing to do.
+	Open func(ctx context.Context) (io.ReadCloser, error)
+
+	// Save consumes the downloaded data. If Open or Save fails, both are retried.
+	Save func(ctx context.Context, r io.Reader) error
+}
+
+// Options control how a Manager runs jobs.
+type Options struct {
+	// Concurrency is the number of jobs to run at once. Defaults to 8.
+	Concurrency int
+
+	// BytesPerSecond limits the total rate at which data is read across all jobs. Zero means unlimited.
+	BytesPerSecond int64
+
+	// Retries is the number of times a failed job is retried. Defaults to 3; a negative value disables retries.
+	Retries int
+
+	// RetryDelay is how long to wait before the first retry; the delay doubles with each subsequent retry. Defaults to one second.
+	RetryDelay time.Duration
+
+	// ShouldRetry decides whether a failure is worth retrying. By default, everything except a 404 Not Found is.
+	ShouldRetry func(err error) bool
+
+	// Progress, if set, receives a copy of every byte downloaded.
+	Progress io.Writer
+
+	// OnComplete, if set, is called after each job finishes, successfully or otherwise.
+	OnComplete func(j *Job, err error)
+
+	// IgnoreErrors causes failed jobs to be logged and skipped, rather than stopping the whole run.
+	IgnoreErrors bool
+
+	// Journal, if set, records each job as it finishes. Jobs it records as finished by an earlier run are skipped without being opened, although OnComplete is still called for them.
+	Journal *Journal
+}
+
+// A Manager queues jobs and runs them.
+type Manager struct {
+	opts    Options
+	limiter *limiter
+
+	mu       sync.Mutex
+	queue    jobQueue
+	seq      int
+	progress Progress
+	started  time.Time
+}
+
+// New creates a Manager.
+func New(opts Options) *Manager {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultConcurrency
+	}
+	if opts.Retries == 0 {
+		opts.Retries = defaultRetries
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultRetryDelay
+	}
+	if opts.ShouldRetry == nil {
+		opts.ShouldRetry = func(err error) bool { return !client.IsNotFound(err) }
+	}
+	m := &Manager{opts: opts}
+	if opts.BytesPerSecond > 0 {
+		m.limiter = &limiter{rate: opts.BytesPerSecond}
+	}
+	return m
+}
+
+// Add queues a job to be run by Run.
+func (m *Manager) Add(j *Job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	heap.Push(&m.queue, queuedJob{j, m.seq})
+	m.seq++
+	m.progress.Jobs++
+	m.progress.TotalBytes += j.Size
+}
+
+func (m *Manager) next() *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.queue.Len() == 0 {
+		return nil
+	}
+	return heap.Pop(&m.queue).(queuedJob).job
+}
+
+// Run runs queued jobs until none remain.
+//
+// Unless IgnoreErrors is set, Run stops at the first job which fails even after retries, and returns its error.
+func (m *Manager) Run(ctx context.Context) error {
+	m.mu.Lock()
+	if m.started.IsZero() {
+		m.started = time.Now()
+	}
+	m.mu.Unlock()
+
+	g, ctx := errgroup.WithContext(ctx)
+	for n := 0; n < m.opts.Concurrency; n++ {
+		g.Go(func() error {
+			for j := m.next(); j != nil; j = m.next() {
+				if m.opts.Journal != nil && m.opts.Journal.Done(j.Name) {
+					m.skip(j)
+					continue
+				}
+
+				err := m.runJournaled(ctx, j)
+				if m.opts.OnComplete != nil {
+					m.opts.OnComplete(j, err)
+				}
+
+				m.mu.Lock()
+				if err != nil {
+					m.progress.FailedJobs++
+				} else {
+					m.progress.DoneJobs++
+				}
+				m.mu.Unlock()
+
+				if err != nil && !m.opts.IgnoreErrors {
+					return errors.Wrap(err, j.Name)
+				}
+				if err != nil {
+					glog.Warningf("%s: %v", j.Name, err)
+				}
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// skip accounts for a job which was finished by an earlier run.
+func (m *Manager) skip(j *Job) {
+	if m.opts.OnComplete != nil {
+		m.opts.OnComplete(j, nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.progress.DoneJobs++
+	m.progress.SkippedJobs++
+	m.progress.TotalBytes -= j.Size
+}
+
+// runJournaled runs a job, recording it in the journal if there is one.
+func (m *Manager) runJournaled(ctx context.Context, j *Job) error {
+	if m.opts.Journal == nil {
+		return m.run(ctx, j)
+	}
+	if err := m.opts.Journal.Start(j.Name); err != nil {
+		return errors.Wrap(err, "downloader: writing journal")
+	}
+	if err := m.run(ctx, j); err != nil {
+		return err
+	}
+	return errors.Wrap(m.opts.Journal.Finish(j.Name), "downloader: writing journal")
+}
+
+// run runs a single job, retrying it if necessary.
+func (m *Manager) run(ctx context.Context, j *Job) error {
+	delay := m.opts.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := m.attempt(ctx, j)
+		if err == nil || attempt >= m.opts.Retries || !m.opts.ShouldRetry(err) || ctx.Err() != nil {
+			return err
+		}
+		glog.Warningf("%s: %v; retrying in %v", j.Name, err, delay)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (m *Manager) attempt(ctx context.Context, j *Job) error {
+	rc, err := j.Open(ctx)
+	if err != nil {
+		return err
+	}
+	if rc == nil {
+		return nil
+	}
+	defer rc.Close()
+	return j.Save(ctx, &jobReader{ctx: ctx, m: m, r: rc})
+}
+
+// A jobReader accounts for, and limits the rate of, the data read by a job.
+type jobReader struct {
+	ctx context.Context
+	m   *Manager
+	r   io.Reader
+}
+
+func (r *jobReader) Read(b []byte) (int, error) {
+	if len(b) > readChunkSize {
+		b = b[:readChunkSize]
+	}
+	n, err := r.r.Read(b)
+	if n > 0 {
+		if r.m.limiter != nil {
+			if lerr := r.m.limiter.wait(r.ctx, n); lerr != nil {
+				return n, lerr
+			}
+		}
+		r.m.mu.Lock()
+		r.m.progress.Bytes += int64(n)
+		r.m.mu.Unlock()
+		if r.m.opts.Progress != nil {
+			r.m.opts.Progress.Write(b[:n])
+		}
+	}
+	return n, err
+}
+
+// Progress summarises how far a Manager has got.
+type Progress struct {
+	Jobs, DoneJobs, FailedJobs int
+
+	// SkippedJobs counts the jobs, included in DoneJobs, which the journal showed were finished by an earlier run.
+	SkippedJobs int
+
+	// Bytes counts every byte read, including those from attempts which were later retried.
+	Bytes, TotalBytes int64
+
+	// Rate is the average number of bytes read per second since Run was first called.
+	Rate float64
+
+	// ETA is the estimated time until every job is done. It is zero if it can't be estimated.
+	ETA time.Duration
+}
+
+// Progress returns a snapshot of the Manager's progress.
+func (m *Manager) Progress() Progress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := m.progress
+	if m.started.IsZero() {
+		return p
+	}
+	if elapsed := time.Since(m.started).Seconds(); elapsed > 0 {
+		p.Rate = float64(p.Bytes) / elapsed
+	}
+	if p.Rate > 0 && p.TotalBytes > p.Bytes {
+		p.ETA = time.Duration(float64(p.TotalBytes-p.Bytes) / p.Rate * float64(time.Second))
+	}
+	return p
+}
+
+type queuedJob struct {
+	job *Job
+	seq int
+}
+
+// A jobQueue is a heap of jobs, ordered by priority and then by the order they were added.
+type jobQueue []queuedJob
+
+func (q jobQueue) Len() int { return len(q) }
+func (q jobQueue) Less(i, j int) bool {
+	if q[i].job.Priority != q[j].job.Priority {
+		return q[i].job.Priority < q[j].job.Priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q jobQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *jobQueue) Push(x interface{}) { *q = append(*q, x.(queuedJob)) }
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// A limiter spreads reads out so that they average no more than rate bytes per second.
+type limiter struct {
+	rate int64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until n more bytes may be read.
+func (l *limiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
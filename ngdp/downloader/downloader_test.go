@@ -0,0 +1,165 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func stringJob(name string, priority int, data string, saved *[]string, mu *sync.Mutex) *Job {
+	return &Job{
+		Name:     name,
+		Priority: priority,
+		Size:     int64(len(data)),
+		Open: func(ctx context.Context) (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(data)), nil
+		},
+		Save: func(ctx context.Context, r io.Reader) error {
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			*saved = append(*saved, name+"="+string(b))
+			return nil
+		},
+	}
+}
+
+func TestPriorityOrder(t *testing.T) {
+	var mu sync.Mutex
+	var saved []string
+	var progress bytes.Buffer
+	m := New(Options{Concurrency: 1, Progress: &progress})
+	m.Add(stringJob("c", 2, "3", &saved, &mu))
+	m.Add(stringJob("a", 0, "1", &saved, &mu))
+	m.Add(stringJob("b", 1, "2", &saved, &mu))
+	m.Add(stringJob("a2", 0, "4", &saved, &mu))
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if want := []string{"a=1", "a2=4", "b=2", "c=3"}; !reflect.DeepEqual(saved, want) {
+		t.Errorf("jobs ran as %v; want %v", saved, want)
+	}
+	if got := progress.String(); got != "1423" {
+		t.Errorf("Progress received %q; want %q", got, "1423")
+	}
+	p := m.Progress()
+	if p.Jobs != 4 || p.DoneJobs != 4 || p.Bytes != 4 || p.TotalBytes != 4 {
+		t.Errorf("Progress() = %+v; want 4 jobs and 4 bytes done", p)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	attempts := 0
+	errFlaky := errors.New("flaky")
+	m := New(Options{RetryDelay: time.Millisecond})
+	m.Add(&Job{
+		Name: "flaky",
+		Open: func(ctx context.Context) (io.ReadCloser, error) {
+			attempts++
+			if attempts < 3 {
+				return nil, errFlaky
+			}
+			return ioutil.NopCloser(strings.NewReader("ok")), nil
+		},
+		Save: func(ctx context.Context, r io.Reader) error {
+			_, err := ioutil.ReadAll(r)
+			return err
+		},
+	})
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("job was attempted %d times; want 3", attempts)
+	}
+
+	attempts = 0
+	m = New(Options{Retries: -1})
+	var completed error
+	m.opts.OnComplete = func(j *Job, err error) { completed = err }
+	m.Add(&Job{
+		Name: "broken",
+		Open: func(ctx context.Context) (io.ReadCloser, error) {
+			attempts++
+			return nil, errFlaky
+		},
+	})
+	if err := m.Run(context.Background()); err == nil {
+		t.Errorf("Run with failing job: nil error; want error")
+	}
+	if attempts != 1 || completed != errFlaky {
+		t.Errorf("job attempted %d times and completed with %v; want 1 and %v", attempts, completed, errFlaky)
+	}
+}
+
+func TestIgnoreErrors(t *testing.T) {
+	var mu sync.Mutex
+	var saved []string
+	m := New(Options{Concurrency: 1, Retries: -1, IgnoreErrors: true})
+	m.Add(&Job{
+		Name: "broken",
+		Open: func(ctx context.Context) (io.ReadCloser, error) {
+			return nil, errors.New("broken")
+		},
+	})
+	m.Add(&Job{
+		Name: "skipped",
+		Open: func(ctx context.Context) (io.ReadCloser, error) {
+			return nil, nil
+		},
+	})
+	m.Add(stringJob("ok", 1, "x", &saved, &mu))
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if want := []string{"ok=x"}; !reflect.DeepEqual(saved, want) {
+		t.Errorf("jobs saved %v; want %v", saved, want)
+	}
+	if p := m.Progress(); p.DoneJobs != 2 || p.FailedJobs != 1 {
+		t.Errorf("Progress() = %+v; want 2 done and 1 failed", p)
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	var mu sync.Mutex
+	var saved []string
+	m := New(Options{BytesPerSecond: 10 * readChunkSize})
+	for n := 0; n < 4; n++ {
+		m.Add(stringJob("big", 0, strings.Repeat("x", readChunkSize), &saved, &mu))
+	}
+	start := time.Now()
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// The first chunk is free; the other three take a tenth of a second each.
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("Run took %v; want at least 300ms", elapsed)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package downloader
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// journalSyncInterval is how often finished jobs are flushed to disk. Jobs finished since the last sync may be repeated after a crash, but are never lost from a completed run.
+const journalSyncInterval = time.Second
+
+// A Journal records which jobs have finished in a file, so that an interrupted run can be resumed without downloading or checking them again.
+//
+// Jobs are identified by their Name, which must therefore be the same from one run to the next.
+// It is safe for concurrent use.
+type Journal struct {
+	fn string
+
+	mu       sync.Mutex
+	f        *os.File
+	done     map[string]bool
+	inFlight map[string]bool
+	lastSync time.Time
+}
+
+// OpenJournal opens the journal in fn, creating it if necessary.
+//
+// id identifies the operation the journal is for, such as a command line. A journal left by a different operation is discarded.
+func OpenJournal(fn, id string) (*Journal, error) {
+	j := &Journal{
+		fn:       fn,
+		done:     make(map[string]bool),
+		inFlight: make(map[string]bool),
+	}
+
+	f, err := os.Open(fn)
+	switch {
+	case err == nil:
+		ok, rerr := j.read(f, id)
+		f.Close()
+		if rerr != nil {
+			return nil, rerr
+		}
+		if !ok {
+			glog.Warningf("Journal %s is for a different operation; starting afresh", fn)
+			j.done = make(map[string]bool)
+			j.inFlight = make(map[string]bool)
+			if err := os.Remove(fn); err != nil {
+				return nil, err
+			}
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	j.f, err = os.OpenFile(fn, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := j.f.Stat(); err != nil {
+		j.f.Close()
+		return nil, err
+	} else if fi.Size() == 0 {
+		if err := j.write("journal", id); err != nil {
+			j.f.Close()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// read loads a journal, returning false if it is for an operation other than id.
+func (j *Journal) read(f *os.File, id string) (bool, error) {
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	first := true
+	for s.Scan() {
+		bits := strings.SplitN(s.Text(), " ", 2)
+		if len(bits) != 2 {
+			// Most likely a line cut short by a crash.
+			continue
+		}
+		name, err := strconv.Unquote(bits[1])
+		if err != nil {
+			continue
+		}
+		if first {
+			if bits[0] != "journal" || name != id {
+				return false, nil
+			}
+			first = false
+			continue
+		}
+		switch bits[0] {
+		case "start":
+			j.inFlight[name] = true
+		case "done":
+			j.done[name] = true
+			delete(j.inFlight, name)
+		case "forget":
+			delete(j.done, name)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return false, fmt.Errorf("downloader: reading journal %s: %v", j.fn, err)
+	}
+	return !first, nil
+}
+
+func (j *Journal) write(verb, name string) error {
+	_, err := fmt.Fprintf(j.f, "%s %q\n", verb, name)
+	return err
+}
+
+// Done returns true if the named job finished in an earlier run.
+func (j *Journal) Done(name string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.done[name]
+}
+
+// Len returns the number of jobs which have finished.
+func (j *Journal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.done)
+}
+
+// Interrupted returns the names of the jobs which were running when an earlier run stopped, in order.
+// They are run again from the start.
+func (j *Journal) Interrupted() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	names := make([]string, 0, len(j.inFlight))
+	for n := range j.inFlight {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Start records that a job is being run. A Manager given the journal calls Start and Finish itself.
+func (j *Journal) Start(name string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.inFlight[name] = true
+	return j.write("start", name)
+}
+
+// Finish records that a job has finished successfully.
+func (j *Journal) Finish(name string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.inFlight, name)
+	j.done[name] = true
+	if err := j.write("done", name); err != nil {
+		return err
+	}
+	if time.Since(j.lastSync) < journalSyncInterval {
+		return nil
+	}
+	j.lastSync = time.Now()
+	return j.f.Sync()
+}
+
+// Forget records that a job should be run again next time, even though it finished.
+func (j *Journal) Forget(name string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.done[name] {
+		return nil
+	}
+	delete(j.done, name)
+	return j.write("forget", name)
+}
+
+// Close flushes the journal to disk and closes it, leaving it in place for the next run.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.f.Sync(); err != nil {
+		j.f.Close()
+		return err
+	}
+	return j.f.Close()
+}
+
+// Remove closes the journal and deletes it. It should be called once the operation has completed, so that a later run starts afresh.
+func (j *Journal) Remove() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.f.Close()
+	return os.Remove(j.fn)
+}
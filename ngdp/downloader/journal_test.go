@@ -0,0 +1,142 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestJournalResume(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "journal")
+
+	jl, err := OpenJournal(fn, "test")
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	var mu sync.Mutex
+	var saved []string
+	m := New(Options{Concurrency: 1, Retries: -1, Journal: jl})
+	m.Add(stringJob("a", 0, "1", &saved, &mu))
+	m.Add(&Job{
+		Name:     "b",
+		Priority: 1,
+		Open: func(ctx context.Context) (io.ReadCloser, error) {
+			return nil, errors.New("crashed")
+		},
+	})
+	if err := m.Run(context.Background()); err == nil {
+		t.Fatal("Run succeeded; want error")
+	}
+	if err := jl.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	jl, err = OpenJournal(fn, "test")
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	if !jl.Done("a") || jl.Done("b") {
+		t.Errorf("Done(a), Done(b) = %v, %v; want true, false", jl.Done("a"), jl.Done("b"))
+	}
+	if got, want := jl.Interrupted(), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Interrupted() = %v; want %v", got, want)
+	}
+
+	var completed []string
+	saved = nil
+	m = New(Options{
+		Concurrency: 1,
+		Journal:     jl,
+		OnComplete: func(j *Job, err error) {
+			if err == nil {
+				completed = append(completed, j.Name)
+			}
+		},
+	})
+	m.Add(stringJob("a", 0, "1", &saved, &mu))
+	m.Add(stringJob("b", 1, "2", &saved, &mu))
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if want := []string{"b=2"}; !reflect.DeepEqual(saved, want) {
+		t.Errorf("saved = %v; want %v", saved, want)
+	}
+	sort.Strings(completed)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(completed, want) {
+		t.Errorf("completed = %v; want %v", completed, want)
+	}
+	if p := m.Progress(); p.DoneJobs != 2 || p.SkippedJobs != 1 || p.TotalBytes != 1 {
+		t.Errorf("Progress() = %+v; want 2 done, 1 skipped, 1 total byte", p)
+	}
+
+	if err := jl.Remove(); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Errorf("journal still exists after Remove: %v", err)
+	}
+}
+
+func TestJournalDifferentOperation(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "journal")
+
+	jl, err := OpenJournal(fn, "first")
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	if err := jl.Start("a"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := jl.Finish("a"); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	jl.Close()
+
+	// A line cut short by a crash is ignored.
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`done "b`)
+	f.Close()
+
+	jl, err = OpenJournal(fn, "first")
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	if !jl.Done("a") || jl.Done("b") {
+		t.Errorf("Done(a), Done(b) = %v, %v; want true, false", jl.Done("a"), jl.Done("b"))
+	}
+	jl.Close()
+
+	jl, err = OpenJournal(fn, "second")
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	defer jl.Close()
+	if jl.Done("a") || jl.Len() != 0 {
+		t.Errorf("journal for another operation was not discarded: Len() = %d", jl.Len())
+	}
+}
@@ -17,12 +17,16 @@ limitations under the Licensm.
 package encoding
 
 import (
+	"bytes"
 	"crypto/md5"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"iter"
+	"runtime"
 	"sort"
+	"sync"
 
 	"github.com/lukegb/snowstorm/ngdp"
 )
@@ -35,16 +39,109 @@ var (
 	ErrBadHashSize        = fmt.Errorf("encoding: bad hash size in header")
 	ErrUnknownContentHash = fmt.Errorf("encoding: unknown content hash")
 	ErrTooManyCDNHashes   = fmt.Errorf("encoding: multiple CDN hashes listed")
+	ErrUnknownCDNHash     = fmt.Errorf("encoding: unknown CDN hash")
+	ErrAmbiguousPrefix    = fmt.Errorf("encoding: several CDN hashes start with prefix")
 )
 
-type mapEntry struct {
-	contentHash ngdp.ContentHash
-	cdnHashes   []ngdp.CDNHash
-}
-
 // A Mapper converts file content hashes into their corresponding CDN hashes.
+//
+// Encoding tables can list millions of files, so the hashes are kept in flat slabs rather than a struct per file: this is far smaller, and holds no pointers for the garbage collector to scan.
 type Mapper struct {
-	keys []mapEntry
+	contentHashes []byte   // 16 bytes per entry, sorted
+	cdnStarts     []uint32 // the index in cdnHashes of each entry's first CDN hash, with a final entry for the end
+	cdnHashes     []byte   // 16 bytes per CDN hash
+	sizes         []byte   // the decoded size of each entry, as 5-byte big-endian integers
+
+	// wanted, if set, lists the only content hashes whose entries are kept while parsing.
+	wanted map[ngdp.ContentHash]bool
+
+	// lazy, if set, is where the entries are read from page by page, and the slabs above are unused.
+	lazy *lazyPages
+
+	// partial indexes the CDN hashes for LookupPartial; it is built on first use.
+	partialOnce sync.Once
+	partial     []partialEntry
+	partialErr  error
+}
+
+// len returns the number of content hashes in the table.
+func (m *Mapper) len() int {
+	return len(m.contentHashes) / md5.Size
+}
+
+func (m *Mapper) contentHash(n int) ngdp.ContentHash {
+	return ngdp.ContentHash(sliceToHash(m.contentHashes[n*md5.Size:]))
+}
+
+func (m *Mapper) size(n int) uint64 {
+	var b [8]byte
+	copy(b[3:], m.sizes[n*5:(n+1)*5])
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// find returns the index of contentHash in the slabs.
+func (m *Mapper) find(contentHash ngdp.ContentHash) (int, error) {
+	i := sort.Search(m.len(), func(n int) bool {
+		return bytes.Compare(m.contentHashes[n*md5.Size:(n+1)*md5.Size], contentHash[:]) >= 0
+	})
+	if i >= m.len() || !m.contentHash(i).Equal(contentHash) {
+		return 0, ErrUnknownContentHash
+	}
+	return i, nil
+}
+
+func (m *Mapper) cdnHash(n uint32) ngdp.CDNHash {
+	return ngdp.CDNHash(sliceToHash(m.cdnHashes[int(n)*md5.Size:]))
+}
+
+// NewSelectiveMapper is like NewMapper, but keeps only the entries for the content hashes in wanted. Other content hashes are reported as unknown.
+//
+// Encoding tables list every file in a build, so this takes far less memory when only a known set of files will ever be looked up.
+func NewSelectiveMapper(r io.Reader, wanted map[ngdp.ContentHash]bool) (*Mapper, error) {
+	m := &Mapper{wanted: wanted}
+	if err := m.init(r); err != nil {
+		return nil, err
+	}
+	m.wanted = nil
+	return m, nil
+}
+
+// Select returns a new Mapper holding only the entries of m for the content hashes in wanted, as NewSelectiveMapper would have built.
+// This is useful when the wanted set can only be worked out from files found through m itself.
+//
+// A lazy Mapper has every page read to do this; the returned Mapper is never lazy.
+func (m *Mapper) Select(wanted map[ngdp.ContentHash]bool) (*Mapper, error) {
+	out := &Mapper{cdnStarts: []uint32{0}}
+	if m.lazy == nil {
+		out.appendSelected(m, wanted)
+		return out, nil
+	}
+	for n := 0; n < m.lazy.pages(); n++ {
+		m.lazy.mu.Lock()
+		p, ok := m.lazy.loaded[n]
+		m.lazy.mu.Unlock()
+		if !ok {
+			var err error
+			if p, err = m.lazy.read(n); err != nil {
+				return nil, err
+			}
+		}
+		out.appendSelected(p, wanted)
+	}
+	return out, nil
+}
+
+// appendSelected appends the entries of p for the content hashes in wanted to the Mapper's slabs.
+func (m *Mapper) appendSelected(p *Mapper, wanted map[ngdp.ContentHash]bool) {
+	for n := 0; n < p.len(); n++ {
+		if !wanted[p.contentHash(n)] {
+			continue
+		}
+		m.contentHashes = append(m.contentHashes, p.contentHashes[n*md5.Size:(n+1)*md5.Size]...)
+		m.sizes = append(m.sizes, p.sizes[n*5:(n+1)*5]...)
+		m.cdnHashes = append(m.cdnHashes, p.cdnHashes[int(p.cdnStarts[n])*md5.Size:int(p.cdnStarts[n+1])*md5.Size]...)
+		m.cdnStarts = append(m.cdnStarts, uint32(len(m.cdnHashes)/md5.Size))
+	}
 }
 
 // NewMapper creates a new Mapper from a provided encoding file.
@@ -58,34 +155,70 @@ func NewMapper(r io.Reader) (*Mapper, error) {
 	return m, nil
 }
 
+// An UnsupportedVersionError reports an encoding file with a header version this package can't read.
+type UnsupportedVersionError struct {
+	Version uint8
+}
+
+func (e UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("encoding: unsupported header version %d", e.Version)
+}
+
+// A HashSizeError reports an encoding file whose hashes aren't the 16 bytes every other part of NGDP uses.
+type HashSizeError struct {
+	ContentHashSize, CDNHashSize uint8
+}
+
+func (e HashSizeError) Error() string {
+	return fmt.Sprintf("%v: content hashes are %d bytes and CDN hashes %d; want 16", ErrBadHashSize, e.ContentHashSize, e.CDNHashSize)
+}
+
 type header struct {
+	version    uint8
 	hashSizeA  uint8
 	hashSizeB  uint8
-	flagsA     uint16
-	flagsB     uint16
+	pageSizeA  int // bytes per page of the key table
+	pageSizeB  int // bytes per page of the layout table
 	sizeA      uint32
 	sizeB      uint32
 	stringSize uint32
 }
 
+// headerSize is the size of the header in each supported version.
+var headerSize = map[uint8]int{
+	1: 22,
+}
+
 func (m *Mapper) readHeader(r io.Reader) (*header, error) {
-	buf := make([]byte, 22)
+	buf := make([]byte, 3)
 	if _, err := io.ReadFull(r, buf); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("encoding: reading header: %v", err)
 	}
-
 	if buf[0] != 'E' || buf[1] != 'N' {
 		return nil, ErrBadMagic
 	}
 
-	var h header
-	hashSizeA := buf[3]
-	hashSizeB := buf[4]
-	if hashSizeA != 0x10 || hashSizeB != 0x10 {
-		return nil, ErrBadHashSize
+	h := header{version: buf[2]}
+	size, ok := headerSize[h.version]
+	if !ok {
+		return nil, UnsupportedVersionError{h.version}
+	}
+	buf = append(buf, make([]byte, size-len(buf))...)
+	if _, err := io.ReadFull(r, buf[3:]); err != nil {
+		return nil, fmt.Errorf("encoding: reading header: %v", err)
+	}
+
+	h.hashSizeA = buf[3]
+	h.hashSizeB = buf[4]
+	if h.hashSizeA != 0x10 || h.hashSizeB != 0x10 {
+		return nil, HashSizeError{h.hashSizeA, h.hashSizeB}
+	}
+	// Page sizes are given in KiB.
+	h.pageSizeA = int(binary.BigEndian.Uint16(buf[0x5:0x7])) * 1024
+	h.pageSizeB = int(binary.BigEndian.Uint16(buf[0x7:0x9])) * 1024
+	if h.pageSizeA == 0 || h.pageSizeB == 0 {
+		return nil, fmt.Errorf("encoding: header gives a page size of zero")
 	}
-	h.flagsA = binary.BigEndian.Uint16(buf[0x5:0x7])
-	h.flagsB = binary.BigEndian.Uint16(buf[0x7:0x9])
 	h.sizeA = binary.BigEndian.Uint32(buf[0x9:0x0d])
 	h.sizeB = binary.BigEndian.Uint32(buf[0x0d:0x11])
 	h.stringSize = binary.BigEndian.Uint32(buf[0x12:0x16])
@@ -95,33 +228,257 @@ func (m *Mapper) readHeader(r io.Reader) (*header, error) {
 
 func sliceToHash(b []byte) hash {
 	var x [16]byte
-	for n := 0; n < 16; n++ {
-		x[n] = b[n]
-	}
+	copy(x[:], b)
 	return x
 }
 
 // ToCDNHash converts a content hash into a single CDN hash.
 //
-// It is possible for a single content hash to map to multiple CDN hashes. In this case, an error is thrown - the semantics of what multiple CDN hashes means is currently unclear.
+// It is possible for a single content hash to map to multiple CDN hashes. In this case, ErrTooManyCDNHashes is returned; use ToCDNHashes to choose between them.
 func (m *Mapper) ToCDNHash(contentHash ngdp.ContentHash) (ngdp.CDNHash, error) {
-	i := sort.Search(len(m.keys), func(n int) bool {
-		return !m.keys[n].contentHash.Less(contentHash)
-	})
-	if i >= len(m.keys) || !m.keys[i].contentHash.Equal(contentHash) {
-		return ngdp.CDNHash{}, ErrUnknownContentHash
+	if m.lazy != nil {
+		p, err := m.lazy.pageFor(contentHash)
+		if err != nil {
+			return ngdp.CDNHash{}, err
+		}
+		return p.ToCDNHash(contentHash)
 	}
-	x := m.keys[i]
-	if len(x.cdnHashes) != 1 {
+	i, err := m.find(contentHash)
+	if err != nil {
+		return ngdp.CDNHash{}, err
+	}
+	if m.cdnStarts[i+1]-m.cdnStarts[i] != 1 {
 		return ngdp.CDNHash{}, ErrTooManyCDNHashes
 	}
-	return x.cdnHashes[0], nil
+	return m.cdnHash(m.cdnStarts[i]), nil
+}
+
+// ToCDNHashes returns every CDN hash listed for a content hash. Most files have exactly one; those with several have been encoded in more than one way, and any of them can be fetched.
+func (m *Mapper) ToCDNHashes(contentHash ngdp.ContentHash) ([]ngdp.CDNHash, error) {
+	if m.lazy != nil {
+		p, err := m.lazy.pageFor(contentHash)
+		if err != nil {
+			return nil, err
+		}
+		return p.ToCDNHashes(contentHash)
+	}
+	i, err := m.find(contentHash)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ngdp.CDNHash, 0, m.cdnStarts[i+1]-m.cdnStarts[i])
+	for c := m.cdnStarts[i]; c < m.cdnStarts[i+1]; c++ {
+		out = append(out, m.cdnHash(c))
+	}
+	return out, nil
+}
+
+// Size returns the decoded size of the file with the given content hash.
+func (m *Mapper) Size(contentHash ngdp.ContentHash) (uint64, error) {
+	if m.lazy != nil {
+		p, err := m.lazy.pageFor(contentHash)
+		if err != nil {
+			return 0, err
+		}
+		return p.Size(contentHash)
+	}
+	i, err := m.find(contentHash)
+	if err != nil {
+		return 0, err
+	}
+	return m.size(i), nil
+}
+
+// CDNHashes returns every CDN hash listed in the encoding table.
+func (m *Mapper) CDNHashes() []ngdp.CDNHash {
+	out := make([]ngdp.CDNHash, 0, len(m.cdnHashes)/md5.Size)
+	for _, h := range m.All() {
+		out = append(out, h)
+	}
+	return out
+}
+
+// All yields every content hash in the encoding table, in order, with its CDN hash. A content hash with several CDN hashes is yielded once for each.
+//
+// A lazy Mapper stops early if a page can't be read; Err then reports why.
+func (m *Mapper) All() iter.Seq2[ngdp.ContentHash, ngdp.CDNHash] {
+	return func(yield func(ngdp.ContentHash, ngdp.CDNHash) bool) {
+		if m.lazy != nil {
+			m.lazy.all(yield)
+			return
+		}
+		for n := 0; n < m.len(); n++ {
+			ch := m.contentHash(n)
+			for c := m.cdnStarts[n]; c < m.cdnStarts[n+1]; c++ {
+				if !yield(ch, m.cdnHash(c)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// parsePage appends the entries in the n'th page of the key table to the Mapper's slabs.
+//
+// Each entry is a count of CDN hashes, the 40-bit decoded size, the content hash, and then the CDN hashes. Pages are padded with zeroes.
+func (m *Mapper) parsePage(buf []byte, n uint32) error {
+	keybuf := buf
+	for len(keybuf) >= 0x16 {
+		cdnKeyCount := int(keybuf[0])
+		if cdnKeyCount == 0 {
+			break
+		}
+		if len(keybuf) < 0x16+0x10*cdnKeyCount {
+			return fmt.Errorf("encoding: key table entry %d overruns its page", n)
+		}
+		if m.wanted != nil && !m.wanted[ngdp.ContentHash(sliceToHash(keybuf[0x06:0x16]))] {
+			keybuf = keybuf[0x16+0x10*cdnKeyCount:]
+			continue
+		}
+		m.sizes = append(m.sizes, keybuf[0x01:0x06]...)
+		m.contentHashes = append(m.contentHashes, keybuf[0x06:0x16]...)
+		keybuf = keybuf[0x16:]
+		m.cdnHashes = append(m.cdnHashes, keybuf[:0x10*cdnKeyCount]...)
+		keybuf = keybuf[0x10*cdnKeyCount:]
+		m.cdnStarts = append(m.cdnStarts, uint32(len(m.cdnHashes)/md5.Size))
+	}
+	return nil
+}
+
+// appendPage appends the entries of a page parsed on its own to the Mapper's slabs.
+func (m *Mapper) appendPage(p *Mapper) {
+	base := uint32(len(m.cdnHashes) / md5.Size)
+	m.contentHashes = append(m.contentHashes, p.contentHashes...)
+	m.cdnHashes = append(m.cdnHashes, p.cdnHashes...)
+	m.sizes = append(m.sizes, p.sizes...)
+	for _, c := range p.cdnStarts[1:] {
+		m.cdnStarts = append(m.cdnStarts, base+c)
+	}
+}
+
+// A pendingPage is a key table page on its way through readPages. They are recycled once their entries have been appended.
+type pendingPage struct {
+	n    int
+	buf  []byte
+	page Mapper
+	err  error
+	done chan struct{}
+}
+
+// readPages reads the key table's pages from r, checking them against checksums, and appends their entries to the Mapper's slabs.
+//
+// Pages are read in order from r, but checked and parsed by a worker for each CPU; their entries are then appended in order. Only a few pages are read ahead of the one being appended, which bounds how much memory is in flight.
+func (m *Mapper) readPages(r io.Reader, pageSize int, checksums []byte) error {
+	pages := len(checksums) / md5.Size
+	workers := runtime.GOMAXPROCS(0)
+	if workers > pages {
+		workers = pages
+	}
+	if workers <= 1 {
+		// Not worth the goroutines.
+		buf := make([]byte, pageSize)
+		for n := 0; n < pages; n++ {
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return fmt.Errorf("encoding: reading %d entry in key table: %v", n, err)
+			}
+			if err := checkPage(buf, n, checksums); err != nil {
+				return err
+			}
+			if err := m.parsePage(buf, uint32(n)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	perPage := pageSize / 0x26
+	free := make(chan *pendingPage, 2*workers)
+	for i := 0; i < cap(free); i++ {
+		free <- &pendingPage{
+			buf: make([]byte, pageSize),
+			page: Mapper{
+				wanted:        m.wanted,
+				contentHashes: make([]byte, 0, perPage*md5.Size),
+				cdnHashes:     make([]byte, 0, perPage*md5.Size),
+				cdnStarts:     make([]uint32, 1, perPage+1),
+				sizes:         make([]byte, 0, perPage*5),
+			},
+		}
+	}
+	work := make(chan *pendingPage)
+	order := make(chan *pendingPage, cap(free))
+	stop := make(chan struct{})
+	readerDone := make(chan struct{})
+
+	// The reader feeds pages to the workers, and to the loop below in order.
+	go func() {
+		defer close(readerDone)
+		defer close(order)
+		defer close(work)
+		for n := 0; n < pages; n++ {
+			var p *pendingPage
+			select {
+			case p = <-free:
+			case <-stop:
+				return
+			}
+			p.n, p.err, p.done = n, nil, make(chan struct{})
+			if _, err := io.ReadFull(r, p.buf); err != nil {
+				p.err = fmt.Errorf("encoding: reading %d entry in key table: %v", n, err)
+				close(p.done)
+				order <- p
+				return
+			}
+			// Neither send blocks for long: order has room for every pendingPage.
+			order <- p
+			work <- p
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for p := range work {
+				if p.err = checkPage(p.buf, p.n, checksums); p.err == nil {
+					p.err = p.page.parsePage(p.buf, uint32(p.n))
+				}
+				close(p.done)
+			}
+		}()
+	}
+
+	var err error
+	for p := range order {
+		<-p.done
+		if p.err != nil {
+			err = p.err
+			break
+		}
+		m.appendPage(&p.page)
+		p.page.contentHashes = p.page.contentHashes[:0]
+		p.page.cdnHashes = p.page.cdnHashes[:0]
+		p.page.cdnStarts = p.page.cdnStarts[:1]
+		p.page.sizes = p.page.sizes[:0]
+		free <- p
+	}
+	close(stop)
+	// Wait for the reader, since the caller carries on reading from r.
+	<-readerDone
+	return err
+}
+
+// checkPage checks the n'th page against its MD5 in checksums.
+func checkPage(buf []byte, n int, checksums []byte) error {
+	want := checksums[n*md5.Size : (n+1)*md5.Size]
+	if h := md5.Sum(buf); !bytes.Equal(h[:], want) {
+		return fmt.Errorf("encoding: key table entry %d hash mismatch: want %x, got %x", n, want, h)
+	}
+	return nil
 }
 
 func (m *Mapper) init(r io.Reader) error {
 	h, err := m.readHeader(r)
 	if err != nil {
-		return fmt.Errorf("encoding: reading header: %v", err)
+		return err
 	}
 
 	// Skip over the layout string table; we don't need it
@@ -130,65 +487,40 @@ func (m *Mapper) init(r io.Reader) error {
 	}
 
 	// Read key table index
-	keyEntryHashes := make([][16]byte, h.sizeA)
+	checksums := make([]byte, 0, 16*int(h.sizeA))
 	buf := make([]byte, 32)
 	for n := uint32(0); n < h.sizeA; n++ {
 		if _, err := io.ReadFull(r, buf); err != nil {
 			return fmt.Errorf("encoding: reading %d entry in key table index: %v", n, err)
 		}
-		for x := 0; x < 16; x++ {
-			keyEntryHashes[n][x] = buf[0x10+x]
-		}
+		checksums = append(checksums, buf[0x10:0x20]...)
 	}
 
-	var slc []mapEntry
-
-	// Read key table entries
-	buf = make([]byte, 4096)
-	for n := uint32(0); n < h.sizeA; n++ {
-		if _, err := io.ReadFull(r, buf); err != nil {
-			return fmt.Errorf("encoding: reading %d entry in key table: %v", n, err)
-		}
-		h := md5.Sum(buf)
-		match := true
-		for x := 0; x < 16; x++ {
-			if h[x] != keyEntryHashes[n][x] {
-				match = false
-			}
-		}
-		if !match {
-			return fmt.Errorf("encoding: key table entry %d hash mismatch: want %x, got %x", keyEntryHashes[n], h)
-		}
-
-		keybuf := buf
-		for {
-			cdnKeyCount := binary.LittleEndian.Uint16(keybuf[0x0:0x2])
-			if cdnKeyCount == 0x0 {
-				break
-			}
-			contentHash := ngdp.ContentHash(sliceToHash(keybuf[0x06:0x16]))
-			keybuf = keybuf[0x16:]
-			cdnKeys := make([]ngdp.CDNHash, cdnKeyCount)
-			for x := uint16(0); x < cdnKeyCount; x++ {
-				cdnKeys[x] = ngdp.CDNHash(sliceToHash(keybuf[:0x10]))
-				keybuf = keybuf[0x10:]
-			}
+	// Pages hold at most this many entries, each with one CDN hash, which is by far the most common case.
+	est := int(h.sizeA) * (h.pageSizeA / 0x26)
+	if m.wanted != nil && len(m.wanted) < est {
+		est = len(m.wanted)
+	}
+	m.contentHashes = make([]byte, 0, est*md5.Size)
+	m.cdnHashes = make([]byte, 0, est*md5.Size)
+	m.cdnStarts = make([]uint32, 1, est+1)
+	m.sizes = make([]byte, 0, est*5)
 
-			slc = append(slc, mapEntry{
-				contentHash: contentHash,
-				cdnHashes:   cdnKeys,
-			})
-		}
+	if err := m.readPages(r, h.pageSizeA, checksums); err != nil {
+		return err
 	}
 
-	m.keys = make([]mapEntry, len(slc))
-	copy(m.keys, slc)
+	// Trim the slabs' spare capacity, since the Mapper may be kept for a long time.
+	m.contentHashes = append([]byte(nil), m.contentHashes...)
+	m.cdnStarts = append([]uint32(nil), m.cdnStarts...)
+	m.cdnHashes = append([]byte(nil), m.cdnHashes...)
+	m.sizes = append([]byte(nil), m.sizes...)
 
 	// Skip over layout table index and entries
-	if _, err := io.CopyN(ioutil.Discard, r, int64(h.sizeB*32)); err != nil {
+	if _, err := io.CopyN(ioutil.Discard, r, int64(h.sizeB)*32); err != nil {
 		return fmt.Errorf("encoding: skipping layout table index: %v", err)
 	}
-	if _, err := io.CopyN(ioutil.Discard, r, int64(h.sizeB*4096)); err != nil {
+	if _, err := io.CopyN(ioutil.Discard, r, int64(h.sizeB)*int64(h.pageSizeB)); err != nil {
 		return fmt.Errorf("encoding: skipping layout table entries: %v", err)
 	}
 	// TODO(lukegb): also skip over the layout string that describes this file at the end
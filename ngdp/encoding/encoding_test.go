@@ -0,0 +1,280 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encoding_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/encoding"
+	"github.com/lukegb/snowstorm/ngdp/ngdptest"
+)
+
+func BenchmarkNewMapper(b *testing.B) {
+	for _, n := range []int{10000, 100000, 1000000} {
+		table := ngdptest.SyntheticEncodingTable(n)
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			b.SetBytes(int64(len(table)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := encoding.NewMapper(bytes.NewReader(table)); err != nil {
+					b.Fatalf("NewMapper: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func syntheticHash(kind byte, n int) [md5.Size]byte {
+	var b [9]byte
+	b[0] = kind
+	binary.BigEndian.PutUint64(b[1:], uint64(n))
+	return md5.Sum(b[:])
+}
+
+func TestMapper(t *testing.T) {
+	const n = 5000
+	m, err := encoding.NewMapper(bytes.NewReader(ngdptest.SyntheticEncodingTable(n)))
+	if err != nil {
+		t.Fatalf("NewMapper: %v", err)
+	}
+
+	for i := 0; i < n; i++ {
+		got, err := m.ToCDNHash(ngdp.ContentHash(syntheticHash('c', i)))
+		if want := ngdp.CDNHash(syntheticHash('e', i)); err != nil || got != want {
+			t.Fatalf("ToCDNHash(file %d) = %032x, %v; want %032x", i, got, err, want)
+		}
+		// Synthetic files are numbered by size.
+		if size, err := m.Size(ngdp.ContentHash(syntheticHash('c', i))); err != nil || size != uint64(i) {
+			t.Fatalf("Size(file %d) = %d, %v; want %d", i, size, err, i)
+		}
+	}
+	if _, err := m.ToCDNHash(ngdp.ContentHash(syntheticHash('x', 0))); err != encoding.ErrUnknownContentHash {
+		t.Errorf("ToCDNHash(unknown) = %v; want %v", err, encoding.ErrUnknownContentHash)
+	}
+
+	var last ngdp.ContentHash
+	count := 0
+	for ch := range m.All() {
+		if count > 0 && !last.Less(ch) {
+			t.Fatalf("All() yielded %032x after %032x", ch, last)
+		}
+		last = ch
+		count++
+	}
+	if count != n || len(m.CDNHashes()) != n {
+		t.Errorf("All() yielded %d hashes and CDNHashes() %d; want %d", count, len(m.CDNHashes()), n)
+	}
+}
+
+func TestMapperCorruptPage(t *testing.T) {
+	table := ngdptest.SyntheticEncodingTable(5000)
+	for _, at := range []int{len(table) / 2, len(table) - 100} {
+		corrupt := append([]byte(nil), table...)
+		corrupt[at] ^= 0xff
+		if _, err := encoding.NewMapper(bytes.NewReader(corrupt)); err == nil || !strings.Contains(err.Error(), "hash mismatch") {
+			t.Errorf("NewMapper(table corrupted at byte %d) = %v; want hash mismatch", at, err)
+		}
+	}
+	if _, err := encoding.NewMapper(bytes.NewReader(table[:len(table)/2])); err == nil {
+		t.Errorf("NewMapper(truncated table) = nil error; want error")
+	}
+}
+
+func TestLazyMapper(t *testing.T) {
+	const n = 5000
+	table := ngdptest.SyntheticEncodingTable(n)
+	m, err := encoding.NewLazyMapper(bytes.NewReader(table), int64(len(table)))
+	if err != nil {
+		t.Fatalf("NewLazyMapper: %v", err)
+	}
+
+	for _, i := range []int{0, 1, n / 2, n - 1} {
+		got, err := m.ToCDNHash(ngdp.ContentHash(syntheticHash('c', i)))
+		if want := ngdp.CDNHash(syntheticHash('e', i)); err != nil || got != want {
+			t.Errorf("ToCDNHash(file %d) = %032x, %v; want %032x", i, got, err, want)
+		}
+		if size, err := m.Size(ngdp.ContentHash(syntheticHash('c', i))); err != nil || size != uint64(i) {
+			t.Errorf("Size(file %d) = %d, %v; want %d", i, size, err, i)
+		}
+	}
+	if _, err := m.ToCDNHash(ngdp.ContentHash{}); err != encoding.ErrUnknownContentHash {
+		t.Errorf("ToCDNHash(before the first page) = %v; want %v", err, encoding.ErrUnknownContentHash)
+	}
+
+	eager, err := encoding.NewMapper(bytes.NewReader(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.CDNHashes(), eager.CDNHashes(); len(got) != len(want) || m.Err() != nil {
+		t.Errorf("CDNHashes() returned %d hashes, %v; want %d", len(got), m.Err(), len(want))
+	}
+
+	// Corruption is found when the damaged page is first needed.
+	corrupt := append([]byte(nil), table...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	m, err = encoding.NewLazyMapper(bytes.NewReader(corrupt), int64(len(corrupt)))
+	if err != nil {
+		t.Fatalf("NewLazyMapper(corrupt table): %v", err)
+	}
+	for range m.All() {
+	}
+	if m.Err() == nil {
+		t.Errorf("walking a corrupt table: Err() = nil; want error")
+	}
+}
+
+func TestMapperHeaderErrors(t *testing.T) {
+	table := ngdptest.SyntheticEncodingTable(10)
+	for _, test := range []struct {
+		name   string
+		offset int
+		value  byte
+		check  func(error) bool
+	}{
+		{"version", 2, 9, func(err error) bool {
+			e, ok := err.(encoding.UnsupportedVersionError)
+			return ok && e.Version == 9
+		}},
+		{"hash size", 3, 9, func(err error) bool {
+			e, ok := err.(encoding.HashSizeError)
+			return ok && e.ContentHashSize == 9
+		}},
+	} {
+		bad := append([]byte(nil), table...)
+		bad[test.offset] = test.value
+		if _, err := encoding.NewMapper(bytes.NewReader(bad)); !test.check(err) {
+			t.Errorf("%s: NewMapper = %v", test.name, err)
+		}
+		if _, err := encoding.NewLazyMapper(bytes.NewReader(bad), int64(len(bad))); !test.check(err) {
+			t.Errorf("%s: NewLazyMapper = %v", test.name, err)
+		}
+	}
+}
+
+// singlePageTable builds an encoding table with one page, listing each content hash with the CDN hashes in ekeys.
+func singlePageTable(ckeys []ngdp.ContentHash, ekeys map[ngdp.ContentHash][]ngdp.CDNHash) []byte {
+	page := make([]byte, 0, 4096)
+	for _, ck := range ckeys {
+		page = append(page, byte(len(ekeys[ck])), 0, 0, 0, 0, 0)
+		page = append(page, ck[:]...)
+		for _, ek := range ekeys[ck] {
+			page = append(page, ek[:]...)
+		}
+	}
+	page = page[:cap(page)]
+
+	hdr := make([]byte, 22)
+	copy(hdr, "EN")
+	hdr[2] = 1
+	hdr[3], hdr[4] = 0x10, 0x10
+	binary.BigEndian.PutUint16(hdr[5:], 4)
+	binary.BigEndian.PutUint16(hdr[7:], 4)
+	binary.BigEndian.PutUint32(hdr[9:], 1)
+	sum := md5.Sum(page)
+	b := append(hdr, ckeys[0][:]...)
+	b = append(b, sum[:]...)
+	return append(b, page...)
+}
+
+func TestToCDNHashes(t *testing.T) {
+	one := ngdp.ContentHash{0x01}
+	many := ngdp.ContentHash{0x02}
+	ekeys := map[ngdp.ContentHash][]ngdp.CDNHash{
+		one:  {{0xe1}},
+		many: {{0xe2}, {0xe3}, {0xe4}},
+	}
+	table := singlePageTable([]ngdp.ContentHash{one, many}, ekeys)
+
+	eager, err := encoding.NewMapper(bytes.NewReader(table))
+	if err != nil {
+		t.Fatalf("NewMapper: %v", err)
+	}
+	lazy, err := encoding.NewLazyMapper(bytes.NewReader(table), int64(len(table)))
+	if err != nil {
+		t.Fatalf("NewLazyMapper: %v", err)
+	}
+
+	for name, m := range map[string]*encoding.Mapper{"eager": eager, "lazy": lazy} {
+		for _, ck := range []ngdp.ContentHash{one, many} {
+			got, err := m.ToCDNHashes(ck)
+			if err != nil || fmt.Sprint(got) != fmt.Sprint(ekeys[ck]) {
+				t.Errorf("%s: ToCDNHashes(%032x) = %032x, %v; want %032x", name, ck, got, err, ekeys[ck])
+			}
+		}
+		if _, err := m.ToCDNHash(many); err != encoding.ErrTooManyCDNHashes {
+			t.Errorf("%s: ToCDNHash(%032x) = %v; want %v", name, many, err, encoding.ErrTooManyCDNHashes)
+		}
+		if _, err := m.ToCDNHashes(ngdp.ContentHash{0x03}); err != encoding.ErrUnknownContentHash {
+			t.Errorf("%s: ToCDNHashes(unknown) = %v; want %v", name, err, encoding.ErrUnknownContentHash)
+		}
+	}
+}
+
+func TestSelectiveMapper(t *testing.T) {
+	const n = 5000
+	table := ngdptest.SyntheticEncodingTable(n)
+	wanted := map[ngdp.ContentHash]bool{}
+	for _, i := range []int{0, 17, n / 2, n - 1} {
+		wanted[ngdp.ContentHash(syntheticHash('c', i))] = true
+	}
+
+	selective, err := encoding.NewSelectiveMapper(bytes.NewReader(table), wanted)
+	if err != nil {
+		t.Fatalf("NewSelectiveMapper: %v", err)
+	}
+	eager, err := encoding.NewMapper(bytes.NewReader(table))
+	if err != nil {
+		t.Fatalf("NewMapper: %v", err)
+	}
+	lazy, err := encoding.NewLazyMapper(bytes.NewReader(table), int64(len(table)))
+	if err != nil {
+		t.Fatalf("NewLazyMapper: %v", err)
+	}
+	fromEager, err := eager.Select(wanted)
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	fromLazy, err := lazy.Select(wanted)
+	if err != nil {
+		t.Fatalf("Select(lazy): %v", err)
+	}
+
+	for name, m := range map[string]*encoding.Mapper{"NewSelectiveMapper": selective, "Select": fromEager, "Select(lazy)": fromLazy} {
+		for _, i := range []int{0, 17, n / 2, n - 1} {
+			ch := ngdp.ContentHash(syntheticHash('c', i))
+			got, err := m.ToCDNHash(ch)
+			if want := ngdp.CDNHash(syntheticHash('e', i)); err != nil || got != want {
+				t.Errorf("%s: ToCDNHash(file %d) = %032x, %v; want %032x", name, i, got, err, want)
+			}
+			if size, err := m.Size(ch); err != nil || size != uint64(i) {
+				t.Errorf("%s: Size(file %d) = %d, %v; want %d", name, i, size, err, i)
+			}
+		}
+		if _, err := m.ToCDNHash(ngdp.ContentHash(syntheticHash('c', 1))); err != encoding.ErrUnknownContentHash {
+			t.Errorf("%s: ToCDNHash(unwanted file) = %v; want %v", name, err, encoding.ErrUnknownContentHash)
+		}
+		if got := len(m.CDNHashes()); got != len(wanted) {
+			t.Errorf("%s: CDNHashes() returned %d hashes; want %d", name, got, len(wanted))
+		}
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encoding
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+// lazyPages holds what a lazy Mapper needs to find and load key table pages.
+type lazyPages struct {
+	r        io.ReaderAt
+	offset   int64 // where the first page starts in r
+	pageSize int
+
+	firstKeys []byte // the first content hash of each page, 16 bytes each
+	checksums []byte // the MD5 of each page, 16 bytes each
+
+	mu     sync.Mutex
+	loaded map[int]*Mapper
+	err    error // the first error All ran into
+}
+
+// NewLazyMapper creates a Mapper which reads only the header and page index of the encoding file in r, which is size bytes long.
+// Key table pages are read, checked and parsed the first time a lookup needs them, and kept for later lookups.
+//
+// As with NewMapper, the encoding file must already have been decoded from BLTE; r might be a file the decoded table was saved to.
+// r must stay readable for as long as the Mapper is used.
+func NewLazyMapper(r io.ReaderAt, size int64) (*Mapper, error) {
+	m := &Mapper{}
+	sr := io.NewSectionReader(r, 0, size)
+	h, err := m.readHeader(sr)
+	if err != nil {
+		return nil, err
+	}
+
+	indexStart := int64(headerSize[h.version]) + int64(h.stringSize)
+	if end := indexStart + (32+int64(h.pageSizeA))*int64(h.sizeA); end > size {
+		return nil, fmt.Errorf("encoding: key table needs %d bytes, but the file is only %d", end, size)
+	}
+	index := make([]byte, 32*int64(h.sizeA))
+	if _, err := r.ReadAt(index, indexStart); err != nil {
+		return nil, fmt.Errorf("encoding: reading key table index: %v", err)
+	}
+	lp := &lazyPages{
+		r:         r,
+		offset:    indexStart + int64(len(index)),
+		pageSize:  h.pageSizeA,
+		firstKeys: make([]byte, 0, 16*int(h.sizeA)),
+		checksums: make([]byte, 0, 16*int(h.sizeA)),
+		loaded:    make(map[int]*Mapper),
+	}
+	for n := 0; n < int(h.sizeA); n++ {
+		lp.firstKeys = append(lp.firstKeys, index[32*n:32*n+16]...)
+		lp.checksums = append(lp.checksums, index[32*n+16:32*n+32]...)
+	}
+	m.lazy = lp
+	return m, nil
+}
+
+// pages returns the number of pages in the key table.
+func (lp *lazyPages) pages() int {
+	return len(lp.firstKeys) / md5.Size
+}
+
+// read reads, checks and parses the n'th page.
+func (lp *lazyPages) read(n int) (*Mapper, error) {
+	buf := make([]byte, lp.pageSize)
+	if _, err := lp.r.ReadAt(buf, lp.offset+int64(lp.pageSize)*int64(n)); err != nil {
+		return nil, fmt.Errorf("encoding: reading %d entry in key table: %v", n, err)
+	}
+	if err := checkPage(buf, n, lp.checksums); err != nil {
+		return nil, err
+	}
+	page := &Mapper{cdnStarts: []uint32{0}}
+	if err := page.parsePage(buf, uint32(n)); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// page returns the n'th page, loading it if it hasn't been already.
+func (lp *lazyPages) page(n int) (*Mapper, error) {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	if p, ok := lp.loaded[n]; ok {
+		return p, nil
+	}
+	p, err := lp.read(n)
+	if err != nil {
+		return nil, err
+	}
+	lp.loaded[n] = p
+	return p, nil
+}
+
+// pageFor returns the only page which could hold contentHash.
+func (lp *lazyPages) pageFor(contentHash ngdp.ContentHash) (*Mapper, error) {
+	// The page is the last one starting at or before the hash.
+	n := sort.Search(lp.pages(), func(n int) bool {
+		return bytes.Compare(lp.firstKeys[n*md5.Size:(n+1)*md5.Size], contentHash[:]) > 0
+	}) - 1
+	if n < 0 {
+		return nil, ErrUnknownContentHash
+	}
+	return lp.page(n)
+}
+
+// all yields every entry, page by page. Pages which haven't been loaded are read for the walk, but not kept.
+func (lp *lazyPages) all(yield func(ngdp.ContentHash, ngdp.CDNHash) bool) {
+	for n := 0; n < lp.pages(); n++ {
+		lp.mu.Lock()
+		p, ok := lp.loaded[n]
+		lp.mu.Unlock()
+		if !ok {
+			var err error
+			if p, err = lp.read(n); err != nil {
+				lp.mu.Lock()
+				if lp.err == nil {
+					lp.err = err
+				}
+				lp.mu.Unlock()
+				return
+			}
+		}
+		for ch, h := range p.All() {
+			if !yield(ch, h) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the error which stopped a lazy Mapper's All early, if a page couldn't be read. It is always nil for other Mappers.
+func (m *Mapper) Err() error {
+	if m.lazy == nil {
+		return nil
+	}
+	m.lazy.mu.Lock()
+	defer m.lazy.mu.Unlock()
+	return m.lazy.err
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encoding
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+// A partialEntry is a CDN hash in the index LookupPartial searches, along with the content hash it belongs to.
+type partialEntry struct {
+	cdnHash     hash
+	contentHash hash
+}
+
+// buildPartial indexes every CDN hash in the table by its value.
+func (m *Mapper) buildPartial() {
+	var entries []partialEntry
+	if m.lazy == nil {
+		entries = make([]partialEntry, 0, len(m.cdnHashes)/16)
+	}
+	for ch, h := range m.All() {
+		entries = append(entries, partialEntry{hash(h), hash(ch)})
+	}
+	if err := m.Err(); err != nil {
+		m.partialErr = err
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].cdnHash[:], entries[j].cdnHash[:]) < 0 })
+	m.partial = entries
+}
+
+// LookupPartial finds the file whose CDN hash starts with prefix, returning its content hash and full CDN hash.
+// Archive indices and local storage indices refer to files by only the first few bytes of their CDN hash; this resolves them without keeping a separate index.
+//
+// The index is built the first time LookupPartial is called, which for a lazy Mapper means reading every page.
+// ErrAmbiguousPrefix is returned if more than one CDN hash starts with prefix.
+func (m *Mapper) LookupPartial(prefix []byte) (ngdp.ContentHash, ngdp.CDNHash, error) {
+	if len(prefix) == 0 || len(prefix) > len(hash{}) {
+		return ngdp.ContentHash{}, ngdp.CDNHash{}, fmt.Errorf("encoding: partial CDN hash must be 1 to %d bytes, not %d", len(hash{}), len(prefix))
+	}
+	m.partialOnce.Do(m.buildPartial)
+	if m.partialErr != nil {
+		return ngdp.ContentHash{}, ngdp.CDNHash{}, m.partialErr
+	}
+
+	n := sort.Search(len(m.partial), func(n int) bool {
+		return bytes.Compare(m.partial[n].cdnHash[:len(prefix)], prefix) >= 0
+	})
+	if n == len(m.partial) || !bytes.Equal(m.partial[n].cdnHash[:len(prefix)], prefix) {
+		return ngdp.ContentHash{}, ngdp.CDNHash{}, ErrUnknownCDNHash
+	}
+	e := m.partial[n]
+	if n+1 < len(m.partial) && m.partial[n+1].cdnHash != e.cdnHash && bytes.Equal(m.partial[n+1].cdnHash[:len(prefix)], prefix) {
+		return ngdp.ContentHash{}, ngdp.CDNHash{}, ErrAmbiguousPrefix
+	}
+	return ngdp.ContentHash(e.contentHash), ngdp.CDNHash(e.cdnHash), nil
+}
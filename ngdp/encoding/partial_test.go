@@ -0,0 +1,73 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encoding_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/encoding"
+	"github.com/lukegb/snowstorm/ngdp/ngdptest"
+)
+
+func TestLookupPartial(t *testing.T) {
+	const n = 5000
+	table := ngdptest.SyntheticEncodingTable(n)
+	eager, err := encoding.NewMapper(bytes.NewReader(table))
+	if err != nil {
+		t.Fatalf("NewMapper: %v", err)
+	}
+	lazy, err := encoding.NewLazyMapper(bytes.NewReader(table), int64(len(table)))
+	if err != nil {
+		t.Fatalf("NewLazyMapper: %v", err)
+	}
+
+	for name, m := range map[string]*encoding.Mapper{"eager": eager, "lazy": lazy} {
+		for _, i := range []int{0, 1, n / 2, n - 1} {
+			want := ngdp.CDNHash(syntheticHash('e', i))
+			ch, h, err := m.LookupPartial(want[:9])
+			if err != nil || h != want || ch != ngdp.ContentHash(syntheticHash('c', i)) {
+				t.Errorf("%s: LookupPartial(file %d) = %032x, %032x, %v; want %032x, %032x", name, i, ch, h, err, syntheticHash('c', i), want)
+			}
+		}
+		if _, _, err := m.LookupPartial([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9}); err != encoding.ErrUnknownCDNHash {
+			t.Errorf("%s: LookupPartial(unknown) = %v; want %v", name, err, encoding.ErrUnknownCDNHash)
+		}
+		if _, _, err := m.LookupPartial(nil); err == nil {
+			t.Errorf("%s: LookupPartial(nil) = nil error; want error", name)
+		}
+	}
+}
+
+func TestLookupPartialAmbiguous(t *testing.T) {
+	a, b := ngdp.ContentHash{0x01}, ngdp.ContentHash{0x02}
+	table := singlePageTable([]ngdp.ContentHash{a, b}, map[ngdp.ContentHash][]ngdp.CDNHash{
+		a: {{0xe1, 0x01}},
+		b: {{0xe1, 0x02}},
+	})
+	m, err := encoding.NewMapper(bytes.NewReader(table))
+	if err != nil {
+		t.Fatalf("NewMapper: %v", err)
+	}
+	if _, _, err := m.LookupPartial([]byte{0xe1}); err != encoding.ErrAmbiguousPrefix {
+		t.Errorf("LookupPartial(e1) = %v; want %v", err, encoding.ErrAmbiguousPrefix)
+	}
+	if ch, _, err := m.LookupPartial([]byte{0xe1, 0x02}); err != nil || ch != b {
+		t.Errorf("LookupPartial(e102) = %032x, %v; want %032x", ch, err, b)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package filetype identifies the formats of files commonly found in Blizzard games.
+package filetype
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path"
+	"strings"
+)
+
+// SniffLen is the number of bytes of a file Detect looks at.
+const SniffLen = 512
+
+// A Type is a file format.
+type Type struct {
+	// Name is a short, human-readable name for the format.
+	Name string
+
+	// MIME is the media type files of this format should be served as.
+	MIME string
+}
+
+var (
+	Unknown = Type{"Unknown", "application/octet-stream"}
+	Text    = Type{"Text", "text/plain; charset=utf-8"}
+
+	BLP    = Type{"BLP", "image/x-blp"}
+	DDS    = Type{"DDS", "image/vnd-ms.dds"}
+	PNG    = Type{"PNG", "image/png"}
+	TGA    = Type{"TGA", "image/x-tga"}
+	M3     = Type{"M3", "application/x-m3"}
+	M2     = Type{"M2", "application/x-m2"}
+	MPQ    = Type{"MPQ", "application/x-mpq"}
+	OGG    = Type{"OGG", "audio/ogg"}
+	WAV    = Type{"WAV", "audio/wav"}
+	XML    = Type{"XML", "application/xml; charset=utf-8"}
+	Galaxy = Type{"Galaxy", "text/x-galaxy; charset=utf-8"}
+)
+
+// magics lists the formats which can be recognised by how they start.
+var magics = []struct {
+	offset int
+	magic  string
+	typ    Type
+}{
+	{0, "BLP1", BLP},
+	{0, "BLP2", BLP},
+	{0, "DDS ", DDS},
+	{0, "\x89PNG\r\n\x1a\n", PNG},
+	{0, "43DM", M3},
+	{0, "MD20", M2},
+	{0, "MD21", M2},
+	{0, "MPQ\x1a", MPQ},
+	{0, "MPQ\x1b", MPQ}, // user data header, found where an MPQ is embedded in another file
+	{0, "OggS", OGG},
+	{8, "WAVE", WAV},
+	{0, "<?xml", XML},
+	{0, "\xef\xbb\xbf<?xml", XML},
+}
+
+// extensions maps filename extensions to formats, for when a file's contents are unavailable or inconclusive.
+var extensions = map[string]Type{
+	".blp":    BLP,
+	".dds":    DDS,
+	".png":    PNG,
+	".tga":    TGA,
+	".m3":     M3,
+	".m2":     M2,
+	".mpq":    MPQ,
+	".ogg":    OGG,
+	".wav":    WAV,
+	".xml":    XML,
+	".galaxy": Galaxy,
+	".txt":    Text,
+	".ini":    Text,
+	".lua":    Text,
+	".toc":    Text,
+}
+
+// textual lists the formats which are text, and so have no magic.
+var textual = map[Type]bool{
+	XML:    true,
+	Galaxy: true,
+	Text:   true,
+}
+
+// looksLikeText reports whether b is plausibly text: it contains no control characters other than whitespace.
+func looksLikeText(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// Detect identifies the format of a file from its name, which may be empty, and its first bytes, of which it needs at most SniffLen.
+func Detect(name string, head []byte) Type {
+	if len(head) > SniffLen {
+		head = head[:SniffLen]
+	}
+	for _, m := range magics {
+		if len(head) >= m.offset+len(m.magic) && string(head[m.offset:m.offset+len(m.magic)]) == m.magic {
+			return m.typ
+		}
+	}
+
+	// Formats with magic are only trusted by extension if there's nothing to contradict it, but TGA has no magic at all.
+	ext := strings.ToLower(path.Ext(strings.Replace(name, "\\", "/", -1)))
+	if t, ok := extensions[ext]; ok {
+		switch {
+		case len(head) == 0, t == TGA:
+			return t
+		case textual[t] && looksLikeText(head):
+			return t
+		}
+	}
+
+	if len(head) > 0 && looksLikeText(head) {
+		if bytes.HasPrefix(bytes.TrimSpace(head), []byte("<")) {
+			return XML
+		}
+		return Text
+	}
+	return Unknown
+}
+
+// Sniff identifies the format of the file r reads. It returns a reader which yields the whole file, including the bytes Sniff consumed.
+func Sniff(name string, r io.Reader) (Type, io.Reader, error) {
+	br := bufio.NewReaderSize(r, SniffLen)
+	head, err := br.Peek(SniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return Unknown, nil, err
+	}
+	return Detect(name, head), br, nil
+}
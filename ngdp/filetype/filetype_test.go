@@ -0,0 +1,79 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filetype
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		head string
+		want Type
+	}{
+		{"Textures\\a.blp", "BLP2\x01\x00", BLP},
+		{"", "BLP1", BLP},
+		{"a.dds", "DDS \x7c\x00\x00\x00", DDS},
+		{"Assets/Units/a.m3", "43DM\x1d\x00", M3},
+		{"world/a.m2", "MD21\x00", M2},
+		{"a.SC2Map", "MPQ\x1b\x00\x00\x00", MPQ},
+		{"a.mpq", "MPQ\x1a\x20\x00", MPQ},
+		{"", "OggS\x00\x02", OGG},
+		{"", "RIFF\x24\x08\x00\x00WAVEfmt ", WAV},
+		{"", "<?xml version=\"1.0\"?>\n<a/>", XML},
+		{"", "\xef\xbb\xbf<?xml version=\"1.0\"?>", XML},
+		{"a.SC2Layout", "\r\n  <Desc>\n", XML},
+		{"MapScript.galaxy", "include \"TriggerLibs/NativeLib\"\r\n", Galaxy},
+		{"a.galaxy", "\x00\x01\x02", Unknown},
+		{"a.tga", "\x00\x00\x02\x00", TGA},
+		{"a.blp", "", BLP},
+		{"a.blp", "not a blp\x00", Unknown},
+		{"notes", "hello, world\n", Text},
+		{"", "\x00\x01\x02", Unknown},
+		{"", "", Unknown},
+	} {
+		if got := Detect(test.name, []byte(test.head)); got != test.want {
+			t.Errorf("Detect(%q, %q) = %v; want %v", test.name, test.head, got.Name, test.want.Name)
+		}
+	}
+}
+
+func TestSniff(t *testing.T) {
+	body := "OggS" + strings.Repeat("x", 2*SniffLen)
+	typ, r, err := Sniff("", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Sniff: %v", err)
+	}
+	if typ != OGG {
+		t.Errorf("Sniff type = %v; want OGG", typ.Name)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(b) != body {
+		t.Errorf("Sniff reader returned %d bytes; want the original %d", len(b), len(body))
+	}
+
+	// Short files are fine too.
+	if typ, _, err := Sniff("", strings.NewReader("DDS ")); err != nil || typ != DDS {
+		t.Errorf("Sniff(short) = %v, %v; want DDS, nil", typ.Name, err)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package install parses install manifests, which list the files that are placed directly into a game's directory, outside local storage.
+package install
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+)
+
+var (
+	// ErrBadMagic means that the manifest doesn't start with "IN".
+	ErrBadMagic = errors.New("install: bad magic")
+)
+
+// An Entry is a single file to be installed.
+type Entry struct {
+	// Name is the file's path, relative to the root of the installation, using backslashes as separators.
+	Name        string
+	ContentHash ngdp.ContentHash
+	Size        uint32
+}
+
+// A Manifest is a parsed install manifest.
+type Manifest struct {
+	Tags    []Tag
+	Entries []Entry
+}
+
+func readCString(r *bufio.Reader) (string, error) {
+	s, err := r.ReadString(0)
+	if err != nil {
+		return "", err
+	}
+	return s[:len(s)-1], nil
+}
+
+// Parse parses a decoded install manifest.
+func Parse(rd io.Reader) (*Manifest, error) {
+	r := bufio.NewReader(rd)
+
+	var hdr struct {
+		Magic      [2]byte
+		Version    uint8
+		HashSize   uint8
+		NumTags    uint16
+		NumEntries uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("install: reading header: %v", err)
+	}
+	if string(hdr.Magic[:]) != "IN" {
+		return nil, ErrBadMagic
+	}
+	if hdr.Version != 1 {
+		return nil, fmt.Errorf("install: unsupported version %d", hdr.Version)
+	}
+	if hdr.HashSize != 16 {
+		return nil, fmt.Errorf("install: unsupported hash size %d", hdr.HashSize)
+	}
+
+	m := &Manifest{
+		Tags:    make([]Tag, hdr.NumTags),
+		Entries: make([]Entry, hdr.NumEntries),
+	}
+	bitmapLen := (int(hdr.NumEntries) + 7) / 8
+	for n := range m.Tags {
+		name, err := readCString(r)
+		if err != nil {
+			return nil, fmt.Errorf("install: reading tag %d: %v", n, err)
+		}
+		var typ uint16
+		if err := binary.Read(r, binary.BigEndian, &typ); err != nil {
+			return nil, fmt.Errorf("install: reading tag %q: %v", name, err)
+		}
+		bitmap := make([]byte, bitmapLen)
+		if _, err := io.ReadFull(r, bitmap); err != nil {
+			return nil, fmt.Errorf("install: reading tag %q: %v", name, err)
+		}
+		m.Tags[n] = Tag{name, TagType(typ), bitmap}
+	}
+
+	for n := range m.Entries {
+		name, err := readCString(r)
+		if err != nil {
+			return nil, fmt.Errorf("install: reading entry %d: %v", n, err)
+		}
+		e := &m.Entries[n]
+		e.Name = name
+		if _, err := io.ReadFull(r, e.ContentHash[:]); err != nil {
+			return nil, fmt.Errorf("install: reading entry %q: %v", name, err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &e.Size); err != nil {
+			return nil, fmt.Errorf("install: reading entry %q: %v", name, err)
+		}
+	}
+	return m, nil
+}
+
+// Fetch retrieves and parses the install manifest with content hash h, which is usually a build config's Install.
+func Fetch(ctx context.Context, f client.Fetcher, h ngdp.ContentHash) (*Manifest, error) {
+	resp, err := f.Fetch(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return Parse(resp.Body)
+}
+
+// Tag returns the tag with the given name.
+func (m *Manifest) Tag(name string) (Tag, bool) {
+	return FindTag(m.Tags, name)
+}
+
+// TagsOf returns the names of the tags carried by the nth entry.
+func (m *Manifest) TagsOf(n int) []string {
+	var names []string
+	for _, t := range m.Tags {
+		if t.Has(n) {
+			names = append(names, t.Name)
+		}
+	}
+	return names
+}
+
+// Filter returns the entries which match the given tags.
+//
+// An entry matches if, for each type of tag given, it carries at least one of the tags of that type.
+// For example, filtering on "Windows", "enUS" and "deDE" selects the Windows files needed for either English or German.
+func (m *Manifest) Filter(tags ...string) ([]Entry, error) {
+	match, err := MatchTags(m.Tags, tags...)
+	if err != nil {
+		return nil, err
+	}
+	var out []Entry
+	for n, e := range m.Entries {
+		if match(n) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
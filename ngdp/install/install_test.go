@@ -0,0 +1,119 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package install
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+var exampleEntries = []Entry{
+	{`Heroes of the Storm.exe`, ngdp.ContentHash{1}, 100},
+	{`HeroesSwitcher.app\Contents\Info.plist`, ngdp.ContentHash{2}, 200},
+	{`Versions\enUS.txt`, ngdp.ContentHash{3}, 300},
+	{`Versions\deDE.txt`, ngdp.ContentHash{4}, 400},
+}
+
+// makeManifest builds an install manifest for exampleEntries, with the given tags and bitmaps.
+func makeManifest(tags []Tag) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("IN")
+	buf.Write([]byte{1, 16})
+	binary.Write(&buf, binary.BigEndian, uint16(len(tags)))
+	binary.Write(&buf, binary.BigEndian, uint32(len(exampleEntries)))
+	for _, t := range tags {
+		buf.WriteString(t.Name)
+		buf.WriteByte(0)
+		binary.Write(&buf, binary.BigEndian, uint16(t.Type))
+		buf.Write(t.bitmap)
+	}
+	for _, e := range exampleEntries {
+		buf.WriteString(e.Name)
+		buf.WriteByte(0)
+		buf.Write(e.ContentHash[:])
+		binary.Write(&buf, binary.BigEndian, e.Size)
+	}
+	return buf.Bytes()
+}
+
+var exampleTags = []Tag{
+	{"Windows", TagTypePlatform, []byte{0xb0}}, // 1011
+	{"OSX", TagTypePlatform, []byte{0x70}},     // 0111
+	{"enUS", TagTypeLocale, []byte{0xe0}},      // 1110
+	{"deDE", TagTypeLocale, []byte{0xd0}},      // 1101
+}
+
+func TestParse(t *testing.T) {
+	m, err := Parse(bytes.NewReader(makeManifest(exampleTags)))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !reflect.DeepEqual(m.Tags, exampleTags) {
+		t.Errorf("m.Tags = %#v; want %#v", m.Tags, exampleTags)
+	}
+	if !reflect.DeepEqual(m.Entries, exampleEntries) {
+		t.Errorf("m.Entries = %#v; want %#v", m.Entries, exampleEntries)
+	}
+	if got, want := m.TagsOf(2), []string{"Windows", "OSX", "enUS"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("m.TagsOf(2) = %v; want %v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	b := makeManifest(exampleTags)
+	if _, err := Parse(bytes.NewReader(append([]byte("XX"), b[2:]...))); err != ErrBadMagic {
+		t.Errorf("Parse with bad magic: %v; want %v", err, ErrBadMagic)
+	}
+	if _, err := Parse(bytes.NewReader(b[:len(b)-2])); err == nil {
+		t.Errorf("Parse of truncated manifest: nil error; want error")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	m, err := Parse(bytes.NewReader(makeManifest(exampleTags)))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	for _, test := range []struct {
+		tags []string
+		want []Entry
+	}{
+		{nil, exampleEntries},
+		{[]string{"Windows"}, []Entry{exampleEntries[0], exampleEntries[2], exampleEntries[3]}},
+		{[]string{"Windows", "enUS"}, []Entry{exampleEntries[0], exampleEntries[2]}},
+		{[]string{"Windows", "enUS", "deDE"}, []Entry{exampleEntries[0], exampleEntries[2], exampleEntries[3]}},
+		{[]string{"OSX", "deDE"}, []Entry{exampleEntries[1], exampleEntries[3]}},
+	} {
+		got, err := m.Filter(test.tags...)
+		if err != nil {
+			t.Errorf("m.Filter(%v): %v", test.tags, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("m.Filter(%v) = %v; want %v", test.tags, got, test.want)
+		}
+	}
+
+	if _, err := m.Filter("Linux"); err == nil {
+		t.Errorf("m.Filter(Linux): nil error; want error")
+	}
+}
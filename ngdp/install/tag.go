@@ -0,0 +1,106 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package install
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownTag means that a tag passed to Filter isn't in the manifest.
+var ErrUnknownTag = errors.New("install: unknown tag")
+
+// A TagType groups related tags.
+type TagType uint16
+
+// These are the tag types used by most products.
+const (
+	TagTypePlatform     TagType = 1
+	TagTypeArchitecture TagType = 2
+	TagTypeLocale       TagType = 3
+	TagTypeRegion       TagType = 4
+	TagTypeCategory     TagType = 5
+)
+
+// A Tag marks a subset of a manifest's entries, such as those needed on a particular platform or for a particular locale.
+//
+// Install, download and size manifests all tag their entries in the same way, so the download and size packages use Tag too.
+type Tag struct {
+	Name string
+	Type TagType
+
+	bitmap []byte
+}
+
+// NewTag creates a tag carried by the entries whose bits are set in bitmap, most significant bit first, as it is stored in a manifest.
+func NewTag(name string, typ TagType, bitmap []byte) Tag {
+	return Tag{name, typ, bitmap}
+}
+
+// Has returns true if the nth entry of the manifest carries the tag.
+func (t Tag) Has(n int) bool {
+	if n < 0 || n/8 >= len(t.bitmap) {
+		return false
+	}
+	return t.bitmap[n/8]&(0x80>>uint(n%8)) != 0
+}
+
+// Bitmap returns the tag's bitmap, as passed to NewTag.
+func (t Tag) Bitmap() []byte {
+	return t.bitmap
+}
+
+// FindTag returns the tag in tags with the given name.
+func FindTag(tags []Tag, name string) (Tag, bool) {
+	for _, t := range tags {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Tag{}, false
+}
+
+// MatchTags returns a function which reports whether the nth entry of a manifest with the given tags matches the tags named.
+//
+// An entry matches if, for each type of tag named, it carries at least one of the tags of that type.
+// For example, matching "Windows", "enUS" and "deDE" selects the Windows files needed for either English or German.
+func MatchTags(tags []Tag, names ...string) (func(n int) bool, error) {
+	byType := make(map[TagType][]Tag)
+	for _, name := range names {
+		t, ok := FindTag(tags, name)
+		if !ok {
+			return nil, fmt.Errorf("%v %q", ErrUnknownTag, name)
+		}
+		byType[t.Type] = append(byType[t.Type], t)
+	}
+
+	return func(n int) bool {
+		for _, ts := range byType {
+			any := false
+			for _, t := range ts {
+				if t.Has(n) {
+					any = true
+					break
+				}
+			}
+			if !any {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package jenkins implements Bob Jenkins' lookup3 hash, which is used throughout local storage and in root files.
+package jenkins
+
+import "encoding/binary"
+
+func rot(x uint32, k uint) uint32 {
+	return x<<k | x>>(32-k)
+}
+
+func mix(a, b, c uint32) (uint32, uint32, uint32) {
+	a -= c
+	a ^= rot(c, 4)
+	c += b
+	b -= a
+	b ^= rot(a, 6)
+	a += c
+	c -= b
+	c ^= rot(b, 8)
+	b += a
+	a -= c
+	a ^= rot(c, 16)
+	c += b
+	b -= a
+	b ^= rot(a, 19)
+	a += c
+	c -= b
+	c ^= rot(b, 4)
+	b += a
+	return a, b, c
+}
+
+func final(a, b, c uint32) (uint32, uint32, uint32) {
+	c ^= b
+	c -= rot(b, 14)
+	a ^= c
+	a -= rot(c, 11)
+	b ^= a
+	b -= rot(a, 25)
+	c ^= b
+	c -= rot(b, 16)
+	a ^= c
+	a -= rot(c, 4)
+	b ^= a
+	b -= rot(a, 14)
+	c ^= b
+	c -= rot(b, 24)
+	return a, b, c
+}
+
+// HashLittle2 returns two 32-bit hashes of k, seeded with pc and pb.
+//
+// pc is the better mixed of the two results; it is the same as HashLittle(k, pc) if pb is zero.
+func HashLittle2(k []byte, pc, pb uint32) (uint32, uint32) {
+	a := 0xdeadbeef + uint32(len(k)) + pc
+	b := a
+	c := a + pb
+
+	for len(k) > 12 {
+		a += binary.LittleEndian.Uint32(k[0:4])
+		b += binary.LittleEndian.Uint32(k[4:8])
+		c += binary.LittleEndian.Uint32(k[8:12])
+		a, b, c = mix(a, b, c)
+		k = k[12:]
+	}
+
+	if len(k) == 0 {
+		return c, b
+	}
+
+	// Zero-pad the last block; this matches the reference implementation's handling of the tail.
+	var tail [12]byte
+	copy(tail[:], k)
+	a += binary.LittleEndian.Uint32(tail[0:4])
+	b += binary.LittleEndian.Uint32(tail[4:8])
+	c += binary.LittleEndian.Uint32(tail[8:12])
+	a, b, c = final(a, b, c)
+	return c, b
+}
+
+// HashLittle returns a 32-bit hash of k, seeded with initval.
+func HashLittle(k []byte, initval uint32) uint32 {
+	c, _ := HashLittle2(k, initval, 0)
+	return c
+}
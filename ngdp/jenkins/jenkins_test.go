@@ -0,0 +1,56 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jenkins
+
+import "testing"
+
+// These are the test vectors from lookup3.c's driver5.
+func TestHashLittle2(t *testing.T) {
+	for _, test := range []struct {
+		k            string
+		pc, pb       uint32
+		wantC, wantB uint32
+	}{
+		{"", 0, 0, 0xdeadbeef, 0xdeadbeef},
+		{"", 0, 0xdeadbeef, 0xbd5b7dde, 0xdeadbeef},
+		{"", 0xdeadbeef, 0xdeadbeef, 0x9c093ccd, 0xbd5b7dde},
+		{"Four score and seven years ago", 0, 0, 0x17770551, 0xce7226e6},
+		{"Four score and seven years ago", 0, 1, 0xe3607cae, 0xbd371de4},
+		{"Four score and seven years ago", 1, 0, 0xcd628161, 0x6cbea4b3},
+	} {
+		gotC, gotB := HashLittle2([]byte(test.k), test.pc, test.pb)
+		if gotC != test.wantC || gotB != test.wantB {
+			t.Errorf("HashLittle2(%q, %#x, %#x) = %#x, %#x; want %#x, %#x", test.k, test.pc, test.pb, gotC, gotB, test.wantC, test.wantB)
+		}
+	}
+}
+
+func TestHashLittle(t *testing.T) {
+	for _, test := range []struct {
+		k       string
+		initval uint32
+		want    uint32
+	}{
+		{"", 0, 0xdeadbeef},
+		{"Four score and seven years ago", 0, 0x17770551},
+		{"Four score and seven years ago", 1, 0xcd628161},
+	} {
+		if got := HashLittle([]byte(test.k), test.initval); got != test.want {
+			t.Errorf("HashLittle(%q, %#x) = %#x; want %#x", test.k, test.initval, got, test.want)
+		}
+	}
+}
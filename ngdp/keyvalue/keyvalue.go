@@ -34,14 +34,53 @@ var (
 // Error constants
 var (
 	ErrNotStructPointer = fmt.Errorf("keyvalue: cannot decode into non-struct-pointer")
+	ErrNotStruct        = fmt.Errorf("keyvalue: cannot encode non-struct")
+	ErrNoSeparator      = fmt.Errorf("line has no " + valueSeparator)
 )
 
+// A DecodeError reports a line of a key-value file which couldn't be decoded.
+type DecodeError struct {
+	Line int    // counting from 1
+	Key  string // or empty if the line has no key
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("keyvalue: line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("keyvalue: line %d: %s: %v", e.Line, e.Key, e.Err)
+}
+
 const (
 	structTag      = "keyvalue"
 	commentChar    = "#"
 	valueSeparator = "="
 )
 
+// An Unmarshaler decodes its own value from a key-value pair, for values in formats Decode doesn't know.
+//
+// Decode uses it in preference to its own decoding for any field, or element of a slice or struct field, whose pointer implements it.
+type Unmarshaler interface {
+	UnmarshalKeyValue(value string) error
+}
+
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+// fieldInfo returns the key a struct field is stored under, and whether its tag asks for integers to be written in hex.
+func fieldInfo(f reflect.StructField) (key string, hexInt bool) {
+	key = convertFieldName(f.Name)
+	tag := f.Tag.Get(structTag)
+	if i := strings.Index(tag, ","); i >= 0 {
+		hexInt = tag[i+1:] == "hex"
+		tag = tag[:i]
+	}
+	if tag != "" {
+		key = tag
+	}
+	return key, hexInt
+}
+
 func convertFieldName(s string) string {
 	bits := fieldNameRegexp.FindAllString(s, -1)
 	for n, bit := range bits {
@@ -51,6 +90,8 @@ func convertFieldName(s string) string {
 }
 
 // Decode decodes a file containing key-value pairs into a given interface.
+//
+// Integers are decimal, or hex if they start with 0x; fields tagged with a ",hex" option, such as `keyvalue:"flags,hex"`, take hex integers without the prefix. Bools are written 0 or 1.
 func Decode(ir io.Reader, s interface{}) error {
 	if reflect.TypeOf(s).Kind() != reflect.Ptr {
 		return ErrNotStructPointer
@@ -64,6 +105,7 @@ func Decode(ir io.Reader, s interface{}) error {
 
 	// create mappings from field names to reflect.Values.
 	fieldToValue := make(map[string]reflect.Value)
+	hexFields := make(map[string]bool)
 	fields := v.NumField()
 	for n := 0; n < fields; n++ {
 		f := st.Field(n)
@@ -73,18 +115,17 @@ func Decode(ir io.Reader, s interface{}) error {
 			continue
 		}
 
-		fieldName := convertFieldName(f.Name)
-		if tag := f.Tag.Get(structTag); tag != "" {
-			fieldName = tag
-		}
-
+		fieldName, hexInt := fieldInfo(f)
 		fieldToValue[fieldName] = v.Field(n)
+		hexFields[fieldName] = hexInt
 	}
 
 	// now read through the file
 	r := bufio.NewScanner(ir)
 
+	line := 0
 	for r.Scan() {
+		line++
 		txt := strings.TrimSpace(r.Text())
 		if len(txt) == 0 || strings.HasPrefix(txt, commentChar) {
 			// skip line
@@ -92,6 +133,9 @@ func Decode(ir io.Reader, s interface{}) error {
 		}
 
 		bits := strings.SplitN(txt, valueSeparator, 2)
+		if len(bits) != 2 {
+			return &DecodeError{Line: line, Err: ErrNoSeparator}
+		}
 		key := strings.TrimSpace(bits[0])
 		value := strings.TrimSpace(bits[1])
 
@@ -101,15 +145,62 @@ func Decode(ir io.Reader, s interface{}) error {
 			continue
 		}
 
+		setValue := setValue
+		if hexFields[key] {
+			setValue = setHexValue
+		}
 		if err := setValue(f, value); err != nil {
-			return fmt.Errorf("keyvalue: setting field %v to %q: %v", key, value, err)
+			return &DecodeError{Line: line, Key: key, Err: fmt.Errorf("setting to %q: %v", value, err)}
 		}
 	}
 
+	if err := r.Err(); err != nil {
+		return &DecodeError{Line: line + 1, Err: err}
+	}
 	return nil
 }
 
+// intBase returns the digits of an integer value, with any sign, and their base: 16 if they start with 0x, and 10 otherwise.
+func intBase(value string) (string, int) {
+	sign, digits := "", value
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if len(digits) > 2 && digits[0] == '0' && (digits[1] == 'x' || digits[1] == 'X') {
+		return sign + digits[2:], 16
+	}
+	return value, 10
+}
+
+// setHexValue is like setValue, but for fields tagged to hold integers written in hex without a 0x prefix.
+func setHexValue(f reflect.Value, value string) error {
+	switch {
+	case f.Kind() >= reflect.Int && f.Kind() <= reflect.Uint64:
+		if _, base := intBase(value); base == 10 && strings.HasPrefix(value, "-") {
+			value = "-0x" + value[1:]
+		} else if base == 10 {
+			value = "0x" + value
+		}
+		return setValue(f, value)
+	case f.Kind() == reflect.Slice && f.Type().Elem().Kind() >= reflect.Int && f.Type().Elem().Kind() <= reflect.Uint64:
+		bits := strings.Split(value, " ")
+		slice := reflect.MakeSlice(f.Type(), len(bits), len(bits))
+		for n, v := range bits {
+			if err := setHexValue(slice.Index(n), v); err != nil {
+				return err
+			}
+		}
+		f.Set(slice)
+		return nil
+	}
+	return setValue(f, value)
+}
+
 func setValue(f reflect.Value, value string) error {
+	if f.CanAddr() && f.Addr().Type().Implements(unmarshalerType) {
+		return f.Addr().Interface().(Unmarshaler).UnmarshalKeyValue(value)
+	}
+
 	switch {
 	case f.Kind() == reflect.String:
 		f.SetString(value)
@@ -140,14 +231,22 @@ func setValue(f reflect.Value, value string) error {
 			}
 		}
 		f.Set(slice)
+	case f.Kind() == reflect.Bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(v)
 	case f.Kind() >= reflect.Int && f.Kind() <= reflect.Int64:
-		v, err := strconv.ParseInt(value, 10, 64)
+		digits, base := intBase(value)
+		v, err := strconv.ParseInt(digits, base, f.Type().Bits())
 		if err != nil {
 			return err
 		}
 		f.SetInt(v)
 	case f.Kind() >= reflect.Uint && f.Kind() <= reflect.Uint64:
-		v, err := strconv.ParseUint(value, 10, 64)
+		digits, base := intBase(value)
+		v, err := strconv.ParseUint(digits, base, f.Type().Bits())
 		if err != nil {
 			return err
 		}
@@ -168,3 +267,94 @@ func setValue(f reflect.Value, value string) error {
 	}
 	return nil
 }
+
+// Encode writes the exported fields of a struct as key-value pairs, in the format Decode reads.
+//
+// Fields are named as Decode would expect them, and written in order. Fields holding their zero value are left out, since Decode leaves missing keys at their zero value anyway.
+func Encode(w io.Writer, s interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(s))
+	if !v.IsValid() || v.Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+	st := v.Type()
+
+	bw := bufio.NewWriter(w)
+	for n := 0; n < v.NumField(); n++ {
+		f := st.Field(n)
+		if f.PkgPath != "" || v.Field(n).IsZero() {
+			continue
+		}
+
+		fieldName, hexInt := fieldInfo(f)
+		formatValue := formatValue
+		if hexInt {
+			formatValue = formatHexValue
+		}
+		value, err := formatValue(v.Field(n))
+		if err != nil {
+			return fmt.Errorf("keyvalue: encoding field %v: %v", fieldName, err)
+		}
+		fmt.Fprintf(bw, "%s %s %s\n", fieldName, valueSeparator, value)
+	}
+	return bw.Flush()
+}
+
+// formatHexValue is like formatValue, but writes integers in hex without a 0x prefix, for fields tagged to hold them.
+func formatHexValue(f reflect.Value) (string, error) {
+	switch {
+	case f.Kind() >= reflect.Int && f.Kind() <= reflect.Int64:
+		return strconv.FormatInt(f.Int(), 16), nil
+	case f.Kind() >= reflect.Uint && f.Kind() <= reflect.Uint64:
+		return strconv.FormatUint(f.Uint(), 16), nil
+	case f.Kind() == reflect.Slice && f.Type().Elem().Kind() >= reflect.Int && f.Type().Elem().Kind() <= reflect.Uint64:
+		bits := make([]string, f.Len())
+		for n := range bits {
+			bits[n], _ = formatHexValue(f.Index(n))
+		}
+		return strings.Join(bits, " "), nil
+	}
+	return formatValue(f)
+}
+
+func formatValue(f reflect.Value) (string, error) {
+	switch {
+	case f.Kind() == reflect.String:
+		return f.String(), nil
+	case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Uint8:
+		return hex.EncodeToString(f.Bytes()), nil
+	case f.Kind() == reflect.Array && f.Type().Elem().Kind() == reflect.Uint8:
+		b := make([]byte, f.Len())
+		reflect.Copy(reflect.ValueOf(b), f)
+		return hex.EncodeToString(b), nil
+	case f.Kind() == reflect.Slice:
+		bits := make([]string, f.Len())
+		for n := range bits {
+			bit, err := formatValue(f.Index(n))
+			if err != nil {
+				return "", err
+			}
+			bits[n] = bit
+		}
+		return strings.Join(bits, " "), nil
+	case f.Kind() == reflect.Bool:
+		if f.Bool() {
+			return "1", nil
+		}
+		return "0", nil
+	case f.Kind() >= reflect.Int && f.Kind() <= reflect.Int64:
+		return strconv.FormatInt(f.Int(), 10), nil
+	case f.Kind() >= reflect.Uint && f.Kind() <= reflect.Uint64:
+		return strconv.FormatUint(f.Uint(), 10), nil
+	case f.Kind() == reflect.Struct:
+		bits := make([]string, f.NumField())
+		for n := range bits {
+			bit, err := formatValue(f.Field(n))
+			if err != nil {
+				return "", err
+			}
+			bits[n] = bit
+		}
+		return strings.Join(bits, " "), nil
+	}
+	return "", fmt.Errorf("keyvalue: don't know how to pack kind %v", f.Kind())
+}
@@ -17,10 +17,14 @@ limitations under the License.
 package keyvalue
 
 import (
+	"fmt"
 	"io"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
 )
 
 type MaybeAString string
@@ -137,3 +141,199 @@ func TestDecodeErrorUnknownType(t *testing.T) {
 		t.Errorf("Decode: %v; want error", err)
 	}
 }
+
+func TestEncode(t *testing.T) {
+	type Embedded struct {
+		Left  string
+		Right [2]byte
+	}
+	type T struct {
+		String               string
+		StringWithCustomName string `keyvalue:"swcn"`
+		SliceOfSliceOfByte   [][]byte
+		Uint                 uint64
+		Int                  int64
+		Zero                 int
+		Embedded             Embedded
+		unexported           string
+	}
+
+	var got strings.Builder
+	if err := Encode(&got, T{
+		String:               "blah",
+		StringWithCustomName: "blah2",
+		SliceOfSliceOfByte:   [][]byte{{0x12, 0x34}, {0xfe, 0xed}},
+		Uint:                 65536,
+		Int:                  -300,
+		Embedded:             Embedded{"left", [2]byte{0xca, 0xfe}},
+		unexported:           "hidden",
+	}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	want := `string = blah
+swcn = blah2
+slice-of-slice-of-byte = 1234 feed
+uint = 65536
+int = -300
+embedded = left cafe
+`
+	if got.String() != want {
+		t.Errorf("Encode wrote %q; want %q", got.String(), want)
+	}
+
+	if err := Encode(&got, "string"); err != ErrNotStruct {
+		t.Errorf("Encode(string): %v; want %v", err, ErrNotStruct)
+	}
+	if err := Encode(&got, struct{ Interface interface{} }{5}); err == nil {
+		t.Errorf("Encode(interface): %v; want error", err)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	buildConfig := ngdp.BuildConfig{
+		Root:        ngdp.ContentHash{0x01},
+		Install:     ngdp.ContentHash{0x02},
+		InstallSize: 1234,
+		Encoding: ngdp.BuildConfigEncoding{
+			ContentHash: ngdp.ContentHash{0x03},
+			CDNHash:     ngdp.CDNHash{0x04},
+		},
+		EncodingSize: ngdp.BuildConfigEncodingSize{UncompressedSize: 5678, CompressedSize: 910},
+	}
+	cdnConfig := ngdp.CDNConfig{
+		Archives:     []ngdp.CDNHash{{0x05}, {0x06}},
+		ArchiveGroup: ngdp.CDNHash{0x07},
+	}
+
+	for _, test := range []struct {
+		in, out interface{}
+	}{
+		{&buildConfig, &ngdp.BuildConfig{}},
+		{&cdnConfig, &ngdp.CDNConfig{}},
+	} {
+		var b strings.Builder
+		if err := Encode(&b, test.in); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		if err := Decode(strings.NewReader(b.String()), test.out); err != nil {
+			t.Fatalf("Decode(%q): %v", b.String(), err)
+		}
+		if !reflect.DeepEqual(test.out, test.in) {
+			t.Errorf("Decode(Encode(%+v)) = %+v", test.in, test.out)
+		}
+	}
+}
+
+// sizeWithUnits decodes sizes such as "12KiB".
+type sizeWithUnits int64
+
+func (s *sizeWithUnits) UnmarshalKeyValue(value string) error {
+	for suffix, mult := range map[string]int64{"KiB": 1 << 10, "MiB": 1 << 20} {
+		if strings.HasSuffix(value, suffix) {
+			n, err := strconv.ParseInt(strings.TrimSuffix(value, suffix), 10, 64)
+			*s = sizeWithUnits(n * mult)
+			return err
+		}
+	}
+	return fmt.Errorf("no units in %q", value)
+}
+
+func TestDecodeUnmarshaler(t *testing.T) {
+	type T struct {
+		Size     sizeWithUnits
+		Sizes    []sizeWithUnits
+		Embedded struct {
+			Name string
+			Size sizeWithUnits
+		}
+	}
+
+	var got T
+	if err := Decode(strings.NewReader("size = 12KiB\nsizes = 1KiB 2MiB\nembedded = foo 3KiB\n"), &got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got.Size != 12<<10 || !reflect.DeepEqual(got.Sizes, []sizeWithUnits{1 << 10, 2 << 20}) || got.Embedded.Size != 3<<10 {
+		t.Errorf("Decode = %+v", got)
+	}
+
+	if err := Decode(strings.NewReader("size = 12\n"), &got); err == nil || !strings.Contains(err.Error(), "no units") {
+		t.Errorf("Decode(size without units): %v; want the Unmarshaler's error", err)
+	}
+}
+
+func TestDecodeErrorLine(t *testing.T) {
+	type T struct {
+		Int int
+	}
+
+	for _, test := range []struct {
+		in      string
+		wantErr DecodeError
+	}{
+		{"# comment\n\nint = 1\nint = z\n", DecodeError{Line: 4, Key: "int"}},
+		{"int = 1\nthis line is malformed\n", DecodeError{Line: 2, Err: ErrNoSeparator}},
+	} {
+		var got T
+		err := Decode(strings.NewReader(test.in), &got)
+		de, ok := err.(*DecodeError)
+		if !ok {
+			t.Errorf("Decode(%q): %v; want a DecodeError", test.in, err)
+			continue
+		}
+		if de.Line != test.wantErr.Line || de.Key != test.wantErr.Key || (test.wantErr.Err != nil && de.Err != test.wantErr.Err) {
+			t.Errorf("Decode(%q): %#v; want %#v", test.in, de, test.wantErr)
+		}
+	}
+
+	err := Decode(strings.NewReader("int = z\n"), &struct{ Int int }{})
+	if want := `keyvalue: line 1: int: setting to "z": `; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("Decode: %v; want error starting %q", err, want)
+	}
+}
+
+func TestDecodeTypedValues(t *testing.T) {
+	type T struct {
+		Enabled  bool
+		Disabled bool
+		Prefixed uint32
+		Negative int16
+		Flags    uint64   `keyvalue:"flags,hex"`
+		Masks    []uint16 `keyvalue:",hex"`
+	}
+
+	in := `enabled = 1
+disabled = 0
+prefixed = 0xdeadbeef
+negative = -0x10
+flags = 1ff
+masks = ff 0x10 a
+`
+	want := T{
+		Enabled:  true,
+		Prefixed: 0xdeadbeef,
+		Negative: -0x10,
+		Flags:    0x1ff,
+		Masks:    []uint16{0xff, 0x10, 0xa},
+	}
+	var got T
+	if err := Decode(strings.NewReader(in), &got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode = %#v; want %#v", got, want)
+	}
+
+	var b strings.Builder
+	if err := Encode(&b, want); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if wantOut := "enabled = 1\nprefixed = 3735928559\nnegative = -16\nflags = 1ff\nmasks = ff 10 a\n"; b.String() != wantOut {
+		t.Errorf("Encode wrote %q; want %q", b.String(), wantOut)
+	}
+
+	for _, in := range []string{"enabled = yes", "prefixed = 0xzz", "negative = 40000", "flags = 0xfg"} {
+		if err := Decode(strings.NewReader(in), &got); err == nil {
+			t.Errorf("Decode(%q): %v; want error", in, err)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package listfile maintains a copy of the community listfile, which names the files World of Warcraft refers to only by FileDataID.
+package listfile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// DefaultURL is where the community listfile is published.
+const DefaultURL = "https://github.com/wowdev/wow-listfile/releases/latest/download/community-listfile.csv"
+
+// Parse reads a listfile, made of "<FileDataID>;<path>" lines, into a map from FileDataID to path.
+func Parse(r io.Reader) (map[uint32]string, error) {
+	names := make(map[uint32]string)
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		ln := strings.TrimSpace(s.Text())
+		if ln == "" {
+			continue
+		}
+		bits := strings.SplitN(ln, ";", 2)
+		if len(bits) != 2 {
+			return nil, fmt.Errorf("listfile: line %d: missing ';'", line)
+		}
+		id, err := strconv.ParseUint(bits[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("listfile: line %d: %v", line, err)
+		}
+		names[uint32(id)] = bits[1]
+	}
+	return names, s.Err()
+}
+
+// A Delta records how one version of the listfile differs from another.
+type Delta struct {
+	// Set holds the new name of every file which was added or renamed.
+	Set map[uint32]string
+
+	// Removed lists the files which no longer appear.
+	Removed []uint32
+}
+
+// Diff returns the changes needed to turn old into new.
+func Diff(old, new map[uint32]string) Delta {
+	d := Delta{Set: make(map[uint32]string)}
+	for id, name := range new {
+		if old[id] != name {
+			d.Set[id] = name
+		}
+	}
+	for id := range old {
+		if _, ok := new[id]; !ok {
+			d.Removed = append(d.Removed, id)
+		}
+	}
+	return d
+}
+
+// A Listfile is a refreshable, cached copy of the listfile. It is safe for concurrent use.
+type Listfile struct {
+	// URL is where the listfile is fetched from. It defaults to DefaultURL.
+	URL string
+
+	// CachePath, if set, is where a copy of the listfile is kept between runs.
+	CachePath string
+
+	// Client makes the requests. It defaults to http.DefaultClient.
+	Client *http.Client
+
+	mu           sync.RWMutex
+	names        map[uint32]string
+	ids          map[string]uint32
+	etag         string
+	lastModified string
+}
+
+func lowerPath(p string) string {
+	return strings.ToLower(strings.Replace(p, "\\", "/", -1))
+}
+
+// Name returns the path of the file with the given FileDataID.
+func (l *Listfile) Name(id uint32) (string, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	name, ok := l.names[id]
+	return name, ok
+}
+
+// ID returns the FileDataID of the file at path. Paths are matched case-insensitively, with either kind of slash.
+func (l *Listfile) ID(path string) (uint32, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	id, ok := l.ids[lowerPath(path)]
+	return id, ok
+}
+
+// Len returns the number of named files.
+func (l *Listfile) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.names)
+}
+
+// Apply updates the listfile in place.
+func (l *Listfile) Apply(d Delta) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.names == nil {
+		l.names = make(map[uint32]string)
+		l.ids = make(map[string]uint32)
+	}
+	for _, id := range d.Removed {
+		delete(l.ids, lowerPath(l.names[id]))
+		delete(l.names, id)
+	}
+	for id, name := range d.Set {
+		if old, ok := l.names[id]; ok {
+			delete(l.ids, lowerPath(old))
+		}
+		l.names[id] = name
+		l.ids[lowerPath(name)] = id
+	}
+}
+
+// snapshot returns a copy of the current names.
+func (l *Listfile) snapshot() map[uint32]string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	names := make(map[uint32]string, len(l.names))
+	for id, name := range l.names {
+		names[id] = name
+	}
+	return names
+}
+
+// LoadCache reads the copy of the listfile kept at CachePath, if there is one.
+func (l *Listfile) LoadCache() error {
+	if l.CachePath == "" {
+		return nil
+	}
+	f, err := os.Open(l.CachePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	names, err := Parse(f)
+	if err != nil {
+		return errors.Wrap(err, "reading cached listfile")
+	}
+	l.Apply(Diff(l.snapshot(), names))
+
+	// The validators are only trusted alongside the copy they describe.
+	if b, err := os.ReadFile(l.CachePath + ".etag"); err == nil {
+		bits := strings.SplitN(string(b), "\n", 2)
+		l.mu.Lock()
+		l.etag = bits[0]
+		if len(bits) == 2 {
+			l.lastModified = strings.TrimSpace(bits[1])
+		}
+		l.mu.Unlock()
+	}
+	return nil
+}
+
+// writeCache atomically writes b, and the validators which came with it, to CachePath.
+func (l *Listfile) writeCache(b []byte, etag, lastModified string) error {
+	if l.CachePath == "" {
+		return nil
+	}
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{l.CachePath, b},
+		{l.CachePath + ".etag", []byte(etag + "\n" + lastModified)},
+	} {
+		tmp, err := os.CreateTemp(filepath.Dir(f.name), ".listfile-")
+		if err != nil {
+			return err
+		}
+		_, err = tmp.Write(f.data)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), f.name)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	return nil
+}
+
+// Refresh fetches the listfile if it has changed since it was last fetched, and applies the changes.
+// It returns the changes, which are empty if the listfile was unchanged.
+func (l *Listfile) Refresh(ctx context.Context) (Delta, error) {
+	u := l.URL
+	if u == "" {
+		u = DefaultURL
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return Delta{}, err
+	}
+	l.mu.RLock()
+	if l.etag != "" {
+		req.Header.Set("If-None-Match", l.etag)
+	}
+	if l.lastModified != "" {
+		req.Header.Set("If-Modified-Since", l.lastModified)
+	}
+	l.mu.RUnlock()
+
+	cl := l.Client
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	resp, err := cl.Do(req.WithContext(ctx))
+	if err != nil {
+		return Delta{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return Delta{}, nil
+	case http.StatusOK:
+	default:
+		return Delta{}, fmt.Errorf("listfile: server status was %q", resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Delta{}, err
+	}
+	names, err := Parse(bytes.NewReader(b))
+	if err != nil {
+		return Delta{}, err
+	}
+
+	d := Diff(l.snapshot(), names)
+	l.Apply(d)
+
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	l.mu.Lock()
+	l.etag, l.lastModified = etag, lastModified
+	l.mu.Unlock()
+	if err := l.writeCache(b, etag, lastModified); err != nil {
+		glog.Warningf("Caching listfile: %v", err)
+	}
+	return d, nil
+}
+
+// Run loads the cache, then refreshes the listfile immediately and every interval until ctx is done.
+// Failed refreshes are logged, and retried at the next interval.
+func (l *Listfile) Run(ctx context.Context, interval time.Duration) error {
+	if err := l.LoadCache(); err != nil {
+		glog.Warningf("Loading cached listfile: %v", err)
+	}
+
+	refresh := func() {
+		d, err := l.Refresh(ctx)
+		if err != nil {
+			glog.Errorf("Refreshing listfile: %v", err)
+			return
+		}
+		if len(d.Set) > 0 || len(d.Removed) > 0 {
+			glog.Infof("Listfile updated: %d files named or renamed, %d removed; %d files known", len(d.Set), len(d.Removed), l.Len())
+		}
+	}
+
+	refresh()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			refresh()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
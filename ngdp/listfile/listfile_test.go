@@ -0,0 +1,124 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package listfile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	got, err := Parse(strings.NewReader("1;world/a.m2\r\n\n53187;Interface\\Icons\\b.blp\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := map[uint32]string{1: "world/a.m2", 53187: "Interface\\Icons\\b.blp"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse = %v; want %v", got, want)
+	}
+
+	for _, bad := range []string{"1\n", "x;a\n", "4294967296;a\n"} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Errorf("Parse(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	body := "1;a.m2\n2;b.m2\n"
+	var requests, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		etag := fmt.Sprintf("%q", fmt.Sprint(len(body)))
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	cache := filepath.Join(t.TempDir(), "listfile.csv")
+	l := &Listfile{URL: srv.URL, CachePath: cache}
+
+	d, err := l.Refresh(ctx)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if len(d.Set) != 2 || len(d.Removed) != 0 {
+		t.Errorf("first Refresh delta = %+v; want 2 set", d)
+	}
+
+	d, err = l.Refresh(ctx)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if notModified != 1 || len(d.Set) != 0 {
+		t.Errorf("second Refresh: %d not modified responses, delta %+v; want 1, empty delta", notModified, d)
+	}
+
+	body = "1;a.m2\n2;Renamed\\B.m2\n3;c.m2\n"
+	d, err = l.Refresh(ctx)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if want := (map[uint32]string{2: "Renamed\\B.m2", 3: "c.m2"}); !reflect.DeepEqual(d.Set, want) {
+		t.Errorf("third Refresh set %v; want %v", d.Set, want)
+	}
+	if id, ok := l.ID("renamed/b.m2"); !ok || id != 2 {
+		t.Errorf("ID(renamed/b.m2) = %d, %v; want 2, true", id, ok)
+	}
+	if _, ok := l.ID("b.m2"); ok {
+		t.Errorf("ID(b.m2) found the old name")
+	}
+
+	// A fresh Listfile picks up the cache, and its validators.
+	l2 := &Listfile{URL: srv.URL, CachePath: cache}
+	if err := l2.LoadCache(); err != nil {
+		t.Fatalf("LoadCache: %v", err)
+	}
+	if name, ok := l2.Name(3); !ok || name != "c.m2" {
+		t.Errorf("Name(3) = %q, %v; want c.m2, true", name, ok)
+	}
+	if _, err := l2.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if notModified != 2 {
+		t.Errorf("Refresh after LoadCache got %d not modified responses; want 2", notModified)
+	}
+
+	body = "1;a.m2\n"
+	d, err = l2.Refresh(ctx)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if len(d.Removed) != 2 || l2.Len() != 1 {
+		t.Errorf("Refresh removed %v, leaving %d; want 2 removed, leaving 1", d.Removed, l2.Len())
+	}
+	if _, ok := l2.ID("c.m2"); ok {
+		t.Errorf("ID(c.m2) found a removed file")
+	}
+}
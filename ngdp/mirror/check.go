@@ -0,0 +1,376 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/lukegb/snowstorm/blte"
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/encoding"
+	"github.com/lukegb/snowstorm/ngdp/keyvalue"
+)
+
+// ErrMissing is reported for files which are listed in a mirrored encoding table, but aren't in the mirror.
+var ErrMissing = errors.New("mirror: file is missing")
+
+// VerifyOptions control what Verify checks, and whether it repairs what it finds.
+type VerifyOptions struct {
+	// Concurrency is the number of objects to check at once. Defaults to 8.
+	Concurrency int
+
+	// Encoding also checks that every file listed in the encoding table of each mirrored build is present, either loose or in a mirrored archive.
+	// This only makes sense for mirrors made with both Archives and LooseFiles.
+	Encoding bool
+
+	// Refetch, if set, is used to download fresh copies of missing and corrupt objects from CDN.
+	// Only objects stored beneath CDN.Path can be refetched.
+	Refetch *client.LowLevelClient
+	CDN     ngdp.CDNInfo
+}
+
+// A Problem is an object which failed verification.
+type Problem struct {
+	// Key is the object's path within the mirror, using forward slashes.
+	Key string
+	Err error
+
+	// Fixed is true if the object was successfully refetched.
+	Fixed bool
+}
+
+func (p Problem) String() string {
+	if p.Fixed {
+		return fmt.Sprintf("%s: %v (refetched)", p.Key, p.Err)
+	}
+	return fmt.Sprintf("%s: %v", p.Key, p.Err)
+}
+
+// A mirroredObject is an object found while walking a mirror.
+type mirroredObject struct {
+	object
+
+	// prefix is the CDN path the object was found beneath.
+	prefix string
+}
+
+func (o mirroredObject) key() string {
+	return Key(ngdp.CDNInfo{Path: o.prefix}, o.contentType, o.hash, o.suffix)
+}
+
+// parseKey recognises the key of an object stored in a mirror, in the form <prefix>/<type>/ab/cd/abcd...[.index].
+func parseKey(key string) (mirroredObject, bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) < 4 {
+		return mirroredObject{}, false
+	}
+	n := len(parts)
+	contentType, ab, cd, name := ngdp.ContentType(parts[n-4]), parts[n-3], parts[n-2], parts[n-1]
+	switch contentType {
+	case ngdp.ContentTypeConfig, ngdp.ContentTypeData, ngdp.ContentTypePatch:
+	default:
+		return mirroredObject{}, false
+	}
+
+	var obj mirroredObject
+	obj.contentType = contentType
+	obj.prefix = path.Join(parts[:n-4]...)
+	if strings.HasSuffix(name, ".index") {
+		obj.suffix = ".index"
+		name = strings.TrimSuffix(name, ".index")
+	}
+	b, err := hex.DecodeString(name)
+	if err != nil || len(b) != len(obj.hash) || name[0:2] != ab || name[2:4] != cd {
+		return mirroredObject{}, false
+	}
+	copy(obj.hash[:], b)
+	return obj, true
+}
+
+type verifier struct {
+	dir   string
+	store blobstore.Store
+	opts  VerifyOptions
+
+	mu       sync.Mutex
+	problems []Problem
+}
+
+func (v *verifier) report(ctx context.Context, obj mirroredObject, err error) {
+	p := Problem{Key: obj.key(), Err: err}
+	if v.opts.Refetch != nil && obj.prefix == path.Clean(v.opts.CDN.Path) {
+		m := &mirrorer{llc: v.opts.Refetch, cdn: v.opts.CDN, store: v.store, opts: Options{Concurrency: 1}}
+		if ferr := m.fetch(ctx, obj.object); ferr != nil {
+			glog.Warningf("Refetching %s: %v", p.Key, ferr)
+		} else {
+			p.Fixed = true
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.problems = append(v.problems, p)
+}
+
+// Verify walks the mirror rooted at dir and checks every object against its name.
+//
+// Archives are checked entry by entry against their indices. Objects which fail are returned as Problems, and are refetched if opts.Refetch is set.
+// The returned error is only non-nil if verification itself couldn't be completed.
+func Verify(ctx context.Context, dir string, opts VerifyOptions) ([]Problem, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultConcurrency
+	}
+	v := &verifier{
+		dir:   dir,
+		store: blobstore.Dir(dir),
+		opts:  opts,
+	}
+
+	var objs []mirroredObject
+	present := make(map[string]bool)
+	err := filepath.Walk(dir, func(fn string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, fn)
+		if err != nil {
+			return err
+		}
+		obj, ok := parseKey(filepath.ToSlash(rel))
+		if !ok {
+			return nil
+		}
+		objs = append(objs, obj)
+		present[obj.key()] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Anything in the data or patch directories with an index alongside it is an archive.
+	for n, obj := range objs {
+		switch {
+		case obj.contentType == ngdp.ContentTypeConfig:
+			objs[n].kind = KindConfig
+		case obj.suffix == ".index":
+			objs[n].kind = KindIndex
+		case present[obj.key()+".index"]:
+			objs[n].kind = KindArchive
+		default:
+			objs[n].kind = KindBLTE
+		}
+	}
+	glog.Infof("Verifying %d objects", len(objs))
+
+	g, gctx := errgroup.WithContext(ctx)
+	objChan := make(chan mirroredObject)
+	g.Go(func() error {
+		defer close(objChan)
+		for _, obj := range objs {
+			select {
+			case objChan <- obj:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+		}
+		return nil
+	})
+	for n := 0; n < opts.Concurrency; n++ {
+		g.Go(func() error {
+			for obj := range objChan {
+				if err := v.check(gctx, obj); err != nil {
+					v.report(gctx, obj, err)
+				}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	if opts.Encoding {
+		if err := v.checkEncoding(ctx, objs, present); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(v.problems, func(i, j int) bool { return v.problems[i].Key < v.problems[j].Key })
+	return v.problems, nil
+}
+
+// check verifies a single object.
+func (v *verifier) check(ctx context.Context, obj mirroredObject) error {
+	key := obj.key()
+	switch obj.kind {
+	case KindArchive:
+		return v.checkArchive(ctx, obj)
+	case KindBLTE:
+		if obj.contentType == ngdp.ContentTypePatch {
+			// Patches may or may not be BLTE-encoded; those which aren't are named after their entire contents.
+			isBLTE, err := v.hasBLTEMagic(ctx, key)
+			if err != nil {
+				return err
+			}
+			if !isBLTE {
+				return verifyBlob(ctx, v.store, key, KindConfig, obj.hash, 0)
+			}
+		}
+	}
+	return verifyBlob(ctx, v.store, key, obj.kind, obj.hash, 0)
+}
+
+func (v *verifier) hasBLTEMagic(ctx context.Context, key string) (bool, error) {
+	r, err := v.store.Open(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return false, nil
+	}
+	return string(magic) == "BLTE", nil
+}
+
+// checkArchive checks that every file an archive's index refers to is intact.
+func (v *verifier) checkArchive(ctx context.Context, obj mirroredObject) error {
+	idx, err := v.store.Open(ctx, obj.key()+".index")
+	if err != nil {
+		return err
+	}
+	read := client.ReadArchiveIndex
+	if obj.contentType == ngdp.ContentTypePatch {
+		read = client.ReadPatchArchiveIndex
+	}
+	entries, err := read(idx, obj.hash)
+	idx.Close()
+	if err != nil {
+		return errors.Wrap(err, "reading index")
+	}
+
+	f, err := os.Open(filepath.Join(v.dir, filepath.FromSlash(obj.key())))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	for h, e := range entries {
+		if end := int64(e.Offset) + int64(e.Size); end > fi.Size() {
+			return verifyArchiveSize(fi.Size(), end)
+		}
+		if err := verifyContents(io.NewSectionReader(f, int64(e.Offset), int64(e.Size)), KindBLTE, h); err != nil {
+			return errors.Wrapf(err, "entry %032x at offset %d", h, e.Offset)
+		}
+	}
+	return nil
+}
+
+// checkEncoding reports every file listed in a mirrored encoding table which isn't present, either loose or in an archive.
+func (v *verifier) checkEncoding(ctx context.Context, objs []mirroredObject, present map[string]bool) error {
+	// Collect the contents of every mirrored archive, per CDN path.
+	archived := make(map[string]map[ngdp.CDNHash]bool)
+	for _, obj := range objs {
+		if obj.kind != KindArchive || obj.contentType != ngdp.ContentTypeData {
+			continue
+		}
+		r, err := v.store.Open(ctx, obj.key()+".index")
+		if err != nil {
+			return err
+		}
+		entries, err := client.ReadArchiveIndex(r, obj.hash)
+		r.Close()
+		if err != nil {
+			// This has already been reported.
+			continue
+		}
+		if archived[obj.prefix] == nil {
+			archived[obj.prefix] = make(map[ngdp.CDNHash]bool)
+		}
+		for h := range entries {
+			archived[obj.prefix][h] = true
+		}
+	}
+
+	for _, obj := range objs {
+		if obj.kind != KindConfig {
+			continue
+		}
+		var buildConfig ngdp.BuildConfig
+		r, err := v.store.Open(ctx, obj.key())
+		if err != nil {
+			return err
+		}
+		err = keyvalue.Decode(r, &buildConfig)
+		r.Close()
+		if err != nil || isZero(buildConfig.Encoding.CDNHash) {
+			// Not a build config.
+			continue
+		}
+
+		encodingObj := mirroredObject{object{ngdp.ContentTypeData, buildConfig.Encoding.CDNHash, "", KindBLTE, 0}, obj.prefix}
+		if !present[encodingObj.key()] {
+			v.report(ctx, encodingObj, ErrMissing)
+			continue
+		}
+		r, err = v.store.Open(ctx, encodingObj.key())
+		if err != nil {
+			return err
+		}
+		mapper, err := encoding.NewMapper(blte.NewReaderContext(ctx, r))
+		r.Close()
+		if err != nil {
+			// This will usually have been reported already, as a corrupt object.
+			glog.Warningf("Parsing encoding table %s: %v", encodingObj.key(), err)
+			continue
+		}
+
+		glog.Infof("Checking files listed by build config %032x", obj.hash)
+		for _, h := range mapper.All() {
+			loose := mirroredObject{object{ngdp.ContentTypeData, h, "", KindBLTE, 0}, obj.prefix}
+			if present[loose.key()] || archived[obj.prefix][h] {
+				continue
+			}
+			v.report(ctx, loose, ErrMissing)
+			present[loose.key()] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"crypto/md5"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+func writeObject(t *testing.T, dir string, contentType ngdp.ContentType, h ngdp.CDNHash, b []byte) {
+	fn := Path(dir, ngdp.CDNInfo{Path: "tpr/test"}, contentType, h, "")
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+
+	config := []byte("# Build Configuration\n")
+	writeObject(t, dir, ngdp.ContentTypeConfig, md5.Sum(config), config)
+
+	// A BLTE file with no chunk table is named after its entire contents.
+	data := []byte("BLTE\x00\x00\x00\x00Nhello")
+	writeObject(t, dir, ngdp.ContentTypeData, md5.Sum(data), data)
+
+	corrupt := ngdp.CDNHash{0x12, 0x34}
+	writeObject(t, dir, ngdp.ContentTypeData, corrupt, data)
+
+	// Files which don't look like objects are ignored.
+	if err := os.WriteFile(filepath.Join(dir, "README"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := Verify(context.Background(), dir, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(problems) != 1 {
+		t.Fatalf("Verify returned %d problems (%v); want 1", len(problems), problems)
+	}
+	if want := "tpr/test/data/12/34/12340000000000000000000000000000"; problems[0].Key != want {
+		t.Errorf("problems[0].Key = %q; want %q", problems[0].Key, want)
+	}
+	if _, ok := problems[0].Err.(ErrHashMismatch); !ok {
+		t.Errorf("problems[0].Err = %v; want an ErrHashMismatch", problems[0].Err)
+	}
+}
@@ -0,0 +1,454 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mirror copies builds from the CDN into a local directory or other blob store, using the same layout as the CDN itself.
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"github.com/lukegb/snowstorm/blte"
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/downloader"
+	"github.com/lukegb/snowstorm/ngdp/encoding"
+	"github.com/lukegb/snowstorm/ngdp/keyvalue"
+)
+
+const defaultConcurrency = 8
+
+// Options control what is copied by Mirror.
+type Options struct {
+	// Archives causes every archive to be copied, rather than only their indices.
+	Archives bool
+
+	// LooseFiles causes every data file which isn't stored in an archive to be copied.
+	LooseFiles bool
+
+	// Concurrency is the number of objects to download at once. Defaults to 8.
+	Concurrency int
+
+	// Progress, if set, receives a copy of every byte downloaded.
+	Progress io.Writer
+
+	// BytesPerSecond limits the total download rate. Zero means unlimited.
+	BytesPerSecond int64
+
+	// Store, if set, is where the mirror is written, instead of the directory passed to Mirror.
+	Store blobstore.Store
+
+	// Program is recorded in the snapshot manifest, if set.
+	Program ngdp.ProgramCode
+
+	// Journal, if set, records which objects have been copied, so that an interrupted mirror can be resumed without checking them again.
+	Journal *downloader.Journal
+}
+
+// Key returns the key an object is stored under within a mirror.
+func Key(cdn ngdp.CDNInfo, contentType ngdp.ContentType, h ngdp.CDNHash, suffix string) string {
+	return blobstore.ContentKey(path.Join(cdn.Path, string(contentType)), h) + suffix
+}
+
+// Path returns where an object is stored within a mirror rooted at dir.
+func Path(dir string, cdn ngdp.CDNInfo, contentType ngdp.ContentType, h ngdp.CDNHash, suffix string) string {
+	return filepath.Join(dir, filepath.FromSlash(Key(cdn, contentType, h, suffix)))
+}
+
+type object struct {
+	contentType ngdp.ContentType
+	hash        ngdp.CDNHash
+	suffix      string
+	kind        ObjectKind
+
+	// minSize is only used for archives.
+	minSize int64
+}
+
+type mirrorer struct {
+	llc   *client.LowLevelClient
+	cdn   ngdp.CDNInfo
+	store blobstore.Store
+	opts  Options
+
+	// mu guards objects, which lists everything which has been mirrored, for the snapshot manifest.
+	mu      sync.Mutex
+	objects []ManifestObject
+}
+
+func isZero(h ngdp.CDNHash) bool {
+	return h.Equal(ngdp.CDNHash{})
+}
+
+// Mirror copies the configs, archive indices, encoding table, and root, install and download manifests of a build into dir, or into opts.Store if it is set.
+//
+// Objects which are already present and intact are not downloaded again, so an interrupted Mirror can be resumed by running it again.
+//
+// Once everything has been copied, Mirror writes a Manifest listing it, so that the build can later be read offline with OpenSnapshot.
+func Mirror(ctx context.Context, llc *client.LowLevelClient, cdn ngdp.CDNInfo, version ngdp.VersionInfo, dir string, opts Options) error {
+	m := &mirrorer{
+		llc:   llc,
+		cdn:   cdn,
+		store: opts.Store,
+		opts:  opts,
+	}
+	if m.store == nil {
+		m.store = blobstore.Dir(dir)
+	}
+	if m.opts.Concurrency <= 0 {
+		m.opts.Concurrency = defaultConcurrency
+	}
+
+	// The configs come first, since they describe everything else.
+	glog.Infof("Mirroring build config %032x and CDN config %032x", version.BuildConfig, version.CDNConfig)
+	if err := m.fetchAll(ctx, false, []object{
+		{ngdp.ContentTypeConfig, version.BuildConfig, "", KindConfig, 0},
+		{ngdp.ContentTypeConfig, version.CDNConfig, "", KindConfig, 0},
+	}); err != nil {
+		return err
+	}
+
+	var buildConfig ngdp.BuildConfig
+	if err := m.decodeConfig(ctx, version.BuildConfig, &buildConfig); err != nil {
+		return errors.Wrap(err, "parsing build config")
+	}
+	var cdnConfig ngdp.CDNConfig
+	if err := m.decodeConfig(ctx, version.CDNConfig, &cdnConfig); err != nil {
+		return errors.Wrap(err, "parsing cdn config")
+	}
+
+	// Then the encoding table and the archive indices, which are needed to find everything else.
+	glog.Infof("Mirroring encoding table and %d archive indices", len(cdnConfig.Archives))
+	objs := []object{
+		{ngdp.ContentTypeData, buildConfig.Encoding.CDNHash, "", KindBLTE, 0},
+	}
+	if !isZero(buildConfig.PatchConfig) {
+		objs = append(objs, object{ngdp.ContentTypeConfig, buildConfig.PatchConfig, "", KindConfig, 0})
+	}
+	for _, a := range cdnConfig.Archives {
+		objs = append(objs, object{ngdp.ContentTypeData, a, ".index", KindIndex, 0})
+	}
+	for _, a := range cdnConfig.PatchArchives {
+		objs = append(objs, object{ngdp.ContentTypePatch, a, ".index", KindIndex, 0})
+	}
+	if err := m.fetchAll(ctx, false, objs); err != nil {
+		return err
+	}
+
+	// Group indices are generated by the client, so they aren't always present on the CDN.
+	objs = nil
+	if !isZero(cdnConfig.ArchiveGroup) {
+		objs = append(objs, object{ngdp.ContentTypeData, cdnConfig.ArchiveGroup, ".index", KindIndex, 0})
+	}
+	if !isZero(cdnConfig.PatchArchiveGroup) {
+		objs = append(objs, object{ngdp.ContentTypePatch, cdnConfig.PatchArchiveGroup, ".index", KindIndex, 0})
+	}
+	if err := m.fetchAll(ctx, true, objs); err != nil {
+		return err
+	}
+	if !isZero(cdnConfig.ArchiveGroup) {
+		if err := m.buildGroupIndex(ctx, cdnConfig.ArchiveGroup, cdnConfig.Archives); err != nil {
+			return errors.Wrap(err, "building archive group index")
+		}
+	}
+
+	encodingMapper, archiveMapper, err := m.mappers(ctx, buildConfig, cdnConfig)
+	if err != nil {
+		return err
+	}
+
+	// Now the manifests, and anything else which was asked for.
+	archives := make(map[ngdp.CDNHash]bool)
+	objs = nil
+	for _, h := range []ngdp.ContentHash{buildConfig.Root, buildConfig.Install, buildConfig.Download} {
+		if h.Equal(ngdp.ContentHash{}) {
+			continue
+		}
+		cdnHash, err := encodingMapper.ToCDNHash(h)
+		if err != nil {
+			return errors.Wrapf(err, "looking up %032x", h)
+		}
+		if e, ok := archiveMapper.Map(cdnHash); ok {
+			archives[e.Archive] = true
+			continue
+		}
+		objs = append(objs, object{ngdp.ContentTypeData, cdnHash, "", KindBLTE, 0})
+	}
+	glog.Infof("Mirroring root, install and download manifests")
+	if err := m.fetchAll(ctx, false, objs); err != nil {
+		return err
+	}
+
+	if opts.LooseFiles {
+		objs = nil
+		for _, cdnHash := range encodingMapper.All() {
+			if _, ok := archiveMapper.Map(cdnHash); ok {
+				continue
+			}
+			objs = append(objs, object{ngdp.ContentTypeData, cdnHash, "", KindBLTE, 0})
+		}
+		// The encoding table lists some files which aren't actually on the CDN.
+		glog.Infof("Mirroring %d loose files", len(objs))
+		if err := m.fetchAll(ctx, true, objs); err != nil {
+			return err
+		}
+	}
+
+	if opts.Archives {
+		for _, a := range cdnConfig.Archives {
+			archives[a] = true
+		}
+	}
+	objs = nil
+	for a := range archives {
+		minSize, err := m.archiveMinSize(ctx, a)
+		if err != nil {
+			return errors.Wrapf(err, "reading index for archive %032x", a)
+		}
+		objs = append(objs, object{ngdp.ContentTypeData, a, "", KindArchive, minSize})
+	}
+	glog.Infof("Mirroring %d archives", len(objs))
+	if err := m.fetchAll(ctx, false, objs); err != nil {
+		return err
+	}
+
+	sort.Slice(m.objects, func(i, j int) bool {
+		return m.objects[i].Key(cdn) < m.objects[j].Key(cdn)
+	})
+	return WriteManifest(ctx, m.store, &Manifest{
+		Format:  ManifestFormat,
+		Program: opts.Program,
+		Created: time.Now().UTC(),
+		CDN:     cdn,
+		Version: version,
+		Objects: m.objects,
+	})
+}
+
+// record notes that obj is present and intact in the store.
+func (m *mirrorer) record(obj object) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects = append(m.objects, ManifestObject{obj.contentType, obj.hash, obj.suffix, obj.kind})
+}
+
+func (m *mirrorer) key(obj object) string {
+	return Key(m.cdn, obj.contentType, obj.hash, obj.suffix)
+}
+
+func (m *mirrorer) decodeConfig(ctx context.Context, h ngdp.CDNHash, v interface{}) error {
+	r, err := m.store.Open(ctx, Key(m.cdn, ngdp.ContentTypeConfig, h, ""))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return keyvalue.Decode(r, v)
+}
+
+func (m *mirrorer) openIndex(ctx context.Context, archiveHash ngdp.CDNHash) (io.ReadCloser, error) {
+	return m.store.Open(ctx, Key(m.cdn, ngdp.ContentTypeData, archiveHash, ".index"))
+}
+
+// mappers builds the encoding and archive mappers from the mirrored copies of the encoding table and archive indices.
+func (m *mirrorer) mappers(ctx context.Context, buildConfig ngdp.BuildConfig, cdnConfig ngdp.CDNConfig) (*encoding.Mapper, *client.ArchiveMapper, error) {
+	r, err := m.store.Open(ctx, Key(m.cdn, ngdp.ContentTypeData, buildConfig.Encoding.CDNHash, ""))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	encodingMapper, err := encoding.NewMapper(blte.NewReaderContext(ctx, r))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parsing encoding table")
+	}
+
+	archiveMapper, err := client.NewArchiveMapperFromIndices(ctx, cdnConfig.Archives, m.openIndex)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "building archive mapper")
+	}
+
+	return encodingMapper, archiveMapper, nil
+}
+
+// buildGroupIndex generates the archive group index from the mirrored archive indices, if it couldn't be downloaded.
+func (m *mirrorer) buildGroupIndex(ctx context.Context, group ngdp.CDNHash, archives []ngdp.CDNHash) error {
+	key := Key(m.cdn, ngdp.ContentTypeData, group, ".index")
+	if _, err := m.store.Size(ctx, key); err == nil {
+		return nil
+	}
+
+	f, err := os.CreateTemp("", "snowstorm-mirror-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	name, err := client.WriteArchiveGroupIndex(ctx, f, archives, m.openIndex)
+	if err != nil {
+		return err
+	}
+	if !name.Equal(group) {
+		// Our index is usable, but it isn't the one the CDN config refers to, so it can't be served under that name.
+		glog.Warningf("Generated archive group index %032x doesn't match %032x from the CDN config; skipping", name, group)
+		return nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	glog.Infof("Generated archive group index %032x", group)
+	if err := m.store.Put(ctx, key, f); err != nil {
+		return err
+	}
+	m.record(object{ngdp.ContentTypeData, group, ".index", KindIndex, 0})
+	return nil
+}
+
+// archiveMinSize returns the size an archive must be to contain everything its index references.
+func (m *mirrorer) archiveMinSize(ctx context.Context, archiveHash ngdp.CDNHash) (int64, error) {
+	r, err := m.openIndex(ctx, archiveHash)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	entries, err := client.ReadArchiveIndex(r, archiveHash)
+	if err != nil {
+		return 0, err
+	}
+
+	var minSize int64
+	for _, e := range entries {
+		if end := int64(e.Offset) + int64(e.Size); end > minSize {
+			minSize = end
+		}
+	}
+	return minSize, nil
+}
+
+func (m *mirrorer) fetchAll(ctx context.Context, tolerateMissing bool, objs []object) error {
+	jobs := make(map[*downloader.Job]object, len(objs))
+
+	// Objects missing from the CDN mustn't be recorded in the manifest.
+	var missingMu sync.Mutex
+	missing := make(map[*downloader.Job]bool)
+
+	dl := downloader.New(downloader.Options{
+		Concurrency:    m.opts.Concurrency,
+		BytesPerSecond: m.opts.BytesPerSecond,
+		Progress:       m.opts.Progress,
+		Journal:        m.opts.Journal,
+		OnComplete: func(j *downloader.Job, err error) {
+			missingMu.Lock()
+			defer missingMu.Unlock()
+			if missing[j] && m.opts.Journal != nil {
+				// Look again next time, in case it has since appeared.
+				if err := m.opts.Journal.Forget(j.Name); err != nil {
+					glog.Warningf("%s: %v", j.Name, err)
+				}
+			}
+			if err == nil && !missing[j] {
+				m.record(jobs[j])
+			}
+		},
+	})
+	for _, obj := range objs {
+		obj := obj
+		j := &downloader.Job{
+			Name: fmt.Sprintf("mirroring %s/%032x%s", obj.contentType, obj.hash, obj.suffix),
+			Save: func(ctx context.Context, r io.Reader) error {
+				return m.save(ctx, obj, r)
+			},
+		}
+		j.Open = func(ctx context.Context) (io.ReadCloser, error) {
+			r, err := m.open(ctx, obj)
+			if err != nil && tolerateMissing && client.IsNotFound(err) {
+				glog.Warningf("%s/%032x%s is missing from the CDN; skipping", obj.contentType, obj.hash, obj.suffix)
+				missingMu.Lock()
+				missing[j] = true
+				missingMu.Unlock()
+				return nil, nil
+			}
+			return r, err
+		}
+		jobs[j] = obj
+		dl.Add(j)
+	}
+	return dl.Run(ctx)
+}
+
+// fetch copies a single object into the store, unless an intact copy is already there.
+func (m *mirrorer) fetch(ctx context.Context, obj object) error {
+	r, err := m.open(ctx, obj)
+	if err != nil || r == nil {
+		return err
+	}
+	defer r.Close()
+	return m.save(ctx, obj, r)
+}
+
+// open starts downloading an object, returning nil if an intact copy is already in the store.
+func (m *mirrorer) open(ctx context.Context, obj object) (io.ReadCloser, error) {
+	if err := verifyBlob(ctx, m.store, m.key(obj), obj.kind, obj.hash, obj.minSize); err == nil {
+		// We already have an intact copy.
+		return nil, nil
+	}
+	return m.llc.FetchRaw(ctx, m.cdn, obj.contentType, obj.hash, obj.suffix)
+}
+
+// save checks a downloaded object and puts it in the store.
+func (m *mirrorer) save(ctx context.Context, obj object, r io.Reader) error {
+	// Objects are downloaded and checked locally first, so that nothing broken ends up in the store.
+	f, err := os.CreateTemp("", "snowstorm-mirror-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+
+	if obj.kind == KindArchive {
+		err = verifyArchiveSize(size, obj.minSize)
+	} else {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		err = verifyContents(f, obj.kind, obj.hash)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return m.store.Put(ctx, m.key(obj), f)
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+	"github.com/lukegb/snowstorm/ngdp/client"
+)
+
+// ManifestFormat is the version of the snapshot manifest format written by Mirror.
+const ManifestFormat = 1
+
+// A Manifest describes a snapshot: a single build, mirrored along with everything needed to read it without access to the CDN.
+type Manifest struct {
+	// Format is the version of the manifest format, so that older snapshots can still be read if it changes.
+	Format int
+
+	Program ngdp.ProgramCode `json:",omitempty"`
+	Created time.Time
+
+	CDN     ngdp.CDNInfo
+	Version ngdp.VersionInfo
+
+	// Objects lists everything the snapshot contains.
+	Objects []ManifestObject
+}
+
+// A ManifestObject is a single object in a snapshot.
+type ManifestObject struct {
+	ContentType ngdp.ContentType
+	Hash        ngdp.CDNHash
+	Suffix      string `json:",omitempty"`
+	Kind        ObjectKind
+}
+
+// Key returns the key the object is stored under within the mirror.
+func (o ManifestObject) Key(cdn ngdp.CDNInfo) string {
+	return Key(cdn, o.ContentType, o.Hash, o.Suffix)
+}
+
+var kindNames = map[ObjectKind]string{
+	KindConfig:  "config",
+	KindBLTE:    "blte",
+	KindIndex:   "index",
+	KindArchive: "archive",
+}
+
+func (k ObjectKind) String() string {
+	if s, ok := kindNames[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("ObjectKind(%d)", int(k))
+}
+
+// MarshalText encodes the kind as its name.
+func (k ObjectKind) MarshalText() ([]byte, error) {
+	s, ok := kindNames[k]
+	if !ok {
+		return nil, fmt.Errorf("mirror: unknown object kind %d", int(k))
+	}
+	return []byte(s), nil
+}
+
+// UnmarshalText decodes a kind from its name.
+func (k *ObjectKind) UnmarshalText(b []byte) error {
+	for kind, s := range kindNames {
+		if s == string(b) {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("mirror: unknown object kind %q", b)
+}
+
+// ManifestKey returns the key the manifest for the snapshot of a build is stored under.
+func ManifestKey(buildConfig ngdp.CDNHash) string {
+	return fmt.Sprintf("snapshots/%032x.json", buildConfig)
+}
+
+// WriteManifest stores m in store, under ManifestKey.
+func WriteManifest(ctx context.Context, store blobstore.Store, m *Manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, ManifestKey(m.Version.BuildConfig), bytes.NewReader(b))
+}
+
+// ReadManifest reads the manifest for the snapshot of a build from store.
+func ReadManifest(ctx context.Context, store blobstore.Store, buildConfig ngdp.CDNHash) (*Manifest, error) {
+	r, err := store.Open(ctx, ManifestKey(buildConfig))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, errors.Wrap(err, "parsing snapshot manifest")
+	}
+	if m.Format > ManifestFormat {
+		return nil, fmt.Errorf("mirror: snapshot manifest format %d is newer than this version understands (%d)", m.Format, ManifestFormat)
+	}
+	return &m, nil
+}
+
+// Snapshots lists the builds with a snapshot in the mirror in dir.
+func Snapshots(dir string) ([]ngdp.CDNHash, error) {
+	fns, err := filepath.Glob(filepath.Join(dir, "snapshots", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var builds []ngdp.CDNHash
+	for _, fn := range fns {
+		var h ngdp.CDNHash
+		if err := h.UnmarshalText([]byte(strings.TrimSuffix(filepath.Base(fn), ".json"))); err != nil {
+			continue
+		}
+		builds = append(builds, h)
+	}
+	sort.Slice(builds, func(i, j int) bool { return builds[i].Less(builds[j]) })
+	return builds, nil
+}
+
+// storeTransport is an http.RoundTripper which answers CDN requests from a mirror, since it uses the same layout.
+type storeTransport struct {
+	store blobstore.Store
+}
+
+func (t storeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+		Body:       http.NoBody,
+	}
+	respond := func(code int) (*http.Response, error) {
+		resp.StatusCode = code
+		resp.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+		return resp, nil
+	}
+
+	r, err := t.store.Open(req.Context(), strings.TrimPrefix(req.URL.Path, "/"))
+	if err == blobstore.ErrNotExist {
+		return respond(http.StatusNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+
+	rng := req.Header.Get("Range")
+	if rng == "" {
+		resp.Body = r
+		return respond(http.StatusOK)
+	}
+
+	// The client only ever asks for a single, closed range.
+	var start, end int64
+	if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil || end < start {
+		r.Close()
+		return respond(http.StatusRequestedRangeNotSatisfiable)
+	}
+	if _, err := io.CopyN(io.Discard, r, start); err != nil {
+		r.Close()
+		if err == io.EOF {
+			return respond(http.StatusRequestedRangeNotSatisfiable)
+		}
+		return nil, err
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(r, end-start+1), r}
+	return respond(http.StatusPartialContent)
+}
+
+// OpenSnapshot returns a Client which reads the snapshot of a build from store, without making any network requests.
+//
+// Only the objects listed in the snapshot's manifest can be fetched; everything else will appear to be missing from the CDN.
+func OpenSnapshot(ctx context.Context, store blobstore.Store, build ngdp.CDNHash) (*client.Client, *Manifest, error) {
+	m, err := ReadManifest(ctx, store, build)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	llc := &client.LowLevelClient{
+		Client: &http.Client{Transport: storeTransport{store}},
+	}
+	cdn, version := m.CDN, m.Version
+	if len(cdn.Hosts) == 0 {
+		// The hosts are never contacted, but the client needs one to build URLs.
+		cdn.Hosts = []string{"snapshot"}
+	}
+
+	cdnConfig, buildConfig, err := llc.Configs(ctx, cdn, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	encodingMapper, archiveMapper, err := llc.Mappers(ctx, cdn, cdnConfig, buildConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &client.Client{
+		LowLevelClient: llc,
+
+		CDNInfo:     &cdn,
+		VersionInfo: &version,
+
+		BuildConfig: &buildConfig,
+		CDNConfig:   &cdnConfig,
+
+		ArchiveMapper:  archiveMapper,
+		EncodingMapper: encodingMapper,
+	}, m, nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"crypto/md5"
+	"io"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+	"github.com/lukegb/snowstorm/ngdp/ngdptest"
+)
+
+func TestSnapshot(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		addBuild func(s *ngdptest.Server, program ngdp.ProgramCode, region ngdp.Region, files ...[]byte) ngdp.VersionInfo
+		opts     Options
+	}{
+		{"loose", (*ngdptest.Server).AddBuild, Options{LooseFiles: true}},
+		{"archived", (*ngdptest.Server).AddArchivedBuild, Options{Archives: true}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := ngdptest.NewServer()
+			defer s.Close()
+
+			files := [][]byte{[]byte("hello"), []byte("world")}
+			version := test.addBuild(s, "test", "eu", files...)
+
+			llc := s.LowLevelClient()
+			cdn, err := llc.CDN(ctx, "test", "eu")
+			if err != nil {
+				t.Fatalf("CDN: %v", err)
+			}
+
+			dir := t.TempDir()
+			opts := test.opts
+			opts.Program = "test"
+			if err := Mirror(ctx, llc, cdn, version, dir, opts); err != nil {
+				t.Fatalf("Mirror: %v", err)
+			}
+
+			builds, err := Snapshots(dir)
+			if err != nil {
+				t.Fatalf("Snapshots: %v", err)
+			}
+			if len(builds) != 1 || !builds[0].Equal(version.BuildConfig) {
+				t.Fatalf("Snapshots = %032x; want [%032x]", builds, version.BuildConfig)
+			}
+
+			// The snapshot must be readable with the CDN gone.
+			s.Close()
+			c, m, err := OpenSnapshot(ctx, blobstore.Dir(dir), version.BuildConfig)
+			if err != nil {
+				t.Fatalf("OpenSnapshot: %v", err)
+			}
+			if m.Program != "test" || m.Version.BuildID != version.BuildID {
+				t.Errorf("manifest program %q, build %d; want %q, %d", m.Program, m.Version.BuildID, "test", version.BuildID)
+			}
+			// Two configs, the encoding table, and either two loose files or an archive and its index.
+			if len(m.Objects) != 5 {
+				t.Errorf("manifest lists %d objects; want 5: %+v", len(m.Objects), m.Objects)
+			}
+
+			for _, f := range files {
+				resp, err := c.Fetch(ctx, ngdp.ContentHash(md5.Sum(f)))
+				if err != nil {
+					t.Fatalf("Fetch(%q): %v", f, err)
+				}
+				b, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					t.Fatalf("reading %q: %v", f, err)
+				}
+				if string(b) != string(f) {
+					t.Errorf("Fetch returned %q; want %q", b, f)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+)
+
+const (
+	// indexFooterSize is the size of an archive index footer with 8-byte checksums; archive indices are named after its MD5.
+	indexFooterSize = 28
+
+	blteHeaderSize = 8
+)
+
+// An ObjectKind describes how an object stored on the CDN can be checked against its name.
+type ObjectKind int
+
+// The object kinds below cover everything stored under the config and data directories.
+const (
+	// KindConfig objects are named after the MD5 of their entire contents.
+	KindConfig ObjectKind = iota
+
+	// KindBLTE objects are named after the MD5 of their BLTE header.
+	KindBLTE
+
+	// KindIndex objects are archive indices, named after the MD5 of their footer.
+	KindIndex
+
+	// KindArchive objects are archives, which can't be checked by hash; instead they must be big enough to contain everything their index references.
+	KindArchive
+)
+
+// ErrHashMismatch is returned when an object doesn't match its name.
+type ErrHashMismatch struct {
+	Want ngdp.CDNHash
+	Got  ngdp.CDNHash
+}
+
+func (e ErrHashMismatch) Error() string {
+	return fmt.Sprintf("mirror: hash mismatch: want %032x, got %032x", e.Want, e.Got)
+}
+
+func checkHash(want ngdp.CDNHash, got [md5.Size]byte) error {
+	if !want.Equal(ngdp.CDNHash(got)) {
+		return ErrHashMismatch{want, got}
+	}
+	return nil
+}
+
+// verifyContents checks that the contents of r match the object named h. Archives can't be checked this way; use verifyArchiveSize instead.
+func verifyContents(r io.Reader, kind ObjectKind, h ngdp.CDNHash) error {
+	switch kind {
+	case KindConfig:
+		hasher := md5.New()
+		if _, err := io.Copy(hasher, r); err != nil {
+			return err
+		}
+		var got [md5.Size]byte
+		copy(got[:], hasher.Sum(nil))
+		return checkHash(h, got)
+
+	case KindBLTE:
+		hdr := make([]byte, blteHeaderSize)
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return fmt.Errorf("mirror: reading BLTE header: %v", err)
+		}
+		hasher := md5.New()
+		hasher.Write(hdr)
+		hdrLen := int64(binary.BigEndian.Uint32(hdr[4:]))
+		if hdrLen == 0 {
+			// No chunk table, so the whole file is hashed.
+			if _, err := io.Copy(hasher, r); err != nil {
+				return err
+			}
+		} else if n, err := io.CopyN(hasher, r, hdrLen-blteHeaderSize); err == io.EOF {
+			return fmt.Errorf("mirror: BLTE header is %d bytes long, but file is only %d bytes", hdrLen, n+blteHeaderSize)
+		} else if err != nil {
+			return err
+		}
+		var got [md5.Size]byte
+		copy(got[:], hasher.Sum(nil))
+		return checkHash(h, got)
+
+	case KindIndex:
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if len(b) < indexFooterSize {
+			return fmt.Errorf("mirror: index is too short to contain a footer")
+		}
+		return checkHash(h, md5.Sum(b[len(b)-indexFooterSize:]))
+	}
+	return fmt.Errorf("mirror: can't verify object kind %v by its contents", kind)
+}
+
+// verifyArchiveSize checks that an archive of the given size contains everything its index references.
+func verifyArchiveSize(size, minSize int64) error {
+	if size < minSize {
+		return fmt.Errorf("mirror: archive is %d bytes long, but its index references %d bytes", size, minSize)
+	}
+	return nil
+}
+
+// verifyBlob checks that the blob at key matches the object named h. For archives, minSize is the size the archive must reach.
+func verifyBlob(ctx context.Context, store blobstore.Store, key string, kind ObjectKind, h ngdp.CDNHash, minSize int64) error {
+	if kind == KindArchive {
+		size, err := store.Size(ctx, key)
+		if err != nil {
+			return err
+		}
+		return verifyArchiveSize(size, minSize)
+	}
+
+	r, err := store.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return verifyContents(r, kind, h)
+}
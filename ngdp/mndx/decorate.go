@@ -19,6 +19,7 @@ package mndx
 import (
 	"context"
 
+	"github.com/lukegb/snowstorm/ngdp"
 	"github.com/lukegb/snowstorm/ngdp/client"
 	"github.com/pkg/errors"
 )
@@ -27,22 +28,31 @@ import (
 //
 // It will automatically download and parse the root file.
 func Decorate(ctx context.Context, c *client.Client) error {
-	root, err := c.Fetch(ctx, c.BuildConfig.Root)
+	tree, err := Load(ctx, c, c.BuildConfig.Root)
 	if err != nil {
-		return errors.Wrap(err, "fetching root file")
+		return err
 	}
-	defer root.Body.Close()
 
-	mapper, err := Parse(root.Body)
+	c.FilenameMapper = tree
+	return nil
+}
+
+// Load retrieves the root file with the given content hash and parses it into a tree.
+func Load(ctx context.Context, f client.Fetcher, root ngdp.ContentHash) (*TreeDirectory, error) {
+	resp, err := f.Fetch(ctx, root)
 	if err != nil {
-		return errors.Wrap(err, "parsing root file")
+		return nil, errors.Wrap(err, "fetching root file")
 	}
+	defer resp.Body.Close()
 
-	tree, err := ToTree(mapper)
+	mapper, err := Parse(resp.Body)
 	if err != nil {
-		return errors.Wrap(err, "converting to tree")
+		return nil, errors.Wrap(err, "parsing root file")
 	}
 
-	c.FilenameMapper = tree
-	return nil
+	tree, err := ToTree(mapper)
+	if err != nil {
+		return nil, errors.Wrap(err, "converting to tree")
+	}
+	return tree, nil
 }
@@ -19,6 +19,7 @@ package mndx
 import (
 	"crypto/md5"
 	"errors"
+	"iter"
 	"path"
 	"sort"
 	"strings"
@@ -102,6 +103,28 @@ func (td *TreeDirectory) List() []TreeDirectoryEntry {
 	return o
 }
 
+// Files yields every file below this directory, with its /-separated path relative to it, in the same order as List.
+func (td *TreeDirectory) Files() iter.Seq2[string, *TreeFile] {
+	return func(yield func(string, *TreeFile) bool) {
+		td.walk("", yield)
+	}
+}
+
+// walk yields the files below td, returning false if yield asked to stop.
+func (td *TreeDirectory) walk(prefix string, yield func(string, *TreeFile) bool) bool {
+	for _, e := range td.flatDents {
+		p := path.Join(prefix, e.Name)
+		if e.Directory != nil {
+			if !e.Directory.walk(p, yield) {
+				return false
+			}
+		} else if e.File != nil && !yield(p, e.File) {
+			return false
+		}
+	}
+	return true
+}
+
 func (td *TreeDirectory) get(path []string) (*TreeDirectoryEntry, error) {
 	cname := strings.ToLower(path[0])
 
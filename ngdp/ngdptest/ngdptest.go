@@ -0,0 +1,349 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ngdptest provides an in-process fake of the NGDP patch server and CDN, for use in tests.
+//
+// A Server answers every request made through its Client, whatever host it was addressed to,
+// so it can stand in for both the patch server and any CDN host named in its CDN table.
+// Builds can be seeded with their files either loose or in an archive.
+package ngdptest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/keyvalue"
+)
+
+const (
+	// CDNHost is the host named in the CDN tables written by AddBuild.
+	CDNHost = "cdn.ngdptest"
+
+	// CDNPath is the path named in the CDN tables written by AddBuild.
+	CDNPath = "tpr/test"
+
+	// ConfigPath is the product config path named in the CDN tables written by AddBuild.
+	ConfigPath = "tpr/configs/data"
+)
+
+// A Server is a fake patch server and CDN.
+type Server struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	files    map[string][]byte
+	versions map[ngdp.ProgramCode][]ngdp.VersionInfo
+	cdns     map[ngdp.ProgramCode][]ngdp.CDNInfo
+}
+
+// NewServer starts a new, empty Server. It should be closed when it is no longer needed.
+func NewServer() *Server {
+	s := &Server{
+		files:    make(map[string][]byte),
+		versions: make(map[ngdp.ProgramCode][]ngdp.VersionInfo),
+		cdns:     make(map[ngdp.ProgramCode][]ngdp.CDNInfo),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Client returns an HTTP client which sends every request to the server.
+func (s *Server) Client() *http.Client {
+	u, _ := url.Parse(s.srv.URL)
+	return &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.URL.Scheme = u.Scheme
+			req.URL.Host = u.Host
+			return http.DefaultTransport.RoundTrip(req)
+		}),
+	}
+}
+
+// LowLevelClient returns a client which talks to the server.
+func (s *Server) LowLevelClient() *client.LowLevelClient {
+	return &client.LowLevelClient{Client: s.Client()}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// Put serves b at path, which should not have a leading slash.
+func (s *Server) Put(path string, b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path] = b
+}
+
+// Delete stops serving path.
+func (s *Server) Delete(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, path)
+}
+
+// ObjectPath returns the path of an object on a CDN with the given path prefix.
+func ObjectPath(cdnPath string, contentType ngdp.ContentType, h ngdp.CDNHash, suffix string) string {
+	return fmt.Sprintf("%s/%s/%02x/%02x/%032x%s", cdnPath, contentType, h[0], h[1], h, suffix)
+}
+
+// PutObject serves b as a CDN object named by its MD5, returning that name.
+func (s *Server) PutObject(cdnPath string, contentType ngdp.ContentType, b []byte) ngdp.CDNHash {
+	h := ngdp.CDNHash(md5.Sum(b))
+	s.Put(ObjectPath(cdnPath, contentType, h, ""), b)
+	return h
+}
+
+// SetVersions sets the versions the patch server reports for program.
+func (s *Server) SetVersions(program ngdp.ProgramCode, versions []ngdp.VersionInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions[program] = versions
+}
+
+// SetCDNs sets the CDNs the patch server reports for program.
+func (s *Server) SetCDNs(program ngdp.ProgramCode, cdns []ngdp.CDNInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cdns[program] = cdns
+}
+
+func hexOrEmpty(h ngdp.CDNHash) string {
+	if h.Equal(ngdp.CDNHash{}) {
+		return ""
+	}
+	return fmt.Sprintf("%032x", h)
+}
+
+func (s *Server) versionsTable(program ngdp.ProgramCode) []byte {
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "Region!STRING:0|BuildConfig!HEX:16|CDNConfig!HEX:16|KeyRing!HEX:16|BuildId!DEC:4|VersionsName!String:0|ProductConfig!HEX:16")
+	for _, v := range s.versions[program] {
+		fmt.Fprintf(&b, "%s|%032x|%032x|%s|%d|%s|%s\n", v.Region, v.BuildConfig, v.CDNConfig, hexOrEmpty(v.KeyRing), v.BuildID, v.VersionsName, hexOrEmpty(v.ProductConfig))
+	}
+	return b.Bytes()
+}
+
+func (s *Server) cdnsTable(program ngdp.ProgramCode) []byte {
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "Name!STRING:0|Path!STRING:0|Hosts!STRING:0|ConfigPath!STRING:0")
+	for _, c := range s.cdns[program] {
+		fmt.Fprintf(&b, "%s|%s|%s|%s\n", c.Name, c.Path, strings.Join(c.Hosts, " "), c.ConfigPath)
+	}
+	return b.Bytes()
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/")
+
+	s.mu.Lock()
+	b, ok := s.files[p]
+	if !ok {
+		// Patch server requests look like <program>/versions.
+		if bits := strings.Split(p, "/"); len(bits) == 2 {
+			program := ngdp.ProgramCode(bits[0])
+			switch {
+			case bits[1] == "versions" && s.versions[program] != nil:
+				b, ok = s.versionsTable(program), true
+			case bits[1] == "cdns" && s.cdns[program] != nil:
+				b, ok = s.cdnsTable(program), true
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+}
+
+// EncodeBLTE wraps b in a BLTE container, without compressing it.
+func EncodeBLTE(b []byte) []byte {
+	out := make([]byte, 0, len(b)+9)
+	out = append(out, 'B', 'L', 'T', 'E', 0, 0, 0, 0, 'N')
+	return append(out, b...)
+}
+
+// encodingTable builds an encoding table mapping each content hash to its CDN hash and decoded size.
+func encodingTable(ckeys []ngdp.ContentHash, ekeys map[ngdp.ContentHash]ngdp.CDNHash, sizes map[ngdp.ContentHash]int) []byte {
+	const pageSize = 4096
+	const entrySize = 0x26
+
+	sort.Slice(ckeys, func(i, j int) bool { return ckeys[i].Less(ckeys[j]) })
+	var pages [][]byte
+	var firstKeys []ngdp.ContentHash
+	for n, ck := range ckeys {
+		if n%(pageSize/entrySize) == 0 {
+			pages = append(pages, make([]byte, 0, pageSize))
+			firstKeys = append(firstKeys, ck)
+		}
+		ek := ekeys[ck]
+		e := make([]byte, entrySize)
+		e[0] = 1 // key count
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(sizes[ck]))
+		copy(e[1:6], size[3:])
+		copy(e[6:0x16], ck[:])
+		copy(e[0x16:], ek[:])
+		pages[len(pages)-1] = append(pages[len(pages)-1], e...)
+	}
+
+	var b bytes.Buffer
+	hdr := make([]byte, 22)
+	copy(hdr, "EN")
+	hdr[2] = 1
+	hdr[3], hdr[4] = 0x10, 0x10
+	binary.BigEndian.PutUint16(hdr[5:], pageSize/1024)
+	binary.BigEndian.PutUint16(hdr[7:], pageSize/1024)
+	binary.BigEndian.PutUint32(hdr[9:], uint32(len(pages)))
+	b.Write(hdr)
+	for n, page := range pages {
+		page = page[:pageSize]
+		pages[n] = page
+		b.Write(firstKeys[n][:])
+		sum := md5.Sum(page)
+		b.Write(sum[:])
+	}
+	for _, page := range pages {
+		b.Write(page)
+	}
+	return b.Bytes()
+}
+
+// encodeConfig formats a build or CDN config, with the comment line the real ones start with.
+func encodeConfig(comment string, v interface{}) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n\n", comment)
+	if err := keyvalue.Encode(&b, v); err != nil {
+		// Configs only hold types keyvalue can encode.
+		panic(err)
+	}
+	return b.Bytes()
+}
+
+// AddBuild seeds the server with a build of program containing files, and makes it the current version in region.
+// Files are stored loose on the CDN, BLTE-encoded.
+//
+// It returns the new version.
+func (s *Server) AddBuild(program ngdp.ProgramCode, region ngdp.Region, files ...[]byte) ngdp.VersionInfo {
+	return s.addBuild(program, region, false, files)
+}
+
+// AddArchivedBuild is like AddBuild, but stores the files in a single archive, with an index.
+func (s *Server) AddArchivedBuild(program ngdp.ProgramCode, region ngdp.Region, files ...[]byte) ngdp.VersionInfo {
+	return s.addBuild(program, region, true, files)
+}
+
+func (s *Server) addBuild(program ngdp.ProgramCode, region ngdp.Region, archived bool, files [][]byte) ngdp.VersionInfo {
+	var archive bytes.Buffer
+	aw := client.NewArchiveWriter(&archive)
+
+	var ckeys []ngdp.ContentHash
+	ekeys := make(map[ngdp.ContentHash]ngdp.CDNHash)
+	sizes := make(map[ngdp.ContentHash]int)
+	for _, f := range files {
+		ck := ngdp.ContentHash(md5.Sum(f))
+		if _, ok := ekeys[ck]; ok {
+			continue
+		}
+		ckeys = append(ckeys, ck)
+		sizes[ck] = len(f)
+
+		encoded := EncodeBLTE(f)
+		if !archived {
+			ekeys[ck] = s.PutObject(CDNPath, ngdp.ContentTypeData, encoded)
+			continue
+		}
+		ekeys[ck] = ngdp.CDNHash(md5.Sum(encoded))
+		// Writes to a bytes.Buffer can't fail, and a test archive won't reach 4GB.
+		aw.Add(ekeys[ck], encoded)
+	}
+
+	var cdnConfig ngdp.CDNConfig
+	if archived {
+		var idx bytes.Buffer
+		name, _ := aw.WriteIndex(&idx)
+		s.Put(ObjectPath(CDNPath, ngdp.ContentTypeData, name, ""), archive.Bytes())
+		s.Put(ObjectPath(CDNPath, ngdp.ContentTypeData, name, ".index"), idx.Bytes())
+		cdnConfig.Archives = []ngdp.CDNHash{name}
+	}
+
+	enc := encodingTable(ckeys, ekeys, sizes)
+	encEncoded := EncodeBLTE(enc)
+	encCKey := ngdp.ContentHash(md5.Sum(enc))
+	encEKey := s.PutObject(CDNPath, ngdp.ContentTypeData, encEncoded)
+
+	buildConfig := s.PutObject(CDNPath, ngdp.ContentTypeConfig, encodeConfig("# Build Configuration", ngdp.BuildConfig{
+		Encoding:     ngdp.BuildConfigEncoding{ContentHash: encCKey, CDNHash: encEKey},
+		EncodingSize: ngdp.BuildConfigEncodingSize{UncompressedSize: uint64(len(enc)), CompressedSize: uint64(len(encEncoded))},
+	}))
+	cdnConfigHash := s.PutObject(CDNPath, ngdp.ContentTypeConfig, encodeConfig("# CDN Configuration", cdnConfig))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buildID := 1
+	var versions []ngdp.VersionInfo
+	for _, v := range s.versions[program] {
+		if v.BuildID >= buildID {
+			buildID = v.BuildID + 1
+		}
+		if v.Region != region {
+			versions = append(versions, v)
+		}
+	}
+	version := ngdp.VersionInfo{
+		Region:       region,
+		BuildConfig:  buildConfig,
+		CDNConfig:    cdnConfigHash,
+		BuildID:      buildID,
+		VersionsName: fmt.Sprintf("1.0.0.%d", buildID),
+	}
+	s.versions[program] = append(versions, version)
+
+	haveCDN := false
+	for _, c := range s.cdns[program] {
+		haveCDN = haveCDN || c.Name == region
+	}
+	if !haveCDN {
+		s.cdns[program] = append(s.cdns[program], ngdp.CDNInfo{
+			Name:       region,
+			Path:       CDNPath,
+			Hosts:      []string{CDNHost},
+			ConfigPath: ConfigPath,
+		})
+	}
+	return version
+}
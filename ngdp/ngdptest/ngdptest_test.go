@@ -0,0 +1,162 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ngdptest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/tactkeys"
+)
+
+func TestAddBuild(t *testing.T) {
+	testAddBuild(t, (*Server).AddBuild)
+}
+
+func TestAddArchivedBuild(t *testing.T) {
+	testAddBuild(t, (*Server).AddArchivedBuild)
+}
+
+func testAddBuild(t *testing.T, addBuild func(s *Server, program ngdp.ProgramCode, region ngdp.Region, files ...[]byte) ngdp.VersionInfo) {
+	s := NewServer()
+	defer s.Close()
+
+	// Enough files to need several encoding table pages.
+	var files [][]byte
+	for n := 0; n < 300; n++ {
+		files = append(files, []byte(fmt.Sprintf("file %d", n)))
+	}
+	addBuild(s, "test", "eu", files[:1]...)
+	want := addBuild(s, "test", "eu", files...)
+
+	ctx := context.Background()
+	c, err := client.NewWithLowLevelClient(ctx, s.LowLevelClient(), "test", "eu")
+	if err != nil {
+		t.Fatalf("NewWithLowLevelClient: %v", err)
+	}
+	if !c.VersionInfo.BuildConfig.Equal(want.BuildConfig) || c.VersionInfo.BuildID != 2 {
+		t.Errorf("client picked up version %+v; want %+v", *c.VersionInfo, want)
+	}
+
+	for _, f := range files {
+		resp, err := c.Fetch(ctx, ngdp.ContentHash(md5.Sum(f)))
+		if err != nil {
+			t.Fatalf("Fetch(%q): %v", f, err)
+		}
+		got, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("reading %q: %v", f, err)
+		}
+		if string(got) != string(f) {
+			t.Errorf("Fetch returned %q; want %q", got, f)
+		}
+	}
+}
+
+func TestKeyRing(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	v := s.AddBuild("test", "eu", []byte("file"))
+	v.KeyRing = s.PutObject(CDNPath, ngdp.ContentTypeConfig, []byte("# Keyring Configuration\nkey-0123456789ABCDEF = 000102030405060708090a0b0c0d0e0f\n"))
+	s.SetVersions("test", []ngdp.VersionInfo{v})
+
+	ctx := context.Background()
+	c, err := client.NewWithLowLevelClient(ctx, s.LowLevelClient(), "test", "eu")
+	if err != nil {
+		t.Fatalf("NewWithLowLevelClient: %v", err)
+	}
+	key, ok := c.Keys.Key(0x0123456789ABCDEF)
+	if !ok || key != (tactkeys.Key{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}) {
+		t.Errorf("Keys.Key = %x, %v; want the key from the keyring", key, ok)
+	}
+
+	llc := s.LowLevelClient()
+	llc.NoKeyRing = true
+	c, err = client.NewWithLowLevelClient(ctx, llc, "test", "eu")
+	if err != nil {
+		t.Fatalf("NewWithLowLevelClient with NoKeyRing: %v", err)
+	}
+	if n := c.Keys.Len(); n != 0 {
+		t.Errorf("with NoKeyRing, Keys has %d keys; want 0", n)
+	}
+}
+
+func TestCaptureReplay(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddBuild("test", "eu", []byte("file"))
+
+	ctx := context.Background()
+	var buf bytes.Buffer
+	llc := s.LowLevelClient()
+	llc.Capture = client.NewCapture(&buf, client.CaptureHAR)
+	c, err := client.NewWithLowLevelClient(ctx, llc, "test", "eu")
+	if err != nil {
+		t.Fatalf("NewWithLowLevelClient: %v", err)
+	}
+
+	// A config which is missing from the CDN at first.
+	late := []byte("# late\n")
+	h := ngdp.CDNHash(md5.Sum(late))
+	if _, err := llc.FetchRaw(ctx, *c.CDNInfo, ngdp.ContentTypeConfig, h, ""); !client.IsNotFound(err) {
+		t.Fatalf("FetchRaw of missing object = %v; want not found", err)
+	}
+	if err := llc.Capture.Close(); err != nil {
+		t.Fatalf("Capture.Close: %v", err)
+	}
+	s.PutObject(CDNPath, ngdp.ContentTypeConfig, late)
+
+	entries, err := client.ReadCapture(&buf)
+	if err != nil {
+		t.Fatalf("ReadCapture: %v", err)
+	}
+	if len(entries) < 2 {
+		t.Fatalf("captured %d requests; want at least 2", len(entries))
+	}
+	if e := entries[0]; e.Response.Status != 200 || e.Response.Content.Size == 0 {
+		t.Errorf("first entry has status %d and size %d; want a 200 with a body", e.Response.Status, e.Response.Content.Size)
+	}
+	last := entries[len(entries)-1]
+	if wantURL := "/" + ObjectPath(CDNPath, ngdp.ContentTypeConfig, h, ""); last.Response.Status != 404 || !strings.HasSuffix(last.Request.URL, wantURL) {
+		t.Errorf("last entry is %d for %s; want 404 for %s", last.Response.Status, last.Request.URL, wantURL)
+	}
+
+	// Replaying the capture reproduces the missing object, even though the server now has it.
+	replay := &client.LowLevelClient{Client: &http.Client{Transport: client.NewReplay(entries, s.Client().Transport)}}
+	c, err = client.NewWithLowLevelClient(ctx, replay, "test", "eu")
+	if err != nil {
+		t.Fatalf("NewWithLowLevelClient with replay: %v", err)
+	}
+	if _, err := replay.FetchRaw(ctx, *c.CDNInfo, ngdp.ContentTypeConfig, h, ""); !client.IsNotFound(err) {
+		t.Errorf("replayed FetchRaw = %v; want not found", err)
+	}
+	r, err := replay.FetchRaw(ctx, *c.CDNInfo, ngdp.ContentTypeConfig, h, "")
+	if err != nil {
+		t.Fatalf("FetchRaw after the capture ran out: %v", err)
+	}
+	r.Close()
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ngdptest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+)
+
+// syntheticHash returns a hash derived from kind and n, so synthetic inputs are the same on every run.
+func syntheticHash(kind byte, n int) [md5.Size]byte {
+	var b [9]byte
+	b[0] = kind
+	binary.BigEndian.PutUint64(b[1:], uint64(n))
+	return md5.Sum(b[:])
+}
+
+// SyntheticEncodingTable builds an encoding table listing n made-up files, for benchmarking parsers against inputs the size of real ones.
+func SyntheticEncodingTable(n int) []byte {
+	ckeys := make([]ngdp.ContentHash, n)
+	ekeys := make(map[ngdp.ContentHash]ngdp.CDNHash, n)
+	sizes := make(map[ngdp.ContentHash]int, n)
+	for i := range ckeys {
+		ck := ngdp.ContentHash(syntheticHash('c', i))
+		ckeys[i] = ck
+		ekeys[ck] = ngdp.CDNHash(syntheticHash('e', i))
+		sizes[ck] = i
+	}
+	return encodingTable(ckeys, ekeys, sizes)
+}
+
+// SyntheticArchiveIndex builds the index of an archive holding n made-up files, returning it along with the archive's name.
+func SyntheticArchiveIndex(n int) ([]byte, ngdp.CDNHash) {
+	entries := make(map[ngdp.CDNHash]client.ArchiveEntry, n)
+	var offset uint32
+	for i := 0; i < n; i++ {
+		size := uint32(1000 + i%5000)
+		entries[ngdp.CDNHash(syntheticHash('a', i))] = client.ArchiveEntry{Size: size, Offset: offset}
+		offset += size
+	}
+
+	var b bytes.Buffer
+	name, err := client.WriteArchiveIndex(&b, entries)
+	if err != nil {
+		// Writing to a bytes.Buffer can't fail, so neither should this.
+		panic(err)
+	}
+	return b.Bytes(), name
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notify tells people and programs about version changes and failures, through configurable sinks such as webhooks, email and shell commands.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/watch"
+)
+
+// A Kind is a type of Event.
+type Kind string
+
+const (
+	// BuildChange events report a new version of a program in a region.
+	BuildChange Kind = "build-change"
+
+	// UpdateFailure events report that something went wrong while following a program.
+	UpdateFailure Kind = "update-failure"
+)
+
+// An Event is something worth telling someone about.
+type Event struct {
+	Kind Kind
+	Time time.Time
+
+	Program ngdp.ProgramCode `json:",omitempty"`
+	Region  ngdp.Region      `json:",omitempty"`
+
+	// Old and New are only set for BuildChange events.
+	Old, New ngdp.VersionInfo
+
+	// Error is only set for UpdateFailure events.
+	Error string `json:",omitempty"`
+}
+
+// ChangeEvent returns the BuildChange event for a change seen by a watch.Watcher.
+func ChangeEvent(c watch.Change) Event {
+	return Event{
+		Kind:    BuildChange,
+		Time:    time.Now().UTC(),
+		Program: c.Program,
+		Region:  c.Region,
+		Old:     c.Old,
+		New:     c.New,
+	}
+}
+
+// FailureEvent returns an UpdateFailure event for err. program may be empty if the failure isn't specific to one.
+func FailureEvent(program ngdp.ProgramCode, err error) Event {
+	return Event{
+		Kind:    UpdateFailure,
+		Time:    time.Now().UTC(),
+		Program: program,
+		Error:   err.Error(),
+	}
+}
+
+// String summarises the event in a single line.
+func (e Event) String() string {
+	switch e.Kind {
+	case BuildChange:
+		return fmt.Sprintf("%s/%s: %s (%d) -> %s (%d)", e.Program, e.Region, e.Old.VersionsName, e.Old.BuildID, e.New.VersionsName, e.New.BuildID)
+	case UpdateFailure:
+		if e.Program == "" {
+			return fmt.Sprintf("update failed: %s", e.Error)
+		}
+		return fmt.Sprintf("%s: update failed: %s", e.Program, e.Error)
+	}
+	return string(e.Kind)
+}
+
+// A Sink delivers events somewhere.
+type Sink interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// A Webhook POSTs each event, encoded as JSON, to URL.
+type Webhook struct {
+	URL string
+
+	// Client makes the requests. It defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Notify implements Sink.
+func (w Webhook) Notify(ctx context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	cl := w.Client
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	resp, err := cl.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("notify: webhook status was %q", resp.Status)
+	}
+	return nil
+}
+
+// An Email sends each event as a plain-text message through the SMTP server at Addr.
+type Email struct {
+	// Addr is the host:port of the SMTP server.
+	Addr string
+
+	// Auth, if set, is used to authenticate to the server.
+	Auth smtp.Auth
+
+	From string
+	To   []string
+}
+
+// message formats e as an email.
+func (m Email) message(e Event) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&b, "Subject: [snowstorm] %s\r\n", e)
+	fmt.Fprintf(&b, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, kv := range e.env() {
+		fmt.Fprintf(&b, "%s\r\n", kv)
+	}
+	return b.Bytes()
+}
+
+// Notify implements Sink. The SMTP client can't be cancelled, so ctx is ignored.
+func (m Email) Notify(ctx context.Context, e Event) error {
+	return smtp.SendMail(m.Addr, m.Auth, m.From, m.To, m.message(e))
+}
+
+// An Exec runs Command with /bin/sh for each event, describing it in SNOWSTORM_* environment variables.
+type Exec struct {
+	Command string
+}
+
+// env describes e as environment variables.
+func (e Event) env() []string {
+	env := []string{
+		"SNOWSTORM_EVENT=" + string(e.Kind),
+		"SNOWSTORM_PRODUCT=" + string(e.Program),
+	}
+	switch e.Kind {
+	case BuildChange:
+		env = append(env,
+			"SNOWSTORM_REGION="+string(e.Region),
+			fmt.Sprintf("SNOWSTORM_OLD_BUILD_ID=%d", e.Old.BuildID),
+			"SNOWSTORM_OLD_VERSION="+e.Old.VersionsName,
+			fmt.Sprintf("SNOWSTORM_OLD_BUILD_CONFIG=%032x", e.Old.BuildConfig),
+			fmt.Sprintf("SNOWSTORM_BUILD_ID=%d", e.New.BuildID),
+			"SNOWSTORM_VERSION="+e.New.VersionsName,
+			fmt.Sprintf("SNOWSTORM_BUILD_CONFIG=%032x", e.New.BuildConfig),
+			fmt.Sprintf("SNOWSTORM_CDN_CONFIG=%032x", e.New.CDNConfig),
+		)
+	case UpdateFailure:
+		env = append(env, "SNOWSTORM_ERROR="+e.Error)
+	}
+	return env
+}
+
+// Notify implements Sink. The command's output is passed through to this process's.
+func (x Exec) Notify(ctx context.Context, e Event) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", x.Command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), e.env()...)
+	return cmd.Run()
+}
+
+// Sinks delivers events to each of a list of sinks. It can be used as a flag.Value, accumulating a sink for each use of the flag.
+type Sinks []Sink
+
+// Notify delivers e to every sink, even if some fail, returning the first error.
+func (s Sinks) Notify(ctx context.Context, e Event) error {
+	var first error
+	for _, sink := range s {
+		if err := sink.Notify(ctx, e); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// String implements flag.Value.
+func (s *Sinks) String() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d sinks", len(*s))
+}
+
+// Set implements flag.Value, parsing a sink with Parse.
+func (s *Sinks) Set(spec string) error {
+	sink, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, sink)
+	return nil
+}
+
+// Parse interprets a sink specification, which is one of:
+//
+//   - an http:// or https:// URL, for a Webhook;
+//   - smtp://[user:password@]host[:port]?from=address&to=address[,address...], for an Email;
+//   - exec:command, for an Exec.
+func Parse(spec string) (Sink, error) {
+	if strings.HasPrefix(spec, "exec:") {
+		return Exec{Command: strings.TrimPrefix(spec, "exec:")}, nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return Webhook{URL: spec}, nil
+	case "smtp":
+		q := u.Query()
+		m := Email{
+			Addr: u.Host,
+			From: q.Get("from"),
+		}
+		if to := q.Get("to"); to != "" {
+			m.To = strings.Split(to, ",")
+		}
+		if m.From == "" || len(m.To) == 0 {
+			return nil, fmt.Errorf("notify: %q needs from and to addresses", spec)
+		}
+		host, _, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			host = u.Host
+			m.Addr = net.JoinHostPort(host, "25")
+		}
+		if u.User != nil {
+			password, _ := u.User.Password()
+			m.Auth = smtp.PlainAuth("", u.User.Username(), password, host)
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("notify: unknown sink %q; want an http(s):// URL, an smtp:// URL or exec:<command>", spec)
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package patch parses TACT patch data and uses it to update files from one build to the next by downloading small deltas.
+package patch
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+// A Record describes a single patch, which turns an old file into a new one.
+type Record struct {
+	// OldCDNHash is the encoding key of the file the patch applies to.
+	OldCDNHash ngdp.CDNHash
+
+	// OldSize is the decoded size of the old file.
+	OldSize uint64
+
+	// PatchCDNHash is the key of the ZBSDIFF1 patch, stored under the CDN's patch directory.
+	PatchCDNHash ngdp.CDNHash
+
+	// PatchSize is the size of the patch.
+	PatchSize uint32
+
+	// PatchIndex orders the patches of a file in the patch manifest. It is zero for patch config entries.
+	PatchIndex uint8
+}
+
+// A ConfigEntry is a patch-entry line of a patch config, describing how to patch one of a build's special files.
+type ConfigEntry struct {
+	// Type is the kind of file, such as "encoding".
+	Type string
+
+	ContentHash ngdp.ContentHash
+	Size        uint64
+	CDNHash     ngdp.CDNHash
+	EncodedSize uint64
+	ESpec       string
+
+	Patches []Record
+}
+
+// A Config is a patch config, referenced by a build config's patch-config.
+type Config struct {
+	// Patch is the key of the patch manifest.
+	Patch     ngdp.CDNHash
+	PatchSize uint64
+
+	Entries []ConfigEntry
+}
+
+func parseHash(s string, h []byte) error {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	if len(b) != len(h) {
+		return fmt.Errorf("hash %q is %d bytes; want %d", s, len(b), len(h))
+	}
+	copy(h, b)
+	return nil
+}
+
+func parseConfigEntry(value string) (ConfigEntry, error) {
+	var e ConfigEntry
+	f := strings.Fields(value)
+	if len(f) < 6 || (len(f)-6)%4 != 0 {
+		return e, fmt.Errorf("want 6 fields followed by groups of 4, got %d fields", len(f))
+	}
+
+	var err error
+	e.Type = f[0]
+	if err = parseHash(f[1], e.ContentHash[:]); err != nil {
+		return e, err
+	}
+	if e.Size, err = strconv.ParseUint(f[2], 10, 64); err != nil {
+		return e, err
+	}
+	if err = parseHash(f[3], e.CDNHash[:]); err != nil {
+		return e, err
+	}
+	if e.EncodedSize, err = strconv.ParseUint(f[4], 10, 64); err != nil {
+		return e, err
+	}
+	e.ESpec = f[5]
+
+	for p := f[6:]; len(p) > 0; p = p[4:] {
+		var r Record
+		if err = parseHash(p[0], r.OldCDNHash[:]); err != nil {
+			return e, err
+		}
+		if r.OldSize, err = strconv.ParseUint(p[1], 10, 64); err != nil {
+			return e, err
+		}
+		if err = parseHash(p[2], r.PatchCDNHash[:]); err != nil {
+			return e, err
+		}
+		size, err := strconv.ParseUint(p[3], 10, 32)
+		if err != nil {
+			return e, err
+		}
+		r.PatchSize = uint32(size)
+		e.Patches = append(e.Patches, r)
+	}
+	return e, nil
+}
+
+// ParseConfig parses a patch config.
+//
+// This doesn't use keyvalue.Decode, since patch configs repeat the patch-entry key.
+func ParseConfig(r io.Reader) (*Config, error) {
+	c := new(Config)
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		ln := strings.TrimSpace(s.Text())
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+		bits := strings.SplitN(ln, "=", 2)
+		if len(bits) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(bits[0]), strings.TrimSpace(bits[1])
+
+		var err error
+		switch key {
+		case "patch":
+			err = parseHash(value, c.Patch[:])
+		case "patch-size":
+			c.PatchSize, err = strconv.ParseUint(value, 10, 64)
+		case "patch-entry":
+			var e ConfigEntry
+			if e, err = parseConfigEntry(value); err == nil {
+				c.Entries = append(c.Entries, e)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("patch: parsing %s: %v", key, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
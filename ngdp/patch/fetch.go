@@ -0,0 +1,63 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+)
+
+// ErrNoPatchConfig means that a build config doesn't reference a patch config, so the build can't be reached by patching.
+var ErrNoPatchConfig = errors.New("patch: build has no patch config")
+
+// FetchConfig retrieves the patch config referenced by buildConfig from the CDN.
+func FetchConfig(ctx context.Context, llc *client.LowLevelClient, cdn ngdp.CDNInfo, buildConfig ngdp.BuildConfig) (*Config, error) {
+	if buildConfig.PatchConfig.Equal(ngdp.CDNHash{}) {
+		return nil, ErrNoPatchConfig
+	}
+
+	body, err := llc.FetchRaw(ctx, cdn, ngdp.ContentTypeConfig, buildConfig.PatchConfig, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving patch config")
+	}
+	defer body.Close()
+
+	c, err := ParseConfig(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing patch config")
+	}
+	return c, nil
+}
+
+// FetchManifest retrieves the patch manifest referenced by c from the CDN. Patch manifests are stored alongside the patches themselves, without BLTE encoding.
+func FetchManifest(ctx context.Context, llc *client.LowLevelClient, cdn ngdp.CDNInfo, c *Config) (*Manifest, error) {
+	body, err := llc.FetchRaw(ctx, cdn, ngdp.ContentTypePatch, c.Patch, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving patch manifest")
+	}
+	defer body.Close()
+
+	m, err := ParseManifest(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing patch manifest")
+	}
+	return m, nil
+}
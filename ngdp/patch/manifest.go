@@ -0,0 +1,251 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/encoding"
+)
+
+var (
+	// ErrBadMagic means that the patch manifest doesn't start with "PA".
+	ErrBadMagic = errors.New("patch: bad magic")
+
+	// ErrTruncated means that the patch manifest ended unexpectedly.
+	ErrTruncated = errors.New("patch: manifest truncated")
+)
+
+// An Entry describes the patches available to produce one file of the new build.
+type Entry struct {
+	// ContentHash is the content hash of the new file.
+	ContentHash ngdp.ContentHash
+
+	// Size is the decoded size of the new file.
+	Size uint64
+
+	// Patches lists the old files which can be patched to produce the new one.
+	Patches []Record
+}
+
+// A Manifest is a patch manifest, referenced by a build config's patch key. It lists every file of the build which can be produced by patching.
+type Manifest struct {
+	Version       uint8
+	BlockSizeBits uint8
+	Flags         uint8
+
+	// The encoding table of the new build is described in the manifest's header.
+	EncodingContentHash ngdp.ContentHash
+	EncodingCDNHash     ngdp.CDNHash
+	EncodingSize        uint32
+	EncodingEncodedSize uint32
+	EncodingESpec       string
+
+	Entries []Entry
+
+	byContentHash map[ngdp.ContentHash]int
+	byOldCDNHash  map[ngdp.CDNHash][]patchRef
+}
+
+// patchRef locates a Record within a Manifest's entries.
+type patchRef struct {
+	entry, patch int
+}
+
+// A Target is a file of the new build which can be produced by patching a particular old file.
+type Target struct {
+	// ContentHash is the content hash the patched file must have.
+	ContentHash ngdp.ContentHash
+
+	// Size is the decoded size of the patched file.
+	Size uint64
+
+	// Patch is the patch which turns the old file into this one.
+	Patch Record
+}
+
+// Lookup returns the entry for the new file with content hash h.
+func (m *Manifest) Lookup(h ngdp.ContentHash) (Entry, bool) {
+	n, ok := m.byContentHash[h]
+	if !ok {
+		return Entry{}, false
+	}
+	return m.Entries[n], true
+}
+
+// PatchesFrom returns every file of the new build which can be produced by patching the old file with encoding key h.
+func (m *Manifest) PatchesFrom(h ngdp.CDNHash) []Target {
+	refs := m.byOldCDNHash[h]
+	if len(refs) == 0 {
+		return nil
+	}
+	ts := make([]Target, len(refs))
+	for n, ref := range refs {
+		e := m.Entries[ref.entry]
+		ts[n] = Target{e.ContentHash, e.Size, e.Patches[ref.patch]}
+	}
+	return ts
+}
+
+// PatchesFromContent is like PatchesFrom, but takes the content hash of the old file.
+//
+// The manifest refers to old files by encoding key, so they are looked up in oldEncoding, which must be the encoding table of the old build.
+func (m *Manifest) PatchesFromContent(h ngdp.ContentHash, oldEncoding *encoding.Mapper) ([]Target, error) {
+	cdnHash, err := oldEncoding.ToCDNHash(h)
+	if err != nil {
+		return nil, err
+	}
+	return m.PatchesFrom(cdnHash), nil
+}
+
+// manifestReader reads big-endian fields from a manifest, remembering the first error.
+type manifestReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *manifestReader) next(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if r.pos+n > len(r.b) {
+		r.err = ErrTruncated
+		return make([]byte, n)
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *manifestReader) uint8() uint8 { return r.next(1)[0] }
+
+func (r *manifestReader) uint16() uint16 { return binary.BigEndian.Uint16(r.next(2)) }
+
+func (r *manifestReader) uint32() uint32 { return binary.BigEndian.Uint32(r.next(4)) }
+
+func (r *manifestReader) uint40() uint64 {
+	b := r.next(5)
+	return uint64(b[0])<<32 | uint64(binary.BigEndian.Uint32(b[1:]))
+}
+
+// key reads a key of length n into h, which is zero-padded on the right if n is short.
+func (r *manifestReader) key(h []byte, n uint8) {
+	b := r.next(int(n))
+	copy(h, b)
+}
+
+// ParseManifest parses a patch manifest.
+func ParseManifest(rd io.Reader) (*Manifest, error) {
+	b, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	r := &manifestReader{b: b}
+
+	if string(r.next(2)) != "PA" {
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, ErrBadMagic
+	}
+	m := &Manifest{
+		Version: r.uint8(),
+	}
+	fileKeySize, oldKeySize, patchKeySize := r.uint8(), r.uint8(), r.uint8()
+	m.BlockSizeBits = r.uint8()
+	blockCount := r.uint16()
+	m.Flags = r.uint8()
+	r.key(m.EncodingContentHash[:], 16)
+	r.key(m.EncodingCDNHash[:], 16)
+	m.EncodingSize = r.uint32()
+	m.EncodingEncodedSize = r.uint32()
+	m.EncodingESpec = string(r.next(int(r.uint8())))
+	if r.err != nil {
+		return nil, r.err
+	}
+	if m.Version < 1 || m.Version > 2 {
+		return nil, fmt.Errorf("patch: unsupported manifest version %d", m.Version)
+	}
+	for _, ks := range []uint8{fileKeySize, oldKeySize, patchKeySize} {
+		if ks == 0 || ks > 16 {
+			return nil, fmt.Errorf("patch: unsupported key size %d", ks)
+		}
+	}
+
+	// The block table gives the offset of each block of entries.
+	offsets := make([]uint32, blockCount)
+	for n := range offsets {
+		r.next(int(fileKeySize)) // last content hash in the block
+		r.next(16)               // MD5 of the block
+		offsets[n] = r.uint32()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	m.byContentHash = make(map[ngdp.ContentHash]int)
+	m.byOldCDNHash = make(map[ngdp.CDNHash][]patchRef)
+	for n, off := range offsets {
+		end := len(b)
+		if n+1 < len(offsets) {
+			end = int(offsets[n+1])
+		}
+		if int(off) > end || end > len(b) {
+			return nil, ErrTruncated
+		}
+		r.pos = int(off)
+
+		// Each block is a list of entries, terminated by one with no patches or by the end of the block.
+		for r.pos < end {
+			numPatches := r.uint8()
+			if r.err != nil {
+				return nil, r.err
+			}
+			if numPatches == 0 {
+				break
+			}
+
+			var e Entry
+			r.key(e.ContentHash[:], fileKeySize)
+			e.Size = r.uint40()
+			for n := uint8(0); n < numPatches; n++ {
+				var rec Record
+				r.key(rec.OldCDNHash[:], oldKeySize)
+				rec.OldSize = r.uint40()
+				r.key(rec.PatchCDNHash[:], patchKeySize)
+				rec.PatchSize = r.uint32()
+				rec.PatchIndex = r.uint8()
+				e.Patches = append(e.Patches, rec)
+			}
+			if r.err != nil {
+				return nil, r.err
+			}
+			m.byContentHash[e.ContentHash] = len(m.Entries)
+			for n, rec := range e.Patches {
+				m.byOldCDNHash[rec.OldCDNHash] = append(m.byOldCDNHash[rec.OldCDNHash], patchRef{len(m.Entries), n})
+			}
+			m.Entries = append(m.Entries, e)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/ngdptest"
+)
+
+const exampleConfig = `# Patch Configuration
+
+patch = 658506593cf1f98a1d9300c418ee5355
+patch-size = 22764
+patch-entry = encoding b07b881f4527bda7cf8a1a2f99e8622e 14004322 d4d25d3a66bf7f84e8b4c4e9b80ac0a9 14004381 b:{22=n,*=z} 7c3e8f4a8b9a1a27d41e61b0d1f16c0e 13981234 8f1e2d3c4b5a69788796a5b4c3d2e1f0 66656
+patch-entry = install 0123456789abcdef0123456789abcdef 1000 fedcba9876543210fedcba9876543210 900 z
+`
+
+func TestParseConfig(t *testing.T) {
+	got, err := ParseConfig(strings.NewReader(exampleConfig))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+
+	want := &Config{
+		Patch:     ngdp.CDNHash{0x65, 0x85, 0x06, 0x59, 0x3c, 0xf1, 0xf9, 0x8a, 0x1d, 0x93, 0x00, 0xc4, 0x18, 0xee, 0x53, 0x55},
+		PatchSize: 22764,
+		Entries: []ConfigEntry{{
+			Type:        "encoding",
+			ContentHash: ngdp.ContentHash{0xb0, 0x7b, 0x88, 0x1f, 0x45, 0x27, 0xbd, 0xa7, 0xcf, 0x8a, 0x1a, 0x2f, 0x99, 0xe8, 0x62, 0x2e},
+			Size:        14004322,
+			CDNHash:     ngdp.CDNHash{0xd4, 0xd2, 0x5d, 0x3a, 0x66, 0xbf, 0x7f, 0x84, 0xe8, 0xb4, 0xc4, 0xe9, 0xb8, 0x0a, 0xc0, 0xa9},
+			EncodedSize: 14004381,
+			ESpec:       "b:{22=n,*=z}",
+			Patches: []Record{{
+				OldCDNHash:   ngdp.CDNHash{0x7c, 0x3e, 0x8f, 0x4a, 0x8b, 0x9a, 0x1a, 0x27, 0xd4, 0x1e, 0x61, 0xb0, 0xd1, 0xf1, 0x6c, 0x0e},
+				OldSize:      13981234,
+				PatchCDNHash: ngdp.CDNHash{0x8f, 0x1e, 0x2d, 0x3c, 0x4b, 0x5a, 0x69, 0x78, 0x87, 0x96, 0xa5, 0xb4, 0xc3, 0xd2, 0xe1, 0xf0},
+				PatchSize:    66656,
+			}},
+		}, {
+			Type:        "install",
+			ContentHash: ngdp.ContentHash{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef},
+			Size:        1000,
+			CDNHash:     ngdp.CDNHash{0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10, 0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10},
+			EncodedSize: 900,
+			ESpec:       "z",
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseConfig = %#v; want %#v", got, want)
+	}
+}
+
+func TestParseConfigBadEntry(t *testing.T) {
+	if _, err := ParseConfig(strings.NewReader("patch-entry = encoding b07b\n")); err == nil {
+		t.Errorf("ParseConfig: nil error; want error")
+	}
+}
+
+func putUint40(buf *bytes.Buffer, v uint64) {
+	buf.WriteByte(byte(v >> 32))
+	binary.Write(buf, binary.BigEndian, uint32(v))
+}
+
+// makeManifest builds a single-block patch manifest.
+func makeManifest(entries []Entry) []byte {
+	var block bytes.Buffer
+	for _, e := range entries {
+		block.WriteByte(byte(len(e.Patches)))
+		block.Write(e.ContentHash[:])
+		putUint40(&block, e.Size)
+		for _, p := range e.Patches {
+			block.Write(p.OldCDNHash[:])
+			putUint40(&block, p.OldSize)
+			block.Write(p.PatchCDNHash[:])
+			binary.Write(&block, binary.BigEndian, p.PatchSize)
+			block.WriteByte(p.PatchIndex)
+		}
+	}
+	block.WriteByte(0)
+
+	var buf bytes.Buffer
+	buf.WriteString("PA")
+	buf.Write([]byte{2, 16, 16, 16, 16})
+	binary.Write(&buf, binary.BigEndian, uint16(1))
+	buf.WriteByte(0)
+	buf.Write(make([]byte, 32)) // encoding hashes
+	binary.Write(&buf, binary.BigEndian, uint32(100))
+	binary.Write(&buf, binary.BigEndian, uint32(90))
+	buf.WriteByte(1)
+	buf.WriteString("z")
+
+	// One block header; the block follows immediately.
+	buf.Write(make([]byte, 32))
+	binary.Write(&buf, binary.BigEndian, uint32(buf.Len()+4))
+	buf.Write(block.Bytes())
+	return buf.Bytes()
+}
+
+func TestParseManifest(t *testing.T) {
+	want := []Entry{
+		{
+			ContentHash: ngdp.ContentHash{1},
+			Size:        0x123456789a,
+			Patches: []Record{
+				{OldCDNHash: ngdp.CDNHash{2}, OldSize: 10, PatchCDNHash: ngdp.CDNHash{3}, PatchSize: 20, PatchIndex: 0},
+				{OldCDNHash: ngdp.CDNHash{4}, OldSize: 30, PatchCDNHash: ngdp.CDNHash{5}, PatchSize: 40, PatchIndex: 1},
+			},
+		},
+		{
+			ContentHash: ngdp.ContentHash{6},
+			Size:        50,
+			Patches:     []Record{{OldCDNHash: ngdp.CDNHash{7}, OldSize: 60, PatchCDNHash: ngdp.CDNHash{8}, PatchSize: 70}},
+		},
+	}
+
+	m, err := ParseManifest(bytes.NewReader(makeManifest(want)))
+	if err != nil {
+		t.Fatalf("ParseManifest: %v", err)
+	}
+	if m.Version != 2 || m.EncodingSize != 100 || m.EncodingEncodedSize != 90 || m.EncodingESpec != "z" {
+		t.Errorf("header = %+v; want version 2, sizes 100 and 90, espec z", m)
+	}
+	if !reflect.DeepEqual(m.Entries, want) {
+		t.Errorf("m.Entries = %#v; want %#v", m.Entries, want)
+	}
+
+	e, ok := m.Lookup(ngdp.ContentHash{6})
+	if !ok || !reflect.DeepEqual(e, want[1]) {
+		t.Errorf("m.Lookup(06...) = %#v, %v; want %#v, true", e, ok, want[1])
+	}
+	if _, ok := m.Lookup(ngdp.ContentHash{9}); ok {
+		t.Errorf("m.Lookup(09...) found an entry; want none")
+	}
+
+	wantTargets := []Target{{ContentHash: ngdp.ContentHash{1}, Size: 0x123456789a, Patch: want[0].Patches[1]}}
+	if ts := m.PatchesFrom(ngdp.CDNHash{4}); !reflect.DeepEqual(ts, wantTargets) {
+		t.Errorf("m.PatchesFrom(04...) = %#v; want %#v", ts, wantTargets)
+	}
+	if ts := m.PatchesFrom(ngdp.CDNHash{1}); ts != nil {
+		t.Errorf("m.PatchesFrom(01...) = %#v; want nil", ts)
+	}
+}
+
+func TestParseManifestErrors(t *testing.T) {
+	b := makeManifest([]Entry{{ContentHash: ngdp.ContentHash{1}, Patches: []Record{{}}}})
+	if _, err := ParseManifest(bytes.NewReader(append([]byte("XX"), b[2:]...))); err != ErrBadMagic {
+		t.Errorf("ParseManifest with bad magic: %v; want %v", err, ErrBadMagic)
+	}
+	if _, err := ParseManifest(bytes.NewReader(b[:len(b)-10])); err != ErrTruncated {
+		t.Errorf("ParseManifest of truncated manifest: %v; want %v", err, ErrTruncated)
+	}
+}
+
+// makeZBSDIFF builds a patch which replaces the whole of an old file with new.
+func makeZBSDIFF(newData []byte) []byte {
+	compress := func(b []byte) []byte {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(b)
+		w.Close()
+		return buf.Bytes()
+	}
+	var ctrl bytes.Buffer
+	binary.Write(&ctrl, binary.BigEndian, [3]int64{0, int64(len(newData)), 0})
+	ctrlZ, diffZ, extraZ := compress(ctrl.Bytes()), compress(nil), compress(newData)
+
+	var buf bytes.Buffer
+	buf.WriteString("ZBSDIFF1")
+	binary.Write(&buf, binary.BigEndian, int64(len(ctrlZ)))
+	binary.Write(&buf, binary.BigEndian, int64(len(diffZ)))
+	binary.Write(&buf, binary.BigEndian, int64(len(newData)))
+	buf.Write(ctrlZ)
+	buf.Write(diffZ)
+	buf.Write(extraZ)
+	return buf.Bytes()
+}
+
+func TestUpdater(t *testing.T) {
+	newData := []byte("the new file")
+	h := ngdp.ContentHash(md5.Sum(newData))
+
+	m, err := ParseManifest(bytes.NewReader(makeManifest([]Entry{{
+		ContentHash: h,
+		Size:        uint64(len(newData)),
+		Patches: []Record{
+			{OldCDNHash: ngdp.CDNHash{1}, PatchCDNHash: ngdp.CDNHash{2}},
+			{OldCDNHash: ngdp.CDNHash{3}, PatchCDNHash: ngdp.CDNHash{4}},
+		},
+	}})))
+	if err != nil {
+		t.Fatalf("ParseManifest: %v", err)
+	}
+
+	u := &Updater{
+		Manifest: m,
+		OpenOld: func(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error) {
+			// Only the second old file is present.
+			if h != (ngdp.CDNHash{3}) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return ioutil.NopCloser(strings.NewReader("the old file")), nil
+		},
+		OpenPatch: func(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(makeZBSDIFF(newData))), nil
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := u.Update(context.Background(), &buf, h); err != nil {
+		t.Fatalf("u.Update: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), newData) {
+		t.Errorf("u.Update wrote %q; want %q", buf.Bytes(), newData)
+	}
+
+	if err := u.Update(context.Background(), &buf, ngdp.ContentHash{}); err != ErrNoPatch {
+		t.Errorf("u.Update of unknown file: %v; want %v", err, ErrNoPatch)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	s := ngdptest.NewServer()
+	defer s.Close()
+	llc := s.LowLevelClient()
+	cdn := ngdp.CDNInfo{Path: ngdptest.CDNPath, Hosts: []string{ngdptest.CDNHost}}
+	ctx := context.Background()
+
+	entries := []Entry{{ContentHash: ngdp.ContentHash{1}, Size: 10, Patches: []Record{{OldCDNHash: ngdp.CDNHash{2}, OldSize: 20, PatchCDNHash: ngdp.CDNHash{3}, PatchSize: 30}}}}
+	manifestHash := s.PutObject(ngdptest.CDNPath, ngdp.ContentTypePatch, makeManifest(entries))
+	config := strings.Replace(exampleConfig, "658506593cf1f98a1d9300c418ee5355", fmt.Sprintf("%032x", manifestHash), 1)
+	configHash := s.PutObject(ngdptest.CDNPath, ngdp.ContentTypeConfig, []byte(config))
+
+	if _, err := FetchConfig(ctx, llc, cdn, ngdp.BuildConfig{}); err != ErrNoPatchConfig {
+		t.Errorf("FetchConfig for a build without a patch config = %v; want %v", err, ErrNoPatchConfig)
+	}
+
+	c, err := FetchConfig(ctx, llc, cdn, ngdp.BuildConfig{PatchConfig: configHash})
+	if err != nil {
+		t.Fatalf("FetchConfig: %v", err)
+	}
+	if c.Patch != manifestHash || len(c.Entries) != 2 {
+		t.Errorf("FetchConfig = %+v; want manifest %032x and 2 entries", c, manifestHash)
+	}
+
+	m, err := FetchManifest(ctx, llc, cdn, c)
+	if err != nil {
+		t.Fatalf("FetchManifest: %v", err)
+	}
+	if !reflect.DeepEqual(m.Entries, entries) {
+		t.Errorf("FetchManifest entries = %#v; want %#v", m.Entries, entries)
+	}
+}
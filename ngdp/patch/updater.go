@@ -0,0 +1,114 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+
+	"github.com/lukegb/snowstorm/blte"
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/zbsdiff"
+)
+
+// ErrNoPatch means that the manifest doesn't list a patch which can produce the file.
+var ErrNoPatch = errors.New("patch: no usable patch for file")
+
+// An OldFileOpener returns the decoded contents of a file from a previous build, given its encoding key.
+type OldFileOpener func(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error)
+
+// A PatchOpener returns a patch, given its key.
+type PatchOpener func(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error)
+
+// CDNPatchOpener returns a PatchOpener which downloads patches from the CDN.
+func CDNPatchOpener(llc *client.LowLevelClient, cdn ngdp.CDNInfo) PatchOpener {
+	return func(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error) {
+		return llc.FetchRaw(ctx, cdn, ngdp.ContentTypePatch, h, "")
+	}
+}
+
+// ArchivedPatchOpener returns a PatchOpener which downloads patches from the CDN, fetching them out of the patch archives when m says they are there.
+func ArchivedPatchOpener(llc *client.LowLevelClient, cdn ngdp.CDNInfo, m *client.PatchArchiveMapper) PatchOpener {
+	loose := CDNPatchOpener(llc, cdn)
+	return func(ctx context.Context, h ngdp.CDNHash) (io.ReadCloser, error) {
+		if e, ok := m.Map(h); ok {
+			return llc.FetchArchived(ctx, cdn, ngdp.ContentTypePatch, e)
+		}
+		return loose(ctx, h)
+	}
+}
+
+// An Updater produces files of a new build by patching files from an older one.
+type Updater struct {
+	Manifest  *Manifest
+	OpenOld   OldFileOpener
+	OpenPatch PatchOpener
+}
+
+func readAll(r io.ReadCloser, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Patches may be BLTE-encoded.
+	br := bufio.NewReader(r)
+	var src io.Reader = br
+	if magic, err := br.Peek(4); err == nil && string(magic) == "BLTE" {
+		src = blte.NewReader(br)
+	}
+	return ioutil.ReadAll(src)
+}
+
+// Update writes the file with content hash h into w, by patching whichever old file it can.
+//
+// Each patch listed in the manifest is tried in turn; nothing is written to w unless a patch succeeds and produces a file with the right hash.
+func (u *Updater) Update(ctx context.Context, w io.Writer, h ngdp.ContentHash) error {
+	e, ok := u.Manifest.Lookup(h)
+	if !ok {
+		return ErrNoPatch
+	}
+
+	err := ErrNoPatch
+	for _, rec := range e.Patches {
+		old, oerr := readAll(u.OpenOld(ctx, rec.OldCDNHash))
+		if oerr != nil {
+			err = errors.Wrapf(oerr, "opening old file %032x", rec.OldCDNHash)
+			continue
+		}
+		p, perr := readAll(u.OpenPatch(ctx, rec.PatchCDNHash))
+		if perr != nil {
+			err = errors.Wrapf(perr, "fetching patch %032x", rec.PatchCDNHash)
+			continue
+		}
+
+		var buf bytes.Buffer
+		if aerr := zbsdiff.ApplyVerified(&buf, bytes.NewReader(old), p, h); aerr != nil {
+			err = errors.Wrapf(aerr, "applying patch %032x", rec.PatchCDNHash)
+			continue
+		}
+		_, err := buf.WriteTo(w)
+		return err
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package productconfig parses the product config, the JSON document the Battle.net agent uses to describe how a product is installed and shown.
+//
+// A product config has an "all" section, per-platform sections under "platform", and a section for each locale.
+// Each holds a "config" object; later sections override earlier ones, so the settings for a particular install are found with Config.Settings.
+package productconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ErrEncrypted means that the product config is encrypted. Decrypting product configs is not supported.
+var ErrEncrypted = errors.New("productconfig: product config is encrypted")
+
+// A GameDir describes the directory a product is installed into.
+type GameDir struct {
+	Dirname string `json:"dirname"`
+
+	// RequiredSpace is the space needed for an install with a single language, in bytes.
+	RequiredSpace int64 `json:"required_space"`
+
+	// SpacePerExtraLanguage is the additional space needed for each further language, in bytes.
+	SpacePerExtraLanguage int64 `json:"space_per_extra_language"`
+}
+
+// A Form holds the settings offered when installing a product.
+type Form struct {
+	GameDir GameDir `json:"game_dir"`
+}
+
+// A Binary is an executable the launcher can start.
+type Binary struct {
+	RelativePath      string   `json:"relative_path"`
+	RelativePathARM64 string   `json:"relative_path_arm64,omitempty"`
+	SwitchArguments   []string `json:"switch_arguments,omitempty"`
+}
+
+// A Shortcut is a shortcut the launcher creates for a product.
+type Shortcut struct {
+	Description string `json:"description"`
+	Link        string `json:"link"`
+}
+
+// An InstallStep is one of the actions the launcher takes after installing a product.
+// Only shortcuts are parsed; other kinds of step are left out.
+type InstallStep struct {
+	StartMenuShortcut *Shortcut `json:"start_menu_shortcut,omitempty"`
+	DesktopShortcut   *Shortcut `json:"desktop_shortcut,omitempty"`
+}
+
+// Settings are the contents of a single "config" object, or several merged together.
+type Settings struct {
+	Product      string `json:"product"`
+	UpdateMethod string `json:"update_method"`
+	DataDir      string `json:"data_dir"`
+
+	// SharedContainerDefaultSubfolder is the subdirectory of the install directory used by products which share one, such as "_retail_".
+	SharedContainerDefaultSubfolder string `json:"shared_container_default_subfolder"`
+
+	SupportedLocales []string `json:"supported_locales"`
+	DisplayLocales   []string `json:"display_locales"`
+	SupportedRegions []string `json:"supported_regions"`
+
+	Form            Form              `json:"form"`
+	Binaries        map[string]Binary `json:"binaries"`
+	LaunchArguments []string          `json:"launch_arguments"`
+	Install         []InstallStep     `json:"install"`
+
+	SupportsMultibox bool `json:"supports_multibox"`
+	SupportsOffline  bool `json:"supports_offline"`
+
+	// DecryptionKeyName names the key needed to decrypt the product's content, if it is encrypted.
+	DecryptionKeyName string `json:"decryption_key_name"`
+}
+
+type section struct {
+	Config json.RawMessage `json:"config"`
+}
+
+// A Config is a parsed product config.
+type Config struct {
+	all       json.RawMessage
+	platforms map[string]json.RawMessage
+	locales   map[string]json.RawMessage
+}
+
+// Parse reads a product config.
+func Parse(r io.Reader) (*Config, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if t := bytes.TrimSpace(b); len(t) == 0 || t[0] != '{' {
+		return nil, ErrEncrypted
+	}
+
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(b, &top); err != nil {
+		return nil, errors.Wrap(err, "productconfig: parsing")
+	}
+
+	c := &Config{
+		platforms: make(map[string]json.RawMessage),
+		locales:   make(map[string]json.RawMessage),
+	}
+	for k, v := range top {
+		switch k {
+		case "all":
+			var s section
+			if err := json.Unmarshal(v, &s); err != nil {
+				return nil, errors.Wrap(err, "productconfig: parsing all")
+			}
+			c.all = s.Config
+		case "platform":
+			var ps map[string]section
+			if err := json.Unmarshal(v, &ps); err != nil {
+				return nil, errors.Wrap(err, "productconfig: parsing platforms")
+			}
+			for p, s := range ps {
+				c.platforms[p] = s.Config
+			}
+		default:
+			var s section
+			if err := json.Unmarshal(v, &s); err != nil {
+				// Not every top-level key is a locale section.
+				continue
+			}
+			if s.Config != nil {
+				c.locales[k] = s.Config
+			}
+		}
+	}
+	return c, nil
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// Platforms returns the names of the platforms with their own settings, such as "win" and "mac".
+func (c *Config) Platforms() []string { return sortedKeys(c.platforms) }
+
+// Locales returns the locales with their own settings, such as "enUS".
+func (c *Config) Locales() []string { return sortedKeys(c.locales) }
+
+// Settings returns the settings for installing on platform in locale.
+// Either may be empty, in which case only the more general settings are used.
+func (c *Config) Settings(platform, locale string) (Settings, error) {
+	var s Settings
+	for _, raw := range []json.RawMessage{c.all, c.platforms[platform], c.locales[locale]} {
+		if raw == nil {
+			continue
+		}
+		// Decoding into the same value overrides only the fields present in the more specific section.
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return Settings{}, errors.Wrap(err, "productconfig: parsing settings")
+		}
+	}
+	return s, nil
+}
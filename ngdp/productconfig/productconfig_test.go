@@ -0,0 +1,91 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package productconfig
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const exampleConfig = `{
+  "all": {
+    "config": {
+      "product": "WoW",
+      "update_method": "ngdp",
+      "data_dir": "Data/",
+      "shared_container_default_subfolder": "_retail_",
+      "supported_locales": ["enUS", "deDE"],
+      "display_locales": ["enUS", "deDE"],
+      "form": {"game_dir": {"dirname": "World of Warcraft", "required_space": 1000, "space_per_extra_language": 10}},
+      "binaries": {"game": {"relative_path": "Wow.exe"}}
+    }
+  },
+  "platform": {
+    "mac": {"config": {"binaries": {"game": {"relative_path": "World of Warcraft.app"}}}}
+  },
+  "deDE": {
+    "config": {"install": [{"start_menu_shortcut": {"description": "World of Warcraft (deutsch)", "link": "Wow.exe"}}]}
+  },
+  "unrelated": 3
+}`
+
+func TestSettings(t *testing.T) {
+	c, err := Parse(strings.NewReader(exampleConfig))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got, want := c.Platforms(), []string{"mac"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Platforms = %v; want %v", got, want)
+	}
+	if got, want := c.Locales(), []string{"deDE"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Locales = %v; want %v", got, want)
+	}
+
+	s, err := c.Settings("mac", "deDE")
+	if err != nil {
+		t.Fatalf("Settings: %v", err)
+	}
+	want := Settings{
+		Product:                         "WoW",
+		UpdateMethod:                    "ngdp",
+		DataDir:                         "Data/",
+		SharedContainerDefaultSubfolder: "_retail_",
+		SupportedLocales:                []string{"enUS", "deDE"},
+		DisplayLocales:                  []string{"enUS", "deDE"},
+		Form:                            Form{GameDir{"World of Warcraft", 1000, 10}},
+		Binaries:                        map[string]Binary{"game": {RelativePath: "World of Warcraft.app"}},
+		Install:                         []InstallStep{{StartMenuShortcut: &Shortcut{"World of Warcraft (deutsch)", "Wow.exe"}}},
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("Settings = %#v; want %#v", s, want)
+	}
+
+	s, err = c.Settings("", "")
+	if err != nil {
+		t.Fatalf("Settings: %v", err)
+	}
+	if got := s.Binaries["game"].RelativePath; got != "Wow.exe" {
+		t.Errorf("default game binary = %q; want Wow.exe", got)
+	}
+}
+
+func TestParseEncrypted(t *testing.T) {
+	if _, err := Parse(strings.NewReader("\x8f\x01\x02garbage")); err != ErrEncrypted {
+		t.Errorf("Parse of encrypted config = %v; want %v", err, ErrEncrypted)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ribbit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+)
+
+const checksumPrefix = "Checksum: "
+
+// A Message is a parsed Ribbit v1 response.
+type Message struct {
+	// Subject is the subject line of the response, which usually echoes the command.
+	Subject string
+
+	// Data is the payload of the response, usually a config table.
+	Data []byte
+
+	// Signature is the raw (base64-encoded) CMS signature attached to the response, if any.
+	Signature []byte
+}
+
+// verifyChecksum checks the trailing checksum line of a v1 response, and returns the message with it removed.
+func verifyChecksum(b []byte) ([]byte, error) {
+	trimmed := bytes.TrimRight(b, "\r\n")
+	idx := bytes.LastIndex(trimmed, []byte("\n"+checksumPrefix))
+	if idx == -1 {
+		return nil, ErrNoChecksum
+	}
+	msg := trimmed[:idx+1]
+	want := string(bytes.TrimSpace(trimmed[idx+1+len(checksumPrefix):]))
+
+	got := sha256.Sum256(msg)
+	if hex.EncodeToString(got[:]) != strings.ToLower(want) {
+		return nil, fmt.Errorf("ribbit: checksum mismatch: calculated %x, message said %s", got, want)
+	}
+	return msg, nil
+}
+
+// parseMessage parses a complete v1 response, verifying its checksum.
+func parseMessage(b []byte) (*Message, error) {
+	b, err := verifyChecksum(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// Servers aren't consistent about line endings between the MIME framing and the payload, which confuses the multipart parser.
+	b = bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1)
+
+	m, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("ribbit: reading MIME message: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("ribbit: parsing content type: %v", err)
+	}
+
+	msg := &Message{
+		Subject: m.Header.Get("Subject"),
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		// Not multipart, so the body is the data.
+		if msg.Data, err = ioutil.ReadAll(m.Body); err != nil {
+			return nil, fmt.Errorf("ribbit: reading body: %v", err)
+		}
+		return msg, nil
+	}
+
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("ribbit: reading MIME part: %v", err)
+		}
+
+		body, err := ioutil.ReadAll(p)
+		if err != nil {
+			return nil, fmt.Errorf("ribbit: reading MIME part: %v", err)
+		}
+
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		switch {
+		case partType == "application/cms":
+			msg.Signature = body
+		case msg.Data == nil:
+			msg.Data = body
+		}
+	}
+
+	if msg.Data == nil {
+		return nil, ErrNoData
+	}
+	return msg, nil
+}
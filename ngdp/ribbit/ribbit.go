@@ -0,0 +1,220 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ribbit implements a client for Ribbit, the TCP protocol which Blizzard uses to publish version and CDN information.
+package ribbit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/configtable"
+)
+
+// Error constants
+var (
+	ErrNoChecksum = fmt.Errorf("ribbit: response has no checksum")
+	ErrNoData     = fmt.Errorf("ribbit: response has no data")
+)
+
+const (
+	// DefaultPort is the port which Ribbit servers listen on.
+	DefaultPort = 1119
+)
+
+// A Protocol is a version of the Ribbit protocol.
+type Protocol int
+
+const (
+	// V1 responses are signed MIME messages, with a trailing checksum.
+	V1 Protocol = 1
+
+	// V2 responses are the bare data, with neither signature nor checksum.
+	V2 Protocol = 2
+)
+
+func (p Protocol) String() string {
+	return fmt.Sprintf("v%d", int(p))
+}
+
+// A SummaryEntry describes the latest sequence number for one kind of data for a given program.
+type SummaryEntry struct {
+	Product ngdp.ProgramCode
+	Seqn    int
+
+	// Flags is empty for versions, and names the kind of data otherwise (e.g. "cdn" or "bgdl").
+	Flags string
+}
+
+// A Client talks to a Ribbit server.
+type Client struct {
+	// Host is the address of the Ribbit server. If empty, the server for Region is used.
+	Host string
+
+	// Region is used to pick a Ribbit server if Host is empty. Defaults to ngdp.RegionUnitedStates.
+	Region ngdp.Region
+
+	// Dialer is used to connect to the server. If nil, a zero net.Dialer is used.
+	Dialer *net.Dialer
+
+	// Protocol is the version of the protocol used by the typed methods, such as Versions. Defaults to V1.
+	Protocol Protocol
+}
+
+// command returns the command for path in the client's protocol.
+func (c *Client) command(path string) string {
+	p := c.Protocol
+	if p == 0 {
+		p = V1
+	}
+	return p.String() + "/" + path
+}
+
+func (c *Client) addr() string {
+	if c.Host != "" {
+		return c.Host
+	}
+	region := c.Region
+	if region == "" {
+		region = ngdp.RegionUnitedStates
+	}
+	if region == ngdp.RegionChina {
+		return fmt.Sprintf("cn.version.battlenet.com.cn:%d", DefaultPort)
+	}
+	return fmt.Sprintf("%s.version.battle.net:%d", region, DefaultPort)
+}
+
+// Do sends a raw command (e.g. "v1/summary") to the server and returns the parsed response.
+// Responses to v2 commands have no subject or signature; their Data is the whole response.
+func (c *Client) Do(ctx context.Context, command string) (*Message, error) {
+	d := c.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+
+	conn, err := d.DialContext(ctx, "tcp", c.addr())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Unblock the read below if the context ends before the server hangs up on us.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if _, err := io.WriteString(conn, command+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	// The server closes the connection once it has sent the whole response.
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	if strings.HasPrefix(command, V2.String()+"/") {
+		return &Message{Data: b}, nil
+	}
+	return parseMessage(b)
+}
+
+// table runs a command which returns a config table, returning a Decoder for it.
+func (c *Client) table(ctx context.Context, command string) (*configtable.Decoder, error) {
+	m, err := c.Do(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	return configtable.NewDecoder(bytes.NewReader(m.Data)), nil
+}
+
+// Summary retrieves the current sequence numbers for every program.
+func (c *Client) Summary(ctx context.Context) ([]SummaryEntry, int, error) {
+	d, err := c.table(ctx, c.command("summary"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var summary []SummaryEntry
+	if err := d.DecodeAll(&summary); err != nil {
+		return nil, 0, err
+	}
+	seqn, err := d.Seqn()
+	if err != nil {
+		return nil, 0, err
+	}
+	return summary, seqn, nil
+}
+
+// Versions retrieves the version information for a program, for every region.
+func (c *Client) Versions(ctx context.Context, program ngdp.ProgramCode) ([]ngdp.VersionInfo, int, error) {
+	return c.versions(ctx, c.command(fmt.Sprintf("products/%s/versions", program)))
+}
+
+// BGDL retrieves the background download version information for a program, for every region.
+func (c *Client) BGDL(ctx context.Context, program ngdp.ProgramCode) ([]ngdp.VersionInfo, int, error) {
+	return c.versions(ctx, c.command(fmt.Sprintf("products/%s/bgdl", program)))
+}
+
+func (c *Client) versions(ctx context.Context, command string) ([]ngdp.VersionInfo, int, error) {
+	d, err := c.table(ctx, command)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var versions []ngdp.VersionInfo
+	if err := d.DecodeAll(&versions); err != nil {
+		return nil, 0, err
+	}
+	seqn, err := d.Seqn()
+	if err != nil {
+		return nil, 0, err
+	}
+	return versions, seqn, nil
+}
+
+// CDNs retrieves the CDN information for a program, for every region.
+func (c *Client) CDNs(ctx context.Context, program ngdp.ProgramCode) ([]ngdp.CDNInfo, int, error) {
+	d, err := c.table(ctx, c.command(fmt.Sprintf("products/%s/cdns", program)))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var cdns []ngdp.CDNInfo
+	if err := d.DecodeAll(&cdns); err != nil {
+		return nil, 0, err
+	}
+	seqn, err := d.Seqn()
+	if err != nil {
+		return nil, 0, err
+	}
+	return cdns, seqn, nil
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ribbit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+const (
+	exampleVersions = `Region!STRING:0|BuildConfig!HEX:16|CDNConfig!HEX:16|KeyRing!HEX:16|BuildId!DEC:4|VersionsName!String:0|ProductConfig!HEX:16
+## seqn = 1234
+us|a423790b9bcee8ac532ceb39fe550685|c8043457fcf9eb6dac433e53fa47f5a0||44247|2.5.0.44247|f03448a5aa6c9f1e9307335946af05b1
+`
+
+	exampleSummary = `Product!STRING:0|Seqn!DEC:4|Flags!STRING:0
+## seqn = 99
+hero|1234|
+hero|1200|cdn
+`
+)
+
+func makeMessage(subject, data string) string {
+	msg := strings.Join([]string{
+		"MIME-Version: 1.0",
+		"Subject: " + subject,
+		`Content-Type: multipart/alternative; boundary="XXX"`,
+		"",
+		"--XXX",
+		"Content-Type: text/plain",
+		"Content-Disposition: " + subject,
+		"",
+		data + "--XXX",
+		"Content-Type: application/cms",
+		`Content-Disposition: attachment; filename="cms.der"`,
+		"Content-Transfer-Encoding: base64",
+		"",
+		"c2lnbmF0dXJl",
+		"--XXX--",
+		"",
+	}, "\r\n")
+	return fmt.Sprintf("%sChecksum: %x\n", msg, sha256.Sum256([]byte(msg)))
+}
+
+func TestParseMessage(t *testing.T) {
+	m, err := parseMessage([]byte(makeMessage("hero/versions", exampleVersions)))
+	if err != nil {
+		t.Fatalf("parseMessage: %v", err)
+	}
+	if m.Subject != "hero/versions" {
+		t.Errorf("m.Subject = %q; want %q", m.Subject, "hero/versions")
+	}
+	if got := string(m.Data); got != strings.TrimSuffix(exampleVersions, "\n") {
+		t.Errorf("m.Data = %q; want %q", got, exampleVersions)
+	}
+	if string(m.Signature) != "c2lnbmF0dXJl" {
+		t.Errorf("m.Signature = %q; want %q", m.Signature, "c2lnbmF0dXJl")
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	good := makeMessage("hero/versions", exampleVersions)
+	for _, test := range []struct {
+		name string
+		msg  string
+	}{
+		{"no checksum", good[:strings.LastIndex(good, "Checksum:")]},
+		{"bad checksum", strings.Replace(good, "44247", "44248", 1)},
+		{"not a message", fmt.Sprintf("blah\nChecksum: %x\n", sha256.Sum256([]byte("blah\n")))},
+	} {
+		if _, err := parseMessage([]byte(test.msg)); err == nil {
+			t.Errorf("%s: parseMessage: %v; want error", test.name, err)
+		}
+	}
+}
+
+func serve(t *testing.T, responses map[string]string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				cmd, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				fmt.Fprint(conn, responses[strings.TrimSpace(cmd)])
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestClient(t *testing.T) {
+	c := &Client{
+		Host: serve(t, map[string]string{
+			"v1/summary":                makeMessage("summary", exampleSummary),
+			"v1/products/hero/versions": makeMessage("hero/versions", exampleVersions),
+		}),
+	}
+	ctx := context.Background()
+
+	summary, seqn, err := c.Summary(ctx)
+	if err != nil {
+		t.Fatalf("Summary: %v", err)
+	}
+	wantSummary := []SummaryEntry{
+		{"hero", 1234, ""},
+		{"hero", 1200, "cdn"},
+	}
+	if !reflect.DeepEqual(summary, wantSummary) || seqn != 99 {
+		t.Errorf("Summary = %v, %d; want %v, %d", summary, seqn, wantSummary, 99)
+	}
+
+	versions, seqn, err := c.Versions(ctx, ngdp.ProgramHotS)
+	if err != nil {
+		t.Fatalf("Versions: %v", err)
+	}
+	if len(versions) != 1 || seqn != 1234 {
+		t.Fatalf("Versions = %v, %d; want 1 version, seqn 1234", versions, seqn)
+	}
+	if versions[0].Region != ngdp.RegionUnitedStates || versions[0].BuildID != 44247 {
+		t.Errorf("Versions[0] = %v; want us/44247", versions[0])
+	}
+}
+
+func TestClientV2(t *testing.T) {
+	const exampleCDNs = `Name!STRING:0|Path!STRING:0|Hosts!STRING:0|Servers!STRING:0|ConfigPath!STRING:0
+## seqn = 1200
+us|tpr/Hero-Live-a|blzddist1-a.akamaihd.net level3.blizzard.com||tpr/configs/data
+`
+	c := &Client{
+		Host: serve(t, map[string]string{
+			"v2/products/hero/versions": exampleVersions,
+			"v2/products/hero/cdns":     exampleCDNs,
+		}),
+		Protocol: V2,
+	}
+	ctx := context.Background()
+
+	versions, seqn, err := c.Versions(ctx, ngdp.ProgramHotS)
+	if err != nil {
+		t.Fatalf("Versions: %v", err)
+	}
+	if len(versions) != 1 || seqn != 1234 || versions[0].BuildID != 44247 {
+		t.Errorf("Versions = %v, %d; want us/44247, seqn 1234", versions, seqn)
+	}
+
+	cdns, seqn, err := c.CDNs(ctx, ngdp.ProgramHotS)
+	if err != nil {
+		t.Fatalf("CDNs: %v", err)
+	}
+	if len(cdns) != 1 || seqn != 1200 {
+		t.Fatalf("CDNs = %v, %d; want 1 CDN, seqn 1200", cdns, seqn)
+	}
+	if want := []string{"blzddist1-a.akamaihd.net", "level3.blizzard.com"}; !reflect.DeepEqual(cdns[0].Hosts, want) {
+		t.Errorf("CDNs[0].Hosts = %v; want %v", cdns[0].Hosts, want)
+	}
+
+	m, err := c.Do(ctx, "v2/products/hero/cdns")
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if string(m.Data) != exampleCDNs || m.Signature != nil {
+		t.Errorf("Do = %q, signature %q; want the bare response", m.Data, m.Signature)
+	}
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package root parses World of Warcraft's root file, which lists every file of a build by FileDataID and, for most files, by a hash of its path.
+//
+// Three layouts are understood: the original headerless one, the "MFST" one introduced in 8.2, and its successor from 10.1.7, whose header carries a size and version.
+package root
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/jenkins"
+)
+
+const (
+	// magic starts root files with a header; it reads "TSFM" on disk.
+	magic = 0x4d465354
+
+	// versionedHeaderSize is the size of the header of 10.1.7 and later root files, which starts by giving its own size.
+	versionedHeaderSize = 24
+)
+
+// LocaleFlags say which locales a file is for.
+type LocaleFlags uint32
+
+// The locales a file can be for.
+const (
+	LocaleEnUS LocaleFlags = 0x2
+	LocaleKoKR LocaleFlags = 0x4
+	LocaleFrFR LocaleFlags = 0x10
+	LocaleDeDE LocaleFlags = 0x20
+	LocaleZhCN LocaleFlags = 0x40
+	LocaleEsES LocaleFlags = 0x80
+	LocaleZhTW LocaleFlags = 0x100
+	LocaleEnGB LocaleFlags = 0x200
+	LocaleEnCN LocaleFlags = 0x400
+	LocaleEnTW LocaleFlags = 0x800
+	LocaleEsMX LocaleFlags = 0x1000
+	LocaleRuRU LocaleFlags = 0x2000
+	LocalePtBR LocaleFlags = 0x4000
+	LocaleItIT LocaleFlags = 0x8000
+	LocalePtPT LocaleFlags = 0x10000
+
+	LocaleAll LocaleFlags = 0xffffffff
+)
+
+// ContentFlags describe how a file is used.
+type ContentFlags uint32
+
+// The content flags which are understood.
+const (
+	ContentLoadOnWindows ContentFlags = 0x8
+	ContentLoadOnMacOS   ContentFlags = 0x10
+	ContentLowViolence   ContentFlags = 0x80
+	ContentDoNotLoad     ContentFlags = 0x100
+	ContentEncrypted     ContentFlags = 0x8000000
+
+	// ContentNoNameHash marks blocks of files which have no name hash, and so can only be found by FileDataID.
+	ContentNoNameHash ContentFlags = 0x10000000
+)
+
+// A File is a single entry of the root file. The same file often has several entries, for different locales or platforms.
+type File struct {
+	FileDataID  uint32
+	ContentHash ngdp.ContentHash
+
+	// NameHash is the hash of the file's path, as calculated by NameHash. It is only meaningful if HasName is set.
+	NameHash uint64
+	HasName  bool
+
+	Locale  LocaleFlags
+	Content ContentFlags
+}
+
+// A Root is a parsed root file.
+type Root struct {
+	Files []File
+
+	byID   map[uint32][]int
+	byName map[uint64][]int
+}
+
+// NameHash returns the hash root files use to refer to path: Jenkins' hashlittle2 of the path in upper case, with backslashes for slashes.
+func NameHash(path string) uint64 {
+	path = strings.ToUpper(strings.Replace(path, "/", "\\", -1))
+	pc, pb := jenkins.HashLittle2([]byte(path), 0, 0)
+	return uint64(pc)<<32 | uint64(pb)
+}
+
+// ByFileDataID returns every entry for the file with the given FileDataID.
+func (r *Root) ByFileDataID(id uint32) []File {
+	return r.files(r.byID[id])
+}
+
+// ByName returns every entry for the file at path.
+func (r *Root) ByName(path string) []File {
+	return r.files(r.byName[NameHash(path)])
+}
+
+func (r *Root) files(idx []int) []File {
+	if len(idx) == 0 {
+		return nil
+	}
+	out := make([]File, len(idx))
+	for n, i := range idx {
+		out[n] = r.Files[i]
+	}
+	return out
+}
+
+// A Mapper is a FilenameMapper which picks, for each path, the first entry in Root for its Locale whose content flags include none of Exclude.
+type Mapper struct {
+	Root *Root
+
+	// Locale selects the locale to map paths for. If zero, files for any locale are used.
+	Locale LocaleFlags
+
+	// Exclude skips entries with any of these content flags, such as ContentLowViolence.
+	Exclude ContentFlags
+}
+
+// ToContentHash returns the content hash of the file at fn.
+func (m Mapper) ToContentHash(fn string) (ngdp.ContentHash, bool) {
+	for _, i := range m.Root.byName[NameHash(fn)] {
+		f := m.Root.Files[i]
+		if (m.Locale == 0 || f.Locale&m.Locale != 0) && f.Content&m.Exclude == 0 {
+			return f.ContentHash, true
+		}
+	}
+	return ngdp.ContentHash{}, false
+}
+
+// reader reads little-endian values from a root file, remembering the first error.
+type reader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if n < 0 || len(r.b)-r.off < n {
+		r.err = fmt.Errorf("root: truncated at offset %d", r.off)
+		return make([]byte, n)
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *reader) uint32() uint32 { return binary.LittleEndian.Uint32(r.next(4)) }
+
+// Parse parses a root file, which should not be BLTE-encoded.
+func Parse(rd io.Reader) (*Root, error) {
+	b, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	r := &reader{b: b}
+	root := &Root{
+		byID:   make(map[uint32][]int),
+		byName: make(map[uint64][]int),
+	}
+
+	// Headerless root files interleave content and name hashes; later ones keep them in separate arrays.
+	headered, version := false, 0
+	allNamed := true
+	if len(b) >= 4 && binary.LittleEndian.Uint32(b) == magic {
+		headered = true
+		r.next(4)
+		if len(b) >= versionedHeaderSize && binary.LittleEndian.Uint32(b[4:]) == versionedHeaderSize {
+			r.next(4)
+			version = int(r.uint32())
+			if version != 1 && version != 2 {
+				return nil, fmt.Errorf("root: unsupported version %d", version)
+			}
+		}
+		total, named := r.uint32(), r.uint32()
+		allNamed = total == named
+		if version > 0 {
+			r.next(4) // padding
+		}
+	}
+
+	for r.err == nil && r.off < len(b) {
+		count := int(r.uint32())
+		var content ContentFlags
+		var locale LocaleFlags
+		if version == 2 {
+			locale = LocaleFlags(r.uint32())
+			unk1, unk2 := r.uint32(), r.uint32()
+			unk3 := r.next(1)[0]
+			content = ContentFlags(unk1 | unk2 | uint32(unk3)<<17)
+		} else {
+			content = ContentFlags(r.uint32())
+			locale = LocaleFlags(r.uint32())
+		}
+		if count < 0 || count > (len(b)-r.off)/4 {
+			return nil, fmt.Errorf("root: block of %d files at offset %d is too long", count, r.off)
+		}
+
+		start := len(root.Files)
+		id := uint32(0)
+		for n := 0; n < count; n++ {
+			delta := r.uint32()
+			if n == 0 {
+				id = delta
+			} else {
+				id += 1 + delta
+			}
+			root.Files = append(root.Files, File{FileDataID: id, Locale: locale, Content: content})
+		}
+		files := root.Files[start:]
+
+		hasNames := !headered || allNamed || content&ContentNoNameHash == 0
+		if !headered {
+			for n := range files {
+				copy(files[n].ContentHash[:], r.next(len(ngdp.ContentHash{})))
+				files[n].NameHash = binary.LittleEndian.Uint64(r.next(8))
+				files[n].HasName = true
+			}
+		} else {
+			for n := range files {
+				copy(files[n].ContentHash[:], r.next(len(ngdp.ContentHash{})))
+			}
+			if hasNames {
+				for n := range files {
+					files[n].NameHash = binary.LittleEndian.Uint64(r.next(8))
+					files[n].HasName = true
+				}
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	for i, f := range root.Files {
+		root.byID[f.FileDataID] = append(root.byID[f.FileDataID], i)
+		if f.HasName {
+			root.byName[f.NameHash] = append(root.byName[f.NameHash], i)
+		}
+	}
+	return root, nil
+}
+
+// Load retrieves the root file with the given content hash and parses it.
+func Load(ctx context.Context, f client.Fetcher, h ngdp.ContentHash) (*Root, error) {
+	resp, err := f.Fetch(ctx, h)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching root file")
+	}
+	defer resp.Body.Close()
+
+	root, err := Parse(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing root file")
+	}
+	return root, nil
+}
+
+// Decorate adds a FilenameMapper for locale to the provided client, skipping low-violence variants of files.
+//
+// It will automatically download and parse the root file.
+func Decorate(ctx context.Context, c *client.Client, locale LocaleFlags) error {
+	root, err := Load(ctx, c, c.BuildConfig.Root)
+	if err != nil {
+		return err
+	}
+
+	c.FilenameMapper = Mapper{Root: root, Locale: locale, Exclude: ContentLowViolence}
+	return nil
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package root
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/jenkins"
+)
+
+// A block is a group of files sharing flags, as laid out in a root file.
+type block struct {
+	locale  LocaleFlags
+	content ContentFlags
+	files   []File
+}
+
+// makeRoot lays out blocks as a root file with the given header version: -1 for headerless, 0 for the 8.2 header, and 1 or 2 for the versioned header.
+func makeRoot(version int, blocks []block) []byte {
+	var buf bytes.Buffer
+	w := func(v interface{}) { binary.Write(&buf, binary.LittleEndian, v) }
+
+	total, named := 0, 0
+	for _, b := range blocks {
+		total += len(b.files)
+		if b.content&ContentNoNameHash == 0 {
+			named += len(b.files)
+		}
+	}
+	switch {
+	case version == 0:
+		w(uint32(magic))
+		w(uint32(total))
+		w(uint32(named))
+	case version > 0:
+		w(uint32(magic))
+		w(uint32(versionedHeaderSize))
+		w(uint32(version))
+		w(uint32(total))
+		w(uint32(named))
+		w(uint32(0))
+	}
+
+	for _, b := range blocks {
+		w(uint32(len(b.files)))
+		if version == 2 {
+			w(uint32(b.locale))
+			w(uint32(b.content))
+			w(uint32(0))
+			w(uint8(0))
+		} else {
+			w(uint32(b.content))
+			w(uint32(b.locale))
+		}
+		prev := uint32(0)
+		for n, f := range b.files {
+			if n == 0 {
+				w(f.FileDataID)
+			} else {
+				w(f.FileDataID - prev - 1)
+			}
+			prev = f.FileDataID
+		}
+		if version < 0 {
+			for _, f := range b.files {
+				buf.Write(f.ContentHash[:])
+				w(f.NameHash)
+			}
+			continue
+		}
+		for _, f := range b.files {
+			buf.Write(f.ContentHash[:])
+		}
+		if b.content&ContentNoNameHash == 0 {
+			for _, f := range b.files {
+				w(f.NameHash)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestNameHash(t *testing.T) {
+	pc, pb := jenkins.HashLittle2([]byte(`INTERFACE\ICONS\INV_MISC_QUESTIONMARK.BLP`), 0, 0)
+	want := uint64(pc)<<32 | uint64(pb)
+	for _, path := range []string{`Interface\Icons\INV_Misc_QuestionMark.blp`, "interface/icons/inv_misc_questionmark.blp"} {
+		if got := NameHash(path); got != want {
+			t.Errorf("NameHash(%q) = %016x; want %016x", path, got, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	named := func(id uint32, path string, h byte, locale LocaleFlags, content ContentFlags) File {
+		return File{FileDataID: id, ContentHash: ngdp.ContentHash{h}, NameHash: NameHash(path), HasName: true, Locale: locale, Content: content}
+	}
+	blocks := []block{
+		{LocaleAll, ContentLoadOnWindows, []File{
+			named(100, "world/a.m2", 1, LocaleAll, ContentLoadOnWindows),
+			named(101, "world/b.m2", 2, LocaleAll, ContentLoadOnWindows),
+			named(200, "world/c.m2", 3, LocaleAll, ContentLoadOnWindows),
+		}},
+		{LocaleDeDE, ContentLoadOnWindows, []File{
+			named(300, "sound/hello.ogg", 4, LocaleDeDE, ContentLoadOnWindows),
+		}},
+		{LocaleEnUS, ContentLoadOnWindows, []File{
+			named(300, "sound/hello.ogg", 5, LocaleEnUS, ContentLoadOnWindows),
+		}},
+	}
+	unnamed := block{LocaleAll, ContentNoNameHash, []File{
+		{FileDataID: 400, ContentHash: ngdp.ContentHash{6}, Locale: LocaleAll, Content: ContentNoNameHash},
+	}}
+
+	for _, version := range []int{-1, 0, 1, 2} {
+		bs := blocks
+		if version >= 0 {
+			bs = append(append([]block(nil), blocks...), unnamed)
+		}
+		root, err := Parse(bytes.NewReader(makeRoot(version, bs)))
+		if err != nil {
+			t.Fatalf("version %d: Parse: %v", version, err)
+		}
+
+		var want []File
+		for _, b := range bs {
+			want = append(want, b.files...)
+		}
+		if !reflect.DeepEqual(root.Files, want) {
+			t.Errorf("version %d: Files = %+v; want %+v", version, root.Files, want)
+		}
+
+		if got := root.ByFileDataID(300); len(got) != 2 {
+			t.Errorf("version %d: ByFileDataID(300) = %+v; want 2 entries", version, got)
+		}
+		if got := root.ByName("World/C.m2"); len(got) != 1 || got[0].FileDataID != 200 {
+			t.Errorf("version %d: ByName(World/C.m2) = %+v; want FileDataID 200", version, got)
+		}
+
+		m := Mapper{Root: root, Locale: LocaleEnUS}
+		if h, ok := m.ToContentHash("sound/hello.ogg"); !ok || h != (ngdp.ContentHash{5}) {
+			t.Errorf("version %d: enUS ToContentHash(sound/hello.ogg) = %x, %v; want 05..., true", version, h, ok)
+		}
+		if _, ok := m.ToContentHash("sound/missing.ogg"); ok {
+			t.Errorf("version %d: ToContentHash of a missing file succeeded", version)
+		}
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	b := makeRoot(0, []block{{LocaleAll, 0, []File{{FileDataID: 1, NameHash: 2}}}})
+	for n := 13; n < len(b); n++ {
+		if _, err := Parse(bytes.NewReader(b[:n])); err == nil {
+			t.Errorf("Parse of the first %d of %d bytes succeeded; want error", n, len(b))
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package size parses size manifests, which list the encoded size of every file in a build.
+//
+// Newer build configs refer to a size manifest, which allows the size of an installation to be estimated without consulting the encoding table or the CDN.
+package size
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/install"
+)
+
+var (
+	// ErrBadMagic means that the manifest doesn't start with "DS".
+	ErrBadMagic = errors.New("size: bad magic")
+
+	// ErrUnknownTag means that a tag passed to Filter isn't in the manifest. Size manifests share their tags with install manifests.
+	ErrUnknownTag = install.ErrUnknownTag
+)
+
+// An Entry records the encoded size of a single file.
+type Entry struct {
+	// Key is a prefix of the file's CDN hash; its length is given by the manifest's KeySize.
+	Key  []byte
+	Size uint64
+}
+
+// A Manifest is a parsed size manifest.
+type Manifest struct {
+	Version   uint8
+	KeySize   int
+	TotalSize uint64
+
+	Tags    []install.Tag
+	Entries []Entry
+
+	byKey map[string]int
+}
+
+// Parse parses a decoded size manifest.
+func Parse(rd io.Reader) (*Manifest, error) {
+	r := bufio.NewReader(rd)
+
+	var hdr struct {
+		Magic      [2]byte
+		Version    uint8
+		KeySize    uint8
+		NumEntries uint32
+		NumTags    uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("size: reading header: %v", err)
+	}
+	if string(hdr.Magic[:]) != "DS" {
+		return nil, ErrBadMagic
+	}
+	if hdr.KeySize == 0 || hdr.KeySize > 16 {
+		return nil, fmt.Errorf("size: unsupported key size %d", hdr.KeySize)
+	}
+
+	m := &Manifest{
+		Version: hdr.Version,
+		KeySize: int(hdr.KeySize),
+		Tags:    make([]install.Tag, hdr.NumTags),
+		Entries: make([]Entry, hdr.NumEntries),
+		byKey:   make(map[string]int, hdr.NumEntries),
+	}
+
+	// Version 1 gives the total size in 8 bytes, followed by the width of each entry's size; version 2 uses 5 bytes and a fixed width of 4.
+	var sizeBytes int
+	switch hdr.Version {
+	case 1:
+		var ext struct {
+			TotalSize uint64
+			SizeBytes uint8
+		}
+		if err := binary.Read(r, binary.BigEndian, &ext); err != nil {
+			return nil, fmt.Errorf("size: reading header: %v", err)
+		}
+		m.TotalSize = ext.TotalSize
+		sizeBytes = int(ext.SizeBytes)
+	case 2:
+		var ext [5]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, fmt.Errorf("size: reading header: %v", err)
+		}
+		m.TotalSize = readUint(ext[:])
+		sizeBytes = 4
+	default:
+		return nil, fmt.Errorf("size: unsupported version %d", hdr.Version)
+	}
+	if sizeBytes == 0 || sizeBytes > 8 {
+		return nil, fmt.Errorf("size: unsupported size width %d", sizeBytes)
+	}
+
+	bitmapLen := (int(hdr.NumEntries) + 7) / 8
+	for n := range m.Tags {
+		name, err := r.ReadString(0)
+		if err != nil {
+			return nil, fmt.Errorf("size: reading tag %d: %v", n, err)
+		}
+		name = name[:len(name)-1]
+		var typ uint16
+		if err := binary.Read(r, binary.BigEndian, &typ); err != nil {
+			return nil, fmt.Errorf("size: reading tag %q: %v", name, err)
+		}
+		bitmap := make([]byte, bitmapLen)
+		if _, err := io.ReadFull(r, bitmap); err != nil {
+			return nil, fmt.Errorf("size: reading tag %q: %v", name, err)
+		}
+		m.Tags[n] = install.NewTag(name, install.TagType(typ), bitmap)
+	}
+
+	buf := make([]byte, m.KeySize+sizeBytes)
+	for n := range m.Entries {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("size: reading entry %d: %v", n, err)
+		}
+		key := append([]byte(nil), buf[:m.KeySize]...)
+		m.Entries[n] = Entry{key, readUint(buf[m.KeySize:])}
+		m.byKey[string(key)] = n
+	}
+	return m, nil
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// Fetch retrieves and parses the size manifest with content hash h, which is usually a build config's Size.
+func Fetch(ctx context.Context, f client.Fetcher, h ngdp.ContentHash) (*Manifest, error) {
+	resp, err := f.Fetch(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return Parse(resp.Body)
+}
+
+// Lookup returns the encoded size of the file with CDN hash h.
+func (m *Manifest) Lookup(h ngdp.CDNHash) (uint64, bool) {
+	n, ok := m.byKey[string(h[:m.KeySize])]
+	if !ok {
+		return 0, false
+	}
+	return m.Entries[n].Size, true
+}
+
+// Tag returns the tag with the given name.
+func (m *Manifest) Tag(name string) (install.Tag, bool) {
+	return install.FindTag(m.Tags, name)
+}
+
+// Filter returns the entries which match the given tags, along with their total size.
+//
+// An entry matches if, for each type of tag given, it carries at least one of the tags of that type.
+func (m *Manifest) Filter(tags ...string) ([]Entry, uint64, error) {
+	match, err := install.MatchTags(m.Tags, tags...)
+	if err != nil {
+		return nil, 0, err
+	}
+	var out []Entry
+	var total uint64
+	for n, e := range m.Entries {
+		if match(n) {
+			out = append(out, e)
+			total += e.Size
+		}
+	}
+	return out, total, nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package size
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/install"
+)
+
+var exampleTags = []install.Tag{
+	install.NewTag("Windows", install.TagTypePlatform, []byte{0xa0}), // 101
+	install.NewTag("OSX", install.TagTypePlatform, []byte{0x60}),     // 011
+}
+
+func makeManifest(version uint8, entries []Entry) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("DS")
+	buf.Write([]byte{version, 9})
+	binary.Write(&buf, binary.BigEndian, uint32(len(entries)))
+	binary.Write(&buf, binary.BigEndian, uint16(len(exampleTags)))
+	var total uint64
+	for _, e := range entries {
+		total += e.Size
+	}
+	sizeBytes := 4
+	switch version {
+	case 1:
+		sizeBytes = 5
+		binary.Write(&buf, binary.BigEndian, total)
+		buf.WriteByte(byte(sizeBytes))
+	case 2:
+		buf.Write([]byte{byte(total >> 32), byte(total >> 24), byte(total >> 16), byte(total >> 8), byte(total)})
+	}
+	for _, t := range exampleTags {
+		buf.WriteString(t.Name)
+		buf.WriteByte(0)
+		binary.Write(&buf, binary.BigEndian, t.Type)
+		buf.Write(t.Bitmap())
+	}
+	for _, e := range entries {
+		buf.Write(e.Key)
+		for n := sizeBytes - 1; n >= 0; n-- {
+			buf.WriteByte(byte(e.Size >> uint(8*n)))
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	h := ngdp.CDNHash{0xab, 0xcd, 0xef, 1, 2, 3, 4, 5, 6, 7, 8}
+	for _, version := range []uint8{1, 2} {
+		entries := []Entry{
+			{[]byte{1, 2, 3, 4, 5, 6, 7, 8, 9}, 100},
+			{h[:9], 200},
+			{[]byte{9, 8, 7, 6, 5, 4, 3, 2, 1}, 300},
+		}
+		if version == 1 {
+			entries[0].Size = 1 << 33
+		}
+		b := makeManifest(version, entries)
+		m, err := Parse(bytes.NewReader(b))
+		if err != nil {
+			t.Errorf("v%d: Parse: %v", version, err)
+			continue
+		}
+		if !reflect.DeepEqual(m.Entries, entries) {
+			t.Errorf("v%d: m.Entries = %v; want %v", version, m.Entries, entries)
+		}
+		if want := entries[0].Size + 500; m.TotalSize != want {
+			t.Errorf("v%d: m.TotalSize = %d; want %d", version, m.TotalSize, want)
+		}
+		if got, ok := m.Lookup(h); !ok || got != 200 {
+			t.Errorf("v%d: m.Lookup(%032x) = %d, %v; want 200, true", version, h, got, ok)
+		}
+		if _, ok := m.Lookup(ngdp.CDNHash{}); ok {
+			t.Errorf("v%d: m.Lookup(0) succeeded; want failure", version)
+		}
+
+		got, total, err := m.Filter("Windows")
+		if err != nil {
+			t.Errorf("v%d: m.Filter: %v", version, err)
+		} else if want := []Entry{entries[0], entries[2]}; !reflect.DeepEqual(got, want) || total != entries[0].Size+300 {
+			t.Errorf("v%d: m.Filter(Windows) = %v, %d; want %v, %d", version, got, total, want, entries[0].Size+300)
+		}
+
+		if _, err := Parse(bytes.NewReader(b[:len(b)-1])); err == nil {
+			t.Errorf("v%d: Parse of truncated manifest: nil error; want error", version)
+		}
+	}
+}
+
+func TestParseBadMagic(t *testing.T) {
+	if _, err := Parse(bytes.NewReader([]byte("DL\x01\x09\x00\x00\x00\x00\x00\x00"))); err != ErrBadMagic {
+		t.Errorf("Parse: %v; want %v", err, ErrBadMagic)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package staticmap reads filename maps from user-supplied manifests.
+//
+// It lets files be browsed and fetched by name for products whose root file can't be parsed, given a list of their files from elsewhere.
+package staticmap
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/mndx"
+)
+
+// A Format is a manifest file format.
+type Format int
+
+const (
+	// CSV manifests have one file per record: its path, its content hash in hex, and optionally its size.
+	// A first record starting with the field "path" is taken to be a header, and skipped.
+	CSV Format = iota
+
+	// JSON manifests are an array of objects, each with a Path, a hex ContentHash and optionally a Size.
+	JSON
+)
+
+// An Entry is a single file listed in a manifest.
+type Entry struct {
+	Path        string
+	ContentHash ngdp.ContentHash
+	Size        uint32
+}
+
+// FormatOf guesses the format of a manifest from its filename.
+func FormatOf(fn string) (Format, error) {
+	switch ext := strings.ToLower(filepath.Ext(fn)); ext {
+	case ".csv":
+		return CSV, nil
+	case ".json":
+		return JSON, nil
+	default:
+		return 0, fmt.Errorf("staticmap: can't tell the format of %q from its extension; want .csv or .json", fn)
+	}
+}
+
+// Read parses a manifest into a FilenameMap.
+func Read(r io.Reader, format Format) (mndx.FilenameMap, error) {
+	var entries []Entry
+	var err error
+	switch format {
+	case CSV:
+		entries, err = readCSV(r)
+	case JSON:
+		err = json.NewDecoder(r).Decode(&entries)
+		if err != nil {
+			err = errors.Wrap(err, "staticmap: parsing JSON manifest")
+		}
+	default:
+		err = fmt.Errorf("staticmap: unknown format %d", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(mndx.FilenameMap, len(entries))
+	for _, e := range entries {
+		p := strings.TrimLeft(strings.Replace(e.Path, "\\", "/", -1), "/")
+		if p == "" {
+			return nil, fmt.Errorf("staticmap: entry for %032x has no path", e.ContentHash)
+		}
+		if _, ok := m[p]; ok {
+			return nil, fmt.Errorf("staticmap: %q is listed more than once", p)
+		}
+		m[p] = &mndx.File{
+			Name:        p,
+			Size:        e.Size,
+			EncodingKey: e.ContentHash,
+		}
+	}
+	return m, nil
+}
+
+func readCSV(r io.Reader) ([]Entry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var entries []Entry
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "staticmap: parsing CSV manifest")
+		}
+		if line == 1 && strings.EqualFold(rec[0], "path") {
+			continue
+		}
+		if len(rec) < 2 || len(rec) > 3 {
+			return nil, fmt.Errorf("staticmap: line %d: want path, content hash and optional size", line)
+		}
+
+		e := Entry{Path: rec[0]}
+		if err := e.ContentHash.UnmarshalText([]byte(rec[1])); err != nil {
+			return nil, fmt.Errorf("staticmap: line %d: %v", line, err)
+		}
+		if len(rec) == 3 && rec[2] != "" {
+			size, err := strconv.ParseUint(rec[2], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("staticmap: line %d: bad size %q", line, rec[2])
+			}
+			e.Size = uint32(size)
+		}
+		entries = append(entries, e)
+	}
+}
+
+// Load reads the manifest in fn, whose format is guessed from its extension, and arranges its files into a tree.
+//
+// The tree can be used as a client's FilenameMapper, and listed like one built from an MNDX root file.
+func Load(fn string) (*mndx.TreeDirectory, error) {
+	format, err := FormatOf(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := Read(f, format)
+	if err != nil {
+		return nil, errors.Wrap(err, fn)
+	}
+	tree, err := mndx.ToTree(m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s: converting to tree", fn)
+	}
+	return tree, nil
+}
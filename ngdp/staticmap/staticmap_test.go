@@ -0,0 +1,138 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package staticmap
+
+import (
+	"crypto/md5"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/mndx"
+)
+
+const (
+	hashA = "0123456789abcdef0123456789abcdef"
+	hashB = "fedcba9876543210fedcba9876543210"
+)
+
+func mustHash(t *testing.T, s string) ngdp.ContentHash {
+	var h ngdp.ContentHash
+	if err := h.UnmarshalText([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestRead(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		format Format
+		in     string
+	}{
+		{"CSV", CSV, "path,content_hash,size\nBase/a.txt," + hashA + ",10\n\\Base\\Sub\\B.dat, " + hashB + "\n"},
+		{"JSON", JSON, `[{"Path": "Base/a.txt", "ContentHash": "` + hashA + `", "Size": 10}, {"path": "/Base/Sub/B.dat", "contenthash": "` + hashB + `"}]`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := Read(strings.NewReader(test.in), test.format)
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if len(m) != 2 {
+				t.Errorf("Read returned %d files; want 2", len(m))
+			}
+			if f := m["Base/a.txt"]; f == nil || f.Size != 10 || f.EncodingKey != mustHash(t, hashA) {
+				t.Errorf("Base/a.txt = %+v; want size 10 and hash %s", f, hashA)
+			}
+			if h, ok := m.ToContentHash("Base/Sub/B.dat"); !ok || h != mustHash(t, hashB) {
+				t.Errorf("ToContentHash(Base/Sub/B.dat) = %032x, %v; want %s", h, ok, hashB)
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		format Format
+		in     string
+	}{
+		{"short hash", CSV, "a.txt,0123\n"},
+		{"bad size", CSV, "a.txt," + hashA + ",big\n"},
+		{"too many fields", CSV, "a.txt," + hashA + ",1,2\n"},
+		{"duplicate", CSV, "a.txt," + hashA + "\n/a.txt," + hashB + "\n"},
+		{"no path", JSON, `[{"ContentHash": "` + hashA + `"}]`},
+		{"bad JSON", JSON, `{`},
+	} {
+		if _, err := Read(strings.NewReader(test.in), test.format); err == nil {
+			t.Errorf("%s: Read succeeded; want error", test.name)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "files.CSV")
+	if err := os.WriteFile(fn, []byte("Base/a.txt,"+hashA+",10\nBase/Sub/B.dat,"+hashB+",20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := Load(fn)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if h, ok := tree.ToContentHash("base/sub/b.dat"); !ok || h != mustHash(t, hashB) {
+		t.Errorf("ToContentHash(base/sub/b.dat) = %032x, %v; want %s", h, ok, hashB)
+	}
+	var paths []string
+	for p := range tree.Files() {
+		paths = append(paths, p)
+	}
+	if got, want := strings.Join(paths, " "), "Base/a.txt Base/Sub/B.dat"; got != want {
+		t.Errorf("tree has files %q; want %q", got, want)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "files.txt")); err == nil {
+		t.Error("Load of a .txt file succeeded; want error")
+	}
+}
+
+func BenchmarkLoadTree(b *testing.B) {
+	for _, n := range []int{10000, 100000} {
+		var buf strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&buf, "Dir%d/Sub%d/file%d.dat,%032x,%d\n", i%50, i%1000, i, md5.Sum([]byte{byte(i), byte(i >> 8), byte(i >> 16)}), i)
+		}
+		manifest := buf.String()
+
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			b.SetBytes(int64(len(manifest)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m, err := Read(strings.NewReader(manifest), CSV)
+				if err != nil {
+					b.Fatalf("Read: %v", err)
+				}
+				if _, err := mndx.ToTree(m); err != nil {
+					b.Fatalf("ToTree: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tactkeys
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// keyConfigPrefix starts the name of every entry in a keyring config.
+const keyConfigPrefix = "key-"
+
+// LoadConfig reads a keyring config, as referenced by a version's KeyRing, into the keyring.
+//
+// A keyring config is a key-value config whose entries are named "key-" followed by a key name, with the key as their value.
+// Entries with any other name are ignored.
+func (k *Keyring) LoadConfig(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	lineNo := 0
+	for s.Scan() {
+		lineNo++
+		ln := strings.TrimSpace(s.Text())
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+
+		bits := strings.SplitN(ln, "=", 2)
+		if len(bits) != 2 {
+			return fmt.Errorf("tactkeys: line %d: want name = value", lineNo)
+		}
+		entry, value := strings.TrimSpace(bits[0]), strings.TrimSpace(bits[1])
+		if !strings.HasPrefix(strings.ToLower(entry), keyConfigPrefix) {
+			continue
+		}
+
+		name, err := ParseKeyName(entry[len(keyConfigPrefix):])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		key, err := ParseKey(value)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		k.Add(name, key)
+	}
+	return s.Err()
+}
+
+// ReadConfig parses a keyring config into a new Keyring.
+func ReadConfig(r io.Reader) (*Keyring, error) {
+	k := New()
+	if err := k.LoadConfig(r); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tactkeys
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// DefaultKeyListURL is the community-maintained list of known World of Warcraft keys.
+const DefaultKeyListURL = "https://raw.githubusercontent.com/wowdev/TACTKeys/master/WoW.txt"
+
+// A Source describes a remote key list.
+type Source struct {
+	// URL is where the key list is downloaded from.
+	URL string
+
+	// SHA256, if set, pins the key list to a known version: a list whose hex-encoded SHA-256 doesn't match is rejected.
+	SHA256 string
+
+	// CachePath, if set, is where a copy of the key list is kept between runs.
+	CachePath string
+
+	// MaxAge is how old the cached copy can be before it is downloaded again. If zero, the cached copy is always used if present.
+	MaxAge time.Duration
+
+	// Client is used to download the key list. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (src Source) checkPin(b []byte) error {
+	if src.SHA256 == "" {
+		return nil
+	}
+	sum := sha256.Sum256(b)
+	if got := hex.EncodeToString(sum[:]); got != src.SHA256 {
+		return fmt.Errorf("tactkeys: key list has SHA-256 %s; want %s", got, src.SHA256)
+	}
+	return nil
+}
+
+// readCache returns the cached copy of the key list, and whether it is still fresh.
+func (src Source) readCache() ([]byte, bool, error) {
+	if src.CachePath == "" {
+		return nil, false, os.ErrNotExist
+	}
+	fi, err := os.Stat(src.CachePath)
+	if err != nil {
+		return nil, false, err
+	}
+	b, err := ioutil.ReadFile(src.CachePath)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := src.checkPin(b); err != nil {
+		return nil, false, err
+	}
+	fresh := src.MaxAge == 0 || time.Since(fi.ModTime()) < src.MaxAge
+	return b, fresh, nil
+}
+
+func (src Source) writeCache(b []byte) error {
+	if src.CachePath == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(src.CachePath), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(src.CachePath), ".tactkeys-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), src.CachePath)
+}
+
+func (src Source) download(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, src.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := src.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tactkeys: server status was %q; wanted 200 OK", resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := src.checkPin(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Fetch retrieves a remote key list.
+//
+// A fresh cached copy is used in preference to downloading the list again; a stale one is used if the download fails.
+func Fetch(ctx context.Context, src Source) (*Keyring, error) {
+	cached, fresh, cacheErr := src.readCache()
+	if cacheErr == nil && fresh {
+		return Read(bytes.NewReader(cached))
+	}
+
+	b, err := src.download(ctx)
+	if err != nil {
+		if cacheErr != nil {
+			return nil, errors.Wrapf(err, "fetching key list from %s", src.URL)
+		}
+		glog.Warningf("Fetching key list from %s failed, using stale cached copy: %v", src.URL, err)
+		return Read(bytes.NewReader(cached))
+	}
+
+	k, err := Read(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing key list from %s", src.URL)
+	}
+	if err := src.writeCache(b); err != nil {
+		glog.Warningf("Caching key list from %s failed: %v", src.URL, err)
+	}
+	return k, nil
+}
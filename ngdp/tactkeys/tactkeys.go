@@ -0,0 +1,175 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tactkeys manages the keys used to decrypt encrypted TACT content.
+package tactkeys
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A KeyName identifies a key. Encrypted BLTE chunks record the name of the key needed to decrypt them.
+//
+// Key names are conventionally written as 16 hex digits, most significant first.
+type KeyName uint64
+
+// String formats the name as it is usually written in key lists.
+func (n KeyName) String() string {
+	return fmt.Sprintf("%016X", uint64(n))
+}
+
+// ParseKeyName parses a key name written as 16 hex digits.
+func ParseKeyName(s string) (KeyName, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("tactkeys: key name %q should be 16 hex digits", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("tactkeys: parsing key name %q: %v", s, err)
+	}
+	return KeyName(v), nil
+}
+
+// A Key is a 128-bit encryption key.
+type Key [16]byte
+
+// ParseKey parses a key written as 32 hex digits.
+func ParseKey(s string) (Key, error) {
+	var k Key
+	if hex.DecodedLen(len(s)) != len(k) {
+		return k, fmt.Errorf("tactkeys: key %q should be 32 hex digits", s)
+	}
+	if _, err := hex.Decode(k[:], []byte(s)); err != nil {
+		return k, fmt.Errorf("tactkeys: parsing key %q: %v", s, err)
+	}
+	return k, nil
+}
+
+// A Keyring holds a set of keys, looked up by name.
+//
+// It is safe for concurrent use.
+type Keyring struct {
+	mu   sync.RWMutex
+	keys map[KeyName]Key
+}
+
+// New returns an empty Keyring.
+func New() *Keyring {
+	return &Keyring{
+		keys: make(map[KeyName]Key),
+	}
+}
+
+// Add adds a key to the keyring, replacing any existing key with the same name.
+func (k *Keyring) Add(name KeyName, key Key) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[name] = key
+}
+
+// Key looks up a key by name.
+func (k *Keyring) Key(name KeyName) (Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[name]
+	return key, ok
+}
+
+// BLTEKey looks up a key by name, so that a Keyring can be used as a blte.KeyProvider.
+func (k *Keyring) BLTEKey(name uint64) ([16]byte, bool) {
+	key, ok := k.Key(KeyName(name))
+	return key, ok
+}
+
+// Len returns the number of keys in the keyring.
+func (k *Keyring) Len() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.keys)
+}
+
+// Names returns the names of every key in the keyring, in no particular order.
+func (k *Keyring) Names() []KeyName {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	names := make([]KeyName, 0, len(k.keys))
+	for n := range k.keys {
+		names = append(names, n)
+	}
+	return names
+}
+
+// Merge adds every key in o to the keyring.
+func (k *Keyring) Merge(o *Keyring) {
+	if k == o {
+		return
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for n, key := range o.keys {
+		k.keys[n] = key
+	}
+}
+
+// Load reads a key list into the keyring.
+//
+// Each line of a key list holds a key name followed by a key, both in hex, separated by whitespace, commas or semicolons.
+// Anything after the key is ignored, as are blank lines and lines starting with # or //.
+func (k *Keyring) Load(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	lineNo := 0
+	for s.Scan() {
+		lineNo++
+		ln := strings.TrimSpace(s.Text())
+		if ln == "" || strings.HasPrefix(ln, "#") || strings.HasPrefix(ln, "//") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(ln, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ',' || r == ';'
+		})
+		if len(fields) < 2 {
+			return fmt.Errorf("tactkeys: line %d: want a key name and a key", lineNo)
+		}
+		name, err := ParseKeyName(fields[0])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		key, err := ParseKey(fields[1])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		k.Add(name, key)
+	}
+	return s.Err()
+}
+
+// Read parses a key list into a new Keyring.
+func Read(r io.Reader) (*Keyring, error) {
+	k := New()
+	if err := k.Load(r); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
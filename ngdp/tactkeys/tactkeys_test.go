@@ -0,0 +1,126 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tactkeys
+
+import (
+	"strings"
+	"testing"
+)
+
+const exampleKeyList = `# A comment
+FA505078126ACB3E BDC51862ABED79B2DE48C8E7E66C6200 some description
+
+ff813f7d062ac0bc;aa0b5c77f088ccc2d39049bd267f066d
+// another comment
+D1E9B5EDF9283668	8E4A2579894E38B4AB9058BA5C7328EE
+`
+
+func TestLoad(t *testing.T) {
+	k, err := Read(strings.NewReader(exampleKeyList))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if got := k.Len(); got != 3 {
+		t.Errorf("k.Len() = %d; want 3", got)
+	}
+
+	for _, test := range []struct {
+		name KeyName
+		want string
+	}{
+		{0xFA505078126ACB3E, "bdc51862abed79b2de48c8e7e66c6200"},
+		{0xFF813F7D062AC0BC, "aa0b5c77f088ccc2d39049bd267f066d"},
+		{0xD1E9B5EDF9283668, "8e4a2579894e38b4ab9058ba5c7328ee"},
+	} {
+		want, err := ParseKey(test.want)
+		if err != nil {
+			t.Fatalf("ParseKey(%q): %v", test.want, err)
+		}
+		got, ok := k.Key(test.name)
+		if !ok {
+			t.Errorf("k.Key(%v): not found", test.name)
+			continue
+		}
+		if got != want {
+			t.Errorf("k.Key(%v) = %x; want %x", test.name, got, want)
+		}
+	}
+
+	if _, ok := k.Key(0x1234); ok {
+		t.Errorf("k.Key(0x1234): found; want not found")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, in := range []string{
+		"FA505078126ACB3E\n",
+		"FA505078126ACB3 BDC51862ABED79B2DE48C8E7E66C6200\n",
+		"FA505078126ACB3E BDC51862ABED79B2DE48C8E7E66C62\n",
+		"FA505078126ACB3X BDC51862ABED79B2DE48C8E7E66C6200\n",
+	} {
+		if _, err := Read(strings.NewReader(in)); err == nil {
+			t.Errorf("Read(%q): nil error; want error", in)
+		}
+	}
+}
+
+func TestKeyNameString(t *testing.T) {
+	if got, want := KeyName(0xFA505078126ACB3E).String(), "FA505078126ACB3E"; got != want {
+		t.Errorf("String() = %q; want %q", got, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b := New(), New()
+	a.Add(1, Key{1})
+	b.Add(2, Key{2})
+	b.Add(1, Key{3})
+	a.Merge(b)
+	if got, _ := a.Key(1); got != (Key{3}) {
+		t.Errorf("a.Key(1) = %x; want %x", got, Key{3})
+	}
+	if _, ok := a.Key(2); !ok {
+		t.Errorf("a.Key(2): not found")
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	const config = `# Keyring Configuration
+
+key-fa505078126acb3e = bdc51862abed79b2de48c8e7e66c6200
+KEY-FF813F7D062AC0BC = aa0b5c77f088ccc2d39049bd267f066d
+unrelated = 1234
+`
+	k, err := ReadConfig(strings.NewReader(config))
+	if err != nil {
+		t.Fatalf("ReadConfig: %v", err)
+	}
+	if k.Len() != 2 {
+		t.Errorf("k.Len() = %d; want 2", k.Len())
+	}
+	key, ok := k.Key(0xFA505078126ACB3E)
+	if want, _ := ParseKey("bdc51862abed79b2de48c8e7e66c6200"); !ok || key != want {
+		t.Errorf("k.Key(FA505078126ACB3E) = %x, %v; want %x, true", key, ok, want)
+	}
+	if _, ok := k.Key(0xFF813F7D062AC0BC); !ok {
+		t.Errorf("k.Key(FF813F7D062AC0BC) missing")
+	}
+
+	if _, err := ReadConfig(strings.NewReader("key-fa505078126acb3e = nothex\n")); err == nil {
+		t.Errorf("ReadConfig with bad key: nil error; want error")
+	}
+}
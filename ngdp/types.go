@@ -16,10 +16,28 @@ limitations under the License.
 
 package ngdp
 
-import "crypto/md5"
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+)
 
 type hash [md5.Size]byte
 
+func (h hash) marshalText() ([]byte, error) {
+	out := make([]byte, hex.EncodedLen(len(h)))
+	hex.Encode(out, h[:])
+	return out, nil
+}
+
+func (h *hash) unmarshalText(b []byte) error {
+	if hex.DecodedLen(len(b)) != len(h) {
+		return fmt.Errorf("ngdp: hash %q is the wrong length", b)
+	}
+	_, err := hex.Decode(h[:], b)
+	return err
+}
+
 // Equal checks two hashes for equality.
 func (h hash) Equal(o hash) bool {
 	for n := 0; n < md5.Size; n++ {
@@ -49,12 +67,24 @@ type CDNHash hash
 func (h CDNHash) Equal(o CDNHash) bool { return hash(h).Equal(hash(o)) }
 func (h CDNHash) Less(o CDNHash) bool  { return hash(h).Less(hash(o)) }
 
+// MarshalText encodes the hash as hex.
+func (h CDNHash) MarshalText() ([]byte, error) { return hash(h).marshalText() }
+
+// UnmarshalText decodes a hex-encoded hash.
+func (h *CDNHash) UnmarshalText(b []byte) error { return (*hash)(h).unmarshalText(b) }
+
 // A ContentHash is an MD5 hash of the raw contents of a file, before it is BLTE-encoded. These must be looked up in the encoding table to get a CDNHash before files can be downloaded.
 type ContentHash hash
 
 func (h ContentHash) Equal(o ContentHash) bool { return hash(h).Equal(hash(o)) }
 func (h ContentHash) Less(o ContentHash) bool  { return hash(h).Less(hash(o)) }
 
+// MarshalText encodes the hash as hex.
+func (h ContentHash) MarshalText() ([]byte, error) { return hash(h).marshalText() }
+
+// UnmarshalText decodes a hex-encoded hash.
+func (h *ContentHash) UnmarshalText(b []byte) error { return (*hash)(h).unmarshalText(b) }
+
 // A CDNInfo contains information on which CDNs hold data for which regions, as well as what path the data is stored under.
 type CDNInfo struct {
 	Name       Region
@@ -71,6 +101,16 @@ type VersionInfo struct {
 	BuildID       int `configtable:"BuildId"`
 	VersionsName  string
 	ProductConfig CDNHash
+
+	// KeyRing names the config holding the encryption keys for this version, if it has one.
+	KeyRing CDNHash
+}
+
+// A BlobInfo lists the hashes of the game and install blobs served by the patch server for a region.
+type BlobInfo struct {
+	Region         Region
+	InstallBlobMD5 ContentHash
+	GameBlobMD5    ContentHash
 }
 
 // A BuildConfigEncoding contains the content and CDN hashes of an encoding file.
@@ -101,6 +141,10 @@ type BuildConfig struct {
 	Patch       ContentHash
 	PatchSize   uint64
 	PatchConfig CDNHash
+
+	// Size refers to the size manifest, which newer builds use to list the encoded size of every file.
+	Size     BuildConfigEncoding
+	SizeSize BuildConfigEncodingSize
 }
 
 // A CDNConfig contains information on the archives, which are used to bundle smaller files together on the CDN.
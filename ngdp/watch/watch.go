@@ -0,0 +1,218 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package watch polls NGDP version information and reports when it changes.
+package watch
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/ribbit"
+)
+
+// A Source retrieves the current versions of a program in every region.
+//
+// The returned sequence number must change whenever the versions do; sources which do not have one return 0.
+type Source interface {
+	Versions(ctx context.Context, program ngdp.ProgramCode) ([]ngdp.VersionInfo, int, error)
+}
+
+// ribbit.Client provides sequence numbers, so it can be used as a Source directly.
+var _ Source = (*ribbit.Client)(nil)
+
+// HTTPSource is a Source which asks the HTTP patch server in Region.
+type HTTPSource struct {
+	Client *client.LowLevelClient
+	Region ngdp.Region
+}
+
+// Versions implements Source. The HTTP patch server does not report sequence numbers, so it always returns 0.
+func (s HTTPSource) Versions(ctx context.Context, program ngdp.ProgramCode) ([]ngdp.VersionInfo, int, error) {
+	vs, err := s.Client.Versions(ctx, program, s.Region)
+	return vs, 0, err
+}
+
+// A Change describes a new version of a program in a region.
+type Change struct {
+	Program ngdp.ProgramCode
+	Region  ngdp.Region
+
+	// Old is the zero VersionInfo if Initial is set.
+	Old, New ngdp.VersionInfo
+
+	// Initial is set for the versions seen by the first successful poll of a program, which are not really changes.
+	Initial bool
+}
+
+// A Watcher polls Source for the versions of each of Programs, reporting changes to its subscribers.
+type Watcher struct {
+	Source   Source
+	Programs []ngdp.ProgramCode
+
+	// Regions, if non-empty, limits the watcher to versions in those regions; versions in other regions are ignored entirely.
+	Regions []ngdp.Region
+
+	// Interval is the time between polls. It defaults to five minutes.
+	Interval time.Duration
+
+	// OnError, if set, is called when polling a program fails. Polling continues regardless.
+	OnError func(program ngdp.ProgramCode, err error)
+
+	mu    sync.Mutex
+	funcs []func(Change)
+	chans []chan<- Change
+	seqns map[ngdp.ProgramCode]int
+	seen  map[ngdp.ProgramCode]map[ngdp.Region]ngdp.VersionInfo
+}
+
+// Subscribe arranges for fn to be called with every change. Calls are made synchronously from Poll, in order.
+func (w *Watcher) Subscribe(fn func(Change)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.funcs = append(w.funcs, fn)
+}
+
+// Notify arranges for every change to be sent to ch.
+//
+// Like signal.Notify, Watcher will not block sending to ch: changes which do not fit in its buffer are dropped.
+func (w *Watcher) Notify(ch chan<- Change) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.chans = append(w.chans, ch)
+}
+
+// Versions returns the versions of program seen by the most recent successful poll.
+func (w *Watcher) Versions(program ngdp.ProgramCode) []ngdp.VersionInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var vs []ngdp.VersionInfo
+	for _, v := range w.seen[program] {
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+// watching reports whether w is interested in versions in region.
+func (w *Watcher) watching(region ngdp.Region) bool {
+	if len(w.Regions) == 0 {
+		return true
+	}
+	for _, r := range w.Regions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+// changed reports whether a version differs in a way that matters to watchers.
+func changed(old, new ngdp.VersionInfo) bool {
+	return old.BuildID != new.BuildID || !old.BuildConfig.Equal(new.BuildConfig) || !old.CDNConfig.Equal(new.CDNConfig)
+}
+
+// Poll checks every program once, reporting any changes. It returns the first error encountered, after polling every program.
+func (w *Watcher) Poll(ctx context.Context) error {
+	var firstErr error
+	for _, program := range w.Programs {
+		if err := w.poll(ctx, program); err != nil {
+			if w.OnError != nil {
+				w.OnError(program, err)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (w *Watcher) poll(ctx context.Context, program ngdp.ProgramCode) error {
+	vs, seqn, err := w.Source.Versions(ctx, program)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	if w.seen == nil {
+		w.seqns = make(map[ngdp.ProgramCode]int)
+		w.seen = make(map[ngdp.ProgramCode]map[ngdp.Region]ngdp.VersionInfo)
+	}
+	old, initial := w.seen[program], w.seen[program] == nil
+	if !initial && seqn != 0 && seqn == w.seqns[program] {
+		// Nothing has changed since the last poll.
+		w.mu.Unlock()
+		return nil
+	}
+	w.seqns[program] = seqn
+
+	var changes []Change
+	seen := make(map[ngdp.Region]ngdp.VersionInfo)
+	for _, v := range vs {
+		if !w.watching(v.Region) {
+			continue
+		}
+		seen[v.Region] = v
+		if o := old[v.Region]; initial || changed(o, v) {
+			changes = append(changes, Change{
+				Program: program,
+				Region:  v.Region,
+				Old:     o,
+				New:     v,
+				Initial: initial,
+			})
+		}
+	}
+	w.seen[program] = seen
+	funcs, chans := w.funcs, w.chans
+	w.mu.Unlock()
+
+	for _, c := range changes {
+		for _, fn := range funcs {
+			fn(c)
+		}
+		for _, ch := range chans {
+			select {
+			case ch <- c:
+			default:
+			}
+		}
+	}
+	return nil
+}
+
+// Run polls immediately, and then every Interval, until ctx is done.
+func (w *Watcher) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval == 0 {
+		interval = 5 * time.Minute
+	}
+
+	w.Poll(ctx)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.Poll(ctx)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watch
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+type fakeSource struct {
+	versions []ngdp.VersionInfo
+	seqn     int
+	calls    int
+}
+
+func (s *fakeSource) Versions(ctx context.Context, program ngdp.ProgramCode) ([]ngdp.VersionInfo, int, error) {
+	s.calls++
+	return s.versions, s.seqn, nil
+}
+
+func TestPoll(t *testing.T) {
+	ctx := context.Background()
+	eu1 := ngdp.VersionInfo{Region: "eu", BuildID: 1, BuildConfig: ngdp.CDNHash{1}}
+	us1 := ngdp.VersionInfo{Region: "us", BuildID: 1, BuildConfig: ngdp.CDNHash{1}}
+	eu2 := ngdp.VersionInfo{Region: "eu", BuildID: 2, BuildConfig: ngdp.CDNHash{2}}
+
+	src := &fakeSource{versions: []ngdp.VersionInfo{eu1, us1}, seqn: 10}
+	w := &Watcher{Source: src, Programs: []ngdp.ProgramCode{"hero"}}
+
+	var got []Change
+	w.Subscribe(func(c Change) { got = append(got, c) })
+	ch := make(chan Change, 1)
+	w.Notify(ch)
+
+	if err := w.Poll(ctx); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	want := []Change{
+		{Program: "hero", Region: "eu", New: eu1, Initial: true},
+		{Program: "hero", Region: "us", New: us1, Initial: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("initial changes = %#v; want %#v", got, want)
+	}
+	if c := <-ch; !reflect.DeepEqual(c, want[0]) {
+		t.Errorf("<-ch = %#v; want %#v (later changes should be dropped)", c, want[0])
+	}
+
+	// An unchanged sequence number means nothing is reported, even if the versions differ.
+	got = nil
+	src.versions = []ngdp.VersionInfo{eu2, us1}
+	if err := w.Poll(ctx); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("changes with unchanged seqn = %#v; want none", got)
+	}
+
+	src.seqn = 11
+	if err := w.Poll(ctx); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	want = []Change{{Program: "hero", Region: "eu", Old: eu1, New: eu2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes = %#v; want %#v", got, want)
+	}
+	if c := <-ch; !reflect.DeepEqual(c, want[0]) {
+		t.Errorf("<-ch = %#v; want %#v", c, want[0])
+	}
+}
+
+func TestPollRegions(t *testing.T) {
+	ctx := context.Background()
+	eu1 := ngdp.VersionInfo{Region: "eu", BuildID: 1, BuildConfig: ngdp.CDNHash{1}}
+	us1 := ngdp.VersionInfo{Region: "us", BuildID: 1, BuildConfig: ngdp.CDNHash{1}}
+	us2 := ngdp.VersionInfo{Region: "us", BuildID: 2, BuildConfig: ngdp.CDNHash{2}}
+
+	src := &fakeSource{versions: []ngdp.VersionInfo{eu1, us1}}
+	w := &Watcher{Source: src, Programs: []ngdp.ProgramCode{"hero"}, Regions: []ngdp.Region{"eu"}}
+
+	var got []Change
+	w.Subscribe(func(c Change) { got = append(got, c) })
+	if err := w.Poll(ctx); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	want := []Change{{Program: "hero", Region: "eu", New: eu1, Initial: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("initial changes = %#v; want %#v", got, want)
+	}
+	if vs := w.Versions("hero"); !reflect.DeepEqual(vs, []ngdp.VersionInfo{eu1}) {
+		t.Errorf("Versions = %#v; want %#v", vs, []ngdp.VersionInfo{eu1})
+	}
+
+	got = nil
+	src.versions = []ngdp.VersionInfo{eu1, us2}
+	if err := w.Poll(ctx); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("changes in an unwatched region = %#v; want none", got)
+	}
+}
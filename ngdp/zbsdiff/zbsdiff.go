@@ -0,0 +1,246 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package zbsdiff applies ZBSDIFF1 patches, the bsdiff variant used by TACT patch entries.
+//
+// A ZBSDIFF1 patch is laid out as follows, with all integers being signed, big-endian and 64 bits wide:
+//
+//	magic "ZBSDIFF1", control block length, diff block length, new file size
+//	zlib-compressed control block: triples of (diff length, extra length, old file seek)
+//	zlib-compressed diff block: bytes to add to the old file
+//	zlib-compressed extra block: bytes to insert verbatim
+package zbsdiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+const headerSize = 32
+
+var (
+	// ErrBadMagic means that the patch doesn't start with "ZBSDIFF1".
+	ErrBadMagic = errors.New("zbsdiff: bad magic")
+
+	// ErrCorrupt means that the patch is internally inconsistent.
+	ErrCorrupt = errors.New("zbsdiff: corrupt patch")
+)
+
+var magic = []byte("ZBSDIFF1")
+
+// ErrHashMismatch is returned when the patched file doesn't have the expected content hash.
+type ErrHashMismatch struct {
+	Want ngdp.ContentHash
+	Got  ngdp.ContentHash
+}
+
+func (e ErrHashMismatch) Error() string {
+	return fmt.Sprintf("zbsdiff: patched file has hash %032x; want %032x", e.Got, e.Want)
+}
+
+// A Reader produces a new file by applying a patch to an old one.
+type Reader struct {
+	old     io.ReaderAt
+	oldPos  int64
+	newSize int64
+	newPos  int64
+
+	ctrl, diff, extra io.Reader
+
+	// The remaining lengths of the current control triple.
+	diffLeft, extraLeft, seek int64
+	haveCtrl                  bool
+
+	buf []byte
+	err error
+}
+
+// NewReader returns a Reader which applies patch to old.
+func NewReader(old io.ReaderAt, patch []byte) (*Reader, error) {
+	if len(patch) < headerSize {
+		return nil, ErrCorrupt
+	}
+	if !bytes.Equal(patch[:len(magic)], magic) {
+		return nil, ErrBadMagic
+	}
+	ctrlLen := int64(binary.BigEndian.Uint64(patch[8:16]))
+	diffLen := int64(binary.BigEndian.Uint64(patch[16:24]))
+	newSize := int64(binary.BigEndian.Uint64(patch[24:32]))
+	rest := int64(len(patch) - headerSize)
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 || ctrlLen > rest || diffLen > rest-ctrlLen {
+		return nil, ErrCorrupt
+	}
+
+	blocks := patch[headerSize:]
+	ctrl, err := zlib.NewReader(bytes.NewReader(blocks[:ctrlLen]))
+	if err != nil {
+		return nil, fmt.Errorf("zbsdiff: control block: %v", err)
+	}
+	diff, err := zlib.NewReader(bytes.NewReader(blocks[ctrlLen : ctrlLen+diffLen]))
+	if err != nil {
+		return nil, fmt.Errorf("zbsdiff: diff block: %v", err)
+	}
+	extra, err := zlib.NewReader(bytes.NewReader(blocks[ctrlLen+diffLen:]))
+	if err != nil {
+		return nil, fmt.Errorf("zbsdiff: extra block: %v", err)
+	}
+
+	return &Reader{
+		old:     old,
+		newSize: newSize,
+		ctrl:    ctrl,
+		diff:    diff,
+		extra:   extra,
+	}, nil
+}
+
+// Size returns the size of the new file.
+func (r *Reader) Size() int64 {
+	return r.newSize
+}
+
+func (r *Reader) readCtrl() error {
+	var triple [3]int64
+	if err := binary.Read(r.ctrl, binary.BigEndian, &triple); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrCorrupt
+		}
+		return err
+	}
+	r.diffLeft, r.extraLeft, r.seek = triple[0], triple[1], triple[2]
+	if r.diffLeft < 0 || r.extraLeft < 0 || r.diffLeft+r.extraLeft > r.newSize-r.newPos {
+		return ErrCorrupt
+	}
+	r.haveCtrl = true
+	return nil
+}
+
+func readBlock(src io.Reader, b []byte) error {
+	if _, err := io.ReadFull(src, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrCorrupt
+		}
+		return err
+	}
+	return nil
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(b []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n := 0
+	for n < len(b) && r.newPos < r.newSize {
+		if !r.haveCtrl {
+			if r.err = r.readCtrl(); r.err != nil {
+				return n, r.err
+			}
+		}
+
+		switch {
+		case r.diffLeft > 0:
+			// Add the diff block to the old file.
+			out := b[n:]
+			if int64(len(out)) > r.diffLeft {
+				out = out[:r.diffLeft]
+			}
+			if err := readBlock(r.diff, out); err != nil {
+				r.err = err
+				return n, err
+			}
+			if cap(r.buf) < len(out) {
+				r.buf = make([]byte, len(out))
+			}
+			old := r.buf[:len(out)]
+			// Bytes beyond the end of the old file count as zero.
+			for i := range old {
+				old[i] = 0
+			}
+			if r.oldPos >= 0 {
+				if _, err := r.old.ReadAt(old, r.oldPos); err != nil && err != io.EOF {
+					r.err = err
+					return n, err
+				}
+			}
+			for i := range out {
+				out[i] += old[i]
+			}
+			r.diffLeft -= int64(len(out))
+			r.oldPos += int64(len(out))
+			r.newPos += int64(len(out))
+			n += len(out)
+
+		case r.extraLeft > 0:
+			// Copy the extra block verbatim.
+			out := b[n:]
+			if int64(len(out)) > r.extraLeft {
+				out = out[:r.extraLeft]
+			}
+			if err := readBlock(r.extra, out); err != nil {
+				r.err = err
+				return n, err
+			}
+			r.extraLeft -= int64(len(out))
+			r.newPos += int64(len(out))
+			n += len(out)
+
+		default:
+			r.oldPos += r.seek
+			r.haveCtrl = false
+		}
+	}
+
+	if r.newPos == r.newSize && n == 0 {
+		r.err = io.EOF
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// Apply writes the result of applying patch to old into w.
+func Apply(w io.Writer, old io.ReaderAt, patch []byte) error {
+	r, err := NewReader(old, patch)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// ApplyVerified is like Apply, but also checks that the new file has the content hash want.
+//
+// Since the output is streamed, w will already have been written to when the hashes don't match.
+func ApplyVerified(w io.Writer, old io.ReaderAt, patch []byte, want ngdp.ContentHash) error {
+	hasher := md5.New()
+	if err := Apply(io.MultiWriter(w, hasher), old, patch); err != nil {
+		return err
+	}
+	var got ngdp.ContentHash
+	copy(got[:], hasher.Sum(nil))
+	if !got.Equal(want) {
+		return ErrHashMismatch{want, got}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zbsdiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/lukegb/snowstorm/ngdp"
+)
+
+func compress(b []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+// makePatch builds a ZBSDIFF1 patch from its uncompressed parts.
+func makePatch(ctrl [][3]int64, diff, extra []byte, newSize int64) []byte {
+	var ctrlBuf bytes.Buffer
+	for _, c := range ctrl {
+		binary.Write(&ctrlBuf, binary.BigEndian, c)
+	}
+	ctrlZ, diffZ, extraZ := compress(ctrlBuf.Bytes()), compress(diff), compress(extra)
+
+	var buf bytes.Buffer
+	buf.Write(magic)
+	binary.Write(&buf, binary.BigEndian, int64(len(ctrlZ)))
+	binary.Write(&buf, binary.BigEndian, int64(len(diffZ)))
+	binary.Write(&buf, binary.BigEndian, newSize)
+	buf.Write(ctrlZ)
+	buf.Write(diffZ)
+	buf.Write(extraZ)
+	return buf.Bytes()
+}
+
+var (
+	testOld = []byte("hello world, this is the old file")
+
+	// Turn "hello" into "jello", insert " there", skip the rest of the old file's ", this is the old" and keep " file".
+	testPatch = makePatch(
+		[][3]int64{
+			{5, 6, 23},
+			{5, 0, 0},
+		},
+		[]byte{2, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		[]byte(" there"),
+		16,
+	)
+	testNew = []byte("jello there file")
+)
+
+func TestApply(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Apply(&buf, bytes.NewReader(testOld), testPatch); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, testNew) {
+		t.Errorf("Apply = %q; want %q", got, testNew)
+	}
+}
+
+func TestReaderSmallReads(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(testOld), testPatch)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if r.Size() != int64(len(testNew)) {
+		t.Errorf("r.Size() = %d; want %d", r.Size(), len(testNew))
+	}
+
+	var got []byte
+	b := make([]byte, 3)
+	for {
+		n, err := r.Read(b)
+		got = append(got, b[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if !bytes.Equal(got, testNew) {
+		t.Errorf("reading 3 bytes at a time = %q; want %q", got, testNew)
+	}
+}
+
+func TestApplyVerified(t *testing.T) {
+	want := ngdp.ContentHash(md5.Sum(testNew))
+	if err := ApplyVerified(ioutil.Discard, bytes.NewReader(testOld), testPatch, want); err != nil {
+		t.Errorf("ApplyVerified: %v", err)
+	}
+
+	err := ApplyVerified(ioutil.Discard, bytes.NewReader(testOld), testPatch, ngdp.ContentHash{})
+	if _, ok := err.(ErrHashMismatch); !ok {
+		t.Errorf("ApplyVerified with wrong hash: %v; want ErrHashMismatch", err)
+	}
+}
+
+func TestBadPatches(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		patch []byte
+		want  error
+	}{
+		{"short", []byte("ZBSDIFF1"), ErrCorrupt},
+		{"bad magic", append([]byte("BSDIFF40"), testPatch[8:]...), ErrBadMagic},
+		{"control block overruns new file", makePatch([][3]int64{{5, 20, 0}}, make([]byte, 5), make([]byte, 20), 16), ErrCorrupt},
+		{"truncated control block", makePatch([][3]int64{{5, 0, 0}}, make([]byte, 5), nil, 16), ErrCorrupt},
+		{"truncated diff block", makePatch([][3]int64{{16, 0, 0}}, make([]byte, 5), nil, 16), ErrCorrupt},
+	} {
+		err := Apply(ioutil.Discard, bytes.NewReader(testOld), test.patch)
+		if err != test.want {
+			t.Errorf("%s: Apply: %v; want %v", test.name, err, test.want)
+		}
+	}
+}
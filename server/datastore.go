@@ -5,23 +5,68 @@ import (
 	"fmt"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/lukegb/snowstorm/blte"
 	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
 	"github.com/lukegb/snowstorm/ngdp/client"
 	"github.com/lukegb/snowstorm/ngdp/encoding"
 	"github.com/lukegb/snowstorm/ngdp/mndx"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// A Datastore keeps track of the current state of a set of region/program pairs.
+//
+// Handlers only talk to the Datastore through this interface, so alternative backends (and fakes for testing) can be swapped in.
+type Datastore interface {
+	// Client returns a ready-to-use client for the current version of the given region/program.
+	Client(region ngdp.Region, program ngdp.ProgramCode) (*client.Client, error)
+
+	// Track adds a region/program pair to the set which will be refreshed by Update.
+	Track(region ngdp.Region, program ngdp.ProgramCode)
+
+	// Tracking returns the region/program pairs currently being tracked.
+	Tracking() []DatastoreTracked
+
+	// Update refreshes every tracked region/program pair, blocking until it is complete.
+	Update(ctx context.Context) error
+
+	// History returns the versions seen for a given region/program, oldest first.
+	History(region ngdp.Region, program ngdp.ProgramCode) ([]DatastoreHistoryEntry, error)
+}
+
 type DatastoreTracked struct {
 	Region  ngdp.Region
 	Program ngdp.ProgramCode
 }
 
-type datastore struct {
+// A DatastoreHistoryEntry records a version of a program that was seen by the Datastore.
+type DatastoreHistoryEntry struct {
+	VersionInfo ngdp.VersionInfo
+	FirstSeen   time.Time
+}
+
+// memoryDatastore is a Datastore which keeps everything in memory, and forgets it all on restart.
+type memoryDatastore struct {
 	llc *client.LowLevelClient
 
+	// cache, if set, is shared by every client the datastore creates.
+	cache blobstore.ContentStore
+
+	// verify is how every client the datastore creates checks chunk checksums.
+	verify blte.VerifyMode
+
+	// shared, if set, is also the cache, and is told which files each build refers to so that it can drop them once no build does.
+	shared *blobstore.SharedStore
+
+	// staticNames holds filename trees loaded from manifests, for programs whose root files can't be parsed.
+	staticNames map[ngdp.ProgramCode]*mndx.TreeDirectory
+
 	// Guards all fields below.
 	l sync.RWMutex
 
@@ -29,6 +74,7 @@ type datastore struct {
 
 	cdnInfos     map[ngdp.ProgramCode]map[ngdp.Region]*ngdp.CDNInfo
 	versionInfos map[ngdp.ProgramCode]map[ngdp.Region]*ngdp.VersionInfo
+	history      map[ngdp.ProgramCode]map[ngdp.Region][]DatastoreHistoryEntry
 
 	// The below are indexed on their own CDNHashes.
 	buildConfigs map[ngdp.CDNHash]*ngdp.BuildConfig
@@ -42,11 +88,12 @@ type datastore struct {
 	archiveMappers map[ngdp.CDNHash]*client.ArchiveMapper
 }
 
-func newDatastore(llc *client.LowLevelClient) *datastore {
-	return &datastore{
+func newMemoryDatastore(llc *client.LowLevelClient) *memoryDatastore {
+	return &memoryDatastore{
 		llc:          llc,
 		cdnInfos:     make(map[ngdp.ProgramCode]map[ngdp.Region]*ngdp.CDNInfo),
 		versionInfos: make(map[ngdp.ProgramCode]map[ngdp.Region]*ngdp.VersionInfo),
+		history:      make(map[ngdp.ProgramCode]map[ngdp.Region][]DatastoreHistoryEntry),
 
 		buildConfigs:    make(map[ngdp.CDNHash]*ngdp.BuildConfig),
 		cdnConfigs:      make(map[ngdp.CDNHash]*ngdp.CDNConfig),
@@ -56,7 +103,7 @@ func newDatastore(llc *client.LowLevelClient) *datastore {
 	}
 }
 
-func (d *datastore) Client(region ngdp.Region, program ngdp.ProgramCode) (*client.Client, error) {
+func (d *memoryDatastore) Client(region ngdp.Region, program ngdp.ProgramCode) (*client.Client, error) {
 	d.l.RLock()
 	defer d.l.RUnlock()
 
@@ -97,6 +144,8 @@ func (d *datastore) Client(region ngdp.Region, program ngdp.ProgramCode) (*clien
 
 	return &client.Client{
 		LowLevelClient: d.llc,
+		Cache:          d.cache,
+		Verify:         d.verify,
 
 		CDNInfo:     cdnInfo,
 		VersionInfo: versionInfo,
@@ -111,7 +160,7 @@ func (d *datastore) Client(region ngdp.Region, program ngdp.ProgramCode) (*clien
 }
 
 // Update runs a single iteration of datastore's update loop, blocking until it is complete.
-func (d *datastore) Update(ctx context.Context) error {
+func (d *memoryDatastore) Update(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -122,10 +171,20 @@ func (d *datastore) Update(ctx context.Context) error {
 
 	var err error
 	for _, t := range tracking {
-		err = d.update(ctx, t.Region, t.Program)
-		if err != nil {
-			glog.Errorf("Error updating %q/%q: %v", t.Program, t.Region, err)
+		uctx, span := tracer.Start(ctx, "datastore.update", trace.WithAttributes(
+			attribute.String("ngdp.program", string(t.Program)),
+			attribute.String("ngdp.region", string(t.Region)),
+		))
+		if uerr := d.update(uctx, t.Region, t.Program); uerr != nil {
+			span.RecordError(uerr)
+			span.SetStatus(codes.Error, uerr.Error())
+			glog.Errorf("Error updating %q/%q: %v", t.Program, t.Region, uerr)
+			// Report the first failure, rather than letting later successes hide it.
+			if err == nil {
+				err = errors.Wrapf(uerr, "updating %s/%s", t.Program, t.Region)
+			}
 		}
+		span.End()
 	}
 
 	glog.Info("Looking for no-longer-referenced entities")
@@ -174,6 +233,9 @@ func (d *datastore) Update(ctx context.Context) error {
 	}
 	for _, e := range toDelete {
 		delete(d.encodingMappers, e)
+		if d.shared != nil {
+			d.shared.Release(e)
+		}
 	}
 	if len(toDelete) > 0 {
 		glog.Infof("Deleted %d encoding mappers", len(toDelete))
@@ -207,6 +269,15 @@ func (d *datastore) Update(ctx context.Context) error {
 
 	d.l.Unlock()
 
+	if d.shared != nil {
+		n, gcErr := d.shared.GC(ctx)
+		if gcErr != nil {
+			glog.Errorf("Removing files no longer referenced from the shared store: %v", gcErr)
+		}
+		builds, files := d.shared.Builds()
+		glog.Infof("Removed %d files from the shared store, which now holds %d files for %d builds", n, files, builds)
+	}
+
 	glog.Info("Collecting garbage")
 	runtime.GC()
 
@@ -214,7 +285,7 @@ func (d *datastore) Update(ctx context.Context) error {
 }
 
 // update updates a single region/program pair.
-func (d *datastore) update(ctx context.Context, region ngdp.Region, program ngdp.ProgramCode) error {
+func (d *memoryDatastore) update(ctx context.Context, region ngdp.Region, program ngdp.ProgramCode) error {
 	glog.Infof("Updating %q/%q", program, region)
 
 	cdn, version, err := d.llc.Info(ctx, program, region)
@@ -273,13 +344,21 @@ func (d *datastore) update(ctx context.Context, region ngdp.Region, program ngdp
 		d.encodingMappers[version.BuildConfig] = encodingMapper
 		d.archiveMappers[version.CDNConfig] = archiveMapper
 		d.l.Unlock()
+
+		if d.shared != nil {
+			d.shared.Retain(version.BuildConfig, append([]ngdp.CDNHash{buildConfig.Encoding.CDNHash}, encodingMapper.CDNHashes()...))
+		}
 	}
 
 	d.l.RLock()
 	_, haveFilenameMapper := d.filenameMappers[version.BuildConfig]
 	d.l.RUnlock()
 
-	if !haveFilenameMapper {
+	if tree, ok := d.staticNames[program]; ok && !haveFilenameMapper {
+		d.l.Lock()
+		d.filenameMappers[version.BuildConfig] = tree
+		d.l.Unlock()
+	} else if !haveFilenameMapper {
 		glog.Info("Building filename map")
 		rootCDNHash, err := encodingMapper.ToCDNHash(buildConfig.Root)
 		if err != nil {
@@ -307,15 +386,41 @@ func (d *datastore) update(ctx context.Context, region ngdp.Region, program ngdp
 		d.l.Unlock()
 	}
 
+	if !haveEncodingMapper {
+		// Only files in the filename map are ever served, so drop the rest of the encoding table.
+		d.l.RLock()
+		tree, ok := d.filenameMappers[version.BuildConfig].(*mndx.TreeDirectory)
+		d.l.RUnlock()
+		if ok {
+			wanted := make(map[ngdp.ContentHash]bool)
+			for _, f := range tree.Files() {
+				wanted[ngdp.ContentHash(f.EncodingKey)] = true
+			}
+			selected, err := encodingMapper.Select(wanted)
+			if err != nil {
+				return errors.Wrap(err, "trimming encoding table")
+			}
+			d.l.Lock()
+			d.encodingMappers[version.BuildConfig] = selected
+			d.l.Unlock()
+		}
+	}
+
 	d.l.Lock()
 	d.cdnInfos[program][region] = &cdn
 	d.versionInfos[program][region] = &version
+	if !haveOldVersion || !oldVersion.BuildConfig.Equal(version.BuildConfig) || oldVersion.BuildID != version.BuildID {
+		d.history[program][region] = append(d.history[program][region], DatastoreHistoryEntry{
+			VersionInfo: version,
+			FirstSeen:   time.Now(),
+		})
+	}
 	d.l.Unlock()
 
 	return nil
 }
 
-func (d *datastore) Track(region ngdp.Region, program ngdp.ProgramCode) {
+func (d *memoryDatastore) Track(region ngdp.Region, program ngdp.ProgramCode) {
 	d.l.Lock()
 	defer d.l.Unlock()
 
@@ -325,6 +430,13 @@ func (d *datastore) Track(region ngdp.Region, program ngdp.ProgramCode) {
 	if _, ok := d.versionInfos[program]; !ok {
 		d.versionInfos[program] = make(map[ngdp.Region]*ngdp.VersionInfo)
 	}
+	if _, ok := d.history[program]; !ok {
+		d.history[program] = make(map[ngdp.Region][]DatastoreHistoryEntry)
+	}
+	if _, ok := d.history[program][region]; !ok {
+		// Tracked pairs have an empty history until their first update.
+		d.history[program][region] = []DatastoreHistoryEntry{}
+	}
 
 	d.tracking = append(d.tracking, DatastoreTracked{
 		Region:  region,
@@ -332,9 +444,23 @@ func (d *datastore) Track(region ngdp.Region, program ngdp.ProgramCode) {
 	})
 }
 
-func (d *datastore) Tracking() []DatastoreTracked {
+func (d *memoryDatastore) Tracking() []DatastoreTracked {
 	d.l.RLock()
 	defer d.l.RUnlock()
 
 	return d.tracking
 }
+
+func (d *memoryDatastore) History(region ngdp.Region, program ngdp.ProgramCode) ([]DatastoreHistoryEntry, error) {
+	d.l.RLock()
+	defer d.l.RUnlock()
+
+	h, ok := d.history[program][region]
+	if !ok {
+		return nil, fmt.Errorf("not tracking %q/%q", program, region)
+	}
+
+	out := make([]DatastoreHistoryEntry, len(h))
+	copy(out, h)
+	return out, nil
+}
@@ -8,15 +8,27 @@ import (
 	"io"
 	"net/http"
 	_ "net/http/pprof"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/NYTimes/gziphandler"
+	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
+	"github.com/lukegb/snowstorm/blte"
 	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/blobstore"
+	"github.com/lukegb/snowstorm/ngdp/blobstore/memcacheblob"
+	"github.com/lukegb/snowstorm/ngdp/blobstore/redisblob"
 	"github.com/lukegb/snowstorm/ngdp/client"
+	"github.com/lukegb/snowstorm/ngdp/filetype"
+	"github.com/lukegb/snowstorm/ngdp/listfile"
 	"github.com/lukegb/snowstorm/ngdp/mndx"
+	"github.com/lukegb/snowstorm/ngdp/notify"
+	"github.com/lukegb/snowstorm/ngdp/staticmap"
+	"github.com/lukegb/snowstorm/ngdp/watch"
 	"gopkg.in/webpack.v0"
 )
 
@@ -24,12 +36,38 @@ var (
 	trackRegionsStr  = flag.String("track-regions", "eu,us", "comma-separated list of regions to track")
 	trackProgramsStr = flag.String("track-programs", "hero,herot", "comma-separated list of programs to track")
 
-	listen  = flag.String("listen", ":8080", "HTTP listen address")
-	devMode = flag.Bool("dev", false, "development mode")
+	listen           = flag.String("listen", ":8080", "HTTP listen address")
+	basePath         = flag.String("base-path", "/", "path prefix the server is mounted under, e.g. /snowstorm/ when behind a reverse proxy")
+	devMode          = flag.Bool("dev", false, "development mode")
+	listfileURL      = flag.String("listfile-url", "", "URL of a community listfile used to name FileDataIDs in listings; if empty, FileDataIDs are not named")
+	listfileCache    = flag.String("listfile-cache", "", "path at which to cache the listfile between runs")
+	listfileInterval = flag.Duration("listfile-interval", 6*time.Hour, "how often to refresh the listfile")
+	staticNamesStr   = flag.String("names", "", "comma-separated list of program=path pairs, naming .csv or .json manifests of file paths and content hashes to use instead of those programs' root files")
+
+	cacheRedis     = flag.String("cache-redis", "", "redis:// URL of a Redis server in which to cache data files, shared between replicas")
+	cacheMemcached = flag.String("cache-memcached", "", "comma-separated list of memcached servers in which to cache data files, shared between replicas")
+	sharedStore    = flag.String("shared-store", "", "directory in which to keep data files shared between every tracked program, removing them once no tracked build needs them")
+	cacheTTL       = flag.Duration("cache-ttl", 24*time.Hour, "how long data files are kept in the shared cache")
+
+	sinks notify.Sinks
+
+	verifyChunks blte.VerifyMode
+
+	captureFile = flag.String("capture", "", "record every request made to patch servers and CDNs in this file, as JSON lines, or as a HAR written on exit if it ends in .har")
+
+	otelFlag = flag.Bool("otel", false, "export OpenTelemetry traces and metrics over OTLP, configured by the standard OTEL_EXPORTER_OTLP_* environment variables")
 )
 
+func init() {
+	flag.Var(&verifyChunks, "verify-chunks", "how to check BLTE chunk checksums when serving files: strict, none (cheaper when the CDN or cache is trusted) or report (log mismatches, but serve the file anyway)")
+	flag.Var(&sinks, "notify", "where to send notifications of new versions and failed updates: an http(s):// webhook URL, an smtp://host?from=...&to=... URL, or exec:<command>; may be repeated")
+}
+
 var (
-	ds *datastore
+	ds Datastore
+
+	// names is nil unless -listfile-url is set.
+	names *listfile.Listfile
 )
 
 type Program struct {
@@ -111,9 +149,147 @@ func ProgramHandler(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(out)
 }
 
+// ProductConfigHandler serves the product config settings for a program, for the platform and locale given in the query string.
+func ProductConfigHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	program := ngdp.ProgramCode(vars["program"])
+	region := ngdp.Region(vars["region"])
+
+	c, err := ds.Client(region, program)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	annotateHeadersWithClient(w.Header(), c)
+
+	pc, err := c.ProductConfig(r.Context())
+	if err == client.ErrNoProductConfig {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out, err := pc.Settings(r.FormValue("platform"), r.FormValue("locale"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+type HistoryEntry struct {
+	BuildConfig  string    `json:"build_config"`
+	CDNConfig    string    `json:"cdn_config"`
+	BuildID      int       `json:"build_id"`
+	VersionsName string    `json:"versions_name"`
+	FirstSeen    time.Time `json:"first_seen"`
+}
+
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	program := ngdp.ProgramCode(vars["program"])
+	region := ngdp.Region(vars["region"])
+
+	history, err := ds.History(region, program)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	out := make([]HistoryEntry, len(history))
+	for n, h := range history {
+		out[n] = HistoryEntry{
+			BuildConfig:  fmt.Sprintf("%032x", h.VersionInfo.BuildConfig),
+			CDNConfig:    fmt.Sprintf("%032x", h.VersionInfo.CDNConfig),
+			BuildID:      h.VersionInfo.BuildID,
+			VersionsName: h.VersionInfo.VersionsName,
+			FirstSeen:    h.FirstSeen,
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
 type FileDirectory struct {
 	Directories map[string]*FileDirectory `json:"directories,omitempty"`
 	Files       []string                  `json:"files,omitempty"`
+
+	// Names maps files with a FileDataID to their name in the listfile.
+	Names map[string]string `json:"names,omitempty"`
+}
+
+// FileDataIDHandler looks a FileDataID up in the listfile.
+func FileDataIDHandler(w http.ResponseWriter, r *http.Request) {
+	if names == nil {
+		http.Error(w, "no listfile configured", http.StatusNotFound)
+		return
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name, ok := names.Name(uint32(id))
+	if !ok {
+		http.Error(w, "unknown FileDataID", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(struct {
+		FileDataID uint32 `json:"file_data_id"`
+		Name       string `json:"name"`
+	}{uint32(id), name})
+}
+
+// serveFileRange answers a Range request for a file, decoding only the chunks the requested ranges need.
+func serveFileRange(w http.ResponseWriter, r *http.Request, c *client.Client, fp string, h ngdp.ContentHash) {
+	cdnHash, err := c.CDNHash(h)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Only the header and the chunks the ranges need are fetched.
+	raw, rawSize, err := c.OpenRaw(r.Context(), cdnHash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ra, err := blte.NewReaderAt(raw, rawSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if c.Keys != nil {
+		ra.Keys = c.Keys
+	}
+	ra.Verify = c.Verify
+	ra.OnMismatch = func(err *blte.ChecksumError) {
+		glog.Warningf("%032x: %v", cdnHash, err)
+	}
+	size, err := ra.Size()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	head := make([]byte, filetype.SniffLen)
+	n, err := ra.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", filetype.Detect(fp, head[:n]).MIME)
+	w.Header().Set("Snowstorm-File-Content-Hash", fmt.Sprintf("%032x", h))
+	w.Header().Set("Snowstorm-File-CDN-Hash", fmt.Sprintf("%032x", cdnHash))
+
+	// ServeContent handles the Range header, and conditional requests against the ETag already set.
+	http.ServeContent(w, r, path.Base(fp), time.Time{}, io.NewSectionReader(ra, 0, size))
 }
 
 func FileHandler(w http.ResponseWriter, r *http.Request) {
@@ -146,6 +322,11 @@ func FileHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if r.Header.Get("Range") != "" {
+			serveFileRange(w, r, c, fp, tde.File.EncodingKey)
+			return
+		}
+
 		// serving as file
 		rc, err := c.Fetch(ctx, tde.File.EncodingKey)
 		if err != nil {
@@ -154,14 +335,29 @@ func FileHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		defer rc.Body.Close()
 
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", tde.File.Size))
+		// The chunk and encoding tables are more trustworthy than the filename tree, but not every file has a chunk table.
+		size := int64(tde.File.Size)
+		if s, err := c.EncodingMapper.Size(tde.File.EncodingKey); err == nil {
+			size = int64(s)
+		}
+		if rc.Size != 0 {
+			size = rc.Size
+		}
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
 		w.Header().Set("Snowstorm-File-Content-Hash", fmt.Sprintf("%032x", rc.ContentHash))
 		w.Header().Set("Snowstorm-File-CDN-Hash", fmt.Sprintf("%032x", rc.CDNHash))
 		if !rc.RetrievedCDNHash.Equal(rc.CDNHash) {
 			w.Header().Set("Snowstorm-Archive-CDN-Hash", fmt.Sprintf("%032x", rc.RetrievedCDNHash))
 		}
 		w.Header().Set("ETag", calcetag)
-		io.Copy(w, rc.Body)
+
+		typ, body, err := filetype.Sniff(fp, rc.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", typ.MIME)
+		io.Copy(w, body)
 		return
 	}
 
@@ -186,6 +382,15 @@ func FileHandler(w http.ResponseWriter, r *http.Request) {
 				}
 			} else if e.File != nil {
 				fd.Files = append(fd.Files, e.Name)
+				if names == nil || e.File.FileDataID == 0 {
+					continue
+				}
+				if name, ok := names.Name(e.File.FileDataID); ok {
+					if fd.Names == nil {
+						fd.Names = make(map[string]string)
+					}
+					fd.Names[e.Name] = name
+				}
 			} else {
 				return nil, fmt.Errorf("somehow %q is neither a directory nor a file", e.Name)
 			}
@@ -202,18 +407,94 @@ func FileHandler(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(out)
 }
 
+// cleanBasePath normalises a -base-path value so it always starts and ends with a slash.
+func cleanBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return "/"
+	}
+	return "/" + p + "/"
+}
+
 func main() {
 	flag.Parse()
 
 	webpack.Init(*devMode)
 
+	if *otelFlag {
+		shutdown, err := setupTelemetry(context.Background())
+		if err != nil {
+			glog.Exitf("Setting up OpenTelemetry: %v", err)
+		}
+		defer shutdown(context.Background())
+	}
+
 	llc := &client.LowLevelClient{
 		Client: &http.Client{
 			Timeout: 5 * time.Minute,
 		},
 	}
+	if *captureFile != "" {
+		capture, err := client.CreateCapture(*captureFile)
+		if err != nil {
+			glog.Exitf("Creating capture: %v", err)
+		}
+		defer func() {
+			if err := capture.Close(); err != nil {
+				glog.Errorf("Writing capture: %v", err)
+			}
+		}()
+		llc.Capture = capture
+	}
 
-	ds = newDatastore(llc)
+	mds := newMemoryDatastore(llc)
+	mds.verify = verifyChunks
+
+	caches := 0
+	for _, f := range []string{*cacheRedis, *cacheMemcached, *sharedStore} {
+		if f != "" {
+			caches++
+		}
+	}
+	switch {
+	case caches > 1:
+		glog.Exit("At most one of -cache-redis, -cache-memcached and -shared-store may be set")
+	case *sharedStore != "":
+		mds.shared = blobstore.NewSharedStore(blobstore.NewDiskContentStore(*sharedStore))
+		mds.cache = mds.shared
+	case *cacheRedis != "":
+		cs := redisblob.Dial(*cacheRedis, "snowstorm:")
+		cs.TTL = *cacheTTL
+		mds.cache = cs
+	case *cacheMemcached != "":
+		cs := memcacheblob.New(memcache.New(strings.Split(*cacheMemcached, ",")...), "snowstorm:")
+		cs.TTL = *cacheTTL
+		mds.cache = cs
+	}
+	if *staticNamesStr != "" {
+		mds.staticNames = make(map[ngdp.ProgramCode]*mndx.TreeDirectory)
+		for _, pair := range strings.Split(*staticNamesStr, ",") {
+			bits := strings.SplitN(pair, "=", 2)
+			if len(bits) != 2 {
+				glog.Exitf("-names: %q should be program=path", pair)
+			}
+			tree, err := staticmap.Load(bits[1])
+			if err != nil {
+				glog.Exitf("Loading names for %q: %v", bits[0], err)
+			}
+			mds.staticNames[ngdp.ProgramCode(bits[0])] = tree
+		}
+	}
+	ds = mds
+
+	if *listfileURL != "" {
+		names = &listfile.Listfile{
+			URL:       *listfileURL,
+			CachePath: *listfileCache,
+			Client:    llc.Client,
+		}
+		go names.Run(context.Background(), *listfileInterval)
+	}
 
 	trackRegions := strings.Split(*trackRegionsStr, ",")
 	trackPrograms := strings.Split(*trackProgramsStr, ",")
@@ -225,30 +506,95 @@ func main() {
 	}
 
 	glog.Info("Performing initial datastore update...")
-	ds.Update(context.Background())
+	updateErr := ds.Update(context.Background())
+
+	// Update the datastore when the patch server reports a new version. Updates which fail are retried with backoff, and
+	// the datastore is refreshed every half hour regardless, in case a change was missed.
+	w := &watch.Watcher{
+		Source:   watch.HTTPSource{Client: llc, Region: ngdp.Region(trackRegions[0])},
+		Interval: 5 * time.Minute,
+		OnError: func(program ngdp.ProgramCode, err error) {
+			glog.Errorf("Polling versions of %q: %v", program, err)
+		},
+	}
+	for _, program := range trackPrograms {
+		w.Programs = append(w.Programs, ngdp.ProgramCode(program))
+	}
+	for _, region := range trackRegions {
+		w.Regions = append(w.Regions, ngdp.Region(region))
+	}
+	changes := make(chan watch.Change, 1)
+	w.Notify(changes)
+	go w.Run(context.Background())
 	go func() {
-		for range time.Tick(30 * time.Minute) {
-			glog.Info("Performing datastore update")
-			ds.Update(context.Background())
+		ctx := context.Background()
+		refresh := time.NewTicker(30 * time.Minute)
+		defer refresh.Stop()
+
+		const minRetryDelay, maxRetryDelay = time.Minute, 30 * time.Minute
+		var retry <-chan time.Time
+		retryDelay := minRetryDelay
+		updated := func(err error) {
+			if err == nil {
+				retry, retryDelay = nil, minRetryDelay
+				return
+			}
+			glog.Errorf("Updating datastore: %v; retrying in %v", err, retryDelay)
+			retry = time.After(retryDelay)
+			if retryDelay *= 2; retryDelay > maxRetryDelay {
+				retryDelay = maxRetryDelay
+			}
+		}
+		updated(updateErr)
+
+		for {
+			select {
+			case c := <-changes:
+				if c.Initial {
+					continue
+				}
+				glog.Infof("Saw new version of %q/%q; performing datastore update", c.Program, c.Region)
+				if err := sinks.Notify(ctx, notify.ChangeEvent(c)); err != nil {
+					glog.Errorf("Sending notifications: %v", err)
+				}
+				err := ds.Update(ctx)
+				if err != nil {
+					if err := sinks.Notify(ctx, notify.FailureEvent("", err)); err != nil {
+						glog.Errorf("Sending notifications: %v", err)
+					}
+				}
+				updated(err)
+			case <-retry:
+				updated(ds.Update(ctx))
+			case <-refresh.C:
+				updated(ds.Update(ctx))
+			}
 		}
 	}()
 
+	base := cleanBasePath(*basePath)
+
+	// Routes are registered relative to the root; the base path is stripped before they're matched.
 	rtr := mux.NewRouter()
-	http.Handle("/", rtr)
+	rtr.Use(traceHandler)
+	http.Handle(base, http.StripPrefix(strings.TrimSuffix(base, "/"), rtr))
 
 	r := rtr.Methods("GET").Subrouter()
 	r.HandleFunc("/programs", ProgramsHandler)
 	r.HandleFunc("/programs/{program}/{region}", ProgramHandler)
+	r.HandleFunc("/programs/{program}/{region}/history", HistoryHandler)
+	r.HandleFunc("/programs/{program}/{region}/product-config", ProductConfigHandler)
+	r.HandleFunc("/filedataids/{id:[0-9]+}", FileDataIDHandler)
 	r.Handle("/programs/{program}/{region}/files", gziphandler.GzipHandler(http.HandlerFunc(FileHandler)))
 	r.Handle("/programs/{program}/{region}/files/{filePath:.+}", gziphandler.GzipHandler(http.HandlerFunc(FileHandler)))
 
 	done := make(chan int)
-	http.HandleFunc("/exit", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc(base+"exit", func(w http.ResponseWriter, r *http.Request) {
 		close(done)
 	})
 
 	go func() {
-		glog.Infof("Listening on %q", *listen)
+		glog.Infof("Listening on %q under %q", *listen, base)
 		glog.Exit(http.ListenAndServe(*listen, nil))
 	}()
 
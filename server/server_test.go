@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lukegb/snowstorm/ngdp"
+	"github.com/lukegb/snowstorm/ngdp/client"
+)
+
+// fakeDatastore is a Datastore whose contents are set up directly by tests.
+type fakeDatastore struct {
+	tracking []DatastoreTracked
+	clients  map[DatastoreTracked]*client.Client
+	history  map[DatastoreTracked][]DatastoreHistoryEntry
+}
+
+func (d *fakeDatastore) Client(region ngdp.Region, program ngdp.ProgramCode) (*client.Client, error) {
+	c, ok := d.clients[DatastoreTracked{region, program}]
+	if !ok {
+		return nil, fmt.Errorf("no client for %q/%q", program, region)
+	}
+	return c, nil
+}
+
+func (d *fakeDatastore) Track(region ngdp.Region, program ngdp.ProgramCode) {
+	d.tracking = append(d.tracking, DatastoreTracked{region, program})
+}
+
+func (d *fakeDatastore) Tracking() []DatastoreTracked { return d.tracking }
+
+func (d *fakeDatastore) Update(ctx context.Context) error { return nil }
+
+func (d *fakeDatastore) History(region ngdp.Region, program ngdp.ProgramCode) ([]DatastoreHistoryEntry, error) {
+	for _, t := range d.tracking {
+		if t.Region == region && t.Program == program {
+			return d.history[t], nil
+		}
+	}
+	return nil, fmt.Errorf("not tracking %q/%q", program, region)
+}
+
+// useDatastore makes the handlers use d for the rest of the test.
+func useDatastore(t *testing.T, d Datastore) {
+	old := ds
+	ds = d
+	t.Cleanup(func() { ds = old })
+}
+
+// serve calls h for a request to path, with the given mux vars.
+func serve(h http.HandlerFunc, path string, vars map[string]string) *httptest.ResponseRecorder {
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, path, nil), vars)
+	w := httptest.NewRecorder()
+	h(w, req)
+	return w
+}
+
+func newFakeDatastore() *fakeDatastore {
+	wow := DatastoreTracked{"us", "wow"}
+	return &fakeDatastore{
+		tracking: []DatastoreTracked{wow},
+		clients: map[DatastoreTracked]*client.Client{
+			wow: {
+				CDNInfo:     &ngdp.CDNInfo{Path: "tpr/wow", Hosts: []string{"cdn.example.com"}},
+				VersionInfo: &ngdp.VersionInfo{BuildConfig: ngdp.CDNHash{1}, BuildID: 1234, VersionsName: "1.2.3.1234"},
+			},
+		},
+		history: map[DatastoreTracked][]DatastoreHistoryEntry{
+			wow: {{VersionInfo: ngdp.VersionInfo{BuildConfig: ngdp.CDNHash{1}, BuildID: 1234, VersionsName: "1.2.3.1234"}, FirstSeen: time.Unix(1600000000, 0).UTC()}},
+		},
+	}
+}
+
+func TestProgramsHandler(t *testing.T) {
+	useDatastore(t, newFakeDatastore())
+
+	w := serve(ProgramsHandler, "/programs", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200: %s", w.Code, w.Body)
+	}
+	var got map[ngdp.ProgramCode]map[ngdp.Region]Program
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	p, ok := got["wow"]["us"]
+	if !ok || len(got) != 1 {
+		t.Fatalf("got programs %v; want just wow/us", got)
+	}
+	if p.VersionInfo.BuildID != 1234 || p.VersionInfo.VersionsName != "1.2.3.1234" || p.CDNInfo.Path != "tpr/wow" {
+		t.Errorf("wow/us = %+v; want build 1234 on tpr/wow", p)
+	}
+}
+
+func TestProgramHandler(t *testing.T) {
+	useDatastore(t, newFakeDatastore())
+
+	w := serve(ProgramHandler, "/programs/wow/us", map[string]string{"program": "wow", "region": "us"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Snowstorm-Build-ID"); got != "1234" {
+		t.Errorf("Snowstorm-Build-ID = %q; want 1234", got)
+	}
+	var p Program
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if want := fmt.Sprintf("%032x", ngdp.CDNHash{1}); p.VersionInfo.BuildConfig != want {
+		t.Errorf("build config = %q; want %q", p.VersionInfo.BuildConfig, want)
+	}
+
+	w = serve(ProgramHandler, "/programs/wow/eu", map[string]string{"program": "wow", "region": "eu"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status for an untracked program = %d; want 404", w.Code)
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	d := newFakeDatastore()
+	d.Track("eu", "wow")
+	useDatastore(t, d)
+
+	for _, test := range []struct {
+		region     string
+		wantStatus int
+		wantBuilds []int
+	}{
+		{"us", http.StatusOK, []int{1234}},
+		{"eu", http.StatusOK, []int{}},
+		{"kr", http.StatusNotFound, nil},
+	} {
+		w := serve(HistoryHandler, "/programs/wow/"+test.region+"/history", map[string]string{"program": "wow", "region": test.region})
+		if w.Code != test.wantStatus {
+			t.Errorf("%s: status = %d; want %d", test.region, w.Code, test.wantStatus)
+			continue
+		}
+		if test.wantStatus != http.StatusOK {
+			continue
+		}
+		var got []HistoryEntry
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Errorf("%s: decoding response: %v", test.region, err)
+			continue
+		}
+		if got == nil {
+			t.Errorf("%s: history is null; want a list", test.region)
+		}
+		if len(got) != len(test.wantBuilds) {
+			t.Errorf("%s: got %d history entries; want %d", test.region, len(got), len(test.wantBuilds))
+			continue
+		}
+		for n, e := range got {
+			if e.BuildID != test.wantBuilds[n] {
+				t.Errorf("%s: entry %d is build %d; want %d", test.region, n, e.BuildID, test.wantBuilds[n])
+			}
+		}
+	}
+}
+
+func TestMemoryDatastoreHistoryBeforeUpdate(t *testing.T) {
+	d := newMemoryDatastore(&client.LowLevelClient{})
+	d.Track("us", "wow")
+
+	h, err := d.History("us", "wow")
+	if err != nil || len(h) != 0 {
+		t.Errorf("History of a tracked pair before its first update = %v, %v; want empty", h, err)
+	}
+	if _, err := d.History("eu", "wow"); err == nil {
+		t.Errorf("History of an untracked pair succeeded; want error")
+	}
+}
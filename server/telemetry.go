@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/lukegb/snowstorm/server")
+
+// setupTelemetry installs OpenTelemetry providers which export traces and metrics over OTLP/HTTP.
+// The exporters are configured by the standard OTEL_EXPORTER_OTLP_* environment variables.
+//
+// The returned function flushes and shuts down the providers.
+func setupTelemetry(ctx context.Context) (func(context.Context) error, error) {
+	traceExporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter))
+
+	metricExporter, err := otlpmetrichttp.New(ctx)
+	if err != nil {
+		tp.Shutdown(ctx)
+		return nil, err
+	}
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)))
+
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if merr := mp.Shutdown(ctx); err == nil {
+			err = merr
+		}
+		return err
+	}, nil
+}
+
+// statusRecorder remembers the status code written to a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// traceHandler wraps every request to the router in a span named after its route.
+func traceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				name = tmpl
+			}
+		}
+
+		vars := mux.Vars(r)
+		ctx, span := tracer.Start(r.Context(), name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", r.URL.RequestURI()),
+			attribute.String("ngdp.program", vars["program"]),
+			attribute.String("ngdp.region", vars["region"]),
+		))
+		defer span.End()
+
+		sw := &statusRecorder{w, http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.status_code", sw.status))
+		if sw.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
@@ -12,6 +12,9 @@ var devServerPort = 3808;
 
 var production = process.env.NODE_ENV === 'production';
 
+// must match the server's -base-path flag
+var basePath = (process.env.BASE_PATH || '/').replace(/\/*$/, '/');
+
 var extractCss = new ExtractTextPlugin({
   filename: "[name].[contenthash].css",
   disable: !production,
@@ -52,7 +55,7 @@ var config = {
 
     // must match config.webpack.output_dir
     path: path.join(__dirname, 'public', 'webpack'),
-    publicPath: '/webpack/',
+    publicPath: basePath + 'webpack/',
 
     filename: production ? '[name]-[chunkhash].js' : '[name].js'
   },